@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+)
+
+// noNewAccess is the result of IAM Access Analyzer's CheckNoNewAccess
+type noNewAccess struct {
+	// "PASS" or "FAIL"
+	Result  string `json:"result"`
+	Message string `json:"message"`
+	Reasons []struct {
+		Description    string `json:"description"`
+		StatementIndex *int   `json:"statementIndex"`
+		StatementID    string `json:"statementId"`
+	} `json:"reasons"`
+}
+
+// checkNoNewAccess asks IAM Access Analyzer whether a new identity policy
+// grants access that an existing one doesn't. It's the CheckNoNewAccess
+// operation, made as a signed HTTP request since it's the only one of the
+// service iamgo needs. Credentials and the region are loaded the same way
+// as by the AWS CLI
+func checkNoNewAccess(ctx context.Context, existing, new []byte) (noNewAccess, error) {
+	cfg, err := awsconfig.LoadDefaultConfig(ctx)
+	if err != nil {
+		return noNewAccess{}, err
+	}
+	if cfg.Region == "" {
+		return noNewAccess{}, errors.New("no region, set AWS_REGION or the region of the profile")
+	}
+	creds, err := cfg.Credentials.Retrieve(ctx)
+	if err != nil {
+		return noNewAccess{}, err
+	}
+
+	body, err := json.Marshal(map[string]string{
+		"existingPolicyDocument": string(existing),
+		"newPolicyDocument":      string(new),
+		"policyType":             "IDENTITY_POLICY",
+	})
+	if err != nil {
+		return noNewAccess{}, err
+	}
+	domain := "amazonaws.com"
+	if strings.HasPrefix(cfg.Region, "cn-") {
+		domain = "amazonaws.com.cn"
+	}
+	url := fmt.Sprintf("https://access-analyzer.%s.%s/policy/check-no-new-access", cfg.Region, domain)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return noNewAccess{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	hash := sha256.Sum256(body)
+	if err := v4.NewSigner().SignHTTP(ctx, creds, req, hex.EncodeToString(hash[:]), "access-analyzer", cfg.Region, time.Now()); err != nil {
+		return noNewAccess{}, err
+	}
+
+	resp, err := cfg.HTTPClient.Do(req)
+	if err != nil {
+		return noNewAccess{}, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return noNewAccess{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		json.Unmarshal(data, &apiErr)
+		if apiErr.Message == "" {
+			apiErr.Message = resp.Status
+		}
+		if kind, _, _ := strings.Cut(resp.Header.Get("X-Amzn-ErrorType"), ":"); kind != "" {
+			return noNewAccess{}, fmt.Errorf("%s: %s", kind, apiErr.Message)
+		}
+		return noNewAccess{}, errors.New(apiErr.Message)
+	}
+	var result noNewAccess
+	if err := json.Unmarshal(data, &result); err != nil {
+		return noNewAccess{}, err
+	}
+	return result, nil
+}
+
+// printNoNewAccess outputs why the generated policy grants more access
+// than the deployed one, if it does, and reports whether it doesn't
+func printNoNewAccess(result noNewAccess) bool {
+	if result.Result == "PASS" {
+		slog.Info("IAM Access Analyzer found that the generated policy grants no new access")
+		return true
+	}
+	fmt.Println("New access according to IAM Access Analyzer:")
+	for _, reason := range result.Reasons {
+		if reason.StatementIndex != nil {
+			fmt.Printf("    statement %d: %s\n", *reason.StatementIndex, reason.Description)
+		} else {
+			fmt.Printf("    %s\n", reason.Description)
+		}
+	}
+	if len(result.Reasons) == 0 {
+		fmt.Printf("    %s\n", result.Message)
+	}
+	return false
+}
@@ -0,0 +1,103 @@
+// Package analyzer reports the AWS SDK calls in Go code and the IAM actions
+// they require, as a go/analysis Analyzer. Unlike iamgo itself it only looks
+// at the call sites in each package, not at what's reachable from main, so
+// it can run alongside other checks under go vet or golangci-lint
+package analyzer
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"sync"
+
+	"golang.org/x/tools/go/analysis"
+	"golang.org/x/tools/go/ssa"
+	"golang.org/x/tools/go/types/typeutil"
+
+	iamanalysis "github.com/esprimo/iamgo/internal/analysis"
+	"github.com/esprimo/iamgo/internal/mapping"
+	"github.com/esprimo/iamgo/internal/sdk"
+)
+
+// Analyzer reports every AWS SDK call that requires an IAM action, e.g.
+//
+//	main.go:12:9: s3.GetObject requires s3:GetObject
+var Analyzer = &analysis.Analyzer{
+	Name: "iamgo",
+	Doc:  "report AWS SDK calls and the IAM actions they require",
+	URL:  "https://github.com/esprimo/iamgo",
+	Run:  run,
+}
+
+// loadMap loads the mapping of SDK methods to the IAM actions they require
+// the first time the analyzer runs, so importing the package can't fail
+var loadMap = sync.OnceValues(mapping.Load)
+
+func run(pass *analysis.Pass) (any, error) {
+	iamMap, err := loadMap()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the action mapping: %w", err)
+	}
+	// SDK calls are recognized by the command's detectors, which look at
+	// functions in SSA form. Only the packages of the functions called are
+	// needed, without their bodies
+	prog := ssa.NewProgram(pass.Fset, 0)
+	for _, file := range pass.Files {
+		// Calls the iamgo command leaves out for //iamgo:ignore comments
+		// aren't reported either
+		var directives iamanalysis.Directives
+		directives.AddFile(pass.Fset, file)
+		for _, decl := range file.Decls {
+			var declPos token.Position
+			if fd, ok := decl.(*ast.FuncDecl); ok {
+				declPos = pass.Fset.Position(fd.Type.Func)
+			}
+			ast.Inspect(decl, func(n ast.Node) bool {
+				call, ok := n.(*ast.CallExpr)
+				if !ok {
+					return true
+				}
+				fn, ok := typeutil.Callee(pass.TypesInfo, call).(*types.Func)
+				if !ok {
+					return true
+				}
+				method := sdkMethod(prog, fn)
+				if method == "" {
+					return true
+				}
+				action := iamMap.Action(method)
+				if _, ignored := directives.Match(action, declPos, pass.Fset.Position(call.Lparen)); action != "" && !ignored {
+					pass.Reportf(call.Pos(), "%s requires %s", method, action)
+				}
+				return true
+			})
+		}
+	}
+	return nil, nil
+}
+
+// sdkMethod returns the SDK method a call to a method makes, e.g.
+// "s3.GetObject", or an empty string if it's not an SDK call. Calls to an
+// AWS SDK v1 client, e.g. GetObjectWithContext, are recognized by the
+// Request method they call the operation through, see sdk.RequestMethod
+func sdkMethod(prog *ssa.Program, fn *types.Func) string {
+	sig := fn.Type().(*types.Signature)
+	if sig.Recv() == nil || fn.Pkg() == nil {
+		return ""
+	}
+	if prog.Package(fn.Pkg()) == nil {
+		prog.CreatePackage(fn.Pkg(), nil, nil, true)
+	}
+	methods := prog.MethodSets.MethodSet(sig.Recv().Type())
+	for _, name := range []string{fn.Name(), sdk.RequestMethod(fn.Name())} {
+		sel := methods.Lookup(fn.Pkg(), name)
+		if sel == nil {
+			continue
+		}
+		if m := prog.MethodValue(sel); m != nil && sdk.AWS.Version(m) != "" {
+			return sdk.AWS.MethodName(m)
+		}
+	}
+	return ""
+}
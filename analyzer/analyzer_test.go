@@ -0,0 +1,14 @@
+package analyzer_test
+
+import (
+	"testing"
+
+	"golang.org/x/tools/go/analysis/analysistest"
+
+	"github.com/esprimo/iamgo/analyzer"
+)
+
+func TestAnalyzer(t *testing.T) {
+	// The module root, so the test data can import the SDK
+	analysistest.Run(t, "..", analyzer.Analyzer, "./analyzer/testdata/ignore")
+}
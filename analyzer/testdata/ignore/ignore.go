@@ -0,0 +1,24 @@
+// Package ignore makes SDK calls, some with //iamgo:ignore comments, for
+// the tests of the analyzer
+package ignore
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+)
+
+func listRoles(ctx context.Context, client *iam.Client) {
+	client.ListRoles(ctx, nil) // want `iam.ListRoles requires iam:ListRoles`
+	//iamgo:ignore iam:GetUser only to show who runs it
+	client.GetUser(ctx, nil)
+	client.GetRole(ctx, nil) //iamgo:ignore iam:Get*
+	//iamgo:ignore s3:GetObject doesn't apply to the call
+	client.GetPolicy(ctx, nil) // want `iam.GetPolicy requires iam:GetPolicy`
+}
+
+//iamgo:ignore iam:Delete* cleans up after the tests
+func cleanUp(ctx context.Context, client *iam.Client) {
+	client.DeleteRole(ctx, nil)
+	client.CreateRole(ctx, nil) // want `iam.CreateRole requires iam:CreateRole`
+}
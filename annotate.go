@@ -0,0 +1,253 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"golang.org/x/tools/go/callgraph"
+	"golang.org/x/tools/go/ssa"
+)
+
+// annotationPrefix starts the comment iamgo inserts above functions. It's
+// written as a directive (no space after the slashes) so it isn't rendered
+// as part of the function's documentation
+const annotationPrefix = "//iamgo:actions"
+
+// annotation is a comment to insert above a function, replacing any
+// previous annotation
+type annotation struct {
+	// Line of the func keyword (1-based)
+	line int
+	// The comment to insert, including the trailing newline. Empty if
+	// there's nothing to insert
+	text string
+}
+
+// annotate inserts a comment above each reachable function in the analyzed
+// packages listing the IAM actions reachable from it. If write is set the
+// source files are updated, otherwise a patch is written to w
+func (g *graph) annotate(w io.Writer, write bool) error {
+	analyzed := make(map[*ssa.Package]bool)
+	for _, pkg := range g.Packages {
+		analyzed[pkg] = true
+	}
+
+	// Group instantiations of generic functions under the function
+	// they are instantiated from, since that's what's in the source
+	funcs := make(map[*ssa.Function][]*ssa.Function)
+	for fn := range g.Reachable {
+		decl := fn
+		if orig := fn.Origin(); orig != nil {
+			decl = orig
+		}
+		if decl.Parent() != nil || !analyzed[decl.Pkg] {
+			continue
+		}
+		if _, ok := decl.Syntax().(*ast.FuncDecl); !ok {
+			continue // wrappers etc
+		}
+		funcs[decl] = append(funcs[decl], fn)
+	}
+
+	annotations := make(map[string][]annotation)
+	for decl, instances := range funcs {
+		// Functions that don't need any actions get an empty annotation so
+		// annotations from previous runs are removed
+		var text string
+		if actions := g.reachableActions(instances); len(actions) > 0 {
+			text = fmt.Sprintf("%s %s\n", annotationPrefix, strings.Join(actions, " "))
+		}
+		pos := g.Prog.Fset.Position(decl.Syntax().(*ast.FuncDecl).Type.Func)
+		annotations[pos.Filename] = append(annotations[pos.Filename], annotation{
+			line: pos.Line,
+			text: text,
+		})
+	}
+
+	filenames := make([]string, 0, len(annotations))
+	for filename := range annotations {
+		filenames = append(filenames, filename)
+	}
+	slices.Sort(filenames)
+
+	for _, filename := range filenames {
+		src, err := os.ReadFile(filename)
+		if err != nil {
+			return err
+		}
+		oldLines := strings.SplitAfter(string(src), "\n")
+		if oldLines[len(oldLines)-1] == "" {
+			oldLines = oldLines[:len(oldLines)-1]
+		}
+		newLines := applyAnnotations(oldLines, annotations[filename])
+		if slices.Equal(oldLines, newLines) {
+			continue
+		}
+
+		if write {
+			if err := os.WriteFile(filename, []byte(strings.Join(newLines, "")), 0o644); err != nil {
+				return err
+			}
+			continue
+		}
+		fmt.Fprint(w, unifiedDiff(filename, oldLines, newLines))
+	}
+	return nil
+}
+
+// reachableActions returns the sorted IAM actions required by the SDK calls
+// reachable from any of the given functions (or functions nested in them)
+func (g *graph) reachableActions(fns []*ssa.Function) []string {
+	var queue []*callgraph.Node
+	visited := make(map[*callgraph.Node]bool)
+	var enqueue func(fn *ssa.Function)
+	enqueue = func(fn *ssa.Function) {
+		if node := g.CallGraph.Nodes[fn]; node != nil && !visited[node] {
+			visited[node] = true
+			queue = append(queue, node)
+		}
+		for _, anon := range fn.AnonFuncs {
+			enqueue(anon)
+		}
+	}
+	for _, fn := range fns {
+		enqueue(fn)
+	}
+
+	var actions []string
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+
+		if fn := current.Func; fn.Synthetic == "" && fn.Pkg != nil && g.detectors.Version(fn) != "" {
+			if action := sdkMethodToAction(g.detectors.MethodName(fn)); action != "" && !slices.Contains(actions, action) {
+				actions = append(actions, action)
+			}
+		}
+
+		for _, edge := range current.Out {
+			if !visited[edge.Callee] {
+				visited[edge.Callee] = true
+				queue = append(queue, edge.Callee)
+			}
+		}
+	}
+	slices.Sort(actions)
+	return actions
+}
+
+// applyAnnotations inserts annotations above the lines they belong to,
+// replacing annotations from a previous run
+func applyAnnotations(lines []string, annotations []annotation) []string {
+	slices.SortFunc(annotations, func(a, b annotation) int { return a.line - b.line })
+
+	var out []string
+	next := 0 // next line in lines to copy
+	for _, a := range annotations {
+		i := a.line - 1
+		if i < next || i >= len(lines) {
+			continue
+		}
+		end := i
+		for end > next && strings.HasPrefix(lines[end-1], annotationPrefix) {
+			end-- // drop the previous annotation
+		}
+		out = append(out, lines[next:end]...)
+		if a.text != "" {
+			out = append(out, a.text)
+		}
+		next = i
+	}
+	return append(out, lines[next:]...)
+}
+
+// unifiedDiff creates a patch, in the format of 'diff -u', of the changes
+// between the lines of two versions of a file. It assumes that the only
+// changes are lines being inserted or replaced, which is all annotate does
+func unifiedDiff(filename string, oldLines, newLines []string) string {
+	const context = 3
+
+	// Find the ranges that changed by walking both versions in step
+	type change struct{ oldStart, oldEnd, newStart, newEnd int }
+	var changes []change
+	i, j := 0, 0
+	for i < len(oldLines) || j < len(newLines) {
+		if i < len(oldLines) && j < len(newLines) && oldLines[i] == newLines[j] {
+			i++
+			j++
+			continue
+		}
+		c := change{oldStart: i, newStart: j}
+		// annotate only inserts annotations, possibly replacing an old one,
+		// right before an unchanged line
+		for i < len(oldLines) && strings.HasPrefix(oldLines[i], annotationPrefix) {
+			i++
+		}
+		for j < len(newLines) && strings.HasPrefix(newLines[j], annotationPrefix) {
+			j++
+		}
+		if c.oldStart == i && c.newStart == j { // shouldn't happen, but don't loop forever
+			i++
+			j++
+		}
+		c.oldEnd, c.newEnd = i, j
+		changes = append(changes, c)
+	}
+
+	// Paths are relative to the working directory, like git's, so the
+	// patch can be applied with 'git apply' or 'patch -p1'
+	if wd, err := os.Getwd(); err == nil {
+		if rel, err := filepath.Rel(wd, filename); err == nil && filepath.IsLocal(rel) {
+			filename = filepath.ToSlash(rel)
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- a/%s\n+++ b/%s\n", filename, filename)
+	for len(changes) > 0 {
+		// Changes closer to each other than the context share a hunk
+		n := 1
+		for n < len(changes) && changes[n].oldStart-changes[n-1].oldEnd <= 2*context {
+			n++
+		}
+		hunk := changes[:n]
+		changes = changes[n:]
+
+		oldStart := max(hunk[0].oldStart-context, 0)
+		oldEnd := min(hunk[n-1].oldEnd+context, len(oldLines))
+		newStart := hunk[0].newStart - (hunk[0].oldStart - oldStart)
+		newEnd := hunk[n-1].newEnd + (oldEnd - hunk[n-1].oldEnd)
+		fmt.Fprintf(&b, "@@ -%d,%d +%d,%d @@\n", oldStart+1, oldEnd-oldStart, newStart+1, newEnd-newStart)
+
+		line := oldStart
+		for _, c := range hunk {
+			for ; line < c.oldStart; line++ {
+				b.WriteString(" " + diffLine(oldLines[line]))
+			}
+			for _, l := range oldLines[c.oldStart:c.oldEnd] {
+				b.WriteString("-" + diffLine(l))
+			}
+			for _, l := range newLines[c.newStart:c.newEnd] {
+				b.WriteString("+" + diffLine(l))
+			}
+			line = c.oldEnd
+		}
+		for ; line < oldEnd; line++ {
+			b.WriteString(" " + diffLine(oldLines[line]))
+		}
+	}
+	return b.String()
+}
+
+// diffLine makes sure a line in a patch ends with a newline
+func diffLine(line string) string {
+	if !strings.HasSuffix(line, "\n") {
+		return line + "\n\\ No newline at end of file\n"
+	}
+	return line
+}
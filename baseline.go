@@ -0,0 +1,27 @@
+package main
+
+import (
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/esprimo/iamgo/internal/libraries"
+)
+
+// baselineTargets returns the names of the baseline profiles, sorted
+func baselineTargets() []string {
+	var names []string
+	for name := range libraries.Baselines {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// logBaseline outputs the baseline actions that were added to stderr, so
+// the output stays a plain list
+func logBaseline(target string, actions []string) {
+	if len(actions) > 0 {
+		slog.Info("added the actions of "+libraries.Baselines[target].Name, "actions", strings.Join(actions, ","))
+	}
+}
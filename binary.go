@@ -0,0 +1,160 @@
+package main
+
+import (
+	"debug/elf"
+	"debug/gosym"
+	"debug/macho"
+	"debug/pe"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// binarySDKMethods returns the SDK methods, e.g. "s3.GetObject", whose
+// functions are compiled into a Go binary.
+//
+// This is only an approximation. The linker drops functions it can prove
+// are never called, but exported methods are often kept since they may be
+// called through interfaces or reflection. Unlike analyzing source, it
+// tells what the program contains rather than what it can actually reach
+func binarySDKMethods(filename string) ([]string, error) {
+	funcs, err := binaryFuncNames(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var sdkMethods []string
+	for _, name := range funcs {
+		sdkMethod := symbolToSDKMethod(name)
+		if sdkMethod != "" && !slices.Contains(sdkMethods, sdkMethod) {
+			sdkMethods = append(sdkMethods, sdkMethod)
+		}
+	}
+	return sdkMethods, nil
+}
+
+// symbolToSDKMethod converts the name of a function in a Go binary to an SDK
+// method if it's an AWS SDK call, or returns an empty string if not
+//
+// For example:
+// In:  github.com/aws/aws-sdk-go-v2/service/s3.(*Client).GetObject
+// Out: s3.GetObject
+// In:  github.com/aws/aws-sdk-go/service/s3.(*S3).GetObjectRequest
+// Out: s3.GetObject
+func symbolToSDKMethod(name string) string {
+	var rest string
+	var v1 bool
+	switch {
+	case strings.HasPrefix(name, "github.com/aws/aws-sdk-go-v2/service/"):
+		rest = strings.TrimPrefix(name, "github.com/aws/aws-sdk-go-v2/service/")
+	case strings.HasPrefix(name, "github.com/aws/aws-sdk-go/service/"):
+		rest = strings.TrimPrefix(name, "github.com/aws/aws-sdk-go/service/")
+		v1 = true
+	default:
+		return ""
+	}
+
+	// e.g. s3.(*Client).GetObject
+	service, rest, ok := strings.Cut(rest, ".")
+	if !ok || strings.Contains(service, "/") { // e.g. nested internal packages
+		return ""
+	}
+	receiver, method, ok := strings.Cut(rest, ".")
+	if !ok || !strings.HasPrefix(receiver, "(*") || method == "" || !unicode.IsUpper(rune(method[0])) {
+		return ""
+	}
+
+	if v1 {
+		// SDK v1 API calls have a "Request" suffix, see sdk.Version
+		if !strings.HasSuffix(method, "Request") || method == "Request" {
+			return ""
+		}
+		method = strings.TrimSuffix(method, "Request")
+	} else if receiver != "(*Client)" || method == "Options" {
+		return ""
+	}
+
+	return fmt.Sprintf("%s.%s", service, method)
+}
+
+// binaryFuncNames returns the names of all functions in a Go binary. It
+// reads the pclntab Go binaries use for stack traces, so it works even for
+// binaries stripped of their symbol table
+func binaryFuncNames(filename string) ([]string, error) {
+	pclntab, text, err := readPclntab(filename)
+	if err != nil {
+		return nil, err
+	}
+	table, err := gosym.NewTable(nil, gosym.NewLineTable(pclntab, text))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Go function table: %w", err)
+	}
+
+	names := make([]string, 0, len(table.Funcs))
+	for _, fn := range table.Funcs {
+		names = append(names, fn.Name)
+	}
+	return names, nil
+}
+
+// readPclntab returns the pclntab section of an ELF, Mach-O or PE binary
+// along with the start address of its text section
+func readPclntab(filename string) ([]byte, uint64, error) {
+	if f, err := elf.Open(filename); err == nil {
+		defer f.Close()
+		section, text := f.Section(".gopclntab"), f.Section(".text")
+		if section == nil || text == nil {
+			return nil, 0, errors.New("not a Go binary")
+		}
+		data, err := section.Data()
+		return data, text.Addr, err
+	}
+
+	if f, err := macho.Open(filename); err == nil {
+		defer f.Close()
+		section, text := f.Section("__gopclntab"), f.Section("__text")
+		if section == nil || text == nil {
+			return nil, 0, errors.New("not a Go binary")
+		}
+		data, err := section.Data()
+		return data, text.Addr, err
+	}
+
+	if f, err := pe.Open(filename); err == nil {
+		defer f.Close()
+		// PE binaries have no dedicated section for the pclntab, but it's
+		// located through the runtime.pclntab and runtime.epclntab symbols
+		var start, end *pe.Symbol
+		for _, sym := range f.Symbols {
+			switch sym.Name {
+			case "runtime.pclntab":
+				start = sym
+			case "runtime.epclntab":
+				end = sym
+			}
+		}
+		text := f.Section(".text")
+		if start == nil || end == nil || text == nil || int(start.SectionNumber) < 1 || int(start.SectionNumber) > len(f.Sections) {
+			return nil, 0, errors.New("not a Go binary or it's stripped of its symbols")
+		}
+		data, err := f.Sections[start.SectionNumber-1].Data()
+		if err != nil {
+			return nil, 0, err
+		}
+		if end.Value < start.Value || int(end.Value) > len(data) {
+			return nil, 0, errors.New("invalid pclntab symbols")
+		}
+		var imageBase uint64
+		switch oh := f.OptionalHeader.(type) {
+		case *pe.OptionalHeader32:
+			imageBase = uint64(oh.ImageBase)
+		case *pe.OptionalHeader64:
+			imageBase = oh.ImageBase
+		}
+		return data[start.Value:end.Value], imageBase + uint64(text.VirtualAddress), nil
+	}
+
+	return nil, 0, errors.New("unknown binary format, expected ELF, Mach-O or PE")
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// lockChange is a version of the lockfile, the actions it added and
+// removed compared to the one before it and who's responsible: the commit
+// that changed it and the Go files it changed, or for the actions the code
+// needs that aren't locked yet the places that call the SDK
+type lockChange struct {
+	// e.g. "Unreleased" or "2024-05-01 1a2b3c4 Upload reports to S3"
+	title string
+	// The actions in this version
+	actions        []string
+	added, removed []string
+	// Where each added action is needed, by action
+	calls map[string]string
+	// Go files changed along with the lockfile
+	files []string
+}
+
+// lockHistory returns the versions of a lockfile in the git history,
+// newest first
+func lockHistory(lockfile string) ([]lockChange, error) {
+	path, err := gitPath(lockfile)
+	if err != nil {
+		return nil, err
+	}
+	out, err := git("log", "--diff-filter=AMR", "--format=%h%x09%as%x09%s", "--", lockfile)
+	if err != nil {
+		return nil, err
+	}
+	if out == "" {
+		return nil, nil
+	}
+
+	var changes []lockChange
+	for _, line := range strings.Split(out, "\n") {
+		commit, rest, _ := strings.Cut(line, "\t")
+		date, subject, _ := strings.Cut(rest, "\t")
+		content, err := git("show", commit+":"+path)
+		if err != nil {
+			return nil, err
+		}
+		files, err := git("show", "--format=", "--name-only", commit)
+		if err != nil {
+			return nil, err
+		}
+		change := lockChange{title: date + " " + commit + " " + subject, actions: parseActions(content)}
+		for _, file := range strings.Split(files, "\n") {
+			if strings.HasSuffix(file, ".go") {
+				change.files = append(change.files, file)
+			}
+		}
+		changes = append(changes, change)
+	}
+	for i := range changes {
+		var older []string
+		if i+1 < len(changes) {
+			older = changes[i+1].actions
+		}
+		changes[i].added, changes[i].removed = compareActions(older, changes[i].actions)
+	}
+	return changes, nil
+}
+
+// gitPath returns the path of a file in the working directory the way git
+// show takes it, relative to the working directory
+func gitPath(filename string) (string, error) {
+	if !filepath.IsAbs(filename) {
+		return "./" + filepath.ToSlash(filename), nil
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(cwd, filename)
+	if err != nil {
+		return "", err
+	}
+	return "./" + filepath.ToSlash(rel), nil
+}
+
+// unreleasedChange compares the actions the code needs with the last
+// committed version of the lockfile, with the place that calls the SDK for
+// each added action
+func (g *graph) unreleasedChange(history []lockChange, actions []string) lockChange {
+	var locked []string
+	if len(history) > 0 {
+		locked = history[0].actions
+	}
+	change := lockChange{title: "Unreleased", actions: actions, calls: make(map[string]string)}
+	change.added, change.removed = compareActions(locked, actions)
+	for _, action := range change.added {
+		if call := g.codeCallSite(action); call != "" {
+			change.calls[action] = call
+		}
+	}
+	return change
+}
+
+// codeCallSite returns where the code itself, rather than the SDK, makes
+// the last call on a shortest path to an action, e.g. "cmd/app/main.go:42",
+// or an empty string if there's no path, e.g. for a library's action
+func (g *graph) codeCallSite(action string) string {
+	paths, err := g.whyPaths(action, whyOptions{})
+	if err != nil || len(paths) == 0 {
+		return ""
+	}
+	steps := g.whyResult(action, paths[:1]).Paths[0].Steps
+	for i := len(steps) - 1; i >= 0; i-- {
+		site := steps[i].CallSite
+		if rel := g.relPath(site.Filename); site.Filename != "" && rel != site.Filename {
+			return fmt.Sprintf("%s:%d", rel, site.Line)
+		}
+	}
+	return ""
+}
+
+// compareActions returns the actions only in the new set and the ones only
+// in the old one
+func compareActions(old, new []string) (added, removed []string) {
+	for _, action := range new {
+		if !slices.Contains(old, action) {
+			added = append(added, action)
+		}
+	}
+	for _, action := range old {
+		if !slices.Contains(new, action) {
+			removed = append(removed, action)
+		}
+	}
+	return added, removed
+}
+
+// writeChangelog writes the changes of the required actions as Markdown,
+// a section per change that adds or removes any
+func writeChangelog(w io.Writer, changes []lockChange) {
+	fmt.Fprint(w, "# IAM actions\n")
+	written := false
+	for _, c := range changes {
+		if len(c.added) == 0 && len(c.removed) == 0 {
+			continue
+		}
+		written = true
+		fmt.Fprintf(w, "\n## %s\n\n", c.title)
+		for _, action := range c.added {
+			if call := c.calls[action]; call != "" {
+				fmt.Fprintf(w, "- Added `%s`, called at `%s`\n", action, call)
+			} else {
+				fmt.Fprintf(w, "- Added `%s`\n", action)
+			}
+		}
+		for _, action := range c.removed {
+			fmt.Fprintf(w, "- Removed `%s`\n", action)
+		}
+		if len(c.files) > 0 {
+			files := c.files
+			more := ""
+			if len(files) > 5 {
+				files, more = files[:5], fmt.Sprintf(" and %d more", len(files)-5)
+			}
+			fmt.Fprintf(w, "\nChanged along with `%s`%s.\n", strings.Join(files, "`, `"), more)
+		}
+	}
+	if !written {
+		fmt.Fprint(w, "\nThe required IAM actions haven't changed.\n")
+	}
+}
+
+// isFile reports whether a path is a regular file, e.g. a lockfile rather
+// than a package pattern
+func isFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular()
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"fmt"
+	"go/token"
+	"go/types"
+	"slices"
+	"strings"
+
+	"golang.org/x/tools/go/ssa"
+)
+
+// client is a place where an AWS SDK client is constructed, e.g. a call
+// to s3.NewFromConfig. Calls made through clients constructed at the same
+// place share the same credentials (as far as we can tell statically)
+type client struct {
+	// Name of the constructor, e.g. "s3.NewFromConfig". Empty if the
+	// client couldn't be traced back to where it was constructed
+	constructor string
+	// Path to file where the constructor was called
+	filename string
+	// Line where the constructor was called
+	line int
+	// Column where the constructor was called
+	column int
+}
+
+func (c client) String() string {
+	if c.constructor == "" {
+		return "unknown client"
+	}
+	return fmt.Sprintf("%s at %s:%d:%d", c.constructor, c.filename, c.line, c.column)
+}
+
+// clientTracer follows the data flow of SDK clients backwards from where
+// they are used to where they are constructed.
+//
+// The tracing is field-based and context-insensitive: a value loaded from
+// a struct field may come from any store to that field (of that struct
+// type) anywhere in the reachable program, and a parameter may come from
+// any call site of the function. It over-approximates, meaning a call may
+// be attributed to more than one client, but it never misses a client
+// that the call graph knows about.
+type clientTracer struct {
+	g *graph
+	// stores to struct fields, globals and local variables, keyed by
+	// what's being stored to
+	stores map[any][]ssa.Value
+}
+
+// fieldKey identifies a struct field regardless of which instance of the
+// struct it belongs to. Structs are identified by their underlying type,
+// which is what the wrappers of methods promoted from embedded fields
+// select the field from
+type fieldKey struct {
+	structType string
+	field      int
+}
+
+func newClientTracer(g *graph) *clientTracer {
+	t := &clientTracer{
+		g:      g,
+		stores: make(map[any][]ssa.Value),
+	}
+	for fn := range g.Reachable {
+		for _, block := range fn.Blocks {
+			for _, instr := range block.Instrs {
+				store, ok := instr.(*ssa.Store)
+				if !ok {
+					continue
+				}
+				if key := storeKey(store.Addr); key != nil {
+					t.stores[key] = append(t.stores[key], store.Val)
+				}
+			}
+		}
+	}
+	return t
+}
+
+// storeKey returns the key stores to (and loads from) an address are
+// indexed by, or nil if it's not something we track
+func storeKey(addr ssa.Value) any {
+	switch addr := addr.(type) {
+	case *ssa.FieldAddr:
+		if ptr, ok := addr.X.Type().Underlying().(*types.Pointer); ok {
+			return fieldKey{ptr.Elem().Underlying().String(), addr.Field}
+		}
+	case *ssa.Global, *ssa.Alloc:
+		return addr
+	}
+	return nil
+}
+
+// clientCalls attributes each of the given SDK calls to the client
+// instances they are made through
+func (g *graph) clientCalls(fns []*ssa.Function) map[client][]*ssa.Function {
+	t := newClientTracer(g)
+
+	clientCalls := make(map[client][]*ssa.Function)
+	for _, fn := range fns {
+		clients := make(map[client]bool)
+		sites, values := g.SDKCallSites(g.CallGraph, fn)
+		receivers := make([]ssa.Value, 0, len(sites)+len(values))
+		for _, edge := range sites {
+			receivers = append(receivers, receiver(edge.Site.Common()))
+		}
+		for _, closure := range values {
+			receivers = append(receivers, closure.Bindings[0])
+		}
+		for _, recv := range receivers {
+			traced := t.trace(recv, make(map[ssa.Value]bool))
+			if len(traced) == 0 {
+				traced = []client{{}}
+			}
+			for _, c := range traced {
+				clients[c] = true
+			}
+		}
+		if len(clients) == 0 {
+			clients[client{}] = true
+		}
+		for c := range clients {
+			clientCalls[c] = append(clientCalls[c], fn)
+		}
+	}
+	return clientCalls
+}
+
+// receiver returns the value a method is called on
+func receiver(call *ssa.CallCommon) ssa.Value {
+	if call.IsInvoke() {
+		return call.Value
+	}
+	if len(call.Args) == 0 {
+		return nil
+	}
+	return call.Args[0]
+}
+
+// trace returns the clients a value may originate from
+func (t *clientTracer) trace(v ssa.Value, seen map[ssa.Value]bool) []client {
+	if v == nil || seen[v] {
+		return nil
+	}
+	seen[v] = true
+
+	var clients []client
+	switch v := v.(type) {
+	case *ssa.Call:
+		if callee := v.Call.StaticCallee(); callee != nil && isClientConstructor(callee) {
+			pos := t.g.Prog.Fset.Position(v.Pos())
+			return []client{{
+				constructor: fmt.Sprintf("%s.%s", callee.Pkg.Pkg.Name(), callee.Name()),
+				filename:    t.g.displayPath(pos.Filename),
+				line:        pos.Line,
+				column:      pos.Column,
+			}}
+		}
+		for _, callee := range t.callees(v) {
+			clients = append(clients, t.traceReturns(callee, 0, seen)...)
+		}
+	case *ssa.Extract:
+		if call, ok := v.Tuple.(*ssa.Call); ok {
+			for _, callee := range t.callees(call) {
+				clients = append(clients, t.traceReturns(callee, v.Index, seen)...)
+			}
+		}
+	case *ssa.Phi:
+		for _, edge := range v.Edges {
+			clients = append(clients, t.trace(edge, seen)...)
+		}
+	case *ssa.UnOp:
+		if v.Op != token.MUL {
+			break
+		}
+		clients = t.traceLoad(v.X, seen)
+	case *ssa.FieldAddr:
+		// The address of a client embedded by value, e.g. the receiver of
+		// its methods promoted to the struct
+		for _, stored := range t.stores[storeKey(v)] {
+			clients = append(clients, t.trace(stored, seen)...)
+		}
+	case *ssa.Field:
+		key := fieldKey{v.X.Type().Underlying().String(), v.Field}
+		for _, stored := range t.stores[key] {
+			clients = append(clients, t.trace(stored, seen)...)
+		}
+	case *ssa.Parameter:
+		clients = t.traceParameter(v, seen)
+	case *ssa.FreeVar:
+		clients = t.traceFreeVar(v, seen)
+	case *ssa.ChangeType:
+		clients = t.trace(v.X, seen)
+	case *ssa.MakeInterface:
+		clients = t.trace(v.X, seen)
+	case *ssa.ChangeInterface:
+		clients = t.trace(v.X, seen)
+	case *ssa.TypeAssert:
+		clients = t.trace(v.X, seen)
+	}
+	if len(clients) == 0 {
+		clients = t.traceEmbedded(v, seen)
+	}
+	return clients
+}
+
+// traceEmbedded returns the clients stored to the embedded fields of a
+// struct, or a pointer to one, e.g. one whose methods promoted from an
+// embedded client are called through an interface
+func (t *clientTracer) traceEmbedded(v ssa.Value, seen map[ssa.Value]bool) []client {
+	typ := v.Type().Underlying()
+	if ptr, ok := typ.(*types.Pointer); ok {
+		typ = ptr.Elem().Underlying()
+	}
+	st, ok := typ.(*types.Struct)
+	if !ok {
+		return nil
+	}
+	var clients []client
+	for i := 0; i < st.NumFields(); i++ {
+		if !st.Field(i).Embedded() {
+			continue
+		}
+		for _, stored := range t.stores[fieldKey{st.String(), i}] {
+			clients = append(clients, t.trace(stored, seen)...)
+		}
+	}
+	return clients
+}
+
+// callees returns the functions a call may call according to the call graph
+func (t *clientTracer) callees(call *ssa.Call) []*ssa.Function {
+	if callee := call.Call.StaticCallee(); callee != nil {
+		return []*ssa.Function{callee}
+	}
+	var callees []*ssa.Function
+	if node := t.g.CallGraph.Nodes[call.Parent()]; node != nil {
+		for _, edge := range node.Out {
+			if edge.Site == ssa.CallInstruction(call) {
+				callees = append(callees, edge.Callee.Func)
+			}
+		}
+	}
+	return callees
+}
+
+// traceReturns traces the values a function may return at the given index
+func (t *clientTracer) traceReturns(fn *ssa.Function, index int, seen map[ssa.Value]bool) []client {
+	var clients []client
+	for _, block := range fn.Blocks {
+		if ret, ok := block.Instrs[len(block.Instrs)-1].(*ssa.Return); ok && index < len(ret.Results) {
+			clients = append(clients, t.trace(ret.Results[index], seen)...)
+		}
+	}
+	return clients
+}
+
+// traceParameter traces the arguments passed as a parameter from every
+// call site of its function
+func (t *clientTracer) traceParameter(p *ssa.Parameter, seen map[ssa.Value]bool) []client {
+	fn := p.Parent()
+	index := slices.Index(fn.Params, p)
+	node := t.g.CallGraph.Nodes[fn]
+	if node == nil || index < 0 {
+		return nil
+	}
+
+	var clients []client
+	for _, edge := range node.In {
+		if edge.Site == nil {
+			continue
+		}
+		call := edge.Site.Common()
+		var arg ssa.Value
+		if call.IsInvoke() { // the receiver isn't part of the arguments
+			if index == 0 {
+				arg = call.Value
+			} else if index-1 < len(call.Args) {
+				arg = call.Args[index-1]
+			}
+		} else if index < len(call.Args) {
+			arg = call.Args[index]
+		}
+		clients = append(clients, t.trace(arg, seen)...)
+	}
+	return clients
+}
+
+// traceLoad returns the clients a value loaded from an address may
+// originate from
+func (t *clientTracer) traceLoad(addr ssa.Value, seen map[ssa.Value]bool) []client {
+	if fv, ok := addr.(*ssa.FreeVar); ok {
+		// A variable captured by a closure, e.g. one passed to a generic
+		// helper, which is captured by its address
+		var clients []client
+		for _, binding := range freeVarBindings(fv) {
+			clients = append(clients, t.traceLoad(binding, seen)...)
+		}
+		return clients
+	}
+	key := storeKey(addr)
+	if key == nil {
+		// A copy of a client, e.g. one embedded by value
+		return t.trace(addr, seen)
+	}
+	var clients []client
+	for _, stored := range t.stores[key] {
+		clients = append(clients, t.trace(stored, seen)...)
+	}
+	return clients
+}
+
+// traceFreeVar traces the values captured by a closure
+func (t *clientTracer) traceFreeVar(fv *ssa.FreeVar, seen map[ssa.Value]bool) []client {
+	var clients []client
+	for _, binding := range freeVarBindings(fv) {
+		clients = append(clients, t.trace(binding, seen)...)
+	}
+	return clients
+}
+
+// freeVarBindings returns the values the closures a free variable belongs
+// to are created with for it
+func freeVarBindings(fv *ssa.FreeVar) []ssa.Value {
+	fn := fv.Parent()
+	index := slices.Index(fn.FreeVars, fv)
+	if fn.Parent() == nil || index < 0 {
+		return nil
+	}
+
+	var bindings []ssa.Value
+	for _, block := range fn.Parent().Blocks {
+		for _, instr := range block.Instrs {
+			closure, ok := instr.(*ssa.MakeClosure)
+			if ok && closure.Fn == fn && index < len(closure.Bindings) {
+				bindings = append(bindings, closure.Bindings[index])
+			}
+		}
+	}
+	return bindings
+}
+
+// isClientConstructor checks whether a function creates a new AWS SDK
+// service client, e.g. s3.New or s3.NewFromConfig
+func isClientConstructor(fn *ssa.Function) bool {
+	if fn.Pkg == nil || fn.Signature.Recv() != nil {
+		return false
+	}
+	pkgpath := fn.Pkg.Pkg.Path()
+	isServicePackage := strings.HasPrefix(pkgpath, "github.com/aws/aws-sdk-go-v2/service/") ||
+		strings.HasPrefix(pkgpath, "github.com/aws/aws-sdk-go/service/")
+
+	return isServicePackage && (fn.Name() == "New" || fn.Name() == "NewFromConfig")
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// cloudTrailEvent is the part of a CloudTrail event needed to tell which
+// action was used and by whom
+type cloudTrailEvent struct {
+	// e.g. "s3.amazonaws.com"
+	EventSource string `json:"eventSource"`
+	// e.g. "GetObject"
+	EventName    string `json:"eventName"`
+	UserIdentity struct {
+		// e.g. "arn:aws:sts::123456789012:assumed-role/app/session"
+		ARN            string `json:"arn"`
+		SessionContext struct {
+			SessionIssuer struct {
+				// e.g. "arn:aws:iam::123456789012:role/app"
+				ARN string `json:"arn"`
+			} `json:"sessionIssuer"`
+		} `json:"sessionContext"`
+	} `json:"userIdentity"`
+	// e.g. {"bucketName": "reports", "key": "2024/01.csv"}
+	RequestParameters map[string]any `json:"requestParameters"`
+	// The resources of data events and some management events
+	Resources []struct {
+		ARN  string `json:"ARN"`
+		Type string `json:"type"`
+	} `json:"resources"`
+}
+
+// cloudTrailActions returns the sorted, unique IAM actions used in the
+// CloudTrail events in a file, or in all files in a directory. Files are
+// either CloudTrail log files ({"Records": [...]}), JSON arrays or lines
+// of events, or CSV files with eventsource and eventname columns, such as
+// Athena query results. Files may be gzipped. If role, an IAM role ARN or
+// name, is given only the events of that role are included
+func cloudTrailActions(path, role string) ([]string, error) {
+	var actions []string
+	err := walkCloudTrail(path, role, func(e cloudTrailEvent) {
+		if action := e.action(); action != "" && !slices.Contains(actions, action) {
+			actions = append(actions, action)
+		}
+	})
+	slices.Sort(actions)
+	return actions, err
+}
+
+// cloudTrailResources returns the resources the actions were used on in
+// the CloudTrail events in a file or directory, as with cloudTrailActions,
+// by action. An action is left out if any of its events doesn't tell, and
+// the actions of S3 objects are on the whole bucket since the objects seen
+// are rarely all there will be
+func cloudTrailResources(path, role string) (map[string][]string, error) {
+	resources := make(map[string][]string)
+	unknown := make(map[string]bool)
+	err := walkCloudTrail(path, role, func(e cloudTrailEvent) {
+		action := e.action()
+		if action == "" || unknown[action] {
+			return
+		}
+		arns, ok := e.resources(action)
+		if !ok {
+			unknown[action] = true
+			return
+		}
+		resources[action] = append(resources[action], arns...)
+	})
+
+	observed := make(map[string][]string)
+	for action, arns := range resources {
+		if !unknown[action] {
+			slices.Sort(arns)
+			observed[action] = slices.Compact(arns)
+		}
+	}
+	return observed, err
+}
+
+// walkCloudTrail calls visit with each CloudTrail event in a file, or in
+// all files in a directory, made by a role if one is given
+func walkCloudTrail(path, role string, visit func(e cloudTrailEvent)) error {
+	return filepath.WalkDir(path, func(filename string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		events, err := readCloudTrail(filename)
+		if err != nil {
+			return fmt.Errorf("%s: %w", filename, err)
+		}
+		for _, e := range events {
+			if role == "" || e.madeBy(role) {
+				visit(e)
+			}
+		}
+		return nil
+	})
+}
+
+// readCloudTrail reads the CloudTrail events in a file, see
+// cloudTrailActions for the supported formats
+func readCloudTrail(filename string) ([]cloudTrailEvent, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(data, []byte{0x1f, 0x8b}) { // gzip magic number
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		if data, err = io.ReadAll(r); err != nil {
+			return nil, err
+		}
+	}
+
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && trimmed[0] != '{' && trimmed[0] != '[' {
+		return readCloudTrailCSV(trimmed)
+	}
+
+	var events []cloudTrailEvent
+	dec := json.NewDecoder(bytes.NewReader(trimmed))
+	for {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if raw[0] == '[' {
+			var list []cloudTrailEvent
+			if err := json.Unmarshal(raw, &list); err != nil {
+				return nil, err
+			}
+			events = append(events, list...)
+			continue
+		}
+		var value struct {
+			Records []cloudTrailEvent `json:"Records"`
+			cloudTrailEvent
+		}
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return nil, err
+		}
+		if value.Records != nil {
+			events = append(events, value.Records...)
+		} else {
+			events = append(events, value.cloudTrailEvent)
+		}
+	}
+	return events, nil
+}
+
+// readCloudTrailCSV reads CloudTrail events from CSV with a header row. The
+// eventsource and eventname columns are required, a useridentity column is
+// used to tell who made the call and a requestparameters column what it was
+// made on if there are ones
+func readCloudTrailCSV(data []byte) ([]cloudTrailEvent, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	source, name, identity, parameters := -1, -1, -1, -1
+	for i, column := range records[0] {
+		switch strings.ToLower(strings.TrimSpace(column)) {
+		case "eventsource":
+			source = i
+		case "eventname":
+			name = i
+		case "useridentity":
+			identity = i
+		case "requestparameters":
+			parameters = i
+		}
+	}
+	if source == -1 || name == -1 {
+		return nil, errors.New("expected eventsource and eventname columns")
+	}
+
+	var events []cloudTrailEvent
+	for _, record := range records[1:] {
+		if max(source, name, identity, parameters) >= len(record) {
+			continue
+		}
+		var e cloudTrailEvent
+		e.EventSource, e.EventName = record[source], record[name]
+		if identity != -1 {
+			// Athena formats the struct as e.g. "{type=AssumedRole, arn=...}",
+			// keep all of it as it's only searched for the role
+			e.UserIdentity.ARN = record[identity]
+		}
+		if parameters != -1 {
+			// Athena keeps them as JSON, anything else tells no resources
+			_ = json.Unmarshal([]byte(record[parameters]), &e.RequestParameters)
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+// action returns the IAM action an event is for, or an empty string if it
+// isn't an AWS API call. Event names are the names of API operations, so
+// the mapping tells the action for most of them
+func (e cloudTrailEvent) action() string {
+	service, ok := strings.CutSuffix(e.EventSource, ".amazonaws.com")
+	if !ok || e.EventName == "" {
+		return ""
+	}
+	if action := sdkMethodToAction(service + "." + e.EventName); action != "" {
+		return action
+	}
+	return service + ":" + e.EventName
+}
+
+// resources returns the ARNs of the resources an event used an action on,
+// from its request parameters the way they're named in the input of the
+// SDK call (see fieldResources) or else its resources. The key of an S3
+// object is left out, making it any object of the bucket
+func (e cloudTrailEvent) resources(action string) ([]string, bool) {
+	fields := make(map[string][]string)
+	for name, value := range e.RequestParameters {
+		s, ok := value.(string)
+		if !ok || name == "" || name == "key" {
+			continue
+		}
+		if name == "bucketName" {
+			name = "Bucket"
+		}
+		name = strings.ToUpper(name[:1]) + name[1:]
+		fields[name] = []string{s}
+	}
+	if arns, ok := fieldResources(action, fields); ok {
+		return arns, true
+	}
+
+	var arns []string
+	for _, r := range e.Resources {
+		if strings.HasPrefix(r.ARN, "arn:") && r.Type != "AWS::S3::Object" {
+			arns = append(arns, r.ARN)
+		}
+	}
+	return arns, len(arns) > 0
+}
+
+// madeBy returns whether an event was made by a role, given by its ARN or
+// name
+func (e cloudTrailEvent) madeBy(role string) bool {
+	name := role[strings.LastIndex(role, "/")+1:]
+	identity := e.UserIdentity.ARN + " " + e.UserIdentity.SessionContext.SessionIssuer.ARN
+	// Look through every ARN in the identity, which for CSV is all of it
+	arns := strings.FieldsFunc(identity, func(r rune) bool { return strings.ContainsRune(" ,{}=", r) })
+	for _, arn := range arns {
+		// e.g. arn:aws:iam::123456789012:role/path/app or
+		// arn:aws:sts::123456789012:assumed-role/app/session
+		parts := strings.SplitN(arn, ":", 6)
+		if len(parts) != 6 || parts[0] != "arn" {
+			continue
+		}
+		resource := strings.Split(parts[5], "/")
+		switch resource[0] {
+		case "role":
+			if resource[len(resource)-1] == name {
+				return true
+			}
+		case "assumed-role":
+			if len(resource) > 1 && resource[1] == name {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// writeFile writes a file in a temporary directory and returns its path
+func writeFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(filename, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestCloudTrailActions(t *testing.T) {
+	const records = `{"Records": [
+		{"eventSource": "s3.amazonaws.com", "eventName": "GetObject",
+		 "userIdentity": {"arn": "arn:aws:sts::123456789012:assumed-role/app/session",
+		  "sessionContext": {"sessionIssuer": {"arn": "arn:aws:iam::123456789012:role/app"}}}},
+		{"eventSource": "sqs.amazonaws.com", "eventName": "SendMessage",
+		 "userIdentity": {"arn": "arn:aws:iam::123456789012:role/other"}},
+		{"eventSource": "signin.amazonaws.com", "eventName": ""},
+		{"eventSource": "s3.amazonaws.com", "eventName": "GetObject",
+		 "userIdentity": {"arn": "arn:aws:sts::123456789012:assumed-role/app/other-session"}}
+	]}`
+	var gzipped bytes.Buffer
+	w := gzip.NewWriter(&gzipped)
+	w.Write([]byte(records))
+	w.Close()
+
+	tests := []struct {
+		name string
+		file string
+		data []byte
+		role string
+		want []string
+	}{
+		{"log file", "log.json", []byte(records), "", []string{"s3:GetObject", "sqs:SendMessage"}},
+		{"gzipped", "log.json.gz", gzipped.Bytes(), "", []string{"s3:GetObject", "sqs:SendMessage"}},
+		{"role name", "log.json", []byte(records), "app", []string{"s3:GetObject"}},
+		{"role ARN", "log.json", []byte(records), "arn:aws:iam::123456789012:role/other", []string{"sqs:SendMessage"}},
+		{"other role", "log.json", []byte(records), "session", nil},
+		{
+			"array",
+			"events.json",
+			[]byte(`[{"eventSource": "sts.amazonaws.com", "eventName": "GetCallerIdentity"}]`),
+			"",
+			[]string{"sts:GetCallerIdentity"},
+		},
+		{
+			"lines",
+			"events.jsonl",
+			[]byte(`{"eventSource": "sqs.amazonaws.com", "eventName": "SendMessage"}` + "\n" +
+				`{"eventSource": "iam.amazonaws.com", "eventName": "ListRoles"}` + "\n"),
+			"",
+			[]string{"iam:ListRoles", "sqs:SendMessage"},
+		},
+		{
+			"Athena CSV",
+			"results.csv",
+			[]byte("eventtime,EventSource,eventname,useridentity\n" +
+				`2024-01-01T00:00:00Z,s3.amazonaws.com,PutObject,"{type=AssumedRole, arn=arn:aws:sts::123456789012:assumed-role/app/session}"` + "\n" +
+				`2024-01-01T00:00:01Z,iam.amazonaws.com,ListRoles,"{type=IAMUser, arn=arn:aws:iam::123456789012:user/admin}"` + "\n" +
+				"2024-01-01T00:00:02Z,s3.amazonaws.com\n"),
+			"app",
+			[]string{"s3:PutObject"},
+		},
+	}
+
+	loadMap()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actions, err := cloudTrailActions(writeFile(t, tt.file, tt.data), tt.role)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(actions, tt.want) {
+				t.Errorf("cloudTrailActions() = %q, want %q", actions, tt.want)
+			}
+		})
+	}
+}
+
+func TestCloudTrailActionsDirectory(t *testing.T) {
+	loadMap()
+	dir := t.TempDir()
+	for name, data := range map[string]string{
+		"a.json":            `{"Records": [{"eventSource": "sqs.amazonaws.com", "eventName": "SendMessage"}]}`,
+		"2024/01/b.json":    `{"Records": [{"eventSource": "s3.amazonaws.com", "eventName": "GetObject"}]}`,
+		"2024/01/copy.json": `{"Records": [{"eventSource": "s3.amazonaws.com", "eventName": "GetObject"}]}`,
+	} {
+		filename := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(filename), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filename, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	actions, err := cloudTrailActions(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"s3:GetObject", "sqs:SendMessage"}; !slices.Equal(actions, want) {
+		t.Errorf("cloudTrailActions() = %q, want %q", actions, want)
+	}
+}
+
+func TestReadCloudTrailErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"invalid JSON", `{"Records": [`},
+		{"CSV without eventname", "eventsource,useridentity\ns3.amazonaws.com,\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := readCloudTrail(writeFile(t, "log", []byte(tt.data))); err == nil {
+				t.Error("read the events, want an error")
+			}
+		})
+	}
+}
+
+func TestCloudTrailResources(t *testing.T) {
+	loadMap()
+	filename := writeFile(t, "log.json", []byte(`{"Records": [
+		{"eventSource": "s3.amazonaws.com", "eventName": "GetObject",
+		 "requestParameters": {"bucketName": "reports", "key": "2024/01.csv"}},
+		{"eventSource": "s3.amazonaws.com", "eventName": "GetObject",
+		 "requestParameters": {"bucketName": "reports", "key": "2024/02.csv"}},
+		{"eventSource": "s3.amazonaws.com", "eventName": "GetObject",
+		 "requestParameters": {"bucketName": "logs", "key": "today.log"}},
+		{"eventSource": "sqs.amazonaws.com", "eventName": "SendMessage",
+		 "resources": [{"ARN": "arn:aws:sqs:us-east-1:123456789012:jobs", "type": "AWS::SQS::Queue"}]},
+		{"eventSource": "sqs.amazonaws.com", "eventName": "SendMessage"}
+	]}`))
+	resources, err := cloudTrailResources(filename, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// One of the sqs:SendMessage events doesn't tell which queue
+	want := map[string][]string{
+		"s3:GetObject": {"arn:aws:s3:::logs/*", "arn:aws:s3:::reports/*"},
+	}
+	if len(resources) != len(want) {
+		t.Errorf("resources of %d actions, want %d: %q", len(resources), len(want), resources)
+	}
+	for action, arns := range want {
+		if !slices.Equal(resources[action], arns) {
+			t.Errorf("resources of %s = %q, want %q", action, resources[action], arns)
+		}
+	}
+}
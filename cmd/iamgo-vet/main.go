@@ -0,0 +1,16 @@
+// Command iamgo-vet reports the AWS SDK calls in Go packages and the IAM
+// actions they require. It can be run on its own or by go vet:
+//
+//	iamgo-vet ./...
+//	go vet -vettool=$(which iamgo-vet) ./...
+package main
+
+import (
+	"golang.org/x/tools/go/analysis/singlechecker"
+
+	"github.com/esprimo/iamgo/analyzer"
+)
+
+func main() {
+	singlechecker.Main(analyzer.Analyzer)
+}
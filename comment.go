@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// revisionRange splits a range of git revisions, e.g. "main..HEAD", into
+// its base and head. The head defaults to HEAD, and with three dots, e.g.
+// "main...HEAD", the base is where the head branched off, as in a pull
+// request
+func revisionRange(s string) (base, head string, err error) {
+	base, head, ok := strings.Cut(s, "..")
+	if !ok || base == "" {
+		return "", "", fmt.Errorf("expected a range of git revisions such as 'main..HEAD', got %q", s)
+	}
+	head, mergeBase := strings.CutPrefix(head, ".")
+	if head == "" {
+		head = "HEAD"
+	}
+	if mergeBase {
+		if base, err = git("merge-base", base, head); err != nil {
+			return "", "", err
+		}
+	}
+	return base, head, nil
+}
+
+// writeComment writes a Markdown comment for a pull request that lists the
+// IAM actions, or SDK calls, added and removed between two versions of a
+// program, with a call path to each added one
+func writeComment(w io.Writer, old, new report, graph *graph, sdkCalls bool) {
+	noun, oldItems, newItems := "IAM action", old.Actions, new.Actions
+	if sdkCalls {
+		noun, oldItems, newItems = "SDK call", old.SDKCalls, new.SDKCalls
+	}
+	var added, removed []string
+	for _, item := range newItems {
+		if !slices.Contains(oldItems, item) {
+			added = append(added, item)
+		}
+	}
+	for _, item := range oldItems {
+		if !slices.Contains(newItems, item) {
+			removed = append(removed, item)
+		}
+	}
+
+	fmt.Fprintf(w, "### %ss\n\n", noun)
+	if len(added) == 0 && len(removed) == 0 {
+		fmt.Fprintf(w, "This change doesn't add or remove any required %ss.\n", noun)
+		return
+	}
+	if len(added) > 0 {
+		fmt.Fprintf(w, "This change requires %s:\n\n", plural(len(added), "new "+noun))
+		for _, item := range added {
+			fmt.Fprintf(w, "- `%s`\n", item)
+		}
+		fmt.Fprintln(w)
+	}
+	if len(removed) > 0 {
+		fmt.Fprintf(w, "It no longer requires %s:\n\n", plural(len(removed), noun))
+		for _, item := range removed {
+			fmt.Fprintf(w, "- `%s`\n", item)
+		}
+		fmt.Fprintln(w)
+	}
+
+	for _, query := range added {
+		paths, err := graph.whyPaths(query, whyOptions{})
+		if err != nil || len(paths) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "<details>\n<summary>Why <code>%s</code> is required</summary>\n\n```text\n", query)
+		res := graph.whyResult(query, paths[:1])
+		fmt.Fprintln(w, res.Paths[0].Root)
+		for _, s := range res.Paths[0].Steps {
+			if s.CallSite.Filename == "" {
+				fmt.Fprintf(w, "  %s\n", s.Callee)
+				continue
+			}
+			fmt.Fprintf(w, "  %s:%d  %s\n", graph.relPath(s.CallSite.Filename), s.CallSite.Line, s.Callee)
+		}
+		fmt.Fprint(w, "```\n\n</details>\n\n")
+	}
+}
+
+// plural returns a count followed by a noun, pluralized if needed
+func plural(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+// relPath returns a filename relative to the directory the program was
+// loaded from, or as-is if it's outside of it
+func (g *graph) relPath(filename string) string {
+	dir := g.dir
+	if dir == "" {
+		dir, _ = os.Getwd()
+	}
+	rel, err := filepath.Rel(dir, filename)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return filename
+	}
+	return filepath.ToSlash(rel)
+}
+
+// postComment posts a comment to a pull request on GitHub or a merge
+// request on GitLab. The token is read from GITHUB_TOKEN or GITLAB_TOKEN.
+// The repository (e.g. "org/app" on GitHub or a project ID or path on
+// GitLab) and the number of the request default to the ones in the CI
+// environment, and the API URL to the one set by the CI or the public one
+func postComment(host, repo string, number int, body string) error {
+	var api, tokenVar, endpoint string
+	header := make(http.Header)
+	switch host {
+	case "github":
+		api, tokenVar = os.Getenv("GITHUB_API_URL"), "GITHUB_TOKEN"
+		if api == "" {
+			api = "https://api.github.com"
+		}
+		if repo == "" {
+			repo = os.Getenv("GITHUB_REPOSITORY")
+		}
+		if number == 0 {
+			// e.g. refs/pull/42/merge
+			if ref, ok := strings.CutPrefix(os.Getenv("GITHUB_REF"), "refs/pull/"); ok {
+				number, _ = strconv.Atoi(strings.TrimSuffix(ref, "/merge"))
+			}
+		}
+		endpoint = fmt.Sprintf("%s/repos/%s/issues/%d/comments", api, repo, number)
+		header.Set("Accept", "application/vnd.github+json")
+		header.Set("Authorization", "Bearer "+os.Getenv(tokenVar))
+	case "gitlab":
+		api, tokenVar = os.Getenv("CI_API_V4_URL"), "GITLAB_TOKEN"
+		if api == "" {
+			api = "https://gitlab.com/api/v4"
+		}
+		if repo == "" {
+			repo = os.Getenv("CI_PROJECT_ID")
+		}
+		if number == 0 {
+			number, _ = strconv.Atoi(os.Getenv("CI_MERGE_REQUEST_IID"))
+		}
+		endpoint = fmt.Sprintf("%s/projects/%s/merge_requests/%d/notes", api, url.PathEscape(repo), number)
+		header.Set("PRIVATE-TOKEN", os.Getenv(tokenVar))
+	default:
+		return fmt.Errorf("unknown host %q, must be github or gitlab", host)
+	}
+	if os.Getenv(tokenVar) == "" {
+		return fmt.Errorf("%s is not set", tokenVar)
+	}
+	if repo == "" || number == 0 {
+		return errors.New("the repository and request number are needed, set -repo and -pr outside of CI")
+	}
+
+	data, err := json.Marshal(struct {
+		Body string `json:"body"`
+	}{body})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header = header
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s: %s", endpoint, resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
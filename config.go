@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/esprimo/iamgo/internal/libraries"
+)
+
+// configFilename is the name of the project configuration file, which is
+// looked for at the root of the module
+const configFilename = ".iamgo.yaml"
+
+// config is the project configuration. It provides defaults for flags that
+// aren't given on the command line, so CI jobs don't need long flag lists
+//
+// For example:
+//
+//	tags: integration
+//	exclude:
+//	  - github.com/org/app/examples/...
+//	format: policy
+//	target: ecs
+//	resources:
+//	  s3:GetObject: arn:aws:s3:::my-bucket/*
+//	  "dynamodb:*":
+//	    - arn:aws:dynamodb:*:*:table/users
+//	    - arn:aws:dynamodb:*:*:table/orders
+//	controls:
+//	  "iam:*": [SOC2-CC6.3, CIS-1.16]
+//	suppress:
+//	  - sts:GetCallerIdentity
+//	plugins:
+//	  - ./tools/iamgo-awsx
+//	libraries:
+//	  - name: acme queue
+//	    packages: [github.com/acme/queue/...]
+//	    actions: [s3:PutObject, s3:GetObject]
+type config struct {
+	// Same as -tags
+	Tags string `yaml:"tags"`
+	// Same as -exclude, in addition to the ones given on the command line
+	Exclude []string `yaml:"exclude"`
+	// Same as -format
+	Format string `yaml:"format"`
+	// Same as -target
+	Target string `yaml:"target"`
+	// Same as -driver. A relative path is relative to the directory of the
+	// file. Only read from a file given with -config, see loadConfig
+	Driver string `yaml:"driver"`
+	// The resources to allow each action on in a generated policy, keyed by
+	// action pattern (e.g. "s3:Get*"). Actions matching none are allowed
+	// on all resources
+	Resources map[string]stringList `yaml:"resources"`
+	// Compliance controls, e.g. SOC 2 or CIS control IDs, keyed by action
+	// pattern, to tag the actions matching each with in the JSON report
+	Controls map[string]stringList `yaml:"controls"`
+	// Action patterns to leave out of the result, e.g. actions granted to
+	// every role anyway
+	Suppress []string `yaml:"suppress"`
+	// Same as -plugin, in addition to the ones given on the command line.
+	// Relative paths are relative to the directory of the file. Only read
+	// from a file given with -config, see loadConfig
+	Plugins []string `yaml:"plugins"`
+	// Helper libraries that need actions the SDK calls found don't show, in
+	// addition to the ones iamgo knows about
+	Libraries []libraries.Library `yaml:"libraries"`
+	// Same as -webhook, in addition to the ones given on the command line
+	Webhooks []string `yaml:"webhooks"`
+}
+
+// stringList is a list of strings in YAML that may also be written as a
+// single string
+type stringList []string
+
+func (l *stringList) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*l = []string{value.Value}
+		return nil
+	}
+	var list []string
+	if err := value.Decode(&list); err != nil {
+		return err
+	}
+	*l = list
+	return nil
+}
+
+// loadConfig reads the project configuration from a file. If filename is
+// empty, the .iamgo.yaml at the root of the module in the working directory
+// is read if there is one. Returns an empty config if there's no file
+//
+// The .iamgo.yaml of the module changes with the code, e.g. in a pull
+// request analyzed in CI, so the plugins and package driver it names aren't
+// run: they're only read from a file given explicitly
+func loadConfig(filename string) (config, error) {
+	var cfg config
+	found := filename == ""
+	if found {
+		filename = findConfig()
+		if filename == "" {
+			return cfg, nil
+		}
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return cfg, err
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true) // catch typos in option names
+	if err := dec.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+		return cfg, fmt.Errorf("%s: %w", filename, err)
+	}
+
+	switch cfg.Format {
+	case "", "text", "json", "json-full", "policy", "terraform", "terraform-role", "cloudformation", "eks", "boundary", "scp":
+	default:
+		return cfg, fmt.Errorf("%s: unknown format %q, must be text, json, json-full, policy, terraform, terraform-role, cloudformation, eks, boundary or scp", filename, cfg.Format)
+	}
+	if _, ok := libraries.Baselines[cfg.Target]; !ok && cfg.Target != "" {
+		return cfg, fmt.Errorf("%s: unknown target %q, must be one of: %s", filename, cfg.Target, strings.Join(baselineTargets(), ", "))
+	}
+	for _, lib := range cfg.Libraries {
+		if lib.Name == "" || len(lib.Packages) == 0 || len(lib.Actions) == 0 {
+			return cfg, fmt.Errorf("%s: every library needs a name, packages and actions", filename)
+		}
+	}
+	// Relative paths are made absolute, so that e.g. "./iamgo-awsx" in
+	// "-config .iamgo.yaml" isn't looked up in the PATH
+	dir, err := filepath.Abs(filepath.Dir(filename))
+	if err != nil {
+		return cfg, err
+	}
+	if strings.HasPrefix(cfg.Driver, "./") || strings.HasPrefix(cfg.Driver, "../") {
+		cfg.Driver = filepath.Join(dir, cfg.Driver)
+	}
+	if found && len(cfg.Plugins) > 0 {
+		slog.Warn("ignored the plugins of " + filename + ", which would run whatever the code names, give it with -config to run them")
+		cfg.Plugins = nil
+	}
+	if found && cfg.Driver != "" {
+		slog.Warn("ignored the driver of " + filename + ", which would run whatever the code names, give it with -config to load packages with it")
+		cfg.Driver = ""
+	}
+	for i, command := range cfg.Plugins {
+		if strings.HasPrefix(command, "./") || strings.HasPrefix(command, "../") {
+			cfg.Plugins[i] = filepath.Join(dir, command)
+		}
+	}
+	return cfg, nil
+}
+
+// findConfig returns the path of the configuration file at the root of the
+// module, or Bazel workspace, in the working directory, or an empty string
+// if there's none
+func findConfig() string {
+	dir, err := os.Getwd()
+	if err != nil {
+		return ""
+	}
+	for {
+		if isRoot(dir) {
+			filename := filepath.Join(dir, configFilename)
+			if _, err := os.Stat(filename); err != nil {
+				return ""
+			}
+			return filename
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "" // not in a module
+		}
+		dir = parent
+	}
+}
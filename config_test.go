@@ -0,0 +1,46 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfigCommands(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"go.mod":       "module example.com/app\n",
+		configFilename: "plugins:\n  - ./iamgo-awsx\ndriver: ./gopackagesdriver.sh\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	cfg, err := loadConfig("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Plugins) != 0 || cfg.Driver != "" {
+		t.Errorf("the %s found in the module has plugins %q and driver %q, want none", configFilename, cfg.Plugins, cfg.Driver)
+	}
+
+	cfg, err = loadConfig(configFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, "iamgo-awsx"); len(cfg.Plugins) != 1 || cfg.Plugins[0] != want {
+		t.Errorf("the plugins of -config %s are %q, want [%q]", configFilename, cfg.Plugins, want)
+	}
+	if want := filepath.Join(dir, "gopackagesdriver.sh"); cfg.Driver != want {
+		t.Errorf("the driver of -config %s is %q, want %q", configFilename, cfg.Driver, want)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"go/types"
+	"slices"
+
+	"golang.org/x/tools/go/ssa"
+	"golang.org/x/tools/go/ssa/ssautil"
+)
+
+// deadCall is a place in the analyzed packages that refers to an SDK
+// method, usually to call it, from a function that isn't reachable from
+// any root, the output of -dead
+type deadCall struct {
+	SDKCall string `json:"sdk_call"`
+	// Empty if the SDK method doesn't require an action
+	Action   string   `json:"action,omitempty"`
+	Function string   `json:"function"`
+	Position position `json:"position"`
+}
+
+// deadCalls returns the references to SDK methods in functions of the
+// analyzed packages that aren't reachable from any root, sorted by SDK
+// method and position. Functions only reachable through reflection count
+// as reachable if includeReflection is set
+func (g *graph) deadCalls(includeReflection bool) []deadCall {
+	analyzed := make(map[*ssa.Package]bool)
+	for _, pkg := range g.Packages {
+		analyzed[pkg] = true
+	}
+	reachable := make(map[*ssa.Function]bool)
+	if includeReflection {
+		for fn := range g.Reachable {
+			reachable[fn] = true
+		}
+	} else {
+		for fn := range g.Visit(nil) {
+			reachable[fn] = true
+		}
+	}
+
+	var calls []deadCall
+	for fn := range ssautil.AllFunctions(g.Prog) {
+		if fn.Pkg == nil || !analyzed[fn.Pkg] || fn.Synthetic != "" || reachable[fn] {
+			continue
+		}
+		for _, b := range fn.Blocks {
+			for _, instr := range b.Instrs {
+				for _, op := range instr.Operands(nil) {
+					callee, ok := (*op).(*ssa.Function)
+					if !ok {
+						continue
+					}
+					// Method values are bound wrappers of the method
+					if obj, ok := callee.Object().(*types.Func); ok && callee.Synthetic != "" {
+						callee = g.Prog.FuncValue(obj)
+					}
+					if orig := callee.Origin(); orig != nil {
+						callee = orig
+					}
+					if callee == nil || g.detectors.Version(callee) == "" || !g.IncludesService(callee) {
+						continue
+					}
+					method := g.detectors.MethodName(callee)
+					pos := g.Prog.Fset.Position(instr.Pos())
+					calls = append(calls, deadCall{
+						SDKCall:  method,
+						Action:   sdkMethodToAction(method),
+						Function: fn.String(),
+						Position: position{Filename: g.displayPath(pos.Filename), Line: pos.Line, Column: pos.Column},
+					})
+				}
+			}
+		}
+	}
+	slices.SortFunc(calls, func(a, b deadCall) int {
+		if c := cmp.Compare(a.SDKCall, b.SDKCall); c != 0 {
+			return c
+		}
+		if c := cmp.Compare(a.Position.Filename, b.Position.Filename); c != 0 {
+			return c
+		}
+		if c := cmp.Compare(a.Position.Line, b.Position.Line); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Position.Column, b.Position.Column)
+	})
+	return calls
+}
+
+// printDeadCalls outputs the references of deadCalls grouped by SDK method,
+// each followed by the action it requires
+func printDeadCalls(calls []deadCall) {
+	for i, call := range calls {
+		if i == 0 || calls[i-1].SDKCall != call.SDKCall {
+			if i > 0 {
+				fmt.Println()
+			}
+			if call.Action != "" {
+				fmt.Printf("%s (%s):\n", call.SDKCall, call.Action)
+			} else {
+				fmt.Printf("%s:\n", call.SDKCall)
+			}
+		}
+		fmt.Printf("    %s:%d in %s\n", call.Position.Filename, call.Position.Line, call.Function)
+	}
+}
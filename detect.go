@@ -0,0 +1,56 @@
+package main
+
+import (
+	"log/slog"
+	"slices"
+	"strings"
+
+	"golang.org/x/tools/go/callgraph"
+	"golang.org/x/tools/go/ssa"
+)
+
+// logDetection outputs why each function of an SDK that the analyzed
+// packages call is or isn't recognized as an API call, for -debug-detect.
+// Calls the SDK makes within itself are left out, there are far too many of
+// them to be useful
+func (g *graph) logDetection() {
+	analyzed := make(map[*ssa.Package]bool)
+	for _, pkg := range g.Packages {
+		analyzed[pkg] = true
+	}
+	var fns []*ssa.Function
+	for fn := range g.Reachable {
+		node := g.CallGraph.Nodes[fn]
+		if node == nil || fn.Synthetic != "" || len(g.detectors.Explain(fn)) == 0 {
+			continue
+		}
+		if slices.ContainsFunc(node.In, func(e *callgraph.Edge) bool {
+			return e.Caller.Func.Pkg != nil && analyzed[e.Caller.Func.Pkg]
+		}) {
+			fns = append(fns, fn)
+		}
+	}
+	slices.SortFunc(fns, func(a, b *ssa.Function) int { return strings.Compare(a.String(), b.String()) })
+
+	for _, fn := range fns {
+		reasons := g.detectors.Explain(fn)
+		names := make([]string, 0, len(reasons))
+		for name := range reasons {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		args := []any{"func", fn.String()}
+		for _, name := range names {
+			args = append(args, name, reasons[name])
+		}
+		if d, method := g.detectors.Detect(fn); d != nil {
+			action := sdkMethodToAction(method)
+			if action == "" {
+				action = "none in the mapping"
+			}
+			slog.Info("detected "+method, append(args, "action", action)...)
+		} else {
+			slog.Info("not an SDK call", args...)
+		}
+	}
+}
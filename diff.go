@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/esprimo/iamgo/schema"
+)
+
+// printDiff outputs the IAM actions, or SDK calls, added and removed between
+// two versions of a program, followed by a call path to each added one if
+// the graph of the newer version is known
+func printDiff(old, new report, graph *graph, sdkCalls bool) {
+	var added []string
+	if sdkCalls {
+		added = diffActions(os.Stdout, old.SDKCalls, new.SDKCalls)
+	} else {
+		added = diffActions(os.Stdout, old.Actions, new.Actions)
+	}
+	if graph == nil {
+		return
+	}
+
+	// Show where the new actions come from, which is what a reviewer needs
+	// to judge them
+	for _, query := range added {
+		paths, err := graph.whyPaths(query, whyOptions{})
+		if err != nil {
+			slog.Warn("failed to find a call path", "query", query, "err", err)
+			continue
+		}
+		fmt.Printf("\n%s:\n", query)
+		graph.printPath(paths[0])
+	}
+}
+
+// graphReport returns the sorted, unique SDK calls and IAM actions of a
+// program
+func graphReport(graph *graph, includeReflection bool, suppress []string) report {
+	var sdkMethods []string
+	for _, fn := range graph.SDKCalls(includeReflection) {
+		sdkMethods = append(sdkMethods, graph.detectors.MethodName(fn))
+	}
+	slices.Sort(sdkMethods)
+	return report{
+		SchemaVersion: schema.Version,
+		Actions:       actionSet(graph, includeReflection, suppress),
+		SDKCalls:      slices.Compact(sdkMethods),
+	}
+}
+
+// pathReport returns the report of a directory, by analyzing all packages
+// in it, or reads a report saved with -format json. The graph is nil for
+// saved reports
+func pathReport(ctx context.Context, config analyzeConfig, path string, includeReflection bool, suppress []string) (report, *graph) {
+	info, err := os.Stat(path)
+	if err != nil {
+		fatal(codeRead, "failed to compare", "err", err)
+	}
+	if !info.IsDir() {
+		r, err := readReport(path)
+		if err != nil {
+			fatal(codeRead, "failed to read report", "file", path, "err", err)
+		}
+		return r, nil
+	}
+
+	config.dir = path
+	config.patterns = []string{"./..."}
+	graph, err := analyze(ctx, config)
+	if err != nil {
+		fatalFailure(codeLoad, "failed to analyze", err)
+	}
+	return graphReport(graph, includeReflection, suppress), graph
+}
+
+// readReport reads a report saved with -format json
+func readReport(filename string) (report, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return report{}, err
+	}
+	defer f.Close()
+	r, err := schema.Read(f)
+	if err != nil {
+		return r, err
+	}
+	slices.Sort(r.Actions)
+	slices.Sort(r.SDKCalls)
+	r.Actions = slices.Compact(r.Actions)
+	r.SDKCalls = slices.Compact(r.SDKCalls)
+	return r, nil
+}
+
+// revisionGraph analyzes the program at a git revision. The worktree it's
+// checked out in is removed before returning, also when the analysis fails,
+// so errors are failures for the caller to exit with
+func revisionGraph(ctx context.Context, config analyzeConfig, rev string) (*graph, error) {
+	dir, remove, err := worktree(rev)
+	if err != nil {
+		return nil, &failure{codeExternal, "failed to check out revision", []any{"rev", rev, "err", err}}
+	}
+	defer remove()
+	config.dir = dir
+	graph, err := analyze(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	// Ignore comments are read from the source, so find them before the
+	// worktree is removed
+	graph.IgnoredCalls()
+	return graph, nil
+}
+
+// worktree checks out a git revision in a temporary worktree of the
+// repository in the working directory. Returns the directory in it that
+// corresponds to the working directory, and a function that removes it
+func worktree(rev string) (string, func(), error) {
+	top, err := git("rev-parse", "--show-toplevel")
+	if err != nil {
+		return "", nil, err
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return "", nil, err
+	}
+	rel, err := filepath.Rel(top, cwd)
+	if err != nil {
+		return "", nil, err
+	}
+
+	tmp, err := os.MkdirTemp("", "iamgo-")
+	if err != nil {
+		return "", nil, err
+	}
+	if _, err := git("worktree", "add", "--detach", tmp, rev); err != nil {
+		os.RemoveAll(tmp)
+		return "", nil, err
+	}
+	remove := func() {
+		if _, err := git("worktree", "remove", "--force", tmp); err != nil {
+			slog.Warn("failed to remove worktree", "dir", tmp, "err", err)
+		}
+	}
+	return filepath.Join(tmp, rel), remove, nil
+}
+
+// git runs a git command and returns its trimmed output
+func git(args ...string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("git", args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errorCode tells tools wrapping iamgo what kind of failure happened,
+// without having to parse the message
+type errorCode string
+
+const (
+	// The flags or arguments are invalid
+	codeUsage errorCode = "usage"
+	// The project configuration couldn't be read
+	codeConfig errorCode = "config"
+	// The packages couldn't be loaded, or have errors
+	codeLoad errorCode = "load"
+	// No main packages were found to start the analysis from
+	codeNoMain errorCode = "no_main"
+	// No AWS SDK calls were found
+	codeNoSDKCalls errorCode = "no_sdk_calls"
+	// The SDK calls found don't map to any IAM actions
+	codeNoActions errorCode = "no_actions"
+	// A -why query matched nothing
+	codeNotFound errorCode = "not_found"
+	// An input file, e.g. a policy or lockfile, couldn't be read
+	codeRead errorCode = "read"
+	// An output couldn't be written
+	codeWrite errorCode = "write"
+	// The code needs actions that aren't allowed, e.g. by the lockfile
+	codeUnexpectedActions errorCode = "unexpected_actions"
+	// git, AWS or another external service failed
+	codeExternal errorCode = "external"
+	// The analysis took longer than -timeout
+	codeTimeout errorCode = "timeout"
+)
+
+// Exit codes, so that CI pipelines can tell what kind of result a run had
+// without parsing stderr
+const (
+	// Success, also when there's nothing to check
+	exitOK = 0
+	// Invalid flags or arguments, or a failure of iamgo or of something it
+	// depends on, e.g. a file that couldn't be read
+	exitError = 1
+	// The packages couldn't be loaded
+	exitLoad = 2
+	// A check failed, e.g. the code needs actions a policy doesn't allow
+	exitViolation = 3
+	// Nothing was found, e.g. no SDK calls or nothing matching a -why query
+	exitNotFound = 4
+)
+
+// exitCode returns the exit code for a failure
+func (c errorCode) exitCode() int {
+	switch c {
+	case codeLoad, codeNoMain:
+		return exitLoad
+	case codeUnexpectedActions:
+		return exitViolation
+	case codeNoSDKCalls, codeNoActions, codeNotFound:
+		return exitNotFound
+	}
+	return exitError
+}
+
+// jsonErrors makes fatal also write the error to stdout as JSON, in place
+// of the result, for -format json
+var jsonErrors bool
+
+// errorReport is the JSON output of a failure with -format json
+type errorReport struct {
+	Error struct {
+		Code    errorCode      `json:"code"`
+		Message string         `json:"message"`
+		Details map[string]any `json:"details,omitempty"`
+	} `json:"error"`
+}
+
+// failure is an error with the code and details fatal reports it with,
+// returned instead of exiting by what the long-running commands use, e.g.
+// analyze, so a failure in them doesn't stop the server
+type failure struct {
+	code errorCode
+	msg  string
+	args []any
+}
+
+func (f *failure) Error() string {
+	var b strings.Builder
+	b.WriteString(f.msg)
+	for i := 0; i+1 < len(f.args); i += 2 {
+		fmt.Fprintf(&b, " %v=%v", f.args[i], f.args[i+1])
+	}
+	return b.String()
+}
+
+// fatalFailure exits like fatal with the code and details of err if it's
+// a failure, or with code and msg if it isn't
+func fatalFailure(code errorCode, msg string, err error) {
+	var f *failure
+	if errors.As(err, &f) {
+		fatal(f.code, f.msg, f.args...)
+	}
+	fatal(code, msg, "err", err)
+}
+
+// fatalMu makes sure only one error is reported when fatal is called from
+// several goroutines, e.g. by -timeout while another step fails
+var fatalMu sync.Mutex
+
+// fatal logs an error and exits with the exit code of its code. With
+// -format json the error is written to stdout as an errorReport too
+func fatal(code errorCode, msg string, args ...any) {
+	fatalMu.Lock() // never unlocked, the program exits
+	slog.Error(msg, append([]any{"code", code}, args...)...)
+	if jsonErrors {
+		writeErrorReport(os.Stdout, code, msg, args...)
+	}
+	os.Exit(code.exitCode())
+}
+
+// writeErrorReport writes an errorReport with the attributes of a log
+// record as details
+func writeErrorReport(w io.Writer, code errorCode, msg string, args ...any) {
+	var rep errorReport
+	rep.Error.Code = code
+	rep.Error.Message = msg
+
+	r := slog.NewRecord(time.Time{}, slog.LevelError, msg, 0)
+	r.Add(args...)
+	r.Attrs(func(a slog.Attr) bool {
+		if rep.Error.Details == nil {
+			rep.Error.Details = make(map[string]any)
+		}
+		value := a.Value.Any()
+		if err, ok := value.(error); ok {
+			value = err.Error()
+		}
+		rep.Error.Details[a.Key] = value
+		return true
+	})
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(rep)
+}
+
+// newJSONLogHandler returns a handler that writes diagnostics as JSON
+// lines, for -format json
+func newJSONLogHandler(w io.Writer, level slog.Leveler) slog.Handler {
+	return slog.NewJSONHandler(w, &slog.HandlerOptions{
+		Level: level,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey && len(groups) == 0 {
+				return slog.Attr{} // not useful for a command
+			}
+			return a
+		},
+	})
+}
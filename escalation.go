@@ -0,0 +1,90 @@
+package main
+
+import (
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/esprimo/iamgo/internal/policy"
+)
+
+// escalation is a set of actions that together let whoever has them give
+// themselves more permissions than they were granted, e.g. by attaching a
+// policy to their own role
+type escalation struct {
+	actions []string
+	// How the permissions are escalated
+	how string
+	// Whether it's only a risk if the actions are allowed on all resources,
+	// e.g. sts:AssumeRole of any role rather than of a certain one
+	wildcard bool
+}
+
+// escalations are the known combinations of actions that allow privilege
+// escalation in AWS
+var escalations = []escalation{
+	{actions: []string{"iam:CreatePolicyVersion"}, how: "may replace a policy attached to it with one that allows everything"},
+	{actions: []string{"iam:SetDefaultPolicyVersion"}, how: "may switch a policy attached to it to a version that allows more"},
+	{actions: []string{"iam:AttachUserPolicy"}, how: "may attach any policy, e.g. AdministratorAccess, to a user"},
+	{actions: []string{"iam:AttachGroupPolicy"}, how: "may attach any policy, e.g. AdministratorAccess, to a group"},
+	{actions: []string{"iam:AttachRolePolicy"}, how: "may attach any policy, e.g. AdministratorAccess, to a role"},
+	{actions: []string{"iam:PutUserPolicy"}, how: "may add an inline policy that allows everything to a user"},
+	{actions: []string{"iam:PutGroupPolicy"}, how: "may add an inline policy that allows everything to a group"},
+	{actions: []string{"iam:PutRolePolicy"}, how: "may add an inline policy that allows everything to a role"},
+	{actions: []string{"iam:AddUserToGroup"}, how: "may add a user to a group with more permissions"},
+	{actions: []string{"iam:CreateAccessKey"}, how: "may create access keys for another user"},
+	{actions: []string{"iam:CreateLoginProfile"}, how: "may set a console password for another user"},
+	{actions: []string{"iam:UpdateLoginProfile"}, how: "may change the console password of another user"},
+	{actions: []string{"iam:UpdateAssumeRolePolicy", "sts:AssumeRole"}, how: "may let itself assume any role and then assume it"},
+	{actions: []string{"iam:PassRole", "lambda:CreateFunction"}, how: "may create a Lambda function that runs with a role with more permissions"},
+	{actions: []string{"lambda:UpdateFunctionCode"}, how: "may change the code of a Lambda function that runs with a role with more permissions"},
+	{actions: []string{"iam:PassRole", "ec2:RunInstances"}, how: "may start an instance with a role with more permissions"},
+	{actions: []string{"iam:PassRole", "ecs:RegisterTaskDefinition", "ecs:RunTask"}, how: "may run an ECS task with a role with more permissions"},
+	{actions: []string{"iam:PassRole", "cloudformation:CreateStack"}, how: "may create a stack that uses a role with more permissions"},
+	{actions: []string{"iam:PassRole", "glue:CreateDevEndpoint"}, how: "may create a Glue development endpoint with a role with more permissions"},
+	{actions: []string{"glue:UpdateDevEndpoint"}, how: "may add an SSH key to a Glue development endpoint that has a role with more permissions"},
+	{actions: []string{"iam:PassRole", "datapipeline:CreatePipeline"}, how: "may create a data pipeline that runs with a role with more permissions"},
+	{actions: []string{"iam:PassRole", "sagemaker:CreateNotebookInstance"}, how: "may create a SageMaker notebook with a role with more permissions"},
+	{actions: []string{"iam:PassRole", "codebuild:CreateProject"}, how: "may create a CodeBuild project that runs with a role with more permissions"},
+	{actions: []string{"ssm:SendCommand"}, how: "may run commands on instances whose roles have more permissions"},
+	{actions: []string{"sts:AssumeRole"}, how: "may assume any role that trusts the account", wildcard: true},
+}
+
+// escalationRisks returns the known privilege escalations the actions
+// allow. The ones that are only a risk on all resources are left out if
+// the config narrows the resources of their actions
+func escalationRisks(actions []string, resources map[string]stringList) []escalation {
+	var risks []escalation
+	for _, e := range escalations {
+		all := true
+		for _, action := range e.actions {
+			if !slices.Contains(actions, action) {
+				all = false
+				break
+			}
+			if arns := policy.Resources(action, resourcePatterns(resources)); e.wildcard && len(arns) > 0 && !slices.Contains(arns, "*") {
+				all = false
+				break
+			}
+		}
+		if all {
+			risks = append(risks, e)
+		}
+	}
+	return risks
+}
+
+// logEscalationRisks warns about the privilege escalations the actions
+// allow, with where the code calls the SDK for each action, so they're
+// seen before the policy is deployed
+func (g *graph) logEscalationRisks(risks []escalation) {
+	for _, risk := range risks {
+		args := []any{"actions", strings.Join(risk.actions, ",")}
+		for _, action := range risk.actions {
+			if call := g.codeCallSite(action); call != "" {
+				args = append(args, action, call)
+			}
+		}
+		slog.Warn("privilege escalation risk: the role "+risk.how, args...)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"slices"
+	"strings"
+
+	"golang.org/x/tools/go/callgraph"
+)
+
+// exportedGraph is the JSON format of -export-graph
+type exportedGraph struct {
+	Nodes []exportedNode `json:"nodes"`
+	Edges []exportedEdge `json:"edges"`
+}
+
+// exportedNode is a function in the exported graph
+type exportedNode struct {
+	ID int `json:"id"`
+	// Full name of the function
+	Name     string   `json:"name"`
+	Package  string   `json:"package"`
+	Position position `json:"position"`
+	// Whether the function is a main or init function of a main package
+	Root bool `json:"root,omitempty"`
+	// The SDK method and the IAM action it requires, if it's an SDK call
+	SDKMethod string `json:"sdk_method,omitempty"`
+	Action    string `json:"action,omitempty"`
+}
+
+// exportedEdge is a call from one function to another in the exported graph
+type exportedEdge struct {
+	// IDs of the calling and called functions
+	Caller int `json:"caller"`
+	Callee int `json:"callee"`
+	// What kind of call it is, e.g. "static method call"
+	CallType string `json:"call_type"`
+	// Where the call happens
+	CallSite position `json:"call_site"`
+}
+
+// exportGraph writes the part of the call graph that's on a path between a
+// root and an AWS SDK call as JSON to a file
+func (g *graph) exportGraph(filename string) error {
+	cg := g.PathGraph()
+
+	// Nodes reachable from a root...
+	forward := make(map[*callgraph.Node]bool)
+	for fn := range g.Visit(nil) {
+		if node := cg.Nodes[fn]; node != nil {
+			forward[node] = true
+		}
+	}
+	// ...that can reach an SDK call
+	keep := make(map[*callgraph.Node]bool)
+	var queue []*callgraph.Node
+	for node := range forward {
+		if node.Func.Pkg != nil && g.detectors.Version(node.Func) != "" {
+			keep[node] = true
+			queue = append(queue, node)
+		}
+	}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, edge := range current.In {
+			if forward[edge.Caller] && !keep[edge.Caller] {
+				keep[edge.Caller] = true
+				queue = append(queue, edge.Caller)
+			}
+		}
+	}
+
+	// Sort by name so the IDs are the same between runs
+	nodes := make([]*callgraph.Node, 0, len(keep))
+	for node := range keep {
+		nodes = append(nodes, node)
+	}
+	slices.SortFunc(nodes, func(a, b *callgraph.Node) int {
+		if c := strings.Compare(a.Func.String(), b.Func.String()); c != 0 {
+			return c
+		}
+		return a.ID - b.ID
+	})
+
+	out := exportedGraph{Nodes: []exportedNode{}, Edges: []exportedEdge{}}
+	ids := make(map[*callgraph.Node]int)
+	for i, node := range nodes {
+		ids[node] = i
+		pos := g.Prog.Fset.Position(node.Func.Pos())
+		n := exportedNode{
+			ID:       i,
+			Name:     cleanName(node.Func),
+			Position: position{Filename: g.displayPath(pos.Filename), Line: pos.Line, Column: pos.Column},
+			Root:     slices.Contains(g.Roots, node.Func),
+		}
+		if node.Func.Pkg != nil {
+			n.Package = node.Func.Pkg.Pkg.Path()
+			if g.detectors.Version(node.Func) != "" {
+				n.SDKMethod = g.detectors.MethodName(node.Func)
+				n.Action = sdkMethodToAction(n.SDKMethod)
+			}
+		}
+		out.Nodes = append(out.Nodes, n)
+	}
+	for _, node := range nodes {
+		for _, edge := range node.Out {
+			if !keep[edge.Callee] {
+				continue
+			}
+			e := exportedEdge{
+				Caller:   ids[edge.Caller],
+				Callee:   ids[edge.Callee],
+				CallType: edge.Description(),
+			}
+			if edge.Site != nil {
+				pos := g.Prog.Fset.Position(edge.Site.Pos())
+				e.CallSite = position{Filename: g.displayPath(pos.Filename), Line: pos.Line, Column: pos.Column}
+			}
+			out.Edges = append(out.Edges, e)
+		}
+	}
+
+	b, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, append(b, '\n'), 0o644)
+}
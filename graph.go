@@ -1,24 +1,46 @@
 package main
 
 import (
-	"flag"
+	"context"
+	"errors"
 	"fmt"
-	"log"
-	"regexp"
-	"slices"
+	"log/slog"
+	"strings"
+	"sync"
 
 	"golang.org/x/tools/go/callgraph"
-	"golang.org/x/tools/go/callgraph/rta"
-	"golang.org/x/tools/go/packages"
 	"golang.org/x/tools/go/ssa"
-	"golang.org/x/tools/go/ssa/ssautil"
+
+	"github.com/esprimo/iamgo/internal/analysis"
+	"github.com/esprimo/iamgo/internal/libraries"
+	"github.com/esprimo/iamgo/internal/loader"
+	"github.com/esprimo/iamgo/internal/sdk"
 )
 
 type graph struct {
-	program   *ssa.Program
-	roots     []*ssa.Function
-	callgraph *callgraph.Graph
-	reachable map[*ssa.Function]struct{ AddrTaken bool }
+	// The program as loaded, with its main packages (whose init and main
+	// functions are the roots) and call graph, and the SDK calls found in it
+	*analysis.Graph
+	// Recognize the SDK calls, see analyzeConfig
+	detectors sdk.Detectors
+	// Cloud provider whose SDK calls are looked for, see -provider
+	provider string
+	// Helper libraries whose actions are added, and whether those that
+	// send traces are too, see libraryFuncs. None unless it's AWS
+	libraries []libraries.Library
+	tracing   bool
+	// Directory the program was loaded from, if not the working directory
+	dir string
+	// Make the positions in the output relative, see displayPath
+	relPaths bool
+	// Whether the tests were loaded too, see testActions
+	tests bool
+	// Root of the module dir is in, see displayPath
+	moduleRoot     string
+	moduleRootOnce sync.Once
+	// Uses of package variables, see globalUses
+	globals     map[*ssa.Global][]ssa.Instruction
+	globalsOnce sync.Once
 }
 
 type step struct {
@@ -45,68 +67,130 @@ type step struct {
 	callComingFromFilename string
 }
 
-// analyze builds call graph and map reachable functions
-func analyze(includeTests bool, buildTags string) *graph {
-	mode := packages.NeedImports | packages.NeedTypes | packages.NeedSyntax | packages.NeedTypesInfo | packages.NeedDeps
-	cfg := &packages.Config{
-		BuildFlags: []string{"-tags=" + buildTags},
-		Mode:       mode,
-		Tests:      includeTests,
+// analyzeConfig controls how the program is loaded and analyzed
+type analyzeConfig struct {
+	// package patterns to analyze, e.g. "./..."
+	patterns []string
+	// include implicit test packages and executables
+	tests bool
+	// comma-separated list of extra build tags
+	tags string
+	// extra flags passed to the build system as-is, e.g. "-mod=vendor"
+	buildFlags []string
+	// package patterns, e.g. "github.com/org/legacy/...", whose functions
+	// are left out of the call graph
+	exclude []string
+	// trade speed for a lower peak memory use
+	lowMemory bool
+	// extra options for building the SSA form, e.g. sanity checks
+	builderMode ssa.BuilderMode
+	// directory to load the packages from, the working directory if empty
+	dir string
+	// program implementing the GOPACKAGESDRIVER protocol to load the
+	// packages with, e.g. for Bazel, see loader.Config.Driver
+	driver string
+	// services to limit the result to, and to leave out of it, see
+	// includesService
+	services        []string
+	excludeServices []string
+
+	// cloud provider whose SDK calls are looked for, see -provider, and
+	// the detectors that recognize them: those of the plugins first, then
+	// the one of the provider's SDK
+	provider  string
+	detectors sdk.Detectors
+	// helper libraries whose actions are added when the program calls
+	// them, the built-in ones followed by those of the project
+	// configuration, and whether to add those that send traces, see -xray
+	libraries []libraries.Library
+	tracing   bool
+	// compute service whose baseline is added, see -target, and whether
+	// to add the baseline of Lambda for Lambda functions, see
+	// -include-runtime-baseline
+	target          string
+	runtimeBaseline bool
+	// make the positions in the output relative, see -relpaths
+	relPaths bool
+}
+
+// analyze builds call graph and map reachable functions. It fails if ctx
+// is done first, e.g. because of -timeout. Errors are failures
+func analyze(ctx context.Context, config analyzeConfig) (*graph, error) {
+	program, err := loader.Load(ctx, loader.Config{
+		Patterns:    config.patterns,
+		Tests:       config.tests,
+		Tags:        config.tags,
+		BuildFlags:  config.buildFlags,
+		Exclude:     config.exclude,
+		LowMemory:   config.lowMemory,
+		BuilderMode: config.builderMode,
+		Dir:         config.dir,
+		Fallback:    true,
+		Driver:      config.driver,
+	})
+	// With a package driver, e.g. for Bazel, go build isn't how the
+	// packages are built
+	buildable := "make sure they're buildable with 'go build'"
+	if driver := loader.Driver(loader.Config{Driver: config.driver}); driver != "" {
+		buildable = "make sure the package driver " + driver + " can load them"
 	}
-	initial, err := packages.Load(cfg, flag.Args()...)
-	if err != nil {
-		log.Fatalf("failed to load package. Make sure it's bildable with 'go build'\n%v", err)
+	var pkgErrs *loader.PackageErrors
+	switch {
+	case ctx.Err() != nil:
+		return nil, &failure{codeTimeout, "the analysis took too long", []any{"err", err}}
+	case errors.As(err, &pkgErrs):
+		return nil, &failure{codeLoad, "packages contain errors, " + buildable, []any{"errors", pkgErrs.Errors}}
+	case errors.Is(err, loader.ErrNoPackages):
+		return nil, &failure{codeLoad, "no packages", nil}
+	case errors.Is(err, loader.ErrNoMainPackages):
+		return nil, &failure{codeNoMain, "no main packages", nil}
+	case err != nil:
+		return nil, &failure{codeLoad, "failed to load packages, " + buildable, []any{"err", err}}
 	}
-	if len(initial) == 0 {
-		log.Fatalf("no packages")
+	for _, main := range program.Mains {
+		slog.Log(context.Background(), levelTrace, "found main package", "package", main.Pkg.Path())
 	}
-	if packages.PrintErrors(initial) > 0 {
-		log.Fatalf("packages contain errors. Make sure it's buildable with 'go build'")
+	if program.Approximate {
+		slog.Warn("no main packages, so every function of the packages is a root and calls through interfaces may go to any implementation: the result is an over-approximation")
 	}
 
-	prog, pkgs := ssautil.AllPackages(initial, ssa.InstantiateGenerics)
-	prog.Build()
-
-	mains := ssautil.MainPackages(pkgs)
-	if len(mains) == 0 {
-		log.Fatalf("no main packages")
+	found := analysis.Config{
+		Detectors: config.detectors,
+		Actions: func(_, method string) []string {
+			if action := sdkMethodToAction(method); action != "" {
+				return []string{action}
+			}
+			return nil
+		},
+		Services:        config.services,
+		ExcludeServices: config.excludeServices,
 	}
-
-	var roots []*ssa.Function
-	for _, main := range mains {
-		roots = append(roots, main.Func("init"), main.Func("main"))
+	// The helper libraries and baselines are of AWS
+	if config.provider == "aws" {
+		found.Libraries = config.libraries
+		found.Tracing = config.tracing
+		found.Target = config.target
+		found.RuntimeBaseline = config.runtimeBaseline
 	}
-
-	res := rta.Analyze(roots, true)
-
-	return &graph{
-		program:   prog,
-		roots:     roots,
-		callgraph: res.CallGraph,
-		reachable: res.Reachable,
+	g := &graph{
+		Graph:     analysis.New(program, found),
+		detectors: config.detectors,
+		provider:  config.provider,
+		libraries: found.Libraries,
+		tracing:   found.Tracing,
+		dir:       config.dir,
+		relPaths:  config.relPaths,
+		tests:     config.tests,
 	}
-}
-
-// whyReachable gives a path of how one reaches a function from any
-// main function. Returns nil if no function is found or a path
-// can't be built
-//
-// An empty path (buth found function) means it's only reachable
-// through reflection
-func (g *graph) whyReachable(fnName string) []*callgraph.Edge {
-	fn := g.findFunc(fnName)
-	if fn == nil {
-		return nil
-	}
-
-	g.callgraph.DeleteSyntheticNodes()
-
-	path := g.findPath(fn)
-	if path == nil {
-		return nil
+	// SDK calls are found when they're first needed, so with plugins find
+	// them now for the analysis to fail if a plugin does
+	if len(plugins) > 0 {
+		g.SDKCalls(true)
+		if err := takePluginError(); err != nil {
+			return nil, err
+		}
 	}
-
-	return path
+	return g, nil
 }
 
 // printPath outputs a call path that's intended to be human readable
@@ -148,7 +232,7 @@ func (g *graph) printPath(path []*callgraph.Edge) {
 // findFunc looks for a reachable function based on the (clean) name
 // of the function. Returns nil if none are found.
 func (g *graph) findFunc(fnName string) *ssa.Function {
-	for fn := range g.reachable {
+	for fn := range g.Reachable {
 		// Only include source named functions (ignore wrappers, instances,
 		// anonymous functions etc) becase we need a name to match
 		if fn.Synthetic == "" && fn.Object() != nil && fn.String() == fnName {
@@ -166,19 +250,19 @@ func (g *graph) createStep(edge *callgraph.Edge) step {
 		outLine = 0
 		outColumn = 0
 	} else {
-		outLine = g.program.Fset.Position(edge.Site.Pos()).Line
-		outColumn = g.program.Fset.Position(edge.Site.Pos()).Column
-		outFilename = g.program.Fset.Position(edge.Site.Pos()).Filename
+		outLine = g.Prog.Fset.Position(edge.Site.Pos()).Line
+		outColumn = g.Prog.Fset.Position(edge.Site.Pos()).Column
+		outFilename = g.displayPath(g.Prog.Fset.Position(edge.Site.Pos()).Filename)
 	}
-	filename := g.program.Fset.Position(edge.Callee.Func.Pos()).Filename
+	filename := g.displayPath(g.Prog.Fset.Position(edge.Callee.Func.Pos()).Filename)
 	if filename == "" {
 		filename = "?"
 	}
 
 	return step{
 		filename:               filename,
-		line:                   g.program.Fset.Position(edge.Callee.Func.Pos()).Line,
-		column:                 g.program.Fset.Position(edge.Callee.Func.Pos()).Column,
+		line:                   g.Prog.Fset.Position(edge.Callee.Func.Pos()).Line,
+		column:                 g.Prog.Fset.Position(edge.Callee.Func.Pos()).Column,
 		callComingFromLine:     outLine,
 		callComingFromColumn:   outColumn,
 		callComingFromFilename: outFilename,
@@ -188,66 +272,31 @@ func (g *graph) createStep(edge *callgraph.Edge) step {
 	}
 }
 
-// findPath does a BFS to find the shortest path from any root to the
-// target and returns the path. Returns nil if no path is found
-func (g *graph) findPath(target *ssa.Function) []*callgraph.Edge {
-	var shortestPath []*callgraph.Edge
-	for _, root := range g.roots {
-		path := g.bfs(root, target)
-		if path != nil {
-			if shortestPath == nil || len(shortestPath) > len(path) {
-				shortestPath = path
-			}
-		}
-	}
-
-	return shortestPath
-}
-
-// bfs does a breadth-first search to find a path from one function
-// to another and returns the path. Returns nil if no path is found
-func (g *graph) bfs(start *ssa.Function, target *ssa.Function) []*callgraph.Edge {
-	root := g.callgraph.Nodes[start]
-	visited := make(map[*callgraph.Node]*callgraph.Edge)
-	visited[root] = nil
-	queue := []*callgraph.Node{root}
-
-	for len(queue) > 0 {
-		current := queue[0]
-		queue = queue[1:]
-
-		if current.Func == target { // path found
-			path := []*callgraph.Edge{}
-
-			// traverse back up to where we started
-			for {
-				edge := visited[current]
-				if edge == nil { // we've reached the start
-					slices.Reverse(path)
-					return path
-				}
-				path = append(path, edge)
-				current = edge.Caller
-			}
-		}
-
-		for _, edge := range current.Out {
-			if _, ok := visited[edge.Callee]; !ok {
-				visited[edge.Callee] = edge
-				queue = append(queue, edge.Callee)
-			}
-		}
-	}
-
-	return nil
-}
-
 // cleanName makes a function name more readable by removing some special0
 // characters from the name.
 //
 // For exmaple
 // In:  (*github.com/aws/aws-sdk-go-v2/service/ssm.Client).GetParameter
 // Out: github.com/aws/aws-sdk-go-v2/service/ssm.Client.GetParameter
+//
+// Type arguments are left as they are, e.g. in an instance of a generic
+// function like example.com/app.Call[*github.com/aws/aws-sdk-go-v2/service/s3.GetObjectOutput]
 func cleanName(fn *ssa.Function) string {
-	return regexp.MustCompile(`[\(\)\*]+`).ReplaceAllString(fn.String(), "")
+	name := fn.String()
+	if !strings.HasPrefix(name, "(") {
+		return name
+	}
+	depth := 0
+	for i, r := range name {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 { // the end of the receiver
+				return strings.TrimPrefix(name[1:i], "*") + name[i+1:]
+			}
+		}
+	}
+	return name
 }
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// historyEntry is the IAM actions a tagged revision of the program needs,
+// and which of them it added and removed compared to the tag before it
+type historyEntry struct {
+	Tag     string   `json:"tag"`
+	Date    string   `json:"date"`
+	Actions []string `json:"actions"`
+	Added   []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+	// Why the tag couldn't be analyzed, e.g. because it doesn't build
+	// anymore, in which case it has no actions
+	Error string `json:"error,omitempty"`
+}
+
+// gitTags returns the tags of the repository in the working directory and
+// the dates they were made, oldest first, starting from since if it isn't
+// empty
+func gitTags(since string) ([][2]string, error) {
+	out, err := git("for-each-ref", "--sort=creatordate", "--format=%(refname:short)%09%(creatordate:short)", "refs/tags")
+	if err != nil {
+		return nil, err
+	}
+	var tags [][2]string
+	for _, line := range strings.Split(out, "\n") {
+		if name, date, ok := strings.Cut(line, "\t"); ok {
+			tags = append(tags, [2]string{name, date})
+		}
+	}
+	if since == "" {
+		return tags, nil
+	}
+	i := slices.IndexFunc(tags, func(tag [2]string) bool { return tag[0] == since })
+	if i == -1 {
+		return nil, fmt.Errorf("no tag %q", since)
+	}
+	return tags[i:], nil
+}
+
+// history analyzes the program at each tag, see gitTags. A tag that fails
+// to analyze gets an error, and the next one is compared with the tag
+// before it
+func history(ctx context.Context, config analyzeConfig, since string, includeReflection bool, suppress []string) ([]historyEntry, error) {
+	tags, err := gitTags(since)
+	if err != nil {
+		return nil, err
+	}
+	var entries []historyEntry
+	var previous []string
+	analyzed := false
+	for _, tag := range tags {
+		entry := historyEntry{Tag: tag[0], Date: tag[1]}
+		graph, err := revisionGraph(ctx, config, tag[0])
+		if err != nil {
+			entry.Error = err.Error()
+			entries = append(entries, entry)
+			continue
+		}
+		entry.Actions = graphReport(graph, includeReflection, suppress).Actions
+		if analyzed {
+			entry.Added, entry.Removed = compareActions(previous, entry.Actions)
+		}
+		previous, analyzed = entry.Actions, true
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// writeHistory writes how the number of actions evolved over the tags as a
+// bar chart, with the actions added and removed by each tag under it, and
+// the first line of the error of the tags that failed to analyze
+func writeHistory(w io.Writer, entries []historyEntry) {
+	const width = 40
+	most, tagWidth := 1, 0
+	for _, e := range entries {
+		most = max(most, len(e.Actions))
+		tagWidth = max(tagWidth, len(e.Tag))
+	}
+	indent := strings.Repeat(" ", tagWidth+20)
+	for _, e := range entries {
+		if e.Error != "" {
+			msg, _, _ := strings.Cut(e.Error, "\n")
+			fmt.Fprintf(w, "%-*s  %s     -  failed to analyze: %s\n", tagWidth, e.Tag, e.Date, msg)
+			continue
+		}
+		bar := strings.Repeat("█", (len(e.Actions)*width+most-1)/most)
+		fmt.Fprintf(w, "%-*s  %s  %4d  %s\n", tagWidth, e.Tag, e.Date, len(e.Actions), bar)
+		for _, action := range e.Added {
+			fmt.Fprintf(w, "%s+ %s\n", indent, action)
+		}
+		for _, action := range e.Removed {
+			fmt.Fprintf(w, "%s- %s\n", indent, action)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package iamgo
+
+import (
+	"context"
+	"go/token"
+	"slices"
+	"strings"
+	"sync"
+
+	"golang.org/x/tools/go/callgraph"
+	"golang.org/x/tools/go/ssa"
+
+	"github.com/esprimo/iamgo/internal/analysis"
+	"github.com/esprimo/iamgo/internal/libraries"
+	"github.com/esprimo/iamgo/internal/loader"
+	"github.com/esprimo/iamgo/internal/mapping"
+)
+
+// Graph is the call graph of the programs among the loaded packages, for
+// tools that show more than the result, e.g. a dashboard or the actions
+// behind a function in an editor:
+//
+//	g, err := a.Load(ctx, "./...")
+//	if err != nil {
+//		return err
+//	}
+//	for _, path := range g.PathsTo("s3:PutObject") {
+//		fmt.Println(path[0].Caller)
+//	}
+//
+// It's safe to use from several goroutines
+type Graph struct {
+	a     *Analyzer
+	graph *analysis.Graph
+	// A shortest path from a root to each function reachable through the
+	// call graph
+	paths map[*ssa.Function][]*callgraph.Edge
+
+	mu     sync.Mutex
+	result *Result
+}
+
+// Function is a function in a Graph
+type Function struct {
+	// Full name, e.g. "(*github.com/org/app/store.Store).Save"
+	Name string
+	// Where the function is declared. It's the zero value for functions
+	// without source, e.g. wrappers generated by the compiler
+	Position token.Position
+}
+
+// Load loads the packages matching patterns, e.g. "./...", and builds the
+// call graph from the main packages among them. Analyze is Load followed
+// by Graph.Result
+func (a *Analyzer) Load(ctx context.Context, patterns ...string) (*Graph, error) {
+	program, err := loader.Load(ctx, loader.Config{
+		Patterns:   patterns,
+		Tests:      a.opts.Tests,
+		Tags:       a.opts.Tags,
+		BuildFlags: a.opts.BuildFlags,
+		Exclude:    a.opts.Exclude,
+		Dir:        a.opts.Dir,
+		Fallback:   true,
+		Driver:     a.opts.Driver,
+	})
+	if err != nil {
+		return nil, err
+	}
+	config := analysis.Config{
+		Detectors: a.detectors,
+		Actions: func(version, method string) []string {
+			var actions []string
+			for _, p := range a.permissions(version, method) {
+				if p.Action != "" {
+					actions = append(actions, p.Action)
+				}
+			}
+			return actions
+		},
+		Services:        a.opts.Services,
+		ExcludeServices: a.opts.ExcludeServices,
+	}
+	// The helper libraries and baselines are of AWS
+	if a.opts.Provider == "" || a.opts.Provider == "aws" {
+		config.Libraries = libraries.Known
+		config.Tracing = a.opts.XRay
+		config.Target = a.opts.Target
+		config.RuntimeBaseline = a.opts.RuntimeBaseline
+	}
+	graph := analysis.New(program, config)
+	paths, err := shortestPaths(ctx, graph.PathGraph(), program.Roots)
+	if err != nil {
+		return nil, err
+	}
+	return &Graph{a: a, graph: graph, paths: paths}, nil
+}
+
+// Result returns what the programs need, the same as Analyze. It's only
+// computed once
+func (g *Graph) Result(ctx context.Context) (*Result, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.result != nil {
+		return g.result, nil
+	}
+	r, err := g.analyze(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	g.result = r
+	return r, nil
+}
+
+// Reachable returns the functions reachable from the main function, or
+// init, of a main package through the call graph, sorted by name. Those
+// only reachable through reflection aren't included
+func (g *Graph) Reachable() []Function {
+	fns := make([]Function, 0, len(g.paths))
+	for fn := range g.paths {
+		if fn.Synthetic != "" {
+			continue
+		}
+		fns = append(fns, Function{Name: fn.String(), Position: g.graph.Prog.Fset.Position(fn.Pos())})
+	}
+	slices.SortFunc(fns, func(a, b Function) int { return strings.Compare(a.Name, b.Name) })
+	return fns
+}
+
+// WhyReachable returns a shortest call path from the main function, or
+// init, of a main package to a function given by its full name, as in
+// Function.Name. It returns false if the function isn't reachable through
+// the call graph, and an empty path for the roots themselves
+func (g *Graph) WhyReachable(fn string) ([]Step, bool) {
+	for f, path := range g.paths {
+		if f.String() == fn {
+			return append([]Step{}, g.steps(path)...), true
+		}
+	}
+	return nil, false
+}
+
+// PathsTo returns a shortest call path to each SDK call that requires an
+// action, or an action matching a pattern such as "s3:Put*", in the order
+// of Result.SDKCalls. Calls only reachable through reflection are left out
+func (g *Graph) PathsTo(action string) [][]Step {
+	r, err := g.Result(context.Background())
+	if err != nil {
+		return nil // can't happen without a context to cancel
+	}
+	var paths [][]Step
+	for _, call := range r.SDKCalls {
+		if call.Path == nil {
+			continue
+		}
+		if slices.ContainsFunc(call.Permissions, func(p Permission) bool { return mapping.MatchAction(action, p.Action) }) {
+			paths = append(paths, call.Path)
+		}
+	}
+	return paths
+}
+
+// steps converts a call path to the Steps of the result
+func (g *Graph) steps(path []*callgraph.Edge) []Step {
+	var steps []Step
+	for _, edge := range path {
+		step := Step{Caller: edge.Caller.Func.String(), Callee: edge.Callee.Func.String()}
+		if edge.Site != nil {
+			step.Position = g.graph.Prog.Fset.Position(edge.Site.Pos())
+		}
+		steps = append(steps, step)
+	}
+	return steps
+}
@@ -0,0 +1,374 @@
+// Package iamgo finds the AWS IAM actions Go programs need, for tools that
+// embed the analysis instead of running the iamgo command and parsing its
+// output:
+//
+//	a, err := iamgo.New(iamgo.Options{Dir: "path/to/app"})
+//	if err != nil {
+//		return err
+//	}
+//	result, err := a.Analyze(ctx, "./...")
+//	if err != nil {
+//		return err
+//	}
+//	for _, action := range result.Actions {
+//		fmt.Println(action)
+//	}
+//
+// The analysis is the command's: calls to the AWS SDK for Go v1 and v2
+// reachable from the main packages, or from every function of the packages
+// if there are none, are mapped to the actions they require, leaving out
+// those ignored by //iamgo:ignore comments. The actions of the helper
+// libraries the programs call, and of the baseline of where they run, are
+// added. The project configuration (.iamgo.yaml) is specific to the command
+// and isn't read, see Options for its equivalents
+package iamgo
+
+import (
+	"context"
+	"fmt"
+	"go/token"
+	"slices"
+	"strings"
+
+	"golang.org/x/tools/go/callgraph"
+	"golang.org/x/tools/go/ssa"
+
+	"github.com/esprimo/iamgo/internal/libraries"
+	"github.com/esprimo/iamgo/internal/mapping"
+	"github.com/esprimo/iamgo/internal/sdk"
+)
+
+// Options controls how packages are loaded and what's included in the
+// result. The zero value analyzes the packages in the working directory
+// with the default build configuration
+type Options struct {
+	// Directory to load the packages from, the working directory if empty
+	Dir string
+	// Include implicit test packages and executables
+	Tests bool
+	// Comma-separated list of extra build tags
+	Tags string
+	// Extra flags passed to the build system as-is, e.g. "-mod=vendor".
+	// GOFLAGS and the rest of the go environment are honored too
+	BuildFlags []string
+	// Program implementing the GOPACKAGESDRIVER protocol to load the
+	// packages with instead of the go command, e.g. for Bazel. If empty,
+	// GOPACKAGESDRIVER is honored
+	Driver string
+	// Package patterns, e.g. "github.com/org/legacy/...", whose functions
+	// are left out of the call graph
+	Exclude []string
+	// If set, only SDK calls to these services are included. A service is
+	// given by the name of its SDK package (e.g. "s3" or "sesv2") or the
+	// prefix of its IAM actions (e.g. "ses"), or an action pattern such as
+	// "*:Delete*". Like everywhere actions are matched, "*" and "?" are
+	// wildcards and case doesn't matter
+	Services []string
+	// SDK calls to these services are left out
+	ExcludeServices []string
+	// Include calls that are only reachable through reflection (false
+	// positive prone)
+	IncludeReflection bool
+	// Action patterns, e.g. "logs:*", to leave out of Result.Actions
+	Suppress []string
+	// Also add the actions of the libraries that send traces to X-Ray the
+	// programs call, e.g. the X-Ray SDK, which they work without
+	XRay bool
+	// Compute service the programs run on whose baseline is added, the
+	// actions they need there regardless of what they do (e.g. to write
+	// logs or pull their image): "lambda", "ecs", "ec2" or "eks"
+	Target string
+	// Add the baseline of Lambda if the programs are Lambda functions
+	RuntimeBaseline bool
+	// Maps SDK methods to the permissions they require, DefaultMapper()
+	// if nil
+	Mapper Mapper
+	// Detectors of other SDKs to recognize API calls of, tried after the
+	// built-in ones of the provider
+	Detectors []Detector
+	// Cloud provider whose SDK calls are recognized by default: "aws" (the
+	// default) for the AWS SDK for Go v1 and v2, "gcp" for the Google Cloud
+	// client libraries, whose permissions are Google Cloud IAM permissions,
+	// e.g. "storage.objects.get", or "azure" for the Azure SDK for Go, whose
+	// permissions are Azure RBAC actions and data actions
+	Provider string
+}
+
+// Detector recognizes the functions of an SDK that call an API, e.g. an
+// organization's own wrapper of a cloud provider's API. Its methods may be
+// called from several goroutines
+type Detector interface {
+	// Name of the SDK, used as SDKCall.Version, e.g. "v2" for the AWS SDK
+	// for Go v2
+	Name() string
+	// Method returns the SDK method fn is as "service.Name", e.g.
+	// "s3.PutObject", or an empty string if fn doesn't call the API
+	Method(fn *ssa.Function) string
+}
+
+// Analyzer analyzes Go packages with a set of Options. It's safe to use
+// from several goroutines
+type Analyzer struct {
+	opts      Options
+	detectors sdk.Detectors
+}
+
+// New returns an Analyzer using opts
+func New(opts Options) (*Analyzer, error) {
+	a := &Analyzer{opts: opts}
+	switch opts.Provider {
+	case "", "aws":
+		a.detectors = slices.Clip(sdk.AWS)
+		if opts.Mapper == nil {
+			m, err := DefaultMapper()
+			if err != nil {
+				return nil, err
+			}
+			a.opts.Mapper = m
+		}
+	case "gcp", "azure":
+		// The mapping tells which methods call the API
+		load, detector := mapping.LoadGCP, sdk.GCP
+		if opts.Provider == "azure" {
+			load, detector = mapping.LoadAzure, sdk.Azure
+		}
+		m, err := load()
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the permission mapping: %w", err)
+		}
+		a.detectors = sdk.Detectors{detector(m.Has)}
+		if opts.Mapper == nil {
+			a.opts.Mapper = defaultMapper{m}
+		}
+	default:
+		return nil, fmt.Errorf("unknown provider %q, must be aws, gcp or azure", opts.Provider)
+	}
+	if _, ok := libraries.Baselines[opts.Target]; !ok && opts.Target != "" {
+		return nil, fmt.Errorf("unknown target %q", opts.Target)
+	}
+	for _, d := range opts.Detectors {
+		a.detectors = append(a.detectors, d)
+	}
+	return a, nil
+}
+
+// Result is what a program needs
+type Result struct {
+	// IAM actions the program needs, sorted and unique: those of the SDK
+	// calls, Libraries and Baseline
+	Actions []string
+	// Reachable SDK calls, sorted by method, including the ones that don't
+	// require any action
+	SDKCalls []SDKCall
+	// Helper libraries the program calls, whose actions don't show as SDK
+	// calls, e.g. because they sign requests themselves
+	Libraries []Library
+	// Options.Target, or "lambda" with Options.RuntimeBaseline if the
+	// program is a Lambda function, and the actions of its baseline
+	Target   string
+	Baseline []string
+	// Actions of SDK calls left out because //iamgo:ignore comments ignore
+	// them, sorted by action
+	Ignored []IgnoredAction
+	// The packages had no main package, so every function of them was
+	// a root and calls through interfaces may go to any implementation:
+	// the result is an over-approximation
+	Approximate bool
+}
+
+// Library is a helper library a program calls
+type Library struct {
+	// e.g. "Amazon SQS Extended Client"
+	Name string
+	// IAM actions it needs, leaving out suppressed ones
+	Actions []string
+	// Why it needs them
+	Reason string
+}
+
+// IgnoredAction is an action an //iamgo:ignore comment ignores
+type IgnoredAction struct {
+	Action string
+	// From "reason=..." of the comment
+	Reason string
+	// Where the comment is
+	Position token.Position
+}
+
+// SDKCall is an AWS SDK method a program calls
+type SDKCall struct {
+	// SDK method, e.g. "s3.PutObject"
+	Method string
+	// Full name of the SDK function, e.g.
+	// "(*github.com/aws/aws-sdk-go-v2/service/s3.Client).PutObject"
+	Function string
+	// SDK version, "v1" or "v2", or the name of the Detector that
+	// recognized the call
+	Version string
+	// IAM action the call requires, or an empty string if it requires
+	// none or the action is suppressed. It's the action of the first of
+	// Permissions
+	Action string
+	// Permissions the call requires according to Options.Mapper, leaving
+	// out suppressed actions
+	Permissions []Permission
+	// Where the analyzed packages make the call. For a method value, e.g.
+	// client.GetObject passed to a helper, it's where the value is created
+	// rather than where the helper calls it
+	CallSites []token.Position
+	// A shortest call path from the main function, or init, of a main
+	// package to the call. It's nil for calls only reachable through
+	// reflection
+	Path []Step
+}
+
+// Step is a call in a call path
+type Step struct {
+	// Full name of the calling function
+	Caller string
+	// Full name of the called function
+	Callee string
+	// Where the call is made. It's the zero value for calls made by the
+	// runtime, e.g. to init functions
+	Position token.Position
+}
+
+// Analyze loads the packages matching patterns, e.g. "./...", and returns
+// what the programs among them need. If ctx is done before the analysis is
+// finished, it stops as soon as the step in progress allows and ctx.Err()
+// is returned
+func (a *Analyzer) Analyze(ctx context.Context, patterns ...string) (*Result, error) {
+	return a.AnalyzeFunc(ctx, nil, patterns...)
+}
+
+// AnalyzeFunc is like Analyze but also calls fn with each SDK call as soon
+// as it's found, in no particular order, e.g. to show results while a long
+// analysis is still running. SDK calls are found once the call graph is
+// built, and the paths to them searched for. Calls to fn are made one at a
+// time from the goroutine calling AnalyzeFunc
+func (a *Analyzer) AnalyzeFunc(ctx context.Context, fn func(SDKCall), patterns ...string) (*Result, error) {
+	g, err := a.Load(ctx, patterns...)
+	if err != nil {
+		return nil, err
+	}
+	return g.analyze(ctx, fn)
+}
+
+// analyze finds the SDK calls among the reachable functions, calling fn (if
+// not nil) with each
+func (g *Graph) analyze(ctx context.Context, fn func(SDKCall)) (*Result, error) {
+	opts := g.a.opts
+	result := &Result{SDKCalls: []SDKCall{}, Approximate: g.graph.Approximate}
+	for _, reached := range g.graph.SDKCalls(opts.IncludeReflection) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		version, method := g.graph.Method(reached)
+		call := SDKCall{
+			Method:      method,
+			Function:    reached.String(),
+			Version:     version,
+			Permissions: g.a.permissions(version, method),
+			Path:        g.steps(g.graph.FindPath(reached)),
+		}
+		for _, pos := range g.graph.CallSites(reached) {
+			call.CallSites = append(call.CallSites, g.graph.Prog.Fset.Position(pos))
+		}
+		if len(call.Permissions) > 0 {
+			call.Action = call.Permissions[0].Action
+		}
+		result.SDKCalls = append(result.SDKCalls, call)
+		for _, p := range call.Permissions {
+			if p.Action != "" {
+				result.Actions = append(result.Actions, p.Action)
+			}
+		}
+		if fn != nil {
+			fn(call)
+		}
+	}
+
+	for _, lib := range g.graph.UsedLibraries(opts.IncludeReflection, opts.Suppress, nil) {
+		result.Libraries = append(result.Libraries, Library{Name: lib.Name, Actions: lib.Actions, Reason: lib.Reason})
+		result.Actions = append(result.Actions, lib.Actions...)
+	}
+	result.Target, result.Baseline = g.graph.Baseline(opts.IncludeReflection, opts.Suppress, nil)
+	result.Actions = append(result.Actions, result.Baseline...)
+	result.Ignored = g.ignored()
+
+	slices.SortFunc(result.SDKCalls, func(a, b SDKCall) int {
+		if c := strings.Compare(a.Method, b.Method); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Function, b.Function)
+	})
+	slices.Sort(result.Actions)
+	result.Actions = slices.Compact(result.Actions)
+	return result, nil
+}
+
+// permissions returns the permissions an SDK method requires according to
+// the mapper, leaving out suppressed actions
+func (a *Analyzer) permissions(version, method string) []Permission {
+	service, name, _ := strings.Cut(method, ".")
+	permissions := a.opts.Mapper.Permissions(SDKMethod{Service: service, Name: name, Version: version})
+	return slices.DeleteFunc(slices.Clone(permissions), func(p Permission) bool {
+		return slices.ContainsFunc(a.opts.Suppress, func(pattern string) bool { return mapping.MatchAction(pattern, p.Action) })
+	})
+}
+
+// ignored returns the actions the SDK calls ignored by //iamgo:ignore
+// comments require, once per comment ignoring them, sorted by action
+func (g *Graph) ignored() []IgnoredAction {
+	var ignored []IgnoredAction
+	for fn, directives := range g.graph.IgnoredCalls() {
+		for _, action := range g.graph.Actions(fn) {
+			for _, d := range directives {
+				i := IgnoredAction{Action: action, Reason: d.Reason, Position: d.Pos}
+				if d.Matches(action) && !slices.Contains(ignored, i) {
+					ignored = append(ignored, i)
+				}
+			}
+		}
+	}
+	slices.SortFunc(ignored, func(a, b IgnoredAction) int {
+		if c := strings.Compare(a.Action, b.Action); c != 0 {
+			return c
+		}
+		if c := strings.Compare(a.Position.Filename, b.Position.Filename); c != 0 {
+			return c
+		}
+		return a.Position.Line - b.Position.Line
+	})
+	return ignored
+}
+
+// shortestPaths does a BFS from the roots and returns a shortest path to
+// every function in the call graph reachable from them, or ctx.Err() if
+// ctx is done first
+func shortestPaths(ctx context.Context, cg *callgraph.Graph, roots []*ssa.Function) (map[*ssa.Function][]*callgraph.Edge, error) {
+	paths := make(map[*ssa.Function][]*callgraph.Edge)
+	var queue []*callgraph.Node
+	for _, root := range roots {
+		if node := cg.Nodes[root]; node != nil {
+			paths[root] = nil
+			queue = append(queue, node)
+		}
+	}
+	for len(queue) > 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		current := queue[0]
+		queue = queue[1:]
+		for _, edge := range current.Out {
+			fn := edge.Callee.Func
+			if _, ok := paths[fn]; ok {
+				continue
+			}
+			paths[fn] = append(slices.Clip(paths[current.Func]), edge)
+			queue = append(queue, edge.Callee)
+		}
+	}
+	return paths, nil
+}
@@ -0,0 +1,69 @@
+package iamgo
+
+import (
+	"fmt"
+
+	"github.com/esprimo/iamgo/internal/mapping"
+)
+
+// SDKMethod is an AWS SDK method, e.g. s3.PutObject
+type SDKMethod struct {
+	// Name of the service's SDK package, e.g. "s3"
+	Service string
+	// Name of the method, e.g. "PutObject", or for Google Cloud the receiver
+	// type and method, e.g. "ObjectHandle.NewReader"
+	Name string
+	// SDK version, "v1" or "v2", or the name of the Detector that
+	// recognized the call
+	Version string
+}
+
+// String returns the method as "service.Name", e.g. "s3.PutObject"
+func (m SDKMethod) String() string {
+	return m.Service + "." + m.Name
+}
+
+// Permission is something an SDK call requires
+type Permission struct {
+	// IAM action, e.g. "s3:PutObject"
+	Action string
+}
+
+// Mapper maps SDK methods to the permissions they require, e.g. to use an
+// organization's own mapping instead of, or on top of, the embedded one.
+// Permissions returns no permissions for methods that don't require any, or
+// that it doesn't know about. It may be called from several goroutines
+type Mapper interface {
+	Permissions(method SDKMethod) []Permission
+}
+
+// MapperFunc is a function used as a Mapper
+type MapperFunc func(method SDKMethod) []Permission
+
+// Permissions calls f(method)
+func (f MapperFunc) Permissions(method SDKMethod) []Permission {
+	return f(method)
+}
+
+// defaultMapper is the mapping embedded in iamgo, from map.json
+type defaultMapper struct {
+	m *mapping.Map
+}
+
+// DefaultMapper returns the Mapper of the AWS mapping embedded in iamgo,
+// the one used when Options.Mapper isn't set. Other mappers can fall back
+// to it for methods they don't know about
+func DefaultMapper() (Mapper, error) {
+	m, err := mapping.Load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the action mapping: %w", err)
+	}
+	return defaultMapper{m}, nil
+}
+
+func (d defaultMapper) Permissions(method SDKMethod) []Permission {
+	if action := d.m.Action(method.String()); action != "" {
+		return []Permission{{Action: action}}
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package iamgo
+
+import (
+	"fmt"
+	"go/token"
+	"io"
+	"slices"
+	"sync"
+
+	"github.com/esprimo/iamgo/internal/render"
+	"github.com/esprimo/iamgo/schema"
+)
+
+// Renderer writes a Result in an output format
+type Renderer interface {
+	Render(w io.Writer, r *Result) error
+}
+
+// RendererFunc is a function used as a Renderer
+type RendererFunc func(w io.Writer, r *Result) error
+
+// Render calls f(w, r)
+func (f RendererFunc) Render(w io.Writer, r *Result) error {
+	return f(w, r)
+}
+
+var (
+	renderersMu sync.RWMutex
+	// renderers are the output formats by name, the built-in ones are
+	// those of the command
+	renderers = map[string]Renderer{
+		"text":      builtinRenderer("text"),
+		"json":      builtinRenderer("json"),
+		"policy":    builtinRenderer("policy"),
+		"terraform": builtinRenderer("terraform"),
+	}
+)
+
+// RegisterRenderer makes an output format available to Render by name. It
+// panics if the name is already registered, e.g. by a built-in format
+// ("text", "json", "policy" or "terraform"), or the renderer is nil
+func RegisterRenderer(name string, r Renderer) {
+	renderersMu.Lock()
+	defer renderersMu.Unlock()
+	if r == nil {
+		panic("iamgo: RegisterRenderer renderer is nil")
+	}
+	if _, dup := renderers[name]; dup {
+		panic("iamgo: RegisterRenderer called twice for format " + name)
+	}
+	renderers[name] = r
+}
+
+// Formats returns the names of the registered output formats, sorted
+func Formats() []string {
+	renderersMu.RLock()
+	defer renderersMu.RUnlock()
+	var formats []string
+	for format := range renderers {
+		formats = append(formats, format)
+	}
+	slices.Sort(formats)
+	return formats
+}
+
+// Render writes a Result in a registered output format:
+//
+//   - text: the actions, one per line
+//   - json: the report of iamgo -format json, see the schema package
+//   - policy: an IAM policy allowing the actions on all resources
+//   - terraform: an aws_iam_policy_document data source of the policy
+func Render(w io.Writer, format string, r *Result) error {
+	renderersMu.RLock()
+	renderer, ok := renderers[format]
+	renderersMu.RUnlock()
+	if !ok {
+		return fmt.Errorf("unknown format %q", format)
+	}
+	return renderer.Render(w, r)
+}
+
+// builtinRenderer is an output format of the command
+type builtinRenderer string
+
+func (b builtinRenderer) Render(w io.Writer, r *Result) error {
+	return render.Render(w, string(b), r.report(), render.Options{})
+}
+
+// report converts a Result to the report the command outputs
+func (r *Result) report() schema.Report {
+	report := schema.Report{
+		SchemaVersion: schema.Version,
+		Actions:       r.Actions,
+		SDKCalls:      []string{},
+		Calls:         []schema.Call{},
+		Target:        r.Target,
+		Baseline:      r.Baseline,
+	}
+	for _, call := range r.SDKCalls {
+		report.SDKCalls = append(report.SDKCalls, call.Method)
+		c := schema.Call{Method: call.Method, Version: call.Version, Action: call.Action, CallSites: []schema.Position{}, Path: []schema.Step{}}
+		for _, site := range call.CallSites {
+			if p := position(site); !slices.Contains(c.CallSites, p) {
+				c.CallSites = append(c.CallSites, p)
+			}
+		}
+		for _, step := range call.Path {
+			c.Path = append(c.Path, schema.Step{
+				Caller:   step.Caller,
+				Callee:   step.Callee,
+				CallSite: position(step.Position),
+			})
+		}
+		report.Calls = append(report.Calls, c)
+	}
+	for _, lib := range r.Libraries {
+		report.Libraries = append(report.Libraries, schema.Library{Name: lib.Name, Actions: lib.Actions, Reason: lib.Reason})
+	}
+	for _, i := range r.Ignored {
+		report.Ignored = append(report.Ignored, schema.IgnoredAction{Action: i.Action, Reason: i.Reason, Position: position(i.Position)})
+	}
+	return report
+}
+
+func position(p token.Position) schema.Position {
+	return schema.Position{Filename: p.Filename, Line: p.Line, Column: p.Column}
+}
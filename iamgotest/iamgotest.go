@@ -0,0 +1,102 @@
+// Package iamgotest asserts in Go tests which IAM actions code needs, e.g.
+// that it never needs more than the role it's deployed with grants:
+//
+//	func TestIAM(t *testing.T) {
+//		iamgotest.RequireActionsSubset(t, "example.com/app/...", []string{
+//			"s3:GetObject",
+//			"dynamodb:*",
+//		})
+//	}
+//
+// The packages are analyzed with the iamgo package in the directory of the
+// test, so the analysis is the command's. The project configuration
+// (.iamgo.yaml) is specific to the command and isn't read: use
+// //iamgo:ignore comments, or ActionsWith for the equivalents of its
+// settings
+package iamgotest
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/esprimo/iamgo/iamgo"
+	"github.com/esprimo/iamgo/internal/mapping"
+)
+
+// Actions returns the sorted, unique IAM actions the programs matching package
+// patterns need. The test fails if they can't be analyzed
+func Actions(t testing.TB, patterns ...string) []string {
+	t.Helper()
+	return ActionsWith(t, iamgo.Options{}, patterns...)
+}
+
+// ActionsWith is like Actions but analyzes the packages with opts, e.g. to
+// suppress actions or add the baseline of where the programs run
+func ActionsWith(t testing.TB, opts iamgo.Options, patterns ...string) []string {
+	t.Helper()
+
+	a, err := iamgo.New(opts)
+	if err != nil {
+		t.Fatalf("iamgo: %v", err)
+	}
+	result, err := a.Analyze(context.Background(), patterns...)
+	if err != nil {
+		t.Fatalf("iamgo %s: %v", strings.Join(patterns, " "), err)
+	}
+	return result.Actions
+}
+
+// RequireActionsSubset fails the test unless every IAM action the programs
+// matching a package pattern need matches one of the allowed actions or
+// patterns, e.g. "s3:Get*"
+func RequireActionsSubset(t testing.TB, pattern string, allowed []string) {
+	t.Helper()
+
+	var extra []string
+	for _, action := range Actions(t, pattern) {
+		if !matchesAny(allowed, action) {
+			extra = append(extra, action)
+		}
+	}
+	if len(extra) > 0 {
+		t.Errorf("%s needs IAM actions that aren't allowed:\n\t%s", pattern, strings.Join(extra, "\n\t"))
+	}
+}
+
+// RequireActions fails the test unless the programs matching a package
+// pattern need exactly the given actions, for keeping a list of them in the
+// test
+func RequireActions(t testing.TB, pattern string, want []string) {
+	t.Helper()
+
+	got := Actions(t, pattern)
+	var missing, extra []string
+	for _, action := range got {
+		if !matchesAny(want, action) {
+			extra = append(extra, action)
+		}
+	}
+	for _, listed := range want {
+		if !slices.ContainsFunc(got, func(action string) bool { return mapping.MatchAction(listed, action) }) {
+			missing = append(missing, listed)
+		}
+	}
+	if len(extra) > 0 {
+		t.Errorf("%s needs IAM actions that aren't listed:\n\t%s", pattern, strings.Join(extra, "\n\t"))
+	}
+	if len(missing) > 0 {
+		t.Errorf("%s doesn't need listed IAM actions:\n\t%s", pattern, strings.Join(missing, "\n\t"))
+	}
+}
+
+// matchesAny returns whether an action matches any of the patterns
+func matchesAny(patterns []string, action string) bool {
+	for _, pattern := range patterns {
+		if mapping.MatchAction(pattern, action) {
+			return true
+		}
+	}
+	return false
+}
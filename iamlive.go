@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// iamliveActions returns the sorted, unique IAM actions in the output of
+// iamlive (github.com/iann0036/iamlive), either the policy it generates
+// or CSV. The actions of a policy are those of its Allow statements
+func iamliveActions(filename string) ([]string, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var actions []string
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		policy, err := parsePolicy(trimmed)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range policy.Statement {
+			if s.Effect == "Allow" {
+				actions = append(actions, s.Action...)
+			}
+		}
+	} else {
+		// Any cell that looks like an action, so it doesn't matter which
+		// columns there are
+		r := csv.NewReader(bytes.NewReader(data))
+		r.FieldsPerRecord = -1
+		records, err := r.ReadAll()
+		if err != nil {
+			return nil, err
+		}
+		actionFormat := regexp.MustCompile(`^[A-Za-z0-9-]+:[A-Za-z0-9*?]+$`)
+		for _, record := range records {
+			for _, cell := range record {
+				if cell = strings.TrimSpace(cell); actionFormat.MatchString(cell) {
+					actions = append(actions, cell)
+				}
+			}
+		}
+	}
+
+	slices.Sort(actions)
+	return slices.Compact(actions), nil
+}
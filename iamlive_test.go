@@ -0,0 +1,62 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestIamliveActions(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		data string
+		want []string
+	}{
+		{
+			name: "policy",
+			file: "policy.json",
+			data: `{
+				"Version": "2012-10-17",
+				"Statement": [
+					{"Effect": "Allow", "Action": ["sqs:SendMessage", "s3:GetObject"], "Resource": "*"},
+					{"Effect": "Allow", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::reports/*"},
+					{"Effect": "Deny", "Action": "iam:*", "Resource": "*"}
+				]
+			}`,
+			want: []string{"s3:GetObject", "sqs:SendMessage"},
+		},
+		{
+			name: "CSV",
+			file: "calls.csv",
+			data: "time,service,action,result\n" +
+				"2024-01-01T00:00:00Z,s3,s3:GetObject,ok\n" +
+				"2024-01-01T00:00:01Z,sqs, sqs:SendMessage ,ok\n" +
+				"2024-01-01T00:00:02Z,s3,s3:GetObject,ok\n" +
+				"2024-01-01T00:00:03Z,s3,not an action,ok\n",
+			want: []string{"s3:GetObject", "sqs:SendMessage"},
+		},
+		{
+			name: "empty",
+			file: "calls.csv",
+			data: "",
+			want: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actions, err := iamliveActions(writeFile(t, tt.file, []byte(tt.data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(actions, tt.want) {
+				t.Errorf("iamliveActions() = %q, want %q", actions, tt.want)
+			}
+		})
+	}
+}
+
+func TestIamliveActionsInvalidPolicy(t *testing.T) {
+	if _, err := iamliveActions(writeFile(t, "policy.json", []byte(`{"Statement": [{"Effect": "allow"}]}`))); err == nil {
+		t.Error("read the actions of a policy with the effect allow, want an error")
+	}
+}
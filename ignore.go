@@ -0,0 +1,41 @@
+package main
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/esprimo/iamgo/schema"
+)
+
+// ignoredAction is an action ignored by //iamgo:ignore comments, as
+// reported next to the list of actions
+type ignoredAction = schema.IgnoredAction
+
+// ignoredActions returns the actions the ignored SDK calls require, once
+// per comment ignoring them, sorted by action
+func (g *graph) ignoredActions() []ignoredAction {
+	var actions []ignoredAction
+	for fn, directives := range g.IgnoredCalls() {
+		action := sdkMethodToAction(g.detectors.MethodName(fn))
+		for _, d := range directives {
+			a := ignoredAction{
+				Action:   action,
+				Reason:   d.Reason,
+				Position: position{Filename: g.displayPath(d.Pos.Filename), Line: d.Pos.Line, Column: d.Pos.Column},
+			}
+			if !slices.Contains(actions, a) {
+				actions = append(actions, a)
+			}
+		}
+	}
+	slices.SortFunc(actions, func(a, b ignoredAction) int {
+		if c := strings.Compare(a.Action, b.Action); c != 0 {
+			return c
+		}
+		if c := strings.Compare(a.Position.Filename, b.Position.Filename); c != 0 {
+			return c
+		}
+		return a.Position.Line - b.Position.Line
+	})
+	return actions
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// injectSidPrefix starts the Sid of every statement the inject command
+// writes, so that they can be told apart from handwritten ones and
+// replaced on the next run
+const injectSidPrefix = "Iamgo"
+
+// injectMetadataKey is the key in the Metadata of a function resource
+// that gives the directory of its main package, relative to the template.
+// It's needed for AWS::Lambda::Function, which has no CodeUri
+const injectMetadataKey = "iamgo"
+
+// templateFunction is a Lambda function in a SAM or CloudFormation template
+type templateFunction struct {
+	id       string
+	resource *yaml.Node
+	// Absolute directory of the main package of the handler
+	dir string
+}
+
+// inject updates the policies of every function in a SAM or CloudFormation
+// template with the statements its handler needs, and writes the template
+// to w or, if write is set, back to its file. Functions whose main package
+// isn't among the analyzed ones are left as they are
+func inject(graph *graph, filename string, write bool, includeReflection bool, cfg config, w io.Writer) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 {
+		return fmt.Errorf("%s: not a template", filename)
+	}
+	resources := mappingValue(doc.Content[0], "Resources")
+	if resources == nil || resources.Kind != yaml.MappingNode {
+		return fmt.Errorf("%s: no Resources", filename)
+	}
+
+	fns, err := templateFunctions(resources, filepath.Dir(filename))
+	if err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+	results := binaryResults(graph, includeReflection, cfg.Suppress)
+	for _, fn := range fns {
+		var actions []string
+		found := false
+		for _, r := range results {
+			if dir, err := filepath.Abs(r.Dir); err == nil && dir == fn.dir {
+				actions, found = r.Actions, true
+				break
+			}
+		}
+		if !found {
+			slog.Warn("no main package analyzed for function, leaving it as it is", "function", fn.id, "dir", fn.dir)
+			continue
+		}
+
+		policy := newPolicy(actions, cfg.Resources)
+		for i := range policy.Statement {
+			policy.Statement[i].Sid = fmt.Sprintf("%s%d", injectSidPrefix, i+1)
+		}
+		if err := injectPolicy(resources, fn, policy); err != nil {
+			return fmt.Errorf("%s: %s: %w", filename, fn.id, err)
+		}
+		slog.Info("updated policy", "function", fn.id, "actions", len(actions))
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return err
+	}
+	if err := enc.Close(); err != nil {
+		return err
+	}
+	if write {
+		return os.WriteFile(filename, buf.Bytes(), 0o644)
+	}
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// templateFunctions returns the Lambda functions among the resources of a
+// template in directory dir, in the order they're defined. Functions
+// without a directory of Go code, e.g. ones written in another language or
+// with their code in S3, are skipped
+func templateFunctions(resources *yaml.Node, dir string) ([]templateFunction, error) {
+	var fns []templateFunction
+	for i := 0; i+1 < len(resources.Content); i += 2 {
+		id, resource := resources.Content[i].Value, resources.Content[i+1]
+		typ := mappingValue(resource, "Type")
+		if typ == nil || (typ.Value != "AWS::Serverless::Function" && typ.Value != "AWS::Lambda::Function") {
+			continue
+		}
+
+		var codeDir string
+		if v := mappingValue(mappingValue(resource, "Metadata"), injectMetadataKey); v != nil && v.Kind == yaml.ScalarNode {
+			codeDir = v.Value
+		} else if v := mappingValue(mappingValue(resource, "Properties"), "CodeUri"); typ.Value == "AWS::Serverless::Function" && v != nil && v.Kind == yaml.ScalarNode && v.Tag == "!!str" {
+			codeDir = v.Value
+		}
+		if codeDir == "" || strings.Contains(codeDir, "://") {
+			slog.Debug("skipping function without a directory of code", "function", id)
+			continue
+		}
+		abs, err := filepath.Abs(filepath.Join(dir, codeDir))
+		if err != nil {
+			return nil, err
+		}
+		fns = append(fns, templateFunction{id: id, resource: resource, dir: abs})
+	}
+	return fns, nil
+}
+
+// injectPolicy puts a policy in the template for a function. If the
+// function has a role defined in the template the policy is an inline
+// policy of the role, named after the function. Otherwise, for SAM, it's
+// a policy document in the Policies of the function, replacing the one
+// written last time
+func injectPolicy(resources *yaml.Node, fn templateFunction, policy policyDocument) error {
+	props := mappingValue(fn.resource, "Properties")
+	if props == nil {
+		props = &yaml.Node{Kind: yaml.MappingNode}
+		setMappingValue(fn.resource, "Properties", props)
+	}
+
+	if role := mappingValue(props, "Role"); role != nil {
+		id := getAttResource(role)
+		roleResource := mappingValue(resources, id)
+		if t := mappingValue(roleResource, "Type"); id == "" || t == nil || t.Value != "AWS::IAM::Role" {
+			return fmt.Errorf("its Role isn't an AWS::IAM::Role in the template, add the policy to the role yourself")
+		}
+		item, err := yamlNode(struct {
+			PolicyName     string
+			PolicyDocument policyDocument
+		}{"iamgo-" + fn.id, policy})
+		if err != nil {
+			return err
+		}
+		roleProps := mappingValue(roleResource, "Properties")
+		if roleProps == nil {
+			roleProps = &yaml.Node{Kind: yaml.MappingNode}
+			setMappingValue(roleResource, "Properties", roleProps)
+		}
+		policies := mappingValue(roleProps, "Policies")
+		if policies == nil || policies.Kind != yaml.SequenceNode {
+			policies = &yaml.Node{Kind: yaml.SequenceNode}
+			setMappingValue(roleProps, "Policies", policies)
+		}
+		for i, p := range policies.Content {
+			if name := mappingValue(p, "PolicyName"); name != nil && name.Value == "iamgo-"+fn.id {
+				policies.Content[i] = item
+				return nil
+			}
+		}
+		policies.Content = append(policies.Content, item)
+		return nil
+	}
+
+	if t := mappingValue(fn.resource, "Type"); t.Value != "AWS::Serverless::Function" {
+		return fmt.Errorf("it has no Role in the template to add the policy to")
+	}
+	item, err := yamlNode(policy)
+	if err != nil {
+		return err
+	}
+	policies := mappingValue(props, "Policies")
+	switch {
+	case policies == nil:
+		policies = &yaml.Node{Kind: yaml.SequenceNode}
+		setMappingValue(props, "Policies", policies)
+	case policies.Kind != yaml.SequenceNode:
+		// A single policy, e.g. "AWSLambdaBasicExecutionRole", becomes
+		// the first in a list
+		single := *policies
+		*policies = yaml.Node{Kind: yaml.SequenceNode, Content: []*yaml.Node{&single}}
+	}
+	kept := policies.Content[:0]
+	for _, p := range policies.Content {
+		if !injectedPolicy(p) {
+			kept = append(kept, p)
+		}
+	}
+	policies.Content = append(kept, item)
+	return nil
+}
+
+// injectedPolicy returns whether a policy document in the Policies of a
+// SAM function was written by the inject command, i.e. all its statements
+// have a Sid it uses
+func injectedPolicy(p *yaml.Node) bool {
+	statements := mappingValue(p, "Statement")
+	if statements == nil || statements.Kind != yaml.SequenceNode || len(statements.Content) == 0 {
+		return false
+	}
+	for _, s := range statements.Content {
+		sid := mappingValue(s, "Sid")
+		if sid == nil || !strings.HasPrefix(sid.Value, injectSidPrefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// getAttResource returns the logical ID of the resource in a reference to
+// its ARN, written as "!GetAtt Role.Arn" or "Fn::GetAtt: [Role, Arn]", or
+// "" if the node isn't one
+func getAttResource(n *yaml.Node) string {
+	if n.Kind == yaml.ScalarNode && n.Tag == "!GetAtt" {
+		id, attr, _ := strings.Cut(n.Value, ".")
+		if attr == "Arn" {
+			return id
+		}
+		return ""
+	}
+	if n.Kind == yaml.SequenceNode && n.Tag == "!GetAtt" && len(n.Content) == 2 && n.Content[1].Value == "Arn" {
+		return n.Content[0].Value
+	}
+	if v := mappingValue(n, "Fn::GetAtt"); v != nil {
+		return getAttResource(&yaml.Node{Kind: v.Kind, Tag: "!GetAtt", Value: v.Value, Content: v.Content})
+	}
+	return ""
+}
+
+// mappingValue returns the value of a key in a YAML mapping, or nil if
+// the node isn't a mapping or the key isn't in it
+func mappingValue(n *yaml.Node, key string) *yaml.Node {
+	if n == nil || n.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return n.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// setMappingValue adds a key to a YAML mapping, or replaces its value
+func setMappingValue(n *yaml.Node, key string, value *yaml.Node) {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			n.Content[i+1] = value
+			return
+		}
+	}
+	n.Content = append(n.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, value)
+}
+
+// yamlNode converts a value to a YAML node through JSON, so that the JSON
+// field names of e.g. policyDocument are used, in block style to match
+// the rest of the template
+func yamlNode(v any) (*yaml.Node, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	n := doc.Content[0]
+	var blockStyle func(*yaml.Node)
+	blockStyle = func(n *yaml.Node) {
+		n.Style = 0
+		for _, c := range n.Content {
+			blockStyle(c)
+		}
+	}
+	blockStyle(n)
+	return n, nil
+}
@@ -0,0 +1,181 @@
+// Package analysis finds the SDK calls reachable in a loaded program and
+// what they need: the actions of the calls that aren't ignored by
+// //iamgo:ignore comments or left out by service, those of the helper
+// libraries the program calls and of the baseline of where it runs, and
+// the places the calls are made from. It's what the iamgo command and the
+// iamgo package have in common
+package analysis
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+
+	"golang.org/x/tools/go/callgraph"
+	"golang.org/x/tools/go/ssa"
+
+	"github.com/esprimo/iamgo/internal/libraries"
+	"github.com/esprimo/iamgo/internal/loader"
+	"github.com/esprimo/iamgo/internal/mapping"
+	"github.com/esprimo/iamgo/internal/sdk"
+)
+
+// levelTrace is the level of the messages about every SDK call found, the
+// same as -vv of the command
+const levelTrace = slog.LevelDebug - 4
+
+// Config controls what's found in a program
+type Config struct {
+	// Recognize the SDK calls among the functions
+	Detectors sdk.Detectors
+	// Actions returns the IAM actions an SDK method, e.g. "s3.PutObject",
+	// of an SDK version, e.g. "v2", requires, or nil for none
+	Actions func(version, method string) []string
+	// If set, only SDK calls to these services are included, and SDK calls
+	// to the excluded ones are left out, see MatchService
+	Services        []string
+	ExcludeServices []string
+	// Helper libraries whose actions are added when the program calls them
+	Libraries []libraries.Library
+	// Also add the actions of libraries that send traces
+	Tracing bool
+	// Compute service whose baseline is added, see libraries.Baselines, or
+	// an empty string for none
+	Target string
+	// Add the baseline of Lambda when the program is a Lambda function
+	RuntimeBaseline bool
+}
+
+// Graph is a loaded program and what's found in it, computed when first
+// needed. It's safe to use from several goroutines
+type Graph struct {
+	*loader.Program
+	config Config
+
+	// SDK calls whose actions are ignored by //iamgo:ignore comments, see
+	// IgnoredCalls
+	ignored     map[*ssa.Function][]Directive
+	ignoredOnce sync.Once
+	// Where method values are created, see MethodValues
+	boundClosures    map[*ssa.Function][]*ssa.MakeClosure
+	methodValuesOnce sync.Once
+	// Copy of the call graph to find call paths in, see PathGraph
+	paths     *callgraph.Graph
+	pathsOnce sync.Once
+}
+
+// New returns the Graph of a program
+func New(program *loader.Program, config Config) *Graph {
+	return &Graph{Program: program, config: config}
+}
+
+// Method returns the SDK method a function is, e.g. "s3.PutObject", and
+// the SDK version, e.g. "v2". Returns empty strings if it isn't an SDK call
+func (g *Graph) Method(fn *ssa.Function) (version, method string) {
+	d, method := g.config.Detectors.Detect(fn)
+	if d == nil {
+		return "", ""
+	}
+	return d.Name(), method
+}
+
+// Actions returns the IAM actions an SDK call requires, nil if it isn't one
+// or requires none
+func (g *Graph) Actions(fn *ssa.Function) []string {
+	version, method := g.Method(fn)
+	if method == "" {
+		return nil
+	}
+	return g.config.Actions(version, method)
+}
+
+// SDKCalls returns the reachable SDK calls that aren't ignored by
+// //iamgo:ignore comments or left out by service. Those only reachable
+// through reflection are left out unless includeReflection is set
+func (g *Graph) SDKCalls(includeReflection bool) []*ssa.Function {
+	var fns []*ssa.Function
+	for fn := range g.Reachable {
+		if fn.Synthetic != "" {
+			continue // ignore synthetic wrappers etc
+		}
+
+		// Use origin rather than instantiations
+		if orig := fn.Origin(); orig != nil {
+			fn = orig
+		}
+
+		// Ignore unreachable nested functions
+		if fn.Parent() != nil {
+			continue
+		}
+
+		if _, method := g.Method(fn); method == "" {
+			continue // We only care about SDK calls
+		}
+		if _, ok := g.IgnoredCalls()[fn]; ok {
+			continue
+		}
+		if !g.IncludesService(fn) {
+			continue
+		}
+
+		// search for a path to determine if it's only reachable
+		// through reflection
+		if !includeReflection {
+			if path := g.FindPath(fn); path == nil { // only reachable through reflection
+				slog.Log(context.Background(), levelTrace, "skipped SDK call only reachable through reflection", "func", fn.String())
+				continue
+			}
+		}
+
+		slog.Log(context.Background(), levelTrace, "found SDK call", "func", fn.String())
+		fns = append(fns, fn)
+	}
+	return fns
+}
+
+// MatchService reports whether a filter of Config.Services or
+// Config.ExcludeServices matches an SDK call, by the name of its SDK
+// package (e.g. "s3" or "sesv2") if any, or an action it requires. A filter
+// is a service, by package or the prefix of its actions (e.g. "ses"), or an
+// action pattern such as "*:Delete*". Like in IAM policies and everywhere
+// else actions are matched, "*" and "?" are wildcards and case doesn't
+// matter
+func MatchService(filter, pkg, action string) bool {
+	if strings.Contains(filter, ":") {
+		return action != "" && mapping.MatchAction(filter, action)
+	}
+	return (pkg != "" && mapping.MatchAction(filter, pkg)) || (action != "" && mapping.MatchAction(filter, mapping.Service(action)))
+}
+
+// IncludesService returns whether an SDK call is to one of the services the
+// result is limited to, if any, and not to an excluded service, see
+// MatchService
+func (g *Graph) IncludesService(fn *ssa.Function) bool {
+	if len(g.config.Services) == 0 && len(g.config.ExcludeServices) == 0 {
+		return true
+	}
+	pkg := fn.Pkg.Pkg.Name()
+	actions := g.Actions(fn)
+	isService := func(filter string) bool {
+		if MatchService(filter, pkg, "") {
+			return true
+		}
+		return slices.ContainsFunc(actions, func(action string) bool { return MatchService(filter, "", action) })
+	}
+	if slices.ContainsFunc(g.config.ExcludeServices, isService) {
+		return false
+	}
+	return len(g.config.Services) == 0 || slices.ContainsFunc(g.config.Services, isService)
+}
+
+// IncludesAction is like IncludesService for an action
+func (g *Graph) IncludesAction(action string) bool {
+	isService := func(filter string) bool { return MatchService(filter, "", action) }
+	if slices.ContainsFunc(g.config.ExcludeServices, isService) {
+		return false
+	}
+	return len(g.config.Services) == 0 || slices.ContainsFunc(g.config.Services, isService)
+}
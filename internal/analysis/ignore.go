@@ -0,0 +1,295 @@
+package analysis
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"golang.org/x/tools/go/callgraph"
+	"golang.org/x/tools/go/ssa"
+
+	"github.com/esprimo/iamgo/internal/mapping"
+)
+
+// IgnorePrefix starts comments that suppress actions, e.g.
+//
+//	//iamgo:ignore s3:DeleteObject reason=legacy cleanup job
+//
+// Above a function it applies to all calls made from it, and on or above a
+// line with a call it applies to that call
+const IgnorePrefix = "//iamgo:ignore"
+
+// Directive is an //iamgo:ignore comment
+type Directive struct {
+	// Action patterns it applies to, e.g. "s3:Delete*"
+	Patterns []string
+	// Why the actions are ignored, from "reason=..."
+	Reason string
+	// Where the comment is
+	Pos token.Position
+}
+
+// Matches returns whether a directive applies to an action
+func (d Directive) Matches(action string) bool {
+	return slices.ContainsFunc(d.Patterns, func(pattern string) bool { return mapping.MatchAction(pattern, action) })
+}
+
+// fileLine is a line in a source file
+type fileLine struct {
+	filename string
+	line     int
+}
+
+// Directives are the //iamgo:ignore comments of source files, by where
+// they apply. The zero value has none
+type Directives struct {
+	// Comments in the documentation of a function, by the line of its
+	// func keyword
+	funcs map[fileLine][]Directive
+	// Other comments, by the line they're on and the line after
+	lines map[fileLine][]Directive
+}
+
+// AddFile adds the //iamgo:ignore comments of a file parsed with its
+// comments
+func (ds *Directives) AddFile(fset *token.FileSet, file *ast.File) {
+	if ds.funcs == nil {
+		ds.funcs = make(map[fileLine][]Directive)
+		ds.lines = make(map[fileLine][]Directive)
+	}
+
+	docs := make(map[*ast.Comment]*ast.FuncDecl)
+	for _, decl := range file.Decls {
+		if fn, ok := decl.(*ast.FuncDecl); ok && fn.Doc != nil {
+			for _, c := range fn.Doc.List {
+				docs[c] = fn
+			}
+		}
+	}
+
+	for _, group := range file.Comments {
+		for _, c := range group.List {
+			d, ok := parseIgnore(c.Text)
+			if !ok {
+				continue
+			}
+			d.Pos = fset.Position(c.Pos())
+			if len(d.Patterns) == 0 {
+				slog.Warn(IgnorePrefix+" needs at least one action", "pos", d.Pos)
+				continue
+			}
+			if fn, ok := docs[c]; ok {
+				pos := fset.Position(fn.Type.Func)
+				ds.funcs[fileLine{pos.Filename, pos.Line}] = append(ds.funcs[fileLine{pos.Filename, pos.Line}], d)
+				continue
+			}
+			for _, line := range []int{d.Pos.Line, d.Pos.Line + 1} {
+				ds.lines[fileLine{d.Pos.Filename, line}] = append(ds.lines[fileLine{d.Pos.Filename, line}], d)
+			}
+		}
+	}
+}
+
+// Match returns the directive that ignores an action required by a call,
+// given where the function the call is made in is declared (its func
+// keyword, or the zero Position if it has no declaration) and where the
+// call is made. Returns false if none does
+func (ds *Directives) Match(action string, decl, site token.Position) (Directive, bool) {
+	for _, directives := range [][]Directive{ds.funcs[fileLine{decl.Filename, decl.Line}], ds.lines[fileLine{site.Filename, site.Line}]} {
+		for _, d := range directives {
+			if d.Matches(action) {
+				return d, true
+			}
+		}
+	}
+	return Directive{}, false
+}
+
+// all returns every directive
+func (ds *Directives) all() []Directive {
+	var all []Directive
+	for _, directives := range ds.funcs {
+		all = append(all, directives...)
+	}
+	for _, directives := range ds.lines {
+		all = append(all, directives...)
+	}
+	return all
+}
+
+// parseIgnore parses the text of an //iamgo:ignore comment. Returns false
+// if it isn't one
+func parseIgnore(text string) (Directive, bool) {
+	rest, ok := strings.CutPrefix(text, IgnorePrefix)
+	if !ok || (rest != "" && rest[0] != ' ' && rest[0] != '\t') {
+		return Directive{}, false
+	}
+	var d Directive
+	rest, d.Reason, _ = strings.Cut(rest, "reason=")
+	d.Reason = strings.TrimSpace(d.Reason)
+	d.Patterns = strings.FieldsFunc(rest, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' })
+	return d, true
+}
+
+// IgnoredCalls returns the reachable SDK calls whose actions are ignored by
+// //iamgo:ignore comments, see findIgnored. They're only looked for once,
+// after the mapping is loaded
+func (g *Graph) IgnoredCalls() map[*ssa.Function][]Directive {
+	g.ignoredOnce.Do(func() { g.ignored = g.findIgnored() })
+	return g.ignored
+}
+
+// findIgnored returns the reachable SDK calls whose actions are ignored by
+// //iamgo:ignore comments, along with the comments that ignore them. A call
+// is only ignored if every call path to it goes through an ignored function
+// or call
+func (g *Graph) findIgnored() map[*ssa.Function][]Directive {
+	ds := g.directives()
+	all := ds.all()
+	if len(all) == 0 {
+		return nil
+	}
+
+	// The SDK calls to check, by the actions they require
+	targets := make(map[string][]*ssa.Function)
+	required := make(map[*ssa.Function]int)
+	for fn := range g.Reachable {
+		if fn.Synthetic != "" || fn.Pkg == nil {
+			continue
+		}
+		actions := slices.Clone(g.Actions(fn))
+		slices.Sort(actions)
+		for _, action := range slices.Compact(actions) {
+			if action != "" {
+				targets[action] = append(targets[action], fn)
+				required[fn]++
+			}
+		}
+	}
+
+	// Calls only reachable through reflection aren't affected
+	before := g.reachableSkipping(func(*callgraph.Edge) bool { return false })
+
+	// A call is ignored if all the actions it requires are
+	found := make(map[*ssa.Function][]Directive)
+	ignoredActions := make(map[*ssa.Function]int)
+	for action, fns := range targets {
+		if !slices.ContainsFunc(all, func(d Directive) bool { return d.Matches(action) }) {
+			continue
+		}
+		// The directives that cut off a call path while searching
+		var used []Directive
+		skip := func(edge *callgraph.Edge) bool {
+			var decl, site token.Position
+			if fd := declOf(edge.Caller.Func); fd != nil {
+				decl = g.Prog.Fset.Position(fd.Type.Func)
+			}
+			if edge.Site != nil {
+				site = g.Prog.Fset.Position(edge.Site.Pos())
+			}
+			d, ok := ds.Match(action, decl, site)
+			if ok && !slices.ContainsFunc(used, func(u Directive) bool { return u.Pos == d.Pos }) {
+				used = append(used, d)
+			}
+			return ok
+		}
+
+		reached := g.reachableSkipping(skip)
+		for _, fn := range fns {
+			if before[fn] && !reached[fn] && len(used) > 0 {
+				for _, d := range used {
+					if !slices.ContainsFunc(found[fn], func(f Directive) bool { return f.Pos == d.Pos }) {
+						found[fn] = append(found[fn], d)
+					}
+				}
+				ignoredActions[fn]++
+			}
+		}
+	}
+
+	ignored := make(map[*ssa.Function][]Directive)
+	for fn, directives := range found {
+		if ignoredActions[fn] == required[fn] {
+			ignored[fn] = directives
+		}
+	}
+	return ignored
+}
+
+// reachableSkipping returns the functions reachable from the roots without
+// following the call graph edges skip returns true for
+func (g *Graph) reachableSkipping(skip func(*callgraph.Edge) bool) map[*ssa.Function]bool {
+	return g.ReachableFrom(g.Roots, skip)
+}
+
+// ReachableFrom returns the functions reachable from some of the roots
+// without following the call graph edges skip returns true for
+func (g *Graph) ReachableFrom(roots []*ssa.Function, skip func(*callgraph.Edge) bool) map[*ssa.Function]bool {
+	visited := make(map[*ssa.Function]bool)
+	var queue []*callgraph.Node
+	for _, root := range roots {
+		if node := g.CallGraph.Nodes[root]; node != nil {
+			visited[root] = true
+			queue = append(queue, node)
+		}
+	}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, edge := range current.Out {
+			if visited[edge.Callee.Func] || skip(edge) {
+				continue
+			}
+			visited[edge.Callee.Func] = true
+			queue = append(queue, edge.Callee)
+		}
+	}
+	return visited
+}
+
+// declOf returns the declaration of the function a function is, or is
+// nested in, or nil if there's none (e.g. wrappers)
+func declOf(fn *ssa.Function) *ast.FuncDecl {
+	for fn.Parent() != nil {
+		fn = fn.Parent()
+	}
+	if orig := fn.Origin(); orig != nil {
+		fn = orig
+	}
+	decl, _ := fn.Syntax().(*ast.FuncDecl)
+	return decl
+}
+
+// directives returns the //iamgo:ignore comments in the source files of
+// the analyzed packages that have reachable functions
+func (g *Graph) directives() *Directives {
+	analyzed := make(map[*ssa.Package]bool)
+	for _, pkg := range g.Packages {
+		analyzed[pkg] = true
+	}
+	var filenames []string
+	for fn := range g.Reachable {
+		if decl := declOf(fn); decl != nil && analyzed[fn.Pkg] {
+			filename := g.Prog.Fset.Position(decl.Pos()).Filename
+			if !slices.Contains(filenames, filename) {
+				filenames = append(filenames, filename)
+			}
+		}
+	}
+	slices.Sort(filenames)
+
+	var ds Directives
+	fset := token.NewFileSet()
+	for _, filename := range filenames {
+		file, err := parser.ParseFile(fset, filename, nil, parser.ParseComments|parser.SkipObjectResolution)
+		if err != nil {
+			slog.Warn("failed to read source for "+IgnorePrefix+" comments", "file", filename, "err", err)
+			continue
+		}
+		ds.AddFile(fset, file)
+	}
+	return &ds
+}
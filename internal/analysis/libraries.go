@@ -0,0 +1,127 @@
+package analysis
+
+import (
+	"slices"
+	"strings"
+
+	"golang.org/x/tools/go/callgraph"
+	"golang.org/x/tools/go/ssa"
+
+	"github.com/esprimo/iamgo/internal/libraries"
+	"github.com/esprimo/iamgo/internal/mapping"
+	"github.com/esprimo/iamgo/schema"
+)
+
+// UsedLibraries returns the helper libraries the program calls, with the
+// actions they need that aren't suppressed or left out by service, see
+// IncludesAction. If reached isn't nil, only the functions in it count
+func (g *Graph) UsedLibraries(includeReflection bool, suppress []string, reached map[*ssa.Function]bool) []schema.Library {
+	var used []schema.Library
+	for _, lib := range g.config.Libraries {
+		if lib.Tracing && !g.config.Tracing {
+			continue
+		}
+		var actions []string
+		for _, action := range lib.Actions {
+			if !suppressed(action, suppress) && g.IncludesAction(action) {
+				actions = append(actions, action)
+			}
+		}
+		if len(actions) == 0 || len(g.LibraryEntries(lib, includeReflection, reached)) == 0 {
+			continue
+		}
+		used = append(used, schema.Library{Name: lib.Name, Actions: actions, Reason: lib.Reason})
+	}
+	return used
+}
+
+// LibraryEntries returns the reachable functions of a library that are
+// called from outside of it, sorted by name. Those only reachable through
+// reflection are left out unless includeReflection is set, and if reached
+// isn't nil so are those not in it
+func (g *Graph) LibraryEntries(lib libraries.Library, includeReflection bool, reached map[*ssa.Function]bool) []*ssa.Function {
+	// Matching compiles the patterns, so it's done once per package
+	matches := make(map[*ssa.Package]bool)
+	inLibrary := func(fn *ssa.Function) bool {
+		if fn.Pkg == nil {
+			return false
+		}
+		m, ok := matches[fn.Pkg]
+		if !ok {
+			m = lib.Matches(fn.Pkg.Pkg.Path())
+			matches[fn.Pkg] = m
+		}
+		return m
+	}
+
+	var fns []*ssa.Function
+	for fn := range g.Reachable {
+		if fn.Synthetic != "" || !inLibrary(fn) || (reached != nil && !reached[fn]) {
+			continue
+		}
+		node := g.CallGraph.Nodes[fn]
+		if node == nil || !slices.ContainsFunc(node.In, func(edge *callgraph.Edge) bool { return !inLibrary(edge.Caller.Func) }) {
+			continue
+		}
+		if !includeReflection && g.FindPath(fn) == nil {
+			continue
+		}
+		fns = append(fns, fn)
+	}
+	slices.SortFunc(fns, func(a, b *ssa.Function) int { return strings.Compare(a.String(), b.String()) })
+	return fns
+}
+
+// TracingLibraries returns the names of the libraries that send traces the
+// program calls, whose actions weren't added because Config.Tracing isn't set
+func (g *Graph) TracingLibraries(includeReflection bool) []string {
+	if g.config.Tracing {
+		return nil
+	}
+	var names []string
+	for _, lib := range g.config.Libraries {
+		if lib.Tracing && len(g.LibraryEntries(lib, includeReflection, nil)) > 0 {
+			names = append(names, lib.Name)
+		}
+	}
+	return names
+}
+
+// Baseline returns the target whose baseline applies and its actions,
+// leaving out the ones that are suppressed or left out by service. That's
+// Config.Target, or with Config.RuntimeBaseline Lambda if the program is a
+// Lambda function. The
+// network interface actions are added for Lambda functions that use a
+// client of VPC resources. If reached isn't nil, only the functions in it
+// count
+func (g *Graph) Baseline(includeReflection bool, suppress []string, reached map[*ssa.Function]bool) (string, []string) {
+	name := g.config.Target
+	if name == "" && g.config.RuntimeBaseline && len(g.LibraryEntries(libraries.LambdaRuntime, includeReflection, reached)) > 0 {
+		name = "lambda"
+	}
+	if name == "" {
+		return "", nil
+	}
+	all := libraries.Baselines[name].Actions
+	if name == "lambda" && len(g.LibraryEntries(libraries.VPCClients, includeReflection, reached)) > 0 {
+		all = append(all[:len(all):len(all)], libraries.VPCBaseline...)
+	}
+	var actions []string
+	for _, action := range all {
+		if !suppressed(action, suppress) && g.IncludesAction(action) {
+			actions = append(actions, action)
+		}
+	}
+	return name, actions
+}
+
+// BaselineActions returns the actions of the baseline, see Baseline
+func (g *Graph) BaselineActions(includeReflection bool, suppress []string, reached map[*ssa.Function]bool) []string {
+	_, actions := g.Baseline(includeReflection, suppress, reached)
+	return actions
+}
+
+// suppressed returns whether an action matches any of the suppress patterns
+func suppressed(action string, patterns []string) bool {
+	return slices.ContainsFunc(patterns, func(pattern string) bool { return mapping.MatchAction(pattern, action) })
+}
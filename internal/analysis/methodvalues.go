@@ -0,0 +1,155 @@
+package analysis
+
+import (
+	"go/token"
+	"slices"
+	"strings"
+
+	"golang.org/x/tools/go/callgraph"
+	"golang.org/x/tools/go/ssa"
+)
+
+// CodePackage returns the package whose code a function is: its package,
+// the package of the generic function for an instance of it, or nil for
+// wrappers the compiler generates, e.g. of method values
+func CodePackage(fn *ssa.Function) *ssa.Package {
+	if fn.Pkg == nil && fn.Origin() != nil {
+		return fn.Origin().Pkg
+	}
+	return fn.Pkg
+}
+
+// IsBoundWrapper reports whether a function is the wrapper of a method
+// value, e.g. of client.GetObject in "op := client.GetObject", which calls
+// the method on the receiver bound when the value was created. Calls of
+// the value are wherever it's passed, so where it's created tells more
+// about which SDK call is made, and it holds the receiver
+func IsBoundWrapper(fn *ssa.Function) bool {
+	return strings.HasPrefix(fn.Synthetic, "bound method wrapper")
+}
+
+// IsPromotionWrapper reports whether a function is the wrapper of a method
+// promoted from an embedded field, e.g. of GetObject for a struct embedding
+// *s3.Client, which interfaces and method values of the struct call. It
+// selects the field and calls the method on it
+func IsPromotionWrapper(fn *ssa.Function) bool {
+	return strings.HasPrefix(fn.Synthetic, "wrapper for ")
+}
+
+// MethodValues returns where the reachable functions create method values,
+// by the wrapper they're created with, computed once
+func (g *Graph) MethodValues() map[*ssa.Function][]*ssa.MakeClosure {
+	g.methodValuesOnce.Do(func() {
+		g.boundClosures = make(map[*ssa.Function][]*ssa.MakeClosure)
+		for fn := range g.Reachable {
+			for _, b := range fn.Blocks {
+				for _, instr := range b.Instrs {
+					closure, ok := instr.(*ssa.MakeClosure)
+					if !ok {
+						continue
+					}
+					if wrapper := closure.Fn.(*ssa.Function); IsBoundWrapper(wrapper) {
+						g.boundClosures[wrapper] = append(g.boundClosures[wrapper], closure)
+					}
+				}
+			}
+		}
+	})
+	return g.boundClosures
+}
+
+// SDKCallSites returns the edges where code outside of the AWS SDK calls
+// into an SDK call, and where it creates method values of it, e.g.
+// client.GetObject passed to a helper, which hold the client. Calls of a
+// method promoted from an embedded client through an interface go through
+// a wrapper, whose call is the one with the client as receiver. For SDK v1
+// that's often not the SDK call itself but a method like
+// GetObjectWithContext, which in turn calls GetObjectRequest. Without the
+// synthetic nodes in cg, see PathGraph, there are only the calls
+func (g *Graph) SDKCallSites(cg *callgraph.Graph, fn *ssa.Function) ([]*callgraph.Edge, []*ssa.MakeClosure) {
+	var sites []*callgraph.Edge
+	var values []*ssa.MakeClosure
+	visited := make(map[*callgraph.Node]bool)
+	var visit func(n *callgraph.Node)
+	visit = func(n *callgraph.Node) {
+		if n == nil || visited[n] {
+			return
+		}
+		visited[n] = true
+		for _, edge := range n.In {
+			caller := edge.Caller.Func
+			if IsBoundWrapper(caller) {
+				for _, closure := range g.MethodValues()[caller] {
+					if parent := closure.Parent(); CodePackage(parent) == fn.Pkg {
+						visit(cg.Nodes[parent])
+					} else if !slices.Contains(values, closure) {
+						values = append(values, closure)
+					}
+				}
+				continue
+			}
+			if IsPromotionWrapper(caller) && edge.Site != nil {
+				// The client is the embedded field the wrapper selects
+				sites = append(sites, edge)
+				continue
+			}
+			if pkg := CodePackage(caller); pkg == nil || pkg == fn.Pkg {
+				visit(edge.Caller) // still within the SDK, keep going
+				continue
+			}
+			if edge.Site != nil {
+				sites = append(sites, edge)
+			}
+		}
+	}
+	visit(cg.Nodes[fn])
+	return sites, values
+}
+
+// CallSites returns the places in the analyzed packages that call an SDK
+// function, directly or through other functions of its package, e.g. the
+// v1 Request method called by the method the program calls. For a method
+// value, e.g. client.GetObject passed to a helper, it's where the value is
+// created rather than where the helper calls it
+func (g *Graph) CallSites(fn *ssa.Function) []token.Pos {
+	var sites []token.Pos
+	visited := map[*ssa.Function]bool{fn: true}
+	queue := []*ssa.Function{fn}
+	visit := func(caller *ssa.Function) {
+		if !visited[caller] {
+			visited[caller] = true
+			queue = append(queue, caller)
+		}
+	}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		node := g.CallGraph.Nodes[current]
+		if node == nil {
+			continue
+		}
+		for _, edge := range node.In {
+			caller := edge.Caller.Func
+			pkg := CodePackage(caller)
+			switch {
+			case edge.Site == nil:
+			case IsBoundWrapper(caller):
+				for _, closure := range g.MethodValues()[caller] {
+					switch parent := closure.Parent(); {
+					case CodePackage(parent) == fn.Pkg:
+						visit(parent)
+					case slices.Contains(g.Packages, CodePackage(parent)):
+						sites = append(sites, closure.Pos())
+					}
+				}
+			case pkg == nil:
+				visit(caller) // another wrapper, e.g. of a method expression
+			case pkg == fn.Pkg:
+				visit(caller)
+			case slices.Contains(g.Packages, pkg):
+				sites = append(sites, edge.Site.Pos())
+			}
+		}
+	}
+	return sites
+}
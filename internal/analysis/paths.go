@@ -0,0 +1,178 @@
+package analysis
+
+import (
+	"slices"
+
+	"golang.org/x/tools/go/callgraph"
+	"golang.org/x/tools/go/ssa"
+)
+
+// FindPath does a BFS to find the shortest path from any root to the
+// target and returns the path. Returns nil if no path is found
+func (g *Graph) FindPath(target *ssa.Function) []*callgraph.Edge {
+	return g.FindPathFrom(g.Roots, target)
+}
+
+// FindPathFrom is like FindPath but only considers paths starting from
+// the given roots
+func (g *Graph) FindPathFrom(roots []*ssa.Function, target *ssa.Function) []*callgraph.Edge {
+	var shortestPath []*callgraph.Edge
+	for _, root := range roots {
+		path := g.BFS(root, target)
+		if path != nil {
+			if shortestPath == nil || len(shortestPath) > len(path) {
+				shortestPath = path
+			}
+		}
+	}
+
+	return shortestPath
+}
+
+// FindPaths returns up to n of the shortest paths from any of the roots to
+// the target, shortest first. Each path passes through a different sequence
+// of functions than the others, so paths that only differ by call site
+// (e.g. a function calling another twice) count as one.
+//
+// It's a variant of Yen's k shortest paths algorithm: each new path is the
+// shortest deviation from a previously found path that doesn't continue to
+// a function an already found path with the same beginning continues to
+func (g *Graph) FindPaths(roots []*ssa.Function, target *ssa.Function, n int) [][]*callgraph.Edge {
+	first := g.FindPathFrom(roots, target)
+	if first == nil {
+		return nil
+	}
+	found := [][]*callgraph.Edge{first}
+
+	// Paths from the other roots are deviations too
+	var candidates [][]*callgraph.Edge
+	for _, root := range roots {
+		if len(first) > 0 && root == first[0].Caller.Func {
+			continue
+		}
+		if path := g.BFS(root, target); path != nil && len(path) > 0 {
+			candidates = append(candidates, path)
+		}
+	}
+
+	for len(found) < n {
+		prev := found[len(found)-1]
+		for i := range prev {
+			// Deviate from prev at the caller of its i:th step, without
+			// going back through the functions before it
+			avoid := make(map[*callgraph.Node]bool)
+			for _, edge := range prev[:i] {
+				avoid[edge.Caller] = true
+			}
+			avoidFirst := make(map[*callgraph.Node]bool)
+			for _, path := range found {
+				if len(path) > i && samePath(path[:i], prev[:i]) && path[i].Caller == prev[i].Caller {
+					avoidFirst[path[i].Callee] = true
+				}
+			}
+
+			tail := g.BFSAvoiding(prev[i].Caller.Func, target, avoid, avoidFirst)
+			if tail == nil {
+				continue
+			}
+			candidate := append(slices.Clip(prev[:i]), tail...)
+			if !slices.ContainsFunc(found, func(p []*callgraph.Edge) bool { return samePath(p, candidate) }) &&
+				!slices.ContainsFunc(candidates, func(p []*callgraph.Edge) bool { return samePath(p, candidate) }) {
+				candidates = append(candidates, candidate)
+			}
+		}
+		if len(candidates) == 0 {
+			break
+		}
+
+		// The shortest deviation is the next path
+		slices.SortStableFunc(candidates, func(a, b []*callgraph.Edge) int { return len(a) - len(b) })
+		found = append(found, candidates[0])
+		candidates = candidates[1:]
+	}
+	return found
+}
+
+// samePath reports whether two paths pass through the same functions
+func samePath(a, b []*callgraph.Edge) bool {
+	return slices.EqualFunc(a, b, func(x, y *callgraph.Edge) bool {
+		return x.Caller == y.Caller && x.Callee == y.Callee
+	})
+}
+
+// PathGraph returns a copy of the call graph without its synthetic nodes,
+// e.g. the wrappers of method values, so that every step of a call path
+// is in the source. The call graph itself keeps them, since CallSites and
+// SDKCallSites follow method values through them
+func (g *Graph) PathGraph() *callgraph.Graph {
+	g.pathsOnce.Do(func() {
+		cg := callgraph.New(g.CallGraph.Root.Func)
+		// In the order the nodes were created, so the edges are too
+		nodes := make([]*callgraph.Node, 0, len(g.CallGraph.Nodes))
+		for _, node := range g.CallGraph.Nodes {
+			nodes = append(nodes, node)
+		}
+		slices.SortFunc(nodes, func(a, b *callgraph.Node) int { return a.ID - b.ID })
+		for _, node := range nodes {
+			cg.CreateNode(node.Func)
+		}
+		for _, node := range nodes {
+			for _, edge := range node.Out {
+				callgraph.AddEdge(cg.Nodes[edge.Caller.Func], edge.Site, cg.Nodes[edge.Callee.Func])
+			}
+		}
+		cg.DeleteSyntheticNodes()
+		g.paths = cg
+	})
+	return g.paths
+}
+
+// BFS does a breadth-first search to find a path from one function
+// to another and returns the path. Returns nil if no path is found
+func (g *Graph) BFS(start *ssa.Function, target *ssa.Function) []*callgraph.Edge {
+	return g.BFSAvoiding(start, target, nil, nil)
+}
+
+// BFSAvoiding is like BFS but never visits the nodes in avoid, and never
+// goes from start directly to any of the nodes in avoidFirst
+func (g *Graph) BFSAvoiding(start *ssa.Function, target *ssa.Function, avoid, avoidFirst map[*callgraph.Node]bool) []*callgraph.Edge {
+	root := g.PathGraph().Nodes[start]
+	if root == nil { // e.g. excluded
+		return nil
+	}
+	visited := make(map[*callgraph.Node]*callgraph.Edge)
+	visited[root] = nil
+	queue := []*callgraph.Node{root}
+
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+
+		if current.Func == target { // path found
+			path := []*callgraph.Edge{}
+
+			// traverse back up to where we started
+			for {
+				edge := visited[current]
+				if edge == nil { // we've reached the start
+					slices.Reverse(path)
+					return path
+				}
+				path = append(path, edge)
+				current = edge.Caller
+			}
+		}
+
+		for _, edge := range current.Out {
+			if avoid[edge.Callee] || (current == root && avoidFirst[edge.Callee]) {
+				continue
+			}
+			if _, ok := visited[edge.Callee]; !ok {
+				visited[edge.Callee] = edge
+				queue = append(queue, edge.Callee)
+			}
+		}
+	}
+
+	return nil
+}
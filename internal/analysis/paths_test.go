@@ -0,0 +1,148 @@
+package analysis
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/esprimo/iamgo/internal/loader"
+	"golang.org/x/tools/go/callgraph"
+	"golang.org/x/tools/go/callgraph/rta"
+	"golang.org/x/tools/go/ssa"
+	"golang.org/x/tools/go/ssa/ssautil"
+)
+
+// There are three ways from main to target, a cycle between d and e, and
+// no way from f
+const pathsProgram = `package main
+
+var x bool
+
+func main() {
+	a()
+	b()
+	c()
+	f()
+}
+
+func a() { target() }
+func b() { d() }
+func c() { d() }
+
+func d() {
+	if x {
+		e()
+	}
+	target()
+}
+
+func e()      { d() }
+func f()      {}
+func target() {}
+`
+
+// buildGraph builds a program of a single file without imports, with its
+// call graph as Load builds it
+func buildGraph(t *testing.T, src string) (*Graph, *ssa.Package) {
+	t.Helper()
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "main.go", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	main, _, err := ssautil.BuildPackage(&types.Config{}, fset, types.NewPackage("example.com/app", "main"), []*ast.File{f}, ssa.InstantiateGenerics)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := []*ssa.Function{main.Func("init"), main.Func("main")}
+	res := rta.Analyze(roots, true)
+	program := &loader.Program{
+		Prog:      main.Prog,
+		Packages:  []*ssa.Package{main},
+		Mains:     []*ssa.Package{main},
+		Roots:     roots,
+		CallGraph: res.CallGraph,
+		Reachable: res.Reachable,
+	}
+	return New(program, Config{}), main
+}
+
+// pathNames returns the functions a path passes through, e.g. "main a target"
+func pathNames(path []*callgraph.Edge) string {
+	names := []string{path[0].Caller.Func.Name()}
+	for _, edge := range path {
+		names = append(names, edge.Callee.Func.Name())
+	}
+	return strings.Join(names, " ")
+}
+
+func TestFindPaths(t *testing.T) {
+	tests := []struct {
+		name  string
+		roots []string
+		n     int
+		want  []string
+	}{
+		{
+			name:  "shortest",
+			roots: []string{"main"},
+			n:     1,
+			want:  []string{"main a target"},
+		},
+		{
+			name:  "limit",
+			roots: []string{"main"},
+			n:     2,
+			want:  []string{"main a target", "main b d target"},
+		},
+		{
+			// The cycle through e never gets to target another way
+			name:  "all",
+			roots: []string{"main"},
+			n:     10,
+			want:  []string{"main a target", "main b d target", "main c d target"},
+		},
+		{
+			name:  "multiple roots",
+			roots: []string{"b", "c", "a"},
+			n:     10,
+			want:  []string{"a target", "b d target", "c d target"},
+		},
+		{
+			name:  "from the cycle",
+			roots: []string{"e"},
+			n:     10,
+			want:  []string{"e d target"},
+		},
+		{
+			name:  "no path",
+			roots: []string{"f"},
+			n:     10,
+			want:  []string{},
+		},
+	}
+
+	g, main := buildGraph(t, pathsProgram)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var roots []*ssa.Function
+			for _, name := range tt.roots {
+				roots = append(roots, main.Func(name))
+			}
+			got := []string{}
+			for _, path := range g.FindPaths(roots, main.Func("target"), tt.n) {
+				if len(path) == 0 {
+					t.Fatal("found an empty path")
+				}
+				got = append(got, pathNames(path))
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("FindPaths() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
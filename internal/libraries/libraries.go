@@ -0,0 +1,178 @@
+// Package libraries is a knowledge base of helper libraries that need IAM
+// actions the SDK calls found in a program don't show, e.g. because the
+// library makes them through a client it's given, signs requests itself or
+// talks to a service without the SDK, and of what programs need to run on
+// a compute service
+package libraries
+
+import "github.com/esprimo/iamgo/internal/loader"
+
+// Library is a helper library and the actions a program that uses it needs
+type Library struct {
+	// Name of the library, e.g. "Amazon SQS Extended Client"
+	Name string `yaml:"name" json:"name"`
+	// Package patterns of the library, as with -exclude. A "..." may also
+	// be used to match any part of the path, e.g. of forks
+	Packages []string `yaml:"packages" json:"packages"`
+	// Actions a program that calls the library needs
+	Actions []string `yaml:"actions" json:"actions"`
+	// Why the library needs them
+	Reason string `yaml:"reason" json:"reason,omitempty"`
+	// Whether the library sends traces, whose actions are only added when
+	// asked for (with -xray) since the program works without them
+	Tracing bool `yaml:"tracing" json:"tracing,omitempty"`
+}
+
+// Known are the libraries iamgo knows about
+var Known = []Library{
+	{
+		Name:     "Amazon SQS Extended Client",
+		Packages: []string{"...sqs-extended-client..."},
+		Actions:  []string{"s3:PutObject", "s3:GetObject", "s3:DeleteObject"},
+		Reason:   "stores message payloads too large for SQS in S3, and deletes them with the message",
+	},
+	{
+		Name:     "AWS Secrets Manager caching client",
+		Packages: []string{"github.com/aws/aws-secretsmanager-caching-go/..."},
+		Actions:  []string{"secretsmanager:GetSecretValue", "secretsmanager:DescribeSecret"},
+		Reason:   "fetches secrets, and checks them for new versions when refreshing the cache",
+	},
+	{
+		Name:     "Amazon MSK IAM SASL signer",
+		Packages: []string{"github.com/aws/aws-msk-iam-sasl-signer-go/..."},
+		Actions:  []string{"kafka-cluster:Connect"},
+		Reason:   "signs tokens to connect to MSK clusters with IAM authentication, the topic and group actions depend on what the client does",
+	},
+	{
+		Name: "Amazon RDS IAM authentication",
+		Packages: []string{
+			"github.com/aws/aws-sdk-go-v2/feature/rds/auth",
+			"github.com/aws/aws-sdk-go/service/rds/rdsutils",
+		},
+		Actions: []string{"rds-db:connect"},
+		Reason:  "signs tokens to connect to RDS databases as an IAM user",
+	},
+	{
+		Name:     "AWS X-Ray SDK",
+		Packages: []string{"github.com/aws/aws-xray-sdk-go/..."},
+		Actions:  []string{"xray:PutTraceSegments", "xray:PutTelemetryRecords"},
+		Reason:   "sends segments through the X-Ray daemon, which drops them without these actions, e.g. in Lambda where it uses the role of the function",
+		Tracing:  true,
+	},
+	{
+		Name:     "OpenTelemetry X-Ray exporter",
+		Packages: []string{"github.com/open-telemetry/opentelemetry-collector-contrib/exporter/awsxrayexporter/..."},
+		Actions:  []string{"xray:PutTraceSegments", "xray:PutTelemetryRecords"},
+		Reason:   "exports spans to X-Ray as segments, which are dropped without these actions",
+		Tracing:  true,
+	},
+}
+
+// Baseline is what a program needs to run on a compute service,
+// regardless of what it does
+type Baseline struct {
+	// What the actions are for, e.g. "the Lambda execution role"
+	Name    string
+	Actions []string
+}
+
+// Baselines are the baselines by the compute service the program runs on
+var Baselines = map[string]Baseline{
+	// The AWSLambdaBasicExecutionRole managed policy, which lets the
+	// function write its logs to CloudWatch Logs
+	"lambda": {"the Lambda execution role", []string{
+		"logs:CreateLogGroup",
+		"logs:CreateLogStream",
+		"logs:PutLogEvents",
+	}},
+	// The AmazonECSTaskExecutionRolePolicy managed policy, which lets ECS
+	// pull the image from ECR and send the logs of the awslogs driver
+	"ecs": {"the ECS task execution role", []string{
+		"ecr:GetAuthorizationToken",
+		"ecr:BatchCheckLayerAvailability",
+		"ecr:GetDownloadUrlForLayer",
+		"ecr:BatchGetImage",
+		"logs:CreateLogStream",
+		"logs:PutLogEvents",
+	}},
+	// The CloudWatch agent sending logs and metrics, and the SSM agent
+	// registering the instance and opening Session Manager sessions, as in
+	// the AmazonSSMManagedInstanceCore managed policy
+	"ec2": {"an EC2 instance profile", []string{
+		"logs:CreateLogGroup",
+		"logs:CreateLogStream",
+		"logs:PutLogEvents",
+		"logs:DescribeLogStreams",
+		"cloudwatch:PutMetricData",
+		"ssm:UpdateInstanceInformation",
+		"ssmmessages:CreateControlChannel",
+		"ssmmessages:CreateDataChannel",
+		"ssmmessages:OpenControlChannel",
+		"ssmmessages:OpenDataChannel",
+	}},
+	// The AmazonEC2ContainerRegistryReadOnly managed policy, to pull images
+	// from ECR, and shipping container logs, e.g. with Fluent Bit. They're
+	// the node's, which pods use unless they have a role of their own
+	"eks": {"an EKS node role", []string{
+		"ecr:GetAuthorizationToken",
+		"ecr:BatchCheckLayerAvailability",
+		"ecr:GetDownloadUrlForLayer",
+		"ecr:BatchGetImage",
+		"logs:CreateLogGroup",
+		"logs:CreateLogStream",
+		"logs:PutLogEvents",
+		"logs:DescribeLogStreams",
+	}},
+}
+
+// LambdaRuntime is the package whose Start functions make a program a
+// Lambda function
+var LambdaRuntime = Library{
+	Name:     "AWS Lambda for Go",
+	Packages: []string{"github.com/aws/aws-lambda-go/lambda"},
+}
+
+// VPCBaseline are the other actions of the AWSLambdaVPCAccessExecutionRole
+// managed policy, which let Lambda attach the function to a VPC
+var VPCBaseline = []string{
+	"ec2:CreateNetworkInterface",
+	"ec2:DescribeNetworkInterfaces",
+	"ec2:DescribeSubnets",
+	"ec2:DeleteNetworkInterface",
+	"ec2:AssignPrivateIpAddresses",
+	"ec2:UnassignPrivateIpAddresses",
+}
+
+// VPCClients are clients of resources that usually only are reachable in a
+// VPC, e.g. databases and caches, so a Lambda function that uses them runs
+// in one
+var VPCClients = Library{
+	Name: "clients of VPC resources",
+	Packages: []string{
+		"github.com/lib/pq/...",
+		"github.com/jackc/pgx/...",
+		"github.com/go-sql-driver/mysql/...",
+		"github.com/redis/go-redis/...",
+		"github.com/go-redis/redis/...",
+		"github.com/bradfitz/gomemcache/...",
+		"go.mongodb.org/mongo-driver/...",
+		"github.com/elastic/go-elasticsearch/...",
+		"github.com/opensearch-project/opensearch-go/...",
+		"github.com/IBM/sarama/...",
+		"github.com/Shopify/sarama/...",
+		"github.com/segmentio/kafka-go/...",
+		"github.com/aws/aws-msk-iam-sasl-signer-go/...",
+		"github.com/aws/aws-sdk-go-v2/feature/rds/auth",
+		"github.com/aws/aws-sdk-go/service/rds/rdsutils",
+	},
+}
+
+// Matches reports whether a package belongs to the library
+func (l Library) Matches(path string) bool {
+	for _, pattern := range l.Packages {
+		if loader.MatchPackagePattern(pattern, path) {
+			return true
+		}
+	}
+	return false
+}
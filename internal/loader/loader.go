@@ -0,0 +1,590 @@
+// Package loader loads Go packages, builds their SSA form and the call graph
+// from their main packages, which is what the rest of the analysis works on
+package loader
+
+import (
+	"context"
+	"errors"
+	"go/constant"
+	"go/types"
+	"log/slog"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"golang.org/x/tools/go/callgraph"
+	"golang.org/x/tools/go/callgraph/cha"
+	"golang.org/x/tools/go/callgraph/rta"
+	"golang.org/x/tools/go/packages"
+	"golang.org/x/tools/go/ssa"
+	"golang.org/x/tools/go/ssa/ssautil"
+)
+
+var (
+	// ErrNoPackages is returned when no packages match the patterns
+	ErrNoPackages = errors.New("no packages")
+	// ErrNoMainPackages is returned when none of the packages is a main
+	// package, so there's nothing to start the analysis from
+	ErrNoMainPackages = errors.New("no main packages")
+)
+
+// PackageErrors is returned when the packages, or their dependencies, have
+// errors, e.g. don't compile
+type PackageErrors struct {
+	Errors []string
+}
+
+func (e *PackageErrors) Error() string {
+	return "packages contain errors: " + strings.Join(e.Errors, "; ")
+}
+
+// Config controls how the program is loaded
+type Config struct {
+	// Package patterns to analyze, e.g. "./..."
+	Patterns []string
+	// Include implicit test packages and executables
+	Tests bool
+	// Comma-separated list of extra build tags
+	Tags string
+	// Extra flags passed to the build system as-is, e.g. "-mod=vendor"
+	BuildFlags []string
+	// Package patterns, e.g. "github.com/org/legacy/...", whose functions
+	// are left out of the call graph
+	Exclude []string
+	// Trade speed for a lower peak memory use
+	LowMemory bool
+	// Extra options for building the SSA form, e.g. sanity checks
+	BuilderMode ssa.BuilderMode
+	// Directory to load the packages from, the working directory if empty
+	Dir string
+	// If none of the packages is a main package, build the call graph from
+	// every function of the packages instead of returning
+	// ErrNoMainPackages, see Program.Approximate
+	Fallback bool
+	// Program implementing the GOPACKAGESDRIVER protocol to load the
+	// packages with instead of the go command, e.g. for Bazel. If empty,
+	// GOPACKAGESDRIVER is honored, as is a gopackagesdriver on the PATH
+	Driver string
+}
+
+// Program is a loaded program and its call graph
+type Program struct {
+	Prog *ssa.Program
+	// Packages matching the patterns, i.e. not including dependencies
+	Packages []*ssa.Package
+	// Main packages, whose init and main functions are the roots
+	Mains     []*ssa.Package
+	Roots     []*ssa.Function
+	CallGraph *callgraph.Graph
+	// Functions reachable from the roots, including through reflection
+	Reachable map[*ssa.Function]struct{ AddrTaken bool }
+	// Whether there were no main packages and the call graph is an
+	// over-approximation: every function of the packages is a root, and
+	// calls through interfaces may go to any method that implements them
+	// (CHA, class hierarchy analysis) rather than only to the types that
+	// are actually used
+	Approximate bool
+}
+
+// Load loads the packages matching the patterns of a config and builds the
+// call graph from the init and main functions of the main packages among
+// them, or from all of their functions if there are none and
+// Config.Fallback is set. If ctx is done before it's finished, loading stops as soon as the
+// step in progress allows and ctx.Err() is returned
+func Load(ctx context.Context, config Config) (*Program, error) {
+	mode := packages.NeedImports | packages.NeedTypes | packages.NeedSyntax | packages.NeedTypesInfo | packages.NeedDeps
+	// Flags in GOFLAGS (and the rest of the go environment, e.g.
+	// GOTOOLCHAIN) are honored by the go command itself, so only pass
+	// the ones that are explicitly set or they'd override GOFLAGS
+	var buildFlags []string
+	if config.Tags != "" {
+		buildFlags = append(buildFlags, "-tags="+config.Tags)
+	}
+	buildFlags = append(buildFlags, config.BuildFlags...)
+
+	cfg := &packages.Config{
+		BuildFlags: buildFlags,
+		Mode:       mode,
+		Tests:      config.Tests,
+		Dir:        config.Dir,
+		Context:    ctx,
+	}
+	if config.Driver != "" {
+		cfg.Env = append(os.Environ(), "GOPACKAGESDRIVER="+config.Driver)
+	}
+	driver := Driver(config)
+	start := time.Now()
+	slog.Debug("loading packages", "patterns", strings.Join(config.Patterns, " "), "dir", config.Dir, "driver", driver)
+	initial, err := packages.Load(cfg, config.Patterns...)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(initial) == 0 {
+		return nil, ErrNoPackages
+	}
+	var errs []string
+	packages.Visit(initial, nil, func(pkg *packages.Package) {
+		for _, err := range pkg.Errors {
+			errs = append(errs, err.Error())
+		}
+	})
+	if len(errs) > 0 {
+		return nil, &PackageErrors{Errors: errs}
+	}
+	slog.Debug("loaded packages", "packages", len(initial), "took", time.Since(start).Round(time.Millisecond))
+
+	// The call graph algorithm (RTA) requires generic functions to be
+	// instantiated so that's always on
+	builderMode := config.BuilderMode | ssa.InstantiateGenerics
+	// The syntax and type information of each package, which is only
+	// needed to build its SSA form. With LowMemory it's released as soon
+	// as that's done, rather than when the whole program is built
+	var loaded map[*types.Package]*packages.Package
+	if config.LowMemory {
+		// Building the SSA form of all packages in parallel is where peak
+		// memory use happens on large programs. Build one package at a time
+		// and collect garbage more often than the default instead, unless
+		// the user already tuned the GC. The setting is global, so it's
+		// restored for whatever else the process does, e.g. serve
+		builderMode |= ssa.BuildSerially
+		if os.Getenv("GOGC") == "" {
+			prev := debug.SetGCPercent(25)
+			defer debug.SetGCPercent(prev)
+		}
+		loaded = make(map[*types.Package]*packages.Package)
+		packages.Visit(initial, nil, func(pkg *packages.Package) { loaded[pkg.Types] = pkg })
+	}
+
+	start = time.Now()
+	prog, pkgs := ssautil.AllPackages(initial, builderMode)
+	built := func(pkg *ssa.Package) {
+		if p := loaded[pkg.Pkg]; p != nil {
+			p.Syntax, p.TypesInfo = nil, nil
+		}
+	}
+	if err := build(ctx, prog, builderMode&ssa.BuildSerially != 0, built); err != nil {
+		return nil, err
+	}
+	slog.Debug("built SSA form", "took", time.Since(start).Round(time.Millisecond))
+
+	if config.LowMemory {
+		// Building leaves a lot of garbage behind, give it back to the OS
+		// before the rest of the analysis
+		debug.FreeOSMemory()
+	}
+
+	mains := ssautil.MainPackages(pkgs)
+	if len(mains) == 0 && !config.Fallback {
+		return nil, ErrNoMainPackages
+	}
+	var p *Program
+	if len(mains) == 0 {
+		if p, err = approximate(ctx, prog, pkgs); err != nil {
+			return nil, err
+		}
+	} else {
+		var roots []*ssa.Function
+		for _, main := range mains {
+			roots = append(roots, main.Func("init"), main.Func("main"))
+		}
+		if config.Tests {
+			roots = append(roots, testRoots(prog, pkgs)...)
+		}
+
+		start = time.Now()
+		res := rta.Analyze(roots, true)
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		slog.Debug("built call graph", "reachable", len(res.Reachable), "took", time.Since(start).Round(time.Millisecond))
+
+		p = &Program{
+			Prog:      prog,
+			Packages:  pkgs,
+			Mains:     mains,
+			Roots:     roots,
+			CallGraph: res.CallGraph,
+			Reachable: res.Reachable,
+		}
+	}
+	p.pruneConstantBranches()
+	if len(config.Exclude) > 0 {
+		p.exclude(config.Exclude)
+	}
+	return p, nil
+}
+
+// testRoots returns the functions of the test files among pkgs that the
+// testing package runs: TestMain, tests, benchmarks, fuzz targets and what
+// they fuzz, and examples. The main packages of test binaries reach them
+// only through the testing package, which makes for confusing call paths,
+// and what's fuzzed is called through reflection
+func testRoots(prog *ssa.Program, pkgs []*ssa.Package) []*ssa.Function {
+	var roots []*ssa.Function
+	for _, pkg := range pkgs {
+		if pkg == nil {
+			continue
+		}
+		for _, member := range pkg.Members {
+			fn, ok := member.(*ssa.Function)
+			if !ok || !isTestFunc(prog, fn) {
+				continue
+			}
+			roots = append(roots, fn)
+			roots = append(roots, fuzzed(fn)...)
+		}
+	}
+	// For the same result every time
+	slices.SortFunc(roots, func(a, b *ssa.Function) int { return strings.Compare(a.String(), b.String()) })
+	return roots
+}
+
+// isTestFunc reports whether a function is one go test runs, by its name
+// and file like go test tells them, e.g. "TestUpload" but not "Testify"
+func isTestFunc(prog *ssa.Program, fn *ssa.Function) bool {
+	if !strings.HasSuffix(prog.Fset.Position(fn.Pos()).Filename, "_test.go") {
+		return false
+	}
+	if fn.Name() == "TestMain" {
+		return true
+	}
+	for _, prefix := range []string{"Test", "Benchmark", "Fuzz", "Example"} {
+		if rest, ok := strings.CutPrefix(fn.Name(), prefix); ok {
+			return rest == "" || !unicode.IsLower(rune(rest[0]))
+		}
+	}
+	return false
+}
+
+// fuzzed returns the functions a fuzz target passes to testing.F.Fuzz
+func fuzzed(fn *ssa.Function) []*ssa.Function {
+	var fns []*ssa.Function
+	for _, b := range fn.Blocks {
+		for _, instr := range b.Instrs {
+			call, ok := instr.(ssa.CallInstruction)
+			if !ok {
+				continue
+			}
+			callee := call.Common().StaticCallee()
+			if callee == nil || callee.Name() != "Fuzz" || callee.Pkg == nil || callee.Pkg.Pkg.Path() != "testing" || len(call.Common().Args) != 2 {
+				continue
+			}
+			arg := call.Common().Args[1]
+			if iface, ok := arg.(*ssa.MakeInterface); ok {
+				arg = iface.X
+			}
+			switch arg := arg.(type) {
+			case *ssa.Function:
+				fns = append(fns, arg)
+			case *ssa.MakeClosure:
+				fns = append(fns, arg.Fn.(*ssa.Function))
+			}
+		}
+	}
+	return fns
+}
+
+// approximate builds the call graph of packages without a main package
+// with CHA, rooted at every function of the packages, see
+// Program.Approximate
+func approximate(ctx context.Context, prog *ssa.Program, pkgs []*ssa.Package) (*Program, error) {
+	// Closures and instances are reached from the functions they're in and
+	// instantiated by
+	var roots []*ssa.Function
+	isRoot := func(fn *ssa.Function) bool {
+		return fn != nil && fn.Blocks != nil && fn.TypeParams().Len() == 0 && !slices.Contains(roots, fn)
+	}
+	for _, pkg := range pkgs {
+		for _, member := range pkg.Members {
+			switch member := member.(type) {
+			case *ssa.Function:
+				if isRoot(member) {
+					roots = append(roots, member)
+				}
+			case *ssa.Type:
+				if _, ok := member.Type().Underlying().(*types.Interface); ok {
+					continue
+				}
+				// The methods declared on T and *T, not the wrappers of
+				// the methods of T that *T has
+				for _, t := range []types.Type{member.Type(), types.NewPointer(member.Type())} {
+					mset := prog.MethodSets.MethodSet(t)
+					for i := 0; i < mset.Len(); i++ {
+						fn := prog.MethodValue(mset.At(i))
+						if fn != nil && fn.Synthetic == "" && fn.Pkg == pkg && isRoot(fn) {
+							roots = append(roots, fn)
+						}
+					}
+				}
+			}
+		}
+	}
+	// For the same result every time
+	slices.SortFunc(roots, func(a, b *ssa.Function) int { return strings.Compare(a.String(), b.String()) })
+
+	start := time.Now()
+	cg := cha.CallGraph(prog)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	// CHA leaves out methods of types that are never converted to an
+	// interface, e.g. the unexported ones a caller of the package would
+	// convert. Their static calls are added, though their calls through
+	// interfaces are still missing
+	queue := slices.Clone(roots)
+	for len(queue) > 0 {
+		fn := queue[0]
+		queue = queue[1:]
+		if node := cg.Nodes[fn]; node != nil && (len(node.Out) > 0 || fn.Blocks == nil) {
+			continue
+		}
+		caller := cg.CreateNode(fn)
+		for _, f := range append([]*ssa.Function{fn}, fn.AnonFuncs...) {
+			for _, b := range f.Blocks {
+				for _, instr := range b.Instrs {
+					site, ok := instr.(ssa.CallInstruction)
+					if !ok || site.Common().StaticCallee() == nil {
+						continue
+					}
+					callee := site.Common().StaticCallee()
+					if cg.Nodes[callee] == nil {
+						queue = append(queue, callee)
+					}
+					callgraph.AddEdge(caller, site, cg.CreateNode(callee))
+				}
+			}
+		}
+	}
+
+	p := &Program{
+		Prog:        prog,
+		Packages:    pkgs,
+		Roots:       roots,
+		CallGraph:   cg,
+		Approximate: true,
+	}
+	p.Reachable = make(map[*ssa.Function]struct{ AddrTaken bool })
+	for fn := range p.Visit(nil) {
+		p.Reachable[fn] = struct{ AddrTaken bool }{}
+	}
+	slog.Debug("built approximate call graph", "roots", len(roots), "reachable", len(p.Reachable), "took", time.Since(start).Round(time.Millisecond))
+	return p, nil
+}
+
+// Driver returns the program implementing the GOPACKAGESDRIVER protocol
+// that Load uses for a config, or an empty string if it uses the go command
+// (though a driver may still leave patterns it doesn't handle to the go
+// command)
+func Driver(config Config) string {
+	if config.Driver != "" {
+		return config.Driver
+	}
+	switch driver := os.Getenv("GOPACKAGESDRIVER"); driver {
+	case "off":
+		return ""
+	case "":
+		path, err := exec.LookPath("gopackagesdriver")
+		if err != nil {
+			return ""
+		}
+		return path
+	default:
+		return driver
+	}
+}
+
+// build builds the SSA form of all packages of a program, like
+// ssa.Program.Build, but stops starting to build packages once ctx is done.
+// Built serially, built is called after each package
+func build(ctx context.Context, prog *ssa.Program, serially bool, built func(*ssa.Package)) error {
+	var wg sync.WaitGroup
+	for _, pkg := range prog.AllPackages() {
+		if ctx.Err() != nil {
+			break
+		}
+		if serially {
+			pkg.Build()
+			built(pkg)
+			continue
+		}
+		wg.Add(1)
+		go func(pkg *ssa.Package) {
+			defer wg.Done()
+			if ctx.Err() == nil {
+				pkg.Build()
+			}
+		}(pkg)
+	}
+	wg.Wait()
+	return ctx.Err()
+}
+
+// exclude removes functions in packages matching any of the patterns from
+// the call graph, along with functions that are only reachable through them
+func (p *Program) exclude(patterns []string) {
+	excluded := func(fn *ssa.Function) bool {
+		if fn.Pkg == nil {
+			return false
+		}
+		for _, pattern := range patterns {
+			if MatchPackagePattern(pattern, fn.Pkg.Pkg.Path()) {
+				return true
+			}
+		}
+		return false
+	}
+
+	before := p.Visit(nil)
+	after := p.Visit(excluded)
+	for fn := range p.Reachable {
+		_, reachableBefore := before[fn]
+		_, reachableAfter := after[fn]
+		// Functions that weren't in the call graph before are only
+		// reachable through reflection, keep those unless excluded
+		if !reachableAfter && (reachableBefore || excluded(fn)) {
+			delete(p.Reachable, fn)
+		}
+	}
+	for fn, node := range p.CallGraph.Nodes {
+		if fn != nil && excluded(fn) {
+			p.CallGraph.DeleteNode(node)
+		}
+	}
+}
+
+// pruneConstantBranches removes the calls in branches that are never taken
+// because their condition is a constant from the call graph, e.g. of
+// features that are compiled in but disabled with a const bool, along with
+// the functions that are only reachable through them
+func (p *Program) pruneConstantBranches() {
+	before := p.Visit(nil)
+	pruned := 0
+	for fn, node := range p.CallGraph.Nodes {
+		if fn == nil {
+			continue
+		}
+		live := liveBlocks(fn)
+		if live == nil {
+			continue
+		}
+		node.Out = slices.DeleteFunc(node.Out, func(edge *callgraph.Edge) bool {
+			if edge.Site == nil || live[edge.Site.Block()] {
+				return false
+			}
+			edge.Callee.In = slices.DeleteFunc(edge.Callee.In, func(in *callgraph.Edge) bool { return in == edge })
+			pruned++
+			return true
+		})
+	}
+	if pruned == 0 {
+		return
+	}
+
+	after := p.Visit(nil)
+	for fn := range p.Reachable {
+		_, reachableBefore := before[fn]
+		_, reachableAfter := after[fn]
+		if reachableBefore && !reachableAfter {
+			delete(p.Reachable, fn)
+		}
+	}
+	slog.Debug("pruned calls in branches that are never taken", "calls", pruned, "reachable", len(p.Reachable))
+}
+
+// liveBlocks returns the blocks of a function that can be run, following
+// only the branch that's taken of ifs whose condition is a constant, or
+// nil if it has no such ifs
+func liveBlocks(fn *ssa.Function) map[*ssa.BasicBlock]bool {
+	if !slices.ContainsFunc(fn.Blocks, constantIf) {
+		return nil
+	}
+
+	live := make(map[*ssa.BasicBlock]bool)
+	queue := []*ssa.BasicBlock{fn.Blocks[0]}
+	if fn.Recover != nil {
+		queue = append(queue, fn.Recover)
+	}
+	for len(queue) > 0 {
+		b := queue[0]
+		queue = queue[1:]
+		if live[b] {
+			continue
+		}
+		live[b] = true
+		succs := b.Succs
+		if constantIf(b) {
+			// An if is the last instruction, then comes before else
+			c := b.Instrs[len(b.Instrs)-1].(*ssa.If).Cond.(*ssa.Const)
+			if constant.BoolVal(c.Value) {
+				succs = succs[:1]
+			} else {
+				succs = succs[1:]
+			}
+		}
+		queue = append(queue, succs...)
+	}
+	return live
+}
+
+// constantIf reports whether a block ends with an if whose condition is a
+// constant
+func constantIf(b *ssa.BasicBlock) bool {
+	if len(b.Instrs) == 0 {
+		return false
+	}
+	branch, ok := b.Instrs[len(b.Instrs)-1].(*ssa.If)
+	if !ok {
+		return false
+	}
+	c, ok := branch.Cond.(*ssa.Const)
+	return ok && c.Value != nil && c.Value.Kind() == constant.Bool
+}
+
+// Visit returns all functions in the call graph reachable from the roots.
+// Functions for which skip returns true are neither visited nor traversed
+func (p *Program) Visit(skip func(*ssa.Function) bool) map[*ssa.Function]struct{} {
+	visited := make(map[*ssa.Function]struct{})
+	var queue []*callgraph.Node
+	for _, root := range p.Roots {
+		if node := p.CallGraph.Nodes[root]; node != nil {
+			visited[root] = struct{}{}
+			queue = append(queue, node)
+		}
+	}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, edge := range current.Out {
+			fn := edge.Callee.Func
+			if _, ok := visited[fn]; ok || (skip != nil && skip(fn)) {
+				continue
+			}
+			visited[fn] = struct{}{}
+			queue = append(queue, edge.Callee)
+		}
+	}
+	return visited
+}
+
+// MatchPackagePattern reports whether a package path matches a pattern.
+// As with the go command, "..." matches any string (so "a/..." matches
+// "a" and all packages below it) and in addition "*" matches any string
+// without a slash
+func MatchPackagePattern(pattern, path string) bool {
+	re := regexp.QuoteMeta(pattern)
+	re = strings.ReplaceAll(re, `\*`, `[^/]*`)
+	if strings.HasSuffix(re, `/\.\.\.`) {
+		re = strings.TrimSuffix(re, `/\.\.\.`) + `(/.*)?`
+	}
+	re = strings.ReplaceAll(re, `\.\.\.`, `.*`)
+	return regexp.MustCompile("^" + re + "$").MatchString(path)
+}
@@ -0,0 +1,112 @@
+package loader
+
+import (
+	"context"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"runtime/debug"
+	"testing"
+
+	"golang.org/x/tools/go/callgraph/rta"
+	"golang.org/x/tools/go/ssa"
+	"golang.org/x/tools/go/ssa/ssautil"
+)
+
+func TestLoadLowMemory(t *testing.T) {
+	if testing.Short() {
+		t.Skip("loads a program")
+	}
+	if os.Getenv("GOGC") != "" {
+		t.Skip("GOGC is set, which LowMemory leaves as it is")
+	}
+	prog, err := Load(context.Background(), Config{Patterns: []string{"./testdata/app"}, Dir: "../..", LowMemory: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prog.Mains) != 1 {
+		t.Errorf("loaded %d main packages, want 1", len(prog.Mains))
+	}
+	// Only loading collects garbage more often, e.g. not the rest of serve
+	if percent := debug.SetGCPercent(100); percent != 100 {
+		t.Errorf("GC percent after loading is %d, want it restored to 100", percent)
+	}
+}
+
+// buildProgram builds a program of a single file without imports the way
+// Load does, before the call graph is pruned
+func buildProgram(t *testing.T, src string) *Program {
+	t.Helper()
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "main.go", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	main, _, err := ssautil.BuildPackage(&types.Config{}, fset, types.NewPackage("example.com/app", "main"), []*ast.File{f}, ssa.InstantiateGenerics)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := []*ssa.Function{main.Func("init"), main.Func("main")}
+	res := rta.Analyze(roots, true)
+	return &Program{
+		Prog:      main.Prog,
+		Packages:  []*ssa.Package{main},
+		Mains:     []*ssa.Package{main},
+		Roots:     roots,
+		CallGraph: res.CallGraph,
+		Reachable: res.Reachable,
+	}
+}
+
+func TestPruneConstantBranches(t *testing.T) {
+	p := buildProgram(t, `package main
+
+const debug = false
+
+var verbose bool
+
+func main() {
+	if debug {
+		dump()
+	} else {
+		run()
+	}
+	if !debug {
+		serve()
+	}
+	if verbose {
+		log()
+	}
+}
+
+func dump()  { helper() }
+func helper() {}
+func run()   { helper() }
+func serve() {}
+func log()   {}
+`)
+	p.pruneConstantBranches()
+
+	main := p.Mains[0]
+	for name, want := range map[string]bool{
+		"dump":   false,
+		"helper": true, // also called by run
+		"run":    true,
+		"serve":  true,
+		"log":    true, // the condition isn't a constant
+	} {
+		if _, reachable := p.Reachable[main.Func(name)]; reachable != want {
+			t.Errorf("%s reachable = %v, want %v", name, reachable, want)
+		}
+	}
+	for _, edge := range p.CallGraph.Nodes[main.Func("main")].Out {
+		if edge.Callee.Func == main.Func("dump") {
+			t.Errorf("the call graph has the call of dump in main, want it pruned")
+		}
+	}
+	if in := p.CallGraph.Nodes[main.Func("dump")].In; len(in) != 0 {
+		t.Errorf("dump is called by %d edges, want none", len(in))
+	}
+}
@@ -0,0 +1,376 @@
+// Package mapping holds the mapping from AWS SDK calls to the IAM actions
+// they require
+package mapping
+
+import (
+	_ "embed"
+	"encoding/json"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// JSON is the mapping, with SDK methods such as "S3.GetObject" under
+// "sdk_method_iam_mappings"
+//
+//go:embed map.json
+var JSON []byte
+
+// GCPJSON is the mapping of the Google Cloud client libraries, in the same
+// format as JSON. Methods are named after the package, receiver type and
+// method, e.g. "storage.ObjectHandle.NewReader"
+//
+//go:embed gcp.json
+var GCPJSON []byte
+
+// AzureJSON is the mapping of the Azure SDK for Go, named like in GCPJSON,
+// e.g. "azblob.Client.DownloadStream". Data actions, as opposed to control
+// plane actions, have "data_action" set
+//
+//go:embed azure.json
+var AzureJSON []byte
+
+// MatchAction reports whether an IAM action matches a pattern, e.g.
+// "s3:Put*". Like in IAM policies, "*" matches any sequence of characters,
+// "?" matches any single character and matching is case-insensitive
+func MatchAction(pattern, action string) bool {
+	re := regexp.QuoteMeta(pattern)
+	re = strings.ReplaceAll(re, `\*`, `.*`)
+	re = strings.ReplaceAll(re, `\?`, `.`)
+	return regexp.MustCompile("(?i)^" + re + "$").MatchString(action)
+}
+
+// readVerbs start the names of AWS IAM actions of the List and Read access
+// levels, e.g. "s3:GetObject" or "dynamodb:Query"
+var readVerbs = []string{"Get", "BatchGet", "List", "Describe", "Query", "Scan", "Search", "Select", "PartiQLSelect", "Lookup", "View", "Filter", "Receive"}
+
+// IsReadOnly reports whether an AWS IAM action only reads, i.e. lists or
+// reads resources, by its name. Actions such as "sqs:ReceiveMessage" count
+// as reads like in the access levels of IAM, though they change some state
+// of the resource
+func IsReadOnly(action string) bool {
+	_, name, ok := strings.Cut(action, ":")
+	if !ok {
+		return false
+	}
+	for _, verb := range readVerbs {
+		if rest, ok := strings.CutPrefix(name, verb); ok && (rest == "" || rest[0] >= 'A' && rest[0] <= 'Z' || rest[0] == '*') {
+			return true
+		}
+	}
+	return false
+}
+
+// RiskLevels are how sensitive an AWS IAM action is, from least to most,
+// see Risk. The score of a level is its index plus one
+var RiskLevels = []string{"read", "write", "destructive", "permissions"}
+
+// permissionServices are the services whose actions, other than reads,
+// all manage permissions
+var permissionServices = []string{"iam", "sts", "organizations", "sso", "identitystore"}
+
+// permissionWords in the names of actions of other services manage who may
+// access a resource, e.g. "s3:PutBucketPolicy" or "kms:CreateGrant"
+var permissionWords = []string{"Policy", "Policies", "Permission", "Grant", "Acl"}
+
+// destructiveVerbs start the names of actions that delete or stop
+// resources, e.g. "dynamodb:DeleteTable"
+var destructiveVerbs = []string{"Delete", "BatchDelete", "Terminate", "Purge", "Destroy", "Remove", "Deregister", "Disable", "Detach"}
+
+// Risk returns the risk level of an AWS IAM action by its name: "read" if
+// it only reads (see IsReadOnly), "permissions" if it manages permissions,
+// e.g. "iam:PassRole" or "s3:PutBucketPolicy", "destructive" if it
+// deletes or stops resources and "write" otherwise
+func Risk(action string) string {
+	if IsReadOnly(action) {
+		return "read"
+	}
+	service, name, _ := strings.Cut(action, ":")
+	if slices.Contains(permissionServices, service) || slices.ContainsFunc(permissionWords, func(word string) bool { return strings.Contains(name, word) }) {
+		return "permissions"
+	}
+	for _, verb := range destructiveVerbs {
+		if rest, ok := strings.CutPrefix(name, verb); ok && (rest == "" || rest[0] >= 'A' && rest[0] <= 'Z' || rest[0] == '*') {
+			return "destructive"
+		}
+	}
+	return "write"
+}
+
+// Service returns the service of an IAM action, e.g. "s3" for
+// "s3:GetObject", "storage" for the Google Cloud permission
+// "storage.objects.get" or "Microsoft.Storage" for the Azure action
+// "Microsoft.Storage/storageAccounts/read"
+func Service(action string) string {
+	if i := strings.Index(action, "/"); i >= 0 {
+		return action[:i]
+	}
+	if i := strings.IndexAny(action, ":."); i >= 0 {
+		return action[:i]
+	}
+	return action
+}
+
+// Map looks up the IAM actions SDK methods require. SDK methods are
+// matched case-insensitively, e.g. "dynamodb.batchgetitem" is the same as
+// "DynamoDB.BatchGetItem"
+type Map struct {
+	// By lowercase SDK method, the first action is the one it requires
+	actions map[string][]string
+	methods map[string]string
+	// Lowercase Azure data actions
+	dataActions map[string]bool
+	// Services by lowercase SDK package name, see packageServices
+	aliases map[string]string
+}
+
+// transferActions are the actions the uploads and downloads of the S3
+// transfer managers require, which aren't API operations and so not in the
+// mapping. The first is the one they're reported with, the others are
+// required by the operations the transfer managers call on their own, e.g.
+// to abort a failed multipart upload
+var transferActions = map[string][]string{
+	// feature/s3/manager of SDK v2
+	"manager.Uploader.Upload":     {"s3:PutObject", "s3:AbortMultipartUpload"},
+	"manager.Downloader.Download": {"s3:GetObject"},
+	// feature/s3/transfermanager of SDK v2
+	"transfermanager.Client.UploadObject":      {"s3:PutObject", "s3:AbortMultipartUpload"},
+	"transfermanager.Client.DownloadObject":    {"s3:GetObject"},
+	"transfermanager.Client.GetObject":         {"s3:GetObject"},
+	"transfermanager.Client.UploadDirectory":   {"s3:PutObject", "s3:AbortMultipartUpload"},
+	"transfermanager.Client.DownloadDirectory": {"s3:GetObject", "s3:ListBucket"},
+	// service/s3/s3manager of SDK v1
+	"s3manager.Uploader.Upload":                 {"s3:PutObject", "s3:AbortMultipartUpload"},
+	"s3manager.Uploader.UploadWithContext":      {"s3:PutObject", "s3:AbortMultipartUpload"},
+	"s3manager.Uploader.UploadWithIterator":     {"s3:PutObject", "s3:AbortMultipartUpload"},
+	"s3manager.Downloader.Download":             {"s3:GetObject"},
+	"s3manager.Downloader.DownloadWithContext":  {"s3:GetObject"},
+	"s3manager.Downloader.DownloadWithIterator": {"s3:GetObject"},
+	"s3manager.BatchDelete.Delete":              {"s3:DeleteObject"},
+}
+
+// packageServices are the services whose SDK packages, in v1 or v2, aren't
+// named like the service in the mapping, by package name. The mapping
+// names services like the JavaScript SDK does, e.g. "StepFunctions" for
+// the sfn package. Packages named like their service in any capitalization,
+// e.g. s3control, apigatewayv2, cloudwatchlogs and dynamodbstreams, need
+// no entry
+var packageServices = map[string]string{
+	"applicationdiscoveryservice": "Discovery",
+	"cognitoidentityprovider":     "CognitoIdentityServiceProvider",
+	"costandusagereportservice":   "CUR",
+	"databasemigrationservice":    "DMS",
+	"elasticloadbalancing":        "ELB",
+	"elasticloadbalancingv2":      "ELBv2",
+	"elasticsearchservice":        "ES",
+	"forecast":                    "ForecastService",
+	"forecastquery":               "ForecastQueryService",
+	"iotdataplane":                "IotData",
+	"kinesisvideosignaling":       "KinesisVideoSignalingChannels",
+	"lexruntimeservice":           "LexRuntime",
+	"rdsdata":                     "RDSDataService",
+	"sagemakera2iruntime":         "AugmentedAIRuntime",
+	"sfn":                         "StepFunctions",
+	"transcribe":                  "TranscribeService",
+}
+
+// accessPointActions are the actions of the access point operations of S3
+// Control, by SDK method. The mapping has the s3-outposts actions for them,
+// which only apply to the access points of S3 on Outposts
+var accessPointActions = map[string]string{
+	"S3Control.CreateAccessPoint":       "s3:CreateAccessPoint",
+	"S3Control.DeleteAccessPoint":       "s3:DeleteAccessPoint",
+	"S3Control.DeleteAccessPointPolicy": "s3:DeleteAccessPointPolicy",
+	"S3Control.GetAccessPoint":          "s3:GetAccessPoint",
+	"S3Control.GetAccessPointPolicy":    "s3:GetAccessPointPolicy",
+	"S3Control.ListAccessPoints":        "s3:ListAccessPoints",
+	"S3Control.PutAccessPointPolicy":    "s3:PutAccessPointPolicy",
+}
+
+// Load parses the embedded mapping
+func Load() (*Map, error) {
+	m, err := parse(JSON)
+	if err != nil {
+		return nil, err
+	}
+	m.aliases = make(map[string]string, len(packageServices))
+	for pkg, service := range packageServices {
+		m.aliases[pkg] = strings.ToLower(service)
+	}
+	for method, actions := range transferActions {
+		key := strings.ToLower(method)
+		m.methods[key] = method
+		m.actions[key] = actions
+	}
+	for method, action := range accessPointActions {
+		if actions := m.actions[strings.ToLower(method)]; len(actions) > 0 {
+			actions[0] = action
+		}
+	}
+	return m, nil
+}
+
+// LoadGCP parses the embedded mapping of the Google Cloud client libraries
+func LoadGCP() (*Map, error) {
+	return parse(GCPJSON)
+}
+
+// LoadAzure parses the embedded mapping of the Azure SDK for Go
+func LoadAzure() (*Map, error) {
+	return parse(AzureJSON)
+}
+
+func parse(data []byte) (*Map, error) {
+	var m struct {
+		SDKMethodIAMMappings map[string][]struct {
+			Action     string `json:"action"`
+			DataAction bool   `json:"data_action"`
+		} `json:"sdk_method_iam_mappings"`
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	mm := &Map{
+		actions:     make(map[string][]string, len(m.SDKMethodIAMMappings)),
+		methods:     make(map[string]string, len(m.SDKMethodIAMMappings)),
+		dataActions: make(map[string]bool),
+	}
+	for method, privs := range m.SDKMethodIAMMappings {
+		key := strings.ToLower(method)
+		mm.methods[key] = method
+		for _, priv := range privs {
+			mm.actions[key] = append(mm.actions[key], priv.Action)
+			if priv.DataAction {
+				mm.dataActions[strings.ToLower(priv.Action)] = true
+			}
+		}
+	}
+	return mm, nil
+}
+
+// Action returns the IAM action an SDK method, e.g. "s3.GetObject",
+// requires, or an empty string if there is no match (not all calls require
+// permissions)
+func (m *Map) Action(sdkMethod string) string {
+	if actions := m.actions[m.key(sdkMethod)]; len(actions) > 0 {
+		return actions[0]
+	}
+	return ""
+}
+
+// Method returns an SDK method, e.g. "dynamodb.batchgetitem", the way it's
+// named in the mapping, e.g. "DynamoDB.BatchGetItem" (or "StepFunctions.
+// StartExecution" for "sfn.StartExecution"). Returns the method as-is if
+// it's not in the mapping
+func (m *Map) Method(sdkMethod string) string {
+	if method, ok := m.methods[m.key(sdkMethod)]; ok {
+		return method
+	}
+	return sdkMethod
+}
+
+// Methods returns all SDK methods that require an IAM action, or an empty
+// list if there are none
+func (m *Map) Methods(action string) []string {
+	var methods []string
+	for key, actions := range m.actions {
+		if slices.ContainsFunc(actions, func(a string) bool { return strings.EqualFold(a, action) }) {
+			methods = append(methods, m.methods[key])
+		}
+	}
+	return methods
+}
+
+// Actions returns every action an SDK method in the mapping requires,
+// sorted
+func (m *Map) Actions() []string {
+	var actions []string
+	for _, a := range m.actions {
+		actions = append(actions, a...)
+	}
+	slices.Sort(actions)
+	return slices.Compact(actions)
+}
+
+// Similar returns the actions in the mapping that are a few typos away from
+// an action that isn't in it, closest first, e.g. "ssm:GetParameters" for
+// "ssm:GetParamters". Actions are compared case-insensitively
+func (m *Map) Similar(action string) []string {
+	action = strings.ToLower(action)
+	// Allow a typo per 4 characters of the name, but at least one
+	_, name, _ := strings.Cut(action, ":")
+	limit := max(1, len(name)/4)
+	distances := make(map[string]int)
+	for _, actions := range m.actions {
+		for _, a := range actions {
+			if _, ok := distances[a]; ok {
+				continue
+			}
+			if d := editDistance(action, strings.ToLower(a)); d <= limit {
+				distances[a] = d
+			}
+		}
+	}
+	similar := make([]string, 0, len(distances))
+	for a := range distances {
+		similar = append(similar, a)
+	}
+	slices.SortFunc(similar, func(a, b string) int {
+		if d := distances[a] - distances[b]; d != 0 {
+			return d
+		}
+		return strings.Compare(a, b)
+	})
+	return similar[:min(len(similar), 3)]
+}
+
+// editDistance returns the Levenshtein distance between two strings, the
+// number of bytes to insert, delete or replace to turn one into the other
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// IsDataAction reports whether an action is an Azure data action, which a
+// role grants separately from the control plane actions
+func (m *Map) IsDataAction(action string) bool {
+	return m.dataActions[strings.ToLower(action)]
+}
+
+// Has reports whether an SDK method is in the mapping
+func (m *Map) Has(sdkMethod string) bool {
+	_, ok := m.methods[m.key(sdkMethod)]
+	return ok
+}
+
+// key returns the lowercase SDK method as it's keyed in the mapping, with
+// the service of its package if that's named differently
+func (m *Map) key(sdkMethod string) string {
+	key := strings.ToLower(sdkMethod)
+	if pkg, name, ok := strings.Cut(key, "."); ok {
+		if service, ok := m.aliases[pkg]; ok {
+			return service + "." + name
+		}
+	}
+	return key
+}
+
+// Len returns the number of SDK methods in the mapping
+func (m *Map) Len() int {
+	return len(m.methods)
+}
@@ -0,0 +1,29 @@
+package mapping
+
+import "testing"
+
+func TestAction(t *testing.T) {
+	m, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		sdkMethod, want string
+	}{
+		{"s3.GetObject", "s3:GetObject"},
+		{"DynamoDB.BatchGetItem", "dynamodb:BatchGetItem"},
+		// Not named like its service in the mapping
+		{"sfn.StartExecution", "states:StartExecution"},
+		// s3-outposts in the mapping
+		{"s3control.CreateAccessPoint", "s3:CreateAccessPoint"},
+		{"S3Control.ListAccessPoints", "s3:ListAccessPoints"},
+		// Not an API operation
+		{"manager.Uploader.Upload", "s3:PutObject"},
+		{"s3.NoSuchOperation", ""},
+	}
+	for _, tt := range tests {
+		if got := m.Action(tt.sdkMethod); got != tt.want {
+			t.Errorf("Action(%q) = %q, want %q", tt.sdkMethod, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,288 @@
+// Package plugin runs external detectors and mappers, e.g. for an
+// organization's own wrapper around the AWS SDK, so they can be added
+// without changing iamgo. A plugin is an executable that iamgo starts and
+// talks to with JSON, one object per line, over its stdin and stdout. Each
+// request gets exactly one response, in order.
+//
+// The first request introduces iamgo and the plugin answers with its name,
+// the package patterns (as with -exclude) whose functions it recognizes and
+// whether it maps other SDK methods too:
+//
+//	{"type":"hello","version":1}
+//	{"name":"acme","packages":["github.com/acme/awsx/..."],"map":false}
+//
+// Every reachable function in those packages is then sent to the plugin,
+// which answers with the SDK method it is and the action it requires, or an
+// empty object if it's neither:
+//
+//	{"type":"detect","function":{"package":"github.com/acme/awsx/blob","receiver":"Store","name":"Put","full_name":"(*github.com/acme/awsx/blob.Store).Put"}}
+//	{"sdk_method":"blob.Put","action":"s3:PutObject"}
+//
+// A plugin that maps is asked for the action of every other SDK method,
+// e.g. to use an organization's own mapping, and answers with an empty
+// object to leave it to the built-in mapping:
+//
+//	{"type":"map","sdk_method":"s3.PutObject"}
+//	{"action":"s3:PutObject"}
+//
+// Any response may be {"error":"..."} instead, which fails the analysis.
+// A plugin that takes longer than Timeout to respond is stopped. The
+// plugin's stdin is closed when iamgo is done and stderr is passed on
+package plugin
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/types"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/tools/go/ssa"
+
+	"github.com/esprimo/iamgo/internal/loader"
+)
+
+// Version is the version of the protocol
+const Version = 1
+
+// Timeout is how long a plugin may take to respond to a request. One that
+// doesn't respond in time is stopped, and fails every request after
+const Timeout = 30 * time.Second
+
+// Plugin is a running plugin
+type Plugin struct {
+	name     string
+	packages []string
+	maps     bool
+
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+	out   *bufio.Scanner
+
+	mu sync.Mutex
+	// Why the plugin can't be talked to anymore, e.g. because it exited
+	// or didn't respond in time
+	broken  error
+	methods map[*ssa.Function]string
+	// Actions by lowercase SDK method, of the detected methods and the
+	// ones the plugin has been asked to map
+	actions map[string]string
+}
+
+// Function is a function sent to the plugin to detect
+type Function struct {
+	// Path of the package, e.g. "github.com/acme/awsx/blob"
+	Package string `json:"package"`
+	// Name of the receiver type for methods, e.g. "Store"
+	Receiver string `json:"receiver,omitempty"`
+	// Name of the function or method, e.g. "Put"
+	Name string `json:"name"`
+	// Full name, e.g. "(*github.com/acme/awsx/blob.Store).Put"
+	FullName string `json:"full_name"`
+}
+
+type request struct {
+	Type      string    `json:"type"`
+	Version   int       `json:"version,omitempty"`
+	Function  *Function `json:"function,omitempty"`
+	SDKMethod string    `json:"sdk_method,omitempty"`
+}
+
+type response struct {
+	Error string `json:"error"`
+	// hello
+	Name     string   `json:"name"`
+	Packages []string `json:"packages"`
+	Map      bool     `json:"map"`
+	// detect and map
+	SDKMethod string `json:"sdk_method"`
+	Action    string `json:"action"`
+}
+
+// Start starts a plugin, given by a command line such as "./iamgo-acme" or
+// "iamgo-acme -v", and asks it what it recognizes
+func Start(command string) (*Plugin, error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return nil, errors.New("empty plugin command")
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	out := bufio.NewScanner(stdout)
+	out.Buffer(nil, 1<<20)
+	p := &Plugin{
+		cmd:     cmd,
+		stdin:   stdin,
+		out:     out,
+		methods: make(map[*ssa.Function]string),
+		actions: make(map[string]string),
+	}
+
+	resp, err := p.call(request{Type: "hello", Version: Version})
+	if err != nil {
+		p.Close()
+		return nil, fmt.Errorf("%s: %w", args[0], err)
+	}
+	if resp.Name == "" {
+		p.Close()
+		return nil, fmt.Errorf("%s: the plugin has no name", args[0])
+	}
+	p.name, p.packages, p.maps = resp.Name, resp.Packages, resp.Map
+	return p, nil
+}
+
+// call sends a request and reads the response, the caller must hold mu or
+// have the only reference to p. If the plugin can't be talked to, e.g.
+// because it doesn't respond within Timeout, it's stopped and every later
+// call fails with the same error
+func (p *Plugin) call(req request) (response, error) {
+	if p.broken != nil {
+		return response{}, p.broken
+	}
+	data, err := json.Marshal(req)
+	if err != nil {
+		return response{}, err
+	}
+
+	type result struct {
+		data []byte
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		if _, err := p.stdin.Write(append(data, '\n')); err != nil {
+			done <- result{err: fmt.Errorf("failed to write request: %w", err)}
+			return
+		}
+		if !p.out.Scan() {
+			if err := p.out.Err(); err != nil {
+				done <- result{err: fmt.Errorf("failed to read response: %w", err)}
+				return
+			}
+			done <- result{err: errors.New("the plugin exited without responding")}
+			return
+		}
+		done <- result{data: p.out.Bytes()}
+	}()
+	timer := time.NewTimer(Timeout)
+	defer timer.Stop()
+	var r result
+	select {
+	case r = <-done:
+	case <-timer.C:
+		// Kill it, or a late response would be taken for the answer to
+		// the next request
+		p.cmd.Process.Kill()
+		r.err = fmt.Errorf("the plugin didn't respond within %s", Timeout)
+	}
+
+	var resp response
+	if r.err == nil {
+		if err := json.Unmarshal(r.data, &resp); err != nil {
+			r.err = fmt.Errorf("invalid response: %w", err)
+		}
+	}
+	if r.err != nil {
+		p.broken = r.err
+		return resp, r.err
+	}
+	if resp.Error != "" {
+		return resp, errors.New(resp.Error)
+	}
+	return resp, nil
+}
+
+// Name returns the name the plugin gave itself
+func (p *Plugin) Name() string {
+	return p.name
+}
+
+// Detect returns the SDK method a function is according to the plugin, or
+// an empty string if it isn't one. Only functions in the plugin's packages
+// are sent to it, once each
+func (p *Plugin) Detect(fn *ssa.Function) (string, error) {
+	if fn.Pkg == nil || fn.Synthetic != "" || !p.recognizes(fn.Pkg.Pkg.Path()) {
+		return "", nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if method, ok := p.methods[fn]; ok {
+		return method, nil
+	}
+
+	f := &Function{Package: fn.Pkg.Pkg.Path(), Name: fn.Name(), FullName: fn.String()}
+	if recv := fn.Signature.Recv(); recv != nil {
+		t := recv.Type()
+		if ptr, ok := t.(*types.Pointer); ok {
+			t = ptr.Elem()
+		}
+		if named, ok := t.(*types.Named); ok {
+			f.Receiver = named.Obj().Name()
+		}
+	}
+	resp, err := p.call(request{Type: "detect", Function: f})
+	if err != nil {
+		return "", err
+	}
+	p.methods[fn] = resp.SDKMethod
+	if resp.SDKMethod != "" {
+		p.actions[strings.ToLower(resp.SDKMethod)] = resp.Action
+	}
+	return resp.SDKMethod, nil
+}
+
+// Action returns the action an SDK method requires according to the
+// plugin, and whether the plugin knows the method: either it detected it,
+// or it maps SDK methods and gave it an action
+func (p *Plugin) Action(method string) (string, bool, error) {
+	key := strings.ToLower(method)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if action, ok := p.actions[key]; ok {
+		return action, true, nil
+	}
+	if !p.maps {
+		return "", false, nil
+	}
+	resp, err := p.call(request{Type: "map", SDKMethod: method})
+	if err != nil {
+		return "", false, err
+	}
+	if resp.Action == "" {
+		return "", false, nil
+	}
+	p.actions[key] = resp.Action
+	return resp.Action, true, nil
+}
+
+// Close closes the plugin's stdin and waits for it to exit
+func (p *Plugin) Close() error {
+	p.stdin.Close()
+	return p.cmd.Wait()
+}
+
+func (p *Plugin) recognizes(path string) bool {
+	for _, pattern := range p.packages {
+		if loader.MatchPackagePattern(pattern, path) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,132 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"github.com/esprimo/iamgo/internal/mapping"
+)
+
+// File is an existing AWS IAM policy, e.g. as read from a file or the
+// policies of a role, to compare the actions a program needs with. Unlike
+// Document, its fields may be written both as a single value and as a list
+type File struct {
+	Statement jsonList[FileStatement] `json:"Statement"`
+	// The statements as written, with their resources and conditions, for
+	// IAM Access Analyzer
+	raw []json.RawMessage
+}
+
+// Add adds the statements of another policy, e.g. of one of the several
+// policies of a role
+func (p *File) Add(other File) {
+	p.Statement = append(p.Statement, other.Statement...)
+	p.raw = append(p.raw, other.raw...)
+}
+
+// Document returns the policy as JSON, with the statements as written
+func (p File) Document() ([]byte, error) {
+	return json.Marshal(struct {
+		Version   string            `json:"Version"`
+		Statement []json.RawMessage `json:"Statement"`
+	}{"2012-10-17", p.raw})
+}
+
+// FileStatement is a statement in a File. Resources and conditions aren't
+// taken into account
+type FileStatement struct {
+	Effect    string           `json:"Effect"`
+	Action    jsonList[string] `json:"Action"`
+	NotAction jsonList[string] `json:"NotAction"`
+}
+
+// jsonList is a list in JSON that may also be written as a single value
+type jsonList[T any] []T
+
+func (l *jsonList[T]) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '[' {
+		var list []T
+		if err := json.Unmarshal(data, &list); err != nil {
+			return err
+		}
+		*l = list
+		return nil
+	}
+	var value T
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	*l = []T{value}
+	return nil
+}
+
+// Parse parses an AWS IAM policy document
+func Parse(data []byte) (File, error) {
+	var policy File
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return policy, err
+	}
+	for _, s := range policy.Statement {
+		if s.Effect != "Allow" && s.Effect != "Deny" {
+			return policy, fmt.Errorf("unknown effect %q, must be Allow or Deny", s.Effect)
+		}
+	}
+	var raw struct {
+		Statement jsonList[json.RawMessage] `json:"Statement"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return policy, err
+	}
+	policy.raw = raw.Statement
+	return policy, nil
+}
+
+// Matches returns whether a statement applies to an action, i.e. whether
+// the action matches any of its Action patterns or none of its NotAction
+// patterns
+func (s FileStatement) Matches(action string) bool {
+	if len(s.NotAction) > 0 {
+		return !slices.ContainsFunc(s.NotAction, func(pattern string) bool { return mapping.MatchAction(pattern, action) })
+	}
+	return slices.ContainsFunc(s.Action, func(pattern string) bool { return mapping.MatchAction(pattern, action) })
+}
+
+// Allows returns whether a policy allows an action, i.e. whether an Allow
+// statement applies to it and no Deny statement does
+func (p File) Allows(action string) bool {
+	allowed := false
+	for _, s := range p.Statement {
+		if !s.Matches(action) {
+			continue
+		}
+		if s.Effect == "Deny" {
+			return false
+		}
+		allowed = true
+	}
+	return allowed
+}
+
+// Check returns the actions a policy doesn't allow, and the action patterns
+// in its Allow statements that allow none of the actions. Allow statements
+// with NotAction can't be listed and aren't reported as unused
+func Check(policy File, actions []string) (missing, unused []string) {
+	for _, action := range actions {
+		if !policy.Allows(action) {
+			missing = append(missing, action)
+		}
+	}
+	for _, s := range policy.Statement {
+		if s.Effect != "Allow" {
+			continue
+		}
+		for _, pattern := range s.Action {
+			used := slices.ContainsFunc(actions, func(action string) bool { return mapping.MatchAction(pattern, action) })
+			if !used && !slices.Contains(unused, pattern) {
+				unused = append(unused, pattern)
+			}
+		}
+	}
+	return missing, unused
+}
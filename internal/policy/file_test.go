@@ -0,0 +1,101 @@
+package policy
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestAllows(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy string
+		allows []string
+		denies []string
+	}{
+		{
+			name:   "action",
+			policy: `{"Statement": {"Effect": "Allow", "Action": "s3:GetObject", "Resource": "*"}}`,
+			allows: []string{"s3:GetObject", "S3:getobject"},
+			denies: []string{"s3:GetObjectAcl", "s3:PutObject"},
+		},
+		{
+			name:   "wildcards",
+			policy: `{"Statement": [{"Effect": "Allow", "Action": ["s3:Get*", "dynamodb:?etItem"], "Resource": "*"}]}`,
+			allows: []string{"s3:GetObject", "s3:Get", "dynamodb:GetItem", "dynamodb:SetItem"},
+			denies: []string{"s3:PutObject", "dynamodb:BatchGetItem", "sqs:GetQueueUrl"},
+		},
+		{
+			name:   "all actions",
+			policy: `{"Statement": [{"Effect": "Allow", "Action": "*", "Resource": "*"}]}`,
+			allows: []string{"s3:GetObject", "iam:PassRole"},
+		},
+		{
+			name:   "NotAction",
+			policy: `{"Statement": [{"Effect": "Allow", "NotAction": ["iam:*", "s3:Delete*"], "Resource": "*"}]}`,
+			allows: []string{"s3:GetObject", "sts:AssumeRole"},
+			denies: []string{"iam:PassRole", "s3:DeleteObject"},
+		},
+		{
+			name: "Deny",
+			policy: `{"Statement": [
+				{"Effect": "Allow", "Action": "s3:*", "Resource": "*"},
+				{"Effect": "Deny", "Action": "s3:Delete*", "Resource": "*"}
+			]}`,
+			allows: []string{"s3:GetObject"},
+			denies: []string{"s3:DeleteObject", "sqs:SendMessage"},
+		},
+		{
+			name: "Deny with NotAction",
+			policy: `{"Statement": [
+				{"Effect": "Allow", "Action": ["s3:*", "sqs:*"], "Resource": "*"},
+				{"Effect": "Deny", "NotAction": "s3:*", "Resource": "*"}
+			]}`,
+			allows: []string{"s3:PutObject"},
+			denies: []string{"sqs:SendMessage"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := Parse([]byte(tt.policy))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, action := range tt.allows {
+				if !p.Allows(action) {
+					t.Errorf("the policy doesn't allow %s, want it to", action)
+				}
+			}
+			for _, action := range tt.denies {
+				if p.Allows(action) {
+					t.Errorf("the policy allows %s, want it not to", action)
+				}
+			}
+		})
+	}
+}
+
+func TestParseEffect(t *testing.T) {
+	if _, err := Parse([]byte(`{"Statement": [{"Effect": "allow", "Action": "s3:GetObject", "Resource": "*"}]}`)); err == nil {
+		t.Error("parsed a statement with the effect allow, want an error")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	p, err := Parse([]byte(`{"Statement": [
+		{"Effect": "Allow", "Action": ["s3:Get*", "s3:PutObject", "sqs:*"], "Resource": "*"},
+		{"Effect": "Allow", "NotAction": "iam:*", "Resource": "arn:aws:s3:::logs/*"},
+		{"Effect": "Deny", "Action": "dynamodb:*", "Resource": "*"}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	missing, unused := Check(p, []string{"s3:GetObject", "s3:GetObjectAcl", "dynamodb:GetItem", "iam:PassRole"})
+	if want := []string{"dynamodb:GetItem", "iam:PassRole"}; !slices.Equal(missing, want) {
+		t.Errorf("missing %q, want %q", missing, want)
+	}
+	// Only the patterns of Action are listed, the NotAction statement
+	// allows too much to tell
+	if want := []string{"s3:PutObject", "sqs:*"}; !slices.Equal(unused, want) {
+		t.Errorf("unused %q, want %q", unused, want)
+	}
+}
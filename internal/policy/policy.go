@@ -0,0 +1,228 @@
+// Package policy generates AWS IAM policies, or Google Cloud and Azure
+// custom roles, allowing the actions a program needs
+package policy
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/esprimo/iamgo/internal/mapping"
+)
+
+// Document is an AWS IAM policy
+type Document struct {
+	Version   string      `json:"Version"`
+	Statement []Statement `json:"Statement"`
+}
+
+// Statement is a statement in an AWS IAM policy
+type Statement struct {
+	Sid       string   `json:"Sid,omitempty"`
+	Effect    string   `json:"Effect"`
+	Action    []string `json:"Action,omitempty"`
+	NotAction []string `json:"NotAction,omitempty"`
+	Resource  []string `json:"Resource"`
+}
+
+// New creates a policy that allows a set of actions. Each action is
+// allowed on the resources of every pattern in resources that matches it,
+// or on all resources if none does. Actions allowed on the same resources
+// share a statement
+func New(actions []string, resources map[string][]string) Document {
+	var statements []Statement
+	for _, action := range actions {
+		arns := Resources(action, resources)
+		if len(arns) == 0 {
+			arns = []string{"*"}
+		}
+
+		i := slices.IndexFunc(statements, func(s Statement) bool {
+			return slices.Equal(s.Resource, arns)
+		})
+		if i == -1 {
+			statements = append(statements, Statement{Effect: "Allow", Resource: arns})
+			i = len(statements) - 1
+		}
+		if !slices.Contains(statements[i].Action, action) {
+			statements[i].Action = append(statements[i].Action, action)
+		}
+	}
+
+	for _, s := range statements {
+		slices.Sort(s.Action)
+	}
+	slices.SortFunc(statements, func(a, b Statement) int {
+		return strings.Compare(strings.Join(a.Resource, ","), strings.Join(b.Resource, ","))
+	})
+	return Document{Version: "2012-10-17", Statement: statements}
+}
+
+// NewBoundary creates a permissions boundary that allows a set of actions
+// on all resources, so a role it's set on can never do more than that
+// whatever its own policies allow. With services, every action of the
+// services of the actions is allowed instead, e.g. "s3:*" for
+// "s3:GetObject", so the boundary doesn't need to change with the code
+func NewBoundary(actions []string, services bool) Document {
+	allowed := slices.Clone(actions)
+	if services {
+		for i, action := range allowed {
+			allowed[i] = mapping.Service(action) + ":*"
+		}
+	}
+	slices.Sort(allowed)
+	return Document{
+		Version: "2012-10-17",
+		Statement: []Statement{{
+			Sid:      "PermissionsBoundary",
+			Effect:   "Allow",
+			Action:   slices.Compact(allowed),
+			Resource: []string{"*"},
+		}},
+	}
+}
+
+// NewSCP creates a service control policy that denies every action of the
+// services that aren't among the services of a set of actions, so accounts
+// it's attached to can only use what the program does
+func NewSCP(actions []string) Document {
+	var services []string
+	for _, action := range actions {
+		services = append(services, mapping.Service(action)+":*")
+	}
+	slices.Sort(services)
+	return Document{
+		Version: "2012-10-17",
+		Statement: []Statement{{
+			Sid:       "DenyUnusedServices",
+			Effect:    "Deny",
+			NotAction: slices.Compact(services),
+			Resource:  []string{"*"},
+		}},
+	}
+}
+
+// Resources returns the sorted, unique resources of every pattern in
+// resources that matches an action, or nil if none does
+func Resources(action string, resources map[string][]string) []string {
+	var arns []string
+	for pattern, patternARNs := range resources {
+		if mapping.MatchAction(pattern, action) {
+			arns = append(arns, patternARNs...)
+		}
+	}
+	slices.Sort(arns)
+	return slices.Compact(arns)
+}
+
+// Role is a Google Cloud IAM custom role, as read by "gcloud iam roles
+// create --file"
+type Role struct {
+	Title               string   `json:"title"`
+	Description         string   `json:"description"`
+	Stage               string   `json:"stage"`
+	IncludedPermissions []string `json:"includedPermissions"`
+}
+
+// NewRole creates a custom role that has a set of permissions, e.g.
+// "storage.objects.get"
+func NewRole(permissions []string) Role {
+	permissions = slices.Clone(permissions)
+	slices.Sort(permissions)
+	return Role{
+		Title:               "iamgo",
+		Description:         "Permissions needed by the program, generated by iamgo",
+		Stage:               "GA",
+		IncludedPermissions: slices.Compact(permissions),
+	}
+}
+
+// AzureRole is an Azure custom role definition, as read by "az role
+// definition create --role-definition"
+type AzureRole struct {
+	Name             string   `json:"Name"`
+	IsCustom         bool     `json:"IsCustom"`
+	Description      string   `json:"Description"`
+	Actions          []string `json:"Actions"`
+	NotActions       []string `json:"NotActions"`
+	DataActions      []string `json:"DataActions"`
+	NotDataActions   []string `json:"NotDataActions"`
+	AssignableScopes []string `json:"AssignableScopes"`
+}
+
+// NewAzureRole creates a custom role that allows a set of actions, split
+// into control plane actions and the data actions isData returns true for.
+// The assignable scope is a placeholder for the subscription to replace
+func NewAzureRole(actions []string, isData func(action string) bool) AzureRole {
+	role := AzureRole{
+		Name:             "iamgo",
+		IsCustom:         true,
+		Description:      "Permissions needed by the program, generated by iamgo",
+		Actions:          []string{},
+		NotActions:       []string{},
+		DataActions:      []string{},
+		NotDataActions:   []string{},
+		AssignableScopes: []string{"/subscriptions/{subscription-id}"},
+	}
+	for _, action := range actions {
+		if isData != nil && isData(action) {
+			role.DataActions = append(role.DataActions, action)
+		} else {
+			role.Actions = append(role.Actions, action)
+		}
+	}
+	slices.Sort(role.Actions)
+	role.Actions = slices.Compact(role.Actions)
+	slices.Sort(role.DataActions)
+	role.DataActions = slices.Compact(role.DataActions)
+	return role
+}
+
+// TrustPolicy is the trust policy of an AWS IAM role, which says who can
+// assume it
+type TrustPolicy struct {
+	Version   string           `json:"Version"`
+	Statement []TrustStatement `json:"Statement"`
+}
+
+// TrustStatement is a statement in a trust policy
+type TrustStatement struct {
+	Effect    string                       `json:"Effect"`
+	Principal map[string]string            `json:"Principal"`
+	Action    []string                     `json:"Action"`
+	Condition map[string]map[string]string `json:"Condition,omitempty"`
+}
+
+// NewWebIdentityTrust creates a trust policy that lets a Kubernetes service
+// account assume a role through the OIDC provider of an EKS cluster, as
+// with IAM roles for service accounts (IRSA). The provider is given by its
+// ARN, e.g. "arn:aws:iam::123456789012:oidc-provider/oidc.eks.eu-west-1.amazonaws.com/id/EXAMPLE"
+func NewWebIdentityTrust(providerARN, namespace, serviceAccount string) TrustPolicy {
+	issuer := providerARN[strings.Index(providerARN, ":oidc-provider/")+len(":oidc-provider/"):]
+	return TrustPolicy{
+		Version: "2012-10-17",
+		Statement: []TrustStatement{{
+			Effect:    "Allow",
+			Principal: map[string]string{"Federated": providerARN},
+			Action:    []string{"sts:AssumeRoleWithWebIdentity"},
+			Condition: map[string]map[string]string{
+				"StringEquals": {
+					issuer + ":aud": "sts.amazonaws.com",
+					issuer + ":sub": "system:serviceaccount:" + namespace + ":" + serviceAccount,
+				},
+			},
+		}},
+	}
+}
+
+// NewPodIdentityTrust creates a trust policy that lets EKS Pod Identity
+// assume a role for the pods of the service accounts it's associated with
+func NewPodIdentityTrust() TrustPolicy {
+	return TrustPolicy{
+		Version: "2012-10-17",
+		Statement: []TrustStatement{{
+			Effect:    "Allow",
+			Principal: map[string]string{"Service": "pods.eks.amazonaws.com"},
+			Action:    []string{"sts:AssumeRole", "sts:TagSession"},
+		}},
+	}
+}
@@ -0,0 +1,89 @@
+package render
+
+import (
+	"io"
+
+	"github.com/esprimo/iamgo/internal/policy"
+)
+
+// cfnTemplate is a CloudFormation template with a role allowing the
+// actions, see cloudFormation
+type cfnTemplate struct {
+	AWSTemplateFormatVersion string                  `json:"AWSTemplateFormatVersion"`
+	Description              string                  `json:"Description"`
+	Parameters               map[string]cfnParameter `json:"Parameters"`
+	Conditions               map[string]any          `json:"Conditions"`
+	Resources                map[string]cfnResource  `json:"Resources"`
+	Outputs                  map[string]cfnOutput    `json:"Outputs"`
+}
+
+type cfnParameter struct {
+	Type          string   `json:"Type"`
+	Description   string   `json:"Description"`
+	Default       string   `json:"Default,omitempty"`
+	AllowedValues []string `json:"AllowedValues,omitempty"`
+}
+
+type cfnResource struct {
+	Type       string         `json:"Type"`
+	Properties map[string]any `json:"Properties"`
+}
+
+type cfnOutput struct {
+	Value any `json:"Value"`
+}
+
+// cloudFormation is a CloudFormation template with an AWS::IAM::Role that
+// has the policy inline, for those who deploy with CloudFormation. Who may
+// assume the role is a parameter, which defaults to the service of a
+// target of -target, if any
+type cloudFormation struct{}
+
+func (cloudFormation) Render(w io.Writer, r Report, opts Options) error {
+	principal := cfnParameter{Type: "String", Description: "Principal that may assume the role, e.g. lambda.amazonaws.com or the ARN of a role"}
+	principal.Default = trustServices[r.Target]
+	ref := map[string]string{"Ref": "TrustPrincipal"}
+	trust := map[string]any{
+		"Version": "2012-10-17",
+		"Statement": []map[string]any{{
+			"Effect": "Allow",
+			"Principal": map[string]any{"Fn::If": []any{
+				"TrustsService",
+				map[string]any{"Service": ref},
+				map[string]any{"AWS": ref},
+			}},
+			"Action": "sts:AssumeRole",
+		}},
+	}
+	return JSON(w, cfnTemplate{
+		AWSTemplateFormatVersion: "2010-09-09",
+		Description:              "Role with the IAM actions the program needs, generated by iamgo",
+		Parameters: map[string]cfnParameter{
+			"TrustPrincipal": principal,
+			"TrustPrincipalType": {
+				Type:          "String",
+				Description:   "Whether TrustPrincipal is a service or an AWS account, user or role",
+				Default:       "Service",
+				AllowedValues: []string{"Service", "AWS"},
+			},
+		},
+		Conditions: map[string]any{
+			"TrustsService": map[string]any{"Fn::Equals": []any{map[string]string{"Ref": "TrustPrincipalType"}, "Service"}},
+		},
+		Resources: map[string]cfnResource{
+			"Role": {
+				Type: "AWS::IAM::Role",
+				Properties: map[string]any{
+					"AssumeRolePolicyDocument": trust,
+					"Policies": []map[string]any{{
+						"PolicyName":     "iamgo",
+						"PolicyDocument": policy.New(r.Actions, opts.Resources),
+					}},
+				},
+			},
+		},
+		Outputs: map[string]cfnOutput{
+			"RoleArn": {Value: map[string]any{"Fn::GetAtt": []string{"Role", "Arn"}}},
+		},
+	})
+}
@@ -0,0 +1,107 @@
+package render
+
+import (
+	"io"
+	"strings"
+
+	"github.com/esprimo/iamgo/internal/policy"
+)
+
+// EKS is the service account a role for -format eks is for
+type EKS struct {
+	// The OIDC provider of the cluster, its issuer URL (e.g.
+	// "https://oidc.eks.eu-west-1.amazonaws.com/id/EXAMPLE") or ARN, for
+	// IAM roles for service accounts (IRSA). EKS Pod Identity is used if
+	// it's empty
+	OIDCProvider   string
+	Namespace      string
+	ServiceAccount string
+	// Name of the role, "<namespace>-<service account>" if empty
+	Role string
+}
+
+// eksArtifacts are what a service account in EKS needs to be able to make
+// the calls a program makes: a role with a policy allowing the actions and
+// a trust policy that lets the service account assume it, and the service
+// account itself with the annotation (IRSA) or association (Pod Identity)
+// that links the two
+type eksArtifacts struct {
+	Policy                 policy.Document         `json:"policy"`
+	Role                   eksRole                 `json:"role"`
+	ServiceAccount         serviceAccount          `json:"service_account"`
+	PodIdentityAssociation *podIdentityAssociation `json:"pod_identity_association,omitempty"`
+}
+
+type eksRole struct {
+	Name        string             `json:"name"`
+	ARN         string             `json:"arn"`
+	TrustPolicy policy.TrustPolicy `json:"trust_policy"`
+}
+
+// serviceAccount is a Kubernetes ServiceAccount manifest, which kubectl
+// reads as JSON as well as YAML
+type serviceAccount struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Name        string            `json:"name"`
+		Namespace   string            `json:"namespace"`
+		Annotations map[string]string `json:"annotations,omitempty"`
+	} `json:"metadata"`
+}
+
+// podIdentityAssociation is the input of "aws eks
+// create-pod-identity-association --cli-input-json"
+type podIdentityAssociation struct {
+	ClusterName    string `json:"clusterName"`
+	Namespace      string `json:"namespace"`
+	ServiceAccount string `json:"serviceAccount"`
+	RoleARN        string `json:"roleArn"`
+}
+
+// eks is the role, trust policy and service account to run the program
+// in EKS with, see eksArtifacts. What isn't known from the options, e.g.
+// the account of the role without the ARN of the OIDC provider, are
+// placeholders in braces to replace
+type eks struct{}
+
+func (eks) Render(w io.Writer, r Report, opts Options) error {
+	o := opts.EKS
+	role := o.Role
+	if role == "" {
+		role = o.Namespace + "-" + o.ServiceAccount
+	}
+
+	// The account and partition are only known from the ARN of the
+	// provider
+	provider := strings.TrimPrefix(o.OIDCProvider, "https://")
+	partition, account := "aws", "{account-id}"
+	if parts := strings.SplitN(provider, ":", 6); len(parts) == 6 && parts[0] == "arn" {
+		partition, account = parts[1], parts[4]
+	} else if provider != "" {
+		provider = "arn:aws:iam::" + account + ":oidc-provider/" + provider
+	}
+	arn := "arn:" + partition + ":iam::" + account + ":role/" + role
+
+	out := eksArtifacts{
+		Policy: policy.New(r.Actions, opts.Resources),
+		Role:   eksRole{Name: role, ARN: arn},
+	}
+	out.ServiceAccount.APIVersion = "v1"
+	out.ServiceAccount.Kind = "ServiceAccount"
+	out.ServiceAccount.Metadata.Name = o.ServiceAccount
+	out.ServiceAccount.Metadata.Namespace = o.Namespace
+	if provider != "" {
+		out.Role.TrustPolicy = policy.NewWebIdentityTrust(provider, o.Namespace, o.ServiceAccount)
+		out.ServiceAccount.Metadata.Annotations = map[string]string{"eks.amazonaws.com/role-arn": arn}
+	} else {
+		out.Role.TrustPolicy = policy.NewPodIdentityTrust()
+		out.PodIdentityAssociation = &podIdentityAssociation{
+			ClusterName:    "{cluster-name}",
+			Namespace:      o.Namespace,
+			ServiceAccount: o.ServiceAccount,
+			RoleARN:        arn,
+		}
+	}
+	return JSON(w, out)
+}
@@ -0,0 +1,324 @@
+// Package render writes the list of IAM actions a program needs in the
+// output formats of -format. Adding a format is a matter of implementing
+// Renderer and adding it to renderers
+package render
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/esprimo/iamgo/internal/mapping"
+	"github.com/esprimo/iamgo/internal/policy"
+	"github.com/esprimo/iamgo/schema"
+)
+
+// Report is the list of actions, see schema.Report
+type Report = schema.Report
+
+// Options are the flags and configuration that affect the output
+type Options struct {
+	// Output the SDK calls instead of the actions, if the format can
+	SDKCalls bool
+	// Resources to allow actions on in a policy, by action pattern, see
+	// policy.New
+	Resources map[string][]string
+	// Cloud provider of the actions, "aws" if empty. For "gcp" and "azure"
+	// the policy is a custom role
+	Provider string
+	// Reports whether an Azure action is a data action
+	DataActions func(action string) bool
+	// The service account of -format eks
+	EKS EKS
+	// Output a policy with the actions that only read and one with those
+	// that write, instead of one with all of them
+	SplitReadWrite bool
+	// Allow all actions of the services used in a permissions boundary
+	ServiceWildcards bool
+}
+
+// Renderer writes a report in an output format
+type Renderer interface {
+	Render(w io.Writer, r Report, opts Options) error
+}
+
+// renderers are the output formats by name
+var renderers = map[string]Renderer{
+	"text":      text{},
+	"json":      jsonReport{},
+	"json-full": jsonReport{},
+	"policy":    policyDocument{},
+	"terraform": terraform{},
+	"eks":       eks{},
+	"boundary":  boundary{},
+	"scp":       scp{},
+
+	"terraform-role": terraformRole{},
+	"cloudformation": cloudFormation{},
+}
+
+// Formats returns the names of the output formats, sorted
+func Formats() []string {
+	var formats []string
+	for format := range renderers {
+		formats = append(formats, format)
+	}
+	slices.Sort(formats)
+	return formats
+}
+
+// Render writes a report in a format
+func Render(w io.Writer, format string, r Report, opts Options) error {
+	renderer, ok := renderers[format]
+	if !ok {
+		return fmt.Errorf("unknown format %q", format)
+	}
+	return renderer.Render(w, r, opts)
+}
+
+// JSON writes a value as indented JSON
+func JSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// text is a plain list with one action, or SDK call, per line
+type text struct{}
+
+func (text) Render(w io.Writer, r Report, opts Options) error {
+	lines := r.Actions
+	if opts.SDKCalls {
+		lines = r.SDKCalls
+	} else if r.Counts != nil || r.SDKVersions != nil || r.Risks != nil {
+		// With -counts, each action is followed by its number of call
+		// sites if it has any, with -sdk-versions by the SDKs it's
+		// required through and with -risk by its risk level
+		versions := make(map[string][]string)
+		for version, actions := range r.SDKVersions {
+			for _, action := range actions {
+				versions[action] = append(versions[action], version)
+			}
+		}
+		lines = make([]string, len(r.Actions))
+		for i, action := range r.Actions {
+			lines[i] = action
+			if count, ok := r.Counts[action]; ok {
+				lines[i] += fmt.Sprintf(" %d", count)
+			}
+			if v := versions[action]; len(v) > 0 {
+				slices.Sort(v)
+				lines[i] += " " + strings.Join(v, ",")
+			}
+			if risk := r.Risks[action]; risk != "" {
+				lines[i] += " " + risk
+			}
+		}
+	}
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// jsonReport is the report as JSON, for other tools. With -format json-full
+// the report has the call paths to each action in it too
+type jsonReport struct{}
+
+func (jsonReport) Render(w io.Writer, r Report, opts Options) error {
+	if opts.SDKCalls {
+		r = Report{SchemaVersion: r.SchemaVersion, SDKCalls: r.SDKCalls}
+	}
+	return JSON(w, r)
+}
+
+// policyDocument is an IAM policy allowing the actions
+type policyDocument struct{}
+
+func (policyDocument) Render(w io.Writer, r Report, opts Options) error {
+	switch opts.Provider {
+	case "gcp":
+		return JSON(w, policy.NewRole(r.Actions))
+	case "azure":
+		return JSON(w, policy.NewAzureRole(r.Actions, opts.DataActions))
+	}
+	if opts.SplitReadWrite {
+		docs := make(map[string]policy.Document)
+		for name, actions := range splitReadWrite(r.Actions) {
+			docs[name] = policy.New(actions, opts.Resources)
+		}
+		return JSON(w, docs)
+	}
+	return JSON(w, policy.New(r.Actions, opts.Resources))
+}
+
+// boundary is a permissions boundary allowing the actions, or all actions
+// of their services, to cap what a role can do at what the program does
+type boundary struct{}
+
+func (boundary) Render(w io.Writer, r Report, opts Options) error {
+	return JSON(w, policy.NewBoundary(r.Actions, opts.ServiceWildcards))
+}
+
+// scp is a service control policy denying the services the program doesn't
+// use, to restrict an account to the ones it does
+type scp struct{}
+
+func (scp) Render(w io.Writer, r Report, opts Options) error {
+	return JSON(w, policy.NewSCP(r.Actions))
+}
+
+// terraform is an aws_iam_policy_document data source allowing the actions,
+// or a google_project_iam_custom_role or azurerm_role_definition resource,
+// to paste into Terraform configuration
+type terraform struct{}
+
+func (terraform) Render(w io.Writer, r Report, opts Options) error {
+	switch opts.Provider {
+	case "gcp":
+		return TerraformRole(w, "iamgo", policy.NewRole(r.Actions))
+	case "azure":
+		return TerraformAzureRole(w, "iamgo", policy.NewAzureRole(r.Actions, opts.DataActions))
+	}
+	if opts.SplitReadWrite {
+		split := splitReadWrite(r.Actions)
+		printed := false
+		for _, name := range []string{"read", "write"} {
+			if split[name] == nil {
+				continue
+			}
+			if printed {
+				if _, err := io.WriteString(w, "\n"); err != nil {
+					return err
+				}
+			}
+			printed = true
+			if err := Terraform(w, "iamgo_"+name, policy.New(split[name], opts.Resources)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return Terraform(w, "iamgo", policy.New(r.Actions, opts.Resources))
+}
+
+// splitReadWrite splits actions into those that only read, under "read",
+// and the others, under "write". A policy needs a statement, so either is
+// left out if there are no such actions
+func splitReadWrite(actions []string) map[string][]string {
+	split := make(map[string][]string)
+	for _, action := range actions {
+		if mapping.IsReadOnly(action) {
+			split["read"] = append(split["read"], action)
+		} else {
+			split["write"] = append(split["write"], action)
+		}
+	}
+	return split
+}
+
+// invalidIdentifier matches what can't be in a Terraform identifier
+var invalidIdentifier = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+// Terraform writes a policy as an aws_iam_policy_document data source with
+// a name, e.g. the name of a binary. Characters that can't be in a
+// Terraform identifier are replaced with underscores
+func Terraform(w io.Writer, name string, doc policy.Document) error {
+	name = identifier(name)
+	var b strings.Builder
+	fmt.Fprintf(&b, "data \"aws_iam_policy_document\" %q {\n", name)
+	for i, s := range doc.Statement {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString("  statement {\n")
+		if s.Sid != "" {
+			fmt.Fprintf(&b, "    sid       = %q\n", s.Sid)
+		}
+		fmt.Fprintf(&b, "    effect    = %q\n", s.Effect)
+		b.WriteString("    actions   = " + hclList(s.Action) + "\n")
+		b.WriteString("    resources = " + hclList(s.Resource) + "\n")
+		b.WriteString("  }\n")
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// TerraformRole writes a Google Cloud custom role as a
+// google_project_iam_custom_role resource with a name, which is also its
+// role ID
+func TerraformRole(w io.Writer, name string, role policy.Role) error {
+	name = identifier(name)
+	var b strings.Builder
+	fmt.Fprintf(&b, "resource \"google_project_iam_custom_role\" %q {\n", name)
+	fmt.Fprintf(&b, "  role_id     = %q\n", strings.ReplaceAll(name, "-", "_"))
+	fmt.Fprintf(&b, "  title       = %q\n", role.Title)
+	fmt.Fprintf(&b, "  description = %q\n", role.Description)
+	b.WriteString("  permissions = " + strings.ReplaceAll(hclList(role.IncludedPermissions), "\n    ", "\n  ") + "\n")
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// TerraformAzureRole writes an Azure custom role as an
+// azurerm_role_definition resource with a name, scoped to the current
+// subscription
+func TerraformAzureRole(w io.Writer, name string, role policy.AzureRole) error {
+	name = identifier(name)
+	var b strings.Builder
+	b.WriteString("data \"azurerm_subscription\" \"current\" {}\n\n")
+	fmt.Fprintf(&b, "resource \"azurerm_role_definition\" %q {\n", name)
+	fmt.Fprintf(&b, "  name        = %q\n", name)
+	b.WriteString("  scope       = data.azurerm_subscription.current.id\n")
+	fmt.Fprintf(&b, "  description = %q\n\n", role.Description)
+	b.WriteString("  permissions {\n")
+	if len(role.Actions) > 0 {
+		b.WriteString("    actions      = " + hclList(role.Actions) + "\n")
+	}
+	if len(role.DataActions) > 0 {
+		b.WriteString("    data_actions = " + hclList(role.DataActions) + "\n")
+	}
+	b.WriteString("  }\n\n")
+	b.WriteString("  assignable_scopes = [data.azurerm_subscription.current.id]\n")
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// identifier makes a name a valid Terraform identifier
+func identifier(name string) string {
+	name = invalidIdentifier.ReplaceAllString(name, "_")
+	if name == "" || (name[0] >= '0' && name[0] <= '9') || name[0] == '-' {
+		name = "_" + name
+	}
+	return name
+}
+
+// hclList formats strings as an HCL list, one item per line unless there's
+// only one
+func hclList(items []string) string {
+	if len(items) == 1 {
+		return fmt.Sprintf("[%s]", hclString(items[0]))
+	}
+	var b strings.Builder
+	b.WriteString("[\n")
+	for _, item := range items {
+		fmt.Fprintf(&b, "      %s,\n", hclString(item))
+	}
+	b.WriteString("    ]")
+	return b.String()
+}
+
+// hclString quotes a string for HCL, escaping what would otherwise be
+// interpolated, e.g. the placeholder of an environment variable in an ARN
+func hclString(s string) string {
+	s = strings.ReplaceAll(s, "${", "$${")
+	return strings.ReplaceAll(strconv.Quote(s), "%{", "%%{")
+}
@@ -0,0 +1,90 @@
+package render
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/esprimo/iamgo/internal/policy"
+)
+
+// trustServices are the services that assume the role of a program on the
+// compute services of -target, by target. EKS nodes are EC2 instances
+var trustServices = map[string]string{
+	"lambda": "lambda.amazonaws.com",
+	"ecs":    "ecs-tasks.amazonaws.com",
+	"ec2":    "ec2.amazonaws.com",
+	"eks":    "ec2.amazonaws.com",
+}
+
+// terraformRole is a role allowing the actions, as Terraform configuration
+// to apply as-is, see TerraformRoleModule
+type terraformRole struct{}
+
+func (terraformRole) Render(w io.Writer, r Report, opts Options) error {
+	return TerraformRoleModule(w, "iamgo", policy.New(r.Actions, opts.Resources), r.Target)
+}
+
+// TerraformRoleModule writes a policy as Terraform configuration for a role
+// with a name: an aws_iam_role, an aws_iam_policy and an
+// aws_iam_role_policy_attachment between them, with variables for the name
+// of the role and who may assume it. The principal defaults to the service
+// of a target of -target, if any, and has to be given otherwise
+func TerraformRoleModule(w io.Writer, name string, doc policy.Document, target string) error {
+	name = identifier(name)
+	var b strings.Builder
+	variable := func(suffix, description, typ, value string) {
+		fmt.Fprintf(&b, "variable %q {\n", name+"_"+suffix)
+		fmt.Fprintf(&b, "  description = %q\n", description)
+		fmt.Fprintf(&b, "  type        = %s\n", typ)
+		if value != "" {
+			fmt.Fprintf(&b, "  default     = %s\n", value)
+		}
+		b.WriteString("}\n\n")
+	}
+	variable("role_name", "Name of the role", "string", fmt.Sprintf("%q", name))
+	principalType, principals := "", ""
+	if service, ok := trustServices[target]; ok {
+		principalType, principals = `"Service"`, fmt.Sprintf("[%q]", service)
+	}
+	variable("trust_principal_type", "Type of the principals that may assume the role, e.g. Service or AWS", "string", principalType)
+	variable("trust_principal_identifiers", "Principals that may assume the role, e.g. [\"lambda.amazonaws.com\"] or the ARNs of roles", "list(string)", principals)
+
+	if err := writeString(w, b.String()); err != nil {
+		return err
+	}
+	if err := Terraform(w, name, doc); err != nil {
+		return err
+	}
+
+	b.Reset()
+	fmt.Fprintf(&b, "\ndata \"aws_iam_policy_document\" %q {\n", name+"_trust")
+	b.WriteString("  statement {\n")
+	b.WriteString("    effect  = \"Allow\"\n")
+	b.WriteString("    actions = [\"sts:AssumeRole\"]\n\n")
+	b.WriteString("    principals {\n")
+	fmt.Fprintf(&b, "      type        = var.%s_trust_principal_type\n", name)
+	fmt.Fprintf(&b, "      identifiers = var.%s_trust_principal_identifiers\n", name)
+	b.WriteString("    }\n")
+	b.WriteString("  }\n")
+	b.WriteString("}\n\n")
+	fmt.Fprintf(&b, "resource \"aws_iam_role\" %q {\n", name)
+	fmt.Fprintf(&b, "  name               = var.%s_role_name\n", name)
+	fmt.Fprintf(&b, "  assume_role_policy = data.aws_iam_policy_document.%s_trust.json\n", name)
+	b.WriteString("}\n\n")
+	fmt.Fprintf(&b, "resource \"aws_iam_policy\" %q {\n", name)
+	fmt.Fprintf(&b, "  name   = var.%s_role_name\n", name)
+	fmt.Fprintf(&b, "  policy = data.aws_iam_policy_document.%s.json\n", name)
+	b.WriteString("}\n\n")
+	fmt.Fprintf(&b, "resource \"aws_iam_role_policy_attachment\" %q {\n", name)
+	fmt.Fprintf(&b, "  role       = aws_iam_role.%s.name\n", name)
+	fmt.Fprintf(&b, "  policy_arn = aws_iam_policy.%s.arn\n", name)
+	b.WriteString("}\n")
+	return writeString(w, b.String())
+}
+
+// writeString writes a string, returning only the error
+func writeString(w io.Writer, s string) error {
+	_, err := io.WriteString(w, s)
+	return err
+}
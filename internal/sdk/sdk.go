@@ -0,0 +1,373 @@
+// Package sdk recognizes calls to cloud APIs, through the AWS SDK for Go v1
+// and v2 by default, the Google Cloud client libraries or the Azure SDK for
+// Go, in SSA form. Each SDK is recognized by a Detector, so
+// recognizing another one is a matter of adding a Detector for it
+package sdk
+
+import (
+	"fmt"
+	"go/types"
+	"slices"
+	"strings"
+
+	"golang.org/x/tools/go/ssa"
+)
+
+// Detector recognizes the functions of an SDK that call the API
+type Detector interface {
+	// Name of the SDK, e.g. "v2" for the AWS SDK for Go v2
+	Name() string
+	// Method returns the SDK method fn is in the format the mapping uses,
+	// e.g. "s3.PutObject", or an empty string if fn doesn't call the API
+	Method(fn *ssa.Function) string
+}
+
+// Detectors are SDKs to recognize, tried in order
+type Detectors []Detector
+
+// AWS recognizes the AWS SDK for Go v2 and v1
+var AWS = Detectors{awsV2{}, awsV1{}}
+
+// Detect returns the detector that recognizes fn as an API call and the
+// SDK method it is, or nil and an empty string if none does
+func (ds Detectors) Detect(fn *ssa.Function) (Detector, string) {
+	if fn.Pkg == nil {
+		return nil, ""
+	}
+	for _, d := range ds {
+		if method := d.Method(fn); method != "" {
+			return d, method
+		}
+	}
+	return nil, ""
+}
+
+// Explainer is a Detector that can tell why it does or doesn't recognize a
+// function, for diagnosing calls that are missed
+type Explainer interface {
+	// Explain returns why fn is or isn't an API call, or an empty string
+	// if fn isn't part of the SDK at all
+	Explain(fn *ssa.Function) string
+}
+
+// Explain returns why the detectors that fn is part of the SDK of do or
+// don't recognize it, e.g. "v2: not a method of Client", by detector name.
+// A detector that isn't an Explainer only tells if it recognizes fn
+func (ds Detectors) Explain(fn *ssa.Function) map[string]string {
+	reasons := make(map[string]string)
+	if fn.Pkg == nil {
+		return reasons
+	}
+	for _, d := range ds {
+		if e, ok := d.(Explainer); ok {
+			if reason := e.Explain(fn); reason != "" {
+				reasons[d.Name()] = reason
+			}
+		} else if method := d.Method(fn); method != "" {
+			reasons[d.Name()] = "recognized as " + method
+		}
+	}
+	return reasons
+}
+
+// MethodName returns the SDK method name of an SDK call in the format the
+// mapping uses, e.g. "ssm.GetParameter"
+func (ds Detectors) MethodName(fn *ssa.Function) string {
+	if _, method := ds.Detect(fn); method != "" {
+		return method
+	}
+	// The package name is the same as the AWS service name
+	return fmt.Sprintf("%s.%s", fn.Pkg.Pkg.Name(), fn.Name())
+}
+
+// FunctionNames takes an SDK method, e.g. "DynamoDB.BatchGetItem", and
+// returns a list of strings with the full names the different SDK versions
+// use
+func FunctionNames(sdkMethod string) []string {
+	var fnNames []string
+	service := strings.ToLower(strings.Split(sdkMethod, ".")[0])
+	method := strings.Split(sdkMethod, ".")[1]
+
+	v1 := fmt.Sprintf("(*github.com/aws/aws-sdk-go/service/%s.%s).%sRequest", // v1 has "Request" suffix
+		service,                          // service
+		strings.Split(sdkMethod, ".")[0], // Correctly capitalized service name
+		method,                           // method
+	)
+	v2 := fmt.Sprintf("(*github.com/aws/aws-sdk-go-v2/service/%s.Client).%s",
+		service, // service
+		method,  // method
+	)
+
+	return append(fnNames, v1, v2)
+}
+
+// Version determines if a function is a call to AWS SDK v1 or v2, or
+// another SDK the detectors recognize. Returns the name of the SDK, or an
+// empty string if it's not a call to any of them
+func (ds Detectors) Version(fn *ssa.Function) string {
+	if d, _ := ds.Detect(fn); d != nil {
+		return d.Name()
+	}
+	return ""
+}
+
+// RequestMethod returns the method of an AWS SDK v1 client another of its
+// methods calls an operation through, e.g. "GetObjectRequest" for
+// "GetObject", "GetObjectWithContext" or "ListObjectsPagesWithContext".
+// The Request method is what's recognized as the SDK call, which the
+// analysis finds by following the calls into the SDK, but code that only
+// looks at call sites has to take the step itself
+func RequestMethod(name string) string {
+	name = strings.TrimSuffix(name, "WithContext")
+	name = strings.TrimSuffix(name, "Pages")
+	return name + "Request"
+}
+
+// awsV2 is the AWS SDK for Go v2
+type awsV2 struct{}
+
+func (awsV2) Name() string { return "v2" }
+
+func (awsV2) Method(fn *ssa.Function) string {
+	if !isV2Call(fn) {
+		return transferMethod(fn, "github.com/aws/aws-sdk-go-v2/")
+	}
+	// The package name is the same as the AWS service name
+	return fn.Pkg.Pkg.Name() + "." + fn.Name()
+}
+
+func (awsV2) Explain(fn *ssa.Function) string {
+	return explain(fn, "github.com/aws/aws-sdk-go-v2/", v2Mismatch(fn))
+}
+
+// awsV1 is the AWS SDK for Go v1
+type awsV1 struct{}
+
+func (awsV1) Name() string { return "v1" }
+
+func (awsV1) Method(fn *ssa.Function) string {
+	if !isV1Call(fn) {
+		return transferMethod(fn, "github.com/aws/aws-sdk-go/")
+	}
+	// All SDK v1 calls has an extra 'Request' suffix
+	return fn.Pkg.Pkg.Name() + "." + strings.TrimSuffix(fn.Name(), "Request")
+}
+
+func (awsV1) Explain(fn *ssa.Function) string {
+	return explain(fn, "github.com/aws/aws-sdk-go/", v1Mismatch(fn))
+}
+
+// explain is Explain of the AWS SDKs, whose modules start with sdkPrefix,
+// given why fn isn't an operation of a service client, if it isn't
+func explain(fn *ssa.Function, sdkPrefix, mismatch string) string {
+	pkgpath := fn.Pkg.Pkg.Path()
+	if !strings.HasPrefix(pkgpath, sdkPrefix) {
+		return ""
+	}
+	if mismatch == "" {
+		return "an operation of a service client"
+	}
+	if methods, ok := transferManagers[pkgpath]; ok {
+		if method := transferMethod(fn, sdkPrefix); method != "" {
+			return "a transfer manager method"
+		}
+		return "not one of the transfer manager methods " + strings.Join(methods, ", ")
+	}
+	return mismatch
+}
+
+// transferManagers are the methods of the S3 transfer managers that upload
+// or download, by package. They're SDK methods of their own, rather than
+// only the S3 operations they're made of, so the result points at where the
+// program calls them
+var transferManagers = map[string][]string{
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager": {"Uploader.Upload", "Downloader.Download"},
+	"github.com/aws/aws-sdk-go-v2/feature/s3/transfermanager": {
+		"Client.UploadObject", "Client.DownloadObject", "Client.GetObject", "Client.UploadDirectory", "Client.DownloadDirectory",
+	},
+	"github.com/aws/aws-sdk-go/service/s3/s3manager": {
+		"Uploader.Upload", "Uploader.UploadWithContext", "Uploader.UploadWithIterator",
+		"Downloader.Download", "Downloader.DownloadWithContext", "Downloader.DownloadWithIterator",
+		"BatchDelete.Delete",
+	},
+}
+
+// transferMethod returns the SDK method of a transfer manager method in an
+// SDK, named after the package, receiver type and method like in the
+// mapping, e.g. "manager.Uploader.Upload", or an empty string if fn isn't
+// one
+func transferMethod(fn *ssa.Function, sdkPrefix string) string {
+	pkgpath := fn.Pkg.Pkg.Path()
+	if !strings.HasPrefix(pkgpath, sdkPrefix) {
+		return ""
+	}
+	method := receiverName(fn) + "." + fn.Name()
+	if !slices.Contains(transferManagers[pkgpath], method) {
+		return ""
+	}
+	return fn.Pkg.Pkg.Name() + "." + method
+}
+
+// mapped is an SDK whose methods that call the API can't be told from
+// their names or files like with the AWS SDK, so only the methods known to
+// the mapping are recognized. Methods are named after the package, receiver
+// type and method, e.g. "storage.ObjectHandle.NewReader"
+type mapped struct {
+	name  string
+	path  string
+	known func(method string) bool
+}
+
+// GCP returns a detector of the Google Cloud client libraries,
+// cloud.google.com/go, that recognizes the methods for which known returns
+// true
+func GCP(known func(method string) bool) Detector {
+	return mapped{"gcp", "cloud.google.com/go/", known}
+}
+
+// Azure returns a detector of the track 2 Azure SDK for Go,
+// github.com/Azure/azure-sdk-for-go/sdk, that recognizes the methods, e.g.
+// "azblob.Client.DownloadStream", for which known returns true
+func Azure(known func(method string) bool) Detector {
+	return mapped{"azure", "github.com/Azure/azure-sdk-for-go/sdk/", known}
+}
+
+func (m mapped) Name() string { return m.name }
+
+func (m mapped) Explain(fn *ssa.Function) string {
+	if !strings.HasPrefix(fn.Pkg.Pkg.Path(), m.path) {
+		return ""
+	}
+	if receiverName(fn) == "" {
+		return "not a method, only methods are in the mapping"
+	}
+	if method := m.Method(fn); method != "" {
+		return method + " is in the mapping"
+	}
+	return fn.Pkg.Pkg.Name() + "." + receiverName(fn) + "." + fn.Name() + " isn't in the mapping"
+}
+
+func (m mapped) Method(fn *ssa.Function) string {
+	if !strings.HasPrefix(fn.Pkg.Pkg.Path(), m.path) {
+		return ""
+	}
+	recv := receiverName(fn)
+	if recv == "" {
+		return ""
+	}
+	method := fn.Pkg.Pkg.Name() + "." + recv + "." + fn.Name()
+	if !m.known(method) {
+		return ""
+	}
+	return method
+}
+
+// isV2Call checks whether a function is an AWS API call via AWS SDK v2.
+// Only the package and the signature are looked at, not where the source
+// is, since that depends on the platform and how the module is stored: an
+// operation is a method of the service's Client like
+//
+//	func (c *Client) GetObject(ctx context.Context, params *GetObjectInput, optFns ...func(*Options)) (*GetObjectOutput, error)
+func isV2Call(fn *ssa.Function) bool {
+	return v2Mismatch(fn) == ""
+}
+
+// v2Mismatch returns why a function isn't an AWS SDK v2 operation, see
+// isV2Call, or an empty string if it is
+func v2Mismatch(fn *ssa.Function) string {
+	if !isServicePackage(fn.Pkg.Pkg.Path(), "github.com/aws/aws-sdk-go-v2/service/") {
+		return "not in the package of a service, github.com/aws/aws-sdk-go-v2/service/*"
+	}
+	if receiverName(fn) != "Client" {
+		return "not a method of Client"
+	}
+	sig := fn.Signature
+	switch {
+	case sig.Params().Len() != 3 || sig.Results().Len() != 2:
+		return "doesn't take a context, input and options and return an output and error"
+	case !isPointerTo(sig.Params().At(1).Type(), fn.Pkg.Pkg, fn.Name()+"Input"):
+		return "doesn't take a *" + fn.Name() + "Input"
+	case !isPointerTo(sig.Results().At(0).Type(), fn.Pkg.Pkg, fn.Name()+"Output"):
+		return "doesn't return a *" + fn.Name() + "Output"
+	}
+	return ""
+}
+
+// isV1Call checks whether a function is an AWS API call via AWS SDK v1.
+// Like with isV2Call only the package and signature are looked at. Every
+// way of calling an operation, e.g. GetObject and GetObjectWithContext,
+// goes through a method of the service's client like
+//
+//	func (c *S3) GetObjectRequest(input *GetObjectInput) (req *request.Request, output *GetObjectOutput)
+func isV1Call(fn *ssa.Function) bool {
+	return v1Mismatch(fn) == ""
+}
+
+// v1Mismatch returns why a function isn't the Request method of an AWS SDK
+// v1 operation, see isV1Call, or an empty string if it is
+func v1Mismatch(fn *ssa.Function) string {
+	if !isServicePackage(fn.Pkg.Pkg.Path(), "github.com/aws/aws-sdk-go/service/") {
+		return "not in the package of a service, github.com/aws/aws-sdk-go/service/*"
+	}
+	if receiverName(fn) == "" {
+		return "not a method of the service client"
+	}
+	operation, ok := strings.CutSuffix(fn.Name(), "Request")
+	if !ok || operation == "" {
+		return "no Request suffix, operations are found by their Request method, e.g. GetObjectRequest for GetObject and GetObjectWithContext"
+	}
+	sig := fn.Signature
+	if sig.Params().Len() != 1 || sig.Results().Len() != 2 {
+		return "doesn't take an input and return a request and output"
+	}
+	req, ok := sig.Results().At(0).Type().(*types.Pointer)
+	if !ok {
+		return "doesn't return a *request.Request"
+	}
+	named, ok := req.Elem().(*types.Named)
+	switch {
+	case !ok || named.Obj().Pkg() == nil || named.Obj().Pkg().Path() != "github.com/aws/aws-sdk-go/aws/request" || named.Obj().Name() != "Request":
+		return "doesn't return a *request.Request"
+	case !isPointerTo(sig.Params().At(0).Type(), fn.Pkg.Pkg, operation+"Input"):
+		return "doesn't take a *" + operation + "Input"
+	case !isPointerTo(sig.Results().At(1).Type(), fn.Pkg.Pkg, operation+"Output"):
+		return "doesn't return a *" + operation + "Output"
+	}
+	return ""
+}
+
+// isServicePackage reports whether a package is the package of a service,
+// e.g. "github.com/aws/aws-sdk-go-v2/service/s3" for the prefix
+// "github.com/aws/aws-sdk-go-v2/service/", rather than one of its
+// subpackages such as ".../s3/types"
+func isServicePackage(pkgpath, prefix string) bool {
+	service, ok := strings.CutPrefix(pkgpath, prefix)
+	return ok && service != "" && !strings.Contains(service, "/")
+}
+
+// receiverName returns the name of the type of a method's receiver, e.g.
+// "Client" for (*Client).GetObject, or an empty string for functions
+func receiverName(fn *ssa.Function) string {
+	recv := fn.Signature.Recv()
+	if recv == nil {
+		return ""
+	}
+	t := recv.Type()
+	if ptr, ok := t.(*types.Pointer); ok {
+		t = ptr.Elem()
+	}
+	if named, ok := t.(*types.Named); ok {
+		return named.Obj().Name()
+	}
+	return ""
+}
+
+// isPointerTo reports whether t is a pointer to the named type of a package
+func isPointerTo(t types.Type, pkg *types.Package, name string) bool {
+	ptr, ok := t.(*types.Pointer)
+	if !ok {
+		return false
+	}
+	named, ok := ptr.Elem().(*types.Named)
+	return ok && named.Obj().Pkg() == pkg && named.Obj().Name() == name
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"golang.org/x/tools/go/ssa"
+
+	"github.com/esprimo/iamgo/schema"
+)
+
+// libraryActions returns the actions of helper libraries
+func libraryActions(libs []schema.Library) []string {
+	var actions []string
+	for _, lib := range libs {
+		actions = append(actions, lib.Actions...)
+	}
+	return actions
+}
+
+// libraryFuncs returns the functions through which the program calls the
+// helper libraries that need an action, or an action matching a pattern
+// such as "s3:Put*", for -why
+func (g *graph) libraryFuncs(action string) []*ssa.Function {
+	var fns []*ssa.Function
+	for _, lib := range g.libraries {
+		if lib.Tracing && !g.tracing {
+			continue
+		}
+		if slices.ContainsFunc(lib.Actions, func(a string) bool { return matchAction(action, a) && g.IncludesAction(a) }) {
+			fns = append(fns, g.LibraryEntries(lib, true, nil)...)
+		}
+	}
+	return fns
+}
+
+// logLibraries outputs the helper libraries whose actions were added to
+// stderr, so the output stays a plain list
+func logLibraries(libs []schema.Library) {
+	for _, lib := range libs {
+		args := []any{"actions", strings.Join(lib.Actions, ",")}
+		if lib.Reason != "" {
+			args = append(args, "reason", lib.Reason)
+		}
+		slog.Info(fmt.Sprintf("added the actions %s needs", lib.Name), args...)
+	}
+}
+
+// logTracing suggests -xray when the program sends traces without the
+// actions for it being added, the most common cause of traces going missing
+func logTracing(names []string) {
+	for _, name := range names {
+		slog.Info("the program sends traces with the " + name + ", add -xray to include the X-Ray actions it needs")
+	}
+}
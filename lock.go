@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+)
+
+// defaultLockfile is where the lock command writes the required actions
+// and the check command compares them to, unless -lockfile is given
+const defaultLockfile = "iamgo.lock"
+
+// lockHeader starts every lockfile
+const lockHeader = "# Generated by iamgo lock. The IAM actions the code needs, one per line.\n"
+
+// writeLock writes a set of actions to a lockfile
+func writeLock(filename string, actions []string) error {
+	var buf bytes.Buffer
+	buf.WriteString(lockHeader)
+	for _, action := range actions {
+		fmt.Fprintln(&buf, action)
+	}
+	return os.WriteFile(filename, buf.Bytes(), 0o644)
+}
+
+// readActions returns the sorted, unique actions in a file with one per
+// line, such as a lockfile, see parseActions
+func readActions(filename string) ([]string, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return parseActions(string(data)), nil
+}
+
+// parseActions returns the sorted, unique actions in text with one per
+// line. Empty lines and lines starting with # are ignored
+func parseActions(text string) []string {
+	var actions []string
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		actions = append(actions, line)
+	}
+	slices.Sort(actions)
+	return slices.Compact(actions)
+}
+
+// diffActions writes the differences between two sets of actions to w,
+// with a "+" in front of actions only in the new set and a "-" in front of
+// actions only in the old one. Returns the added actions
+func diffActions(w io.Writer, old, new []string) []string {
+	added, removed := compareActions(old, new)
+	for _, action := range added {
+		fmt.Fprintf(w, "+ %s\n", action)
+	}
+	for _, action := range removed {
+		fmt.Fprintf(w, "- %s\n", action)
+	}
+	return added
+}
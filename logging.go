@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/esprimo/iamgo/schema"
+)
+
+// levelTrace is the level of -vv, for details such as every root and SDK
+// call found
+const levelTrace = slog.LevelDebug - 4
+
+// cliHandler is a slog.Handler that writes records the way command-line
+// tools do, e.g.
+//
+//	iamgo: warning: failed to read main.go: open main.go: permission denied
+//
+// Attributes follow the message as key=value, except errors (the "err" key)
+// which end the line after a colon, and lists which follow on lines of their
+// own. Attributes without a value, and error codes, are left out
+type cliHandler struct {
+	w     io.Writer
+	level slog.Leveler
+	attrs []slog.Attr
+	mu    *sync.Mutex
+}
+
+func newCLIHandler(w io.Writer, level slog.Leveler) *cliHandler {
+	return &cliHandler{w: w, level: level, mu: &sync.Mutex{}}
+}
+
+func (h *cliHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *cliHandler) Handle(_ context.Context, r slog.Record) error {
+	var b strings.Builder
+	b.WriteString("iamgo: ")
+	switch {
+	case r.Level >= slog.LevelError:
+	case r.Level >= slog.LevelWarn:
+		b.WriteString("warning: ")
+	case r.Level < slog.LevelInfo:
+		b.WriteString("debug: ")
+	}
+	b.WriteString(r.Message)
+
+	var err string
+	var lists []string
+	write := func(a slog.Attr) bool {
+		switch value := a.Value.Any().(type) {
+		case errorCode:
+			return true
+		case []string:
+			lists = append(lists, value...)
+			return true
+		}
+		if a.Key == "err" {
+			err = a.Value.String()
+			return true
+		}
+		value := a.Value.String()
+		if value == "" {
+			return true
+		}
+		if strings.ContainsAny(value, " \t\"=") {
+			value = fmt.Sprintf("%q", value)
+		}
+		fmt.Fprintf(&b, " %s=%s", a.Key, value)
+		return true
+	}
+	for _, a := range h.attrs {
+		write(a)
+	}
+	r.Attrs(write)
+	if err != "" {
+		b.WriteString(": " + err)
+	}
+	b.WriteString("\n")
+	for _, line := range lists {
+		b.WriteString("\t" + line + "\n")
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, werr := io.WriteString(h.w, b.String())
+	return werr
+}
+
+func (h *cliHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *h
+	c.attrs = append(append([]slog.Attr{}, h.attrs...), attrs...)
+	return &c
+}
+
+// WithGroup isn't needed for the command line, groups are flattened
+func (h *cliHandler) WithGroup(string) slog.Handler {
+	return h
+}
+
+// diagnostics are the warnings and errors logged during the analysis, for
+// the JSON report
+var diagnostics struct {
+	mu      sync.Mutex
+	records []schema.Diagnostic
+}
+
+// diagnosticHandler is a slog.Handler that records warnings and errors in
+// diagnostics before passing records on to the wrapped handler. Warnings
+// are recorded even if the wrapped handler leaves them out, e.g. with -q
+type diagnosticHandler struct {
+	slog.Handler
+	attrs []slog.Attr
+}
+
+func (h *diagnosticHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= slog.LevelWarn || h.Handler.Enabled(ctx, level)
+}
+
+func (h *diagnosticHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level >= slog.LevelWarn {
+		d := schema.Diagnostic{Level: "warning", Message: r.Message}
+		if r.Level >= slog.LevelError {
+			d.Level = "error"
+		}
+		add := func(a slog.Attr) bool {
+			if _, ok := a.Value.Any().(errorCode); ok || a.Key == "" {
+				return true
+			}
+			if d.Details == nil {
+				d.Details = make(map[string]any)
+			}
+			value := a.Value.Any()
+			switch v := value.(type) {
+			case error:
+				value = v.Error()
+			case fmt.Stringer:
+				value = v.String()
+			}
+			d.Details[a.Key] = value
+			return true
+		}
+		for _, a := range h.attrs {
+			add(a)
+		}
+		r.Attrs(add)
+		diagnostics.mu.Lock()
+		diagnostics.records = append(diagnostics.records, d)
+		diagnostics.mu.Unlock()
+	}
+	if !h.Handler.Enabled(ctx, r.Level) {
+		return nil
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *diagnosticHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &diagnosticHandler{
+		Handler: h.Handler.WithAttrs(attrs),
+		attrs:   append(append([]slog.Attr{}, h.attrs...), attrs...),
+	}
+}
+
+func (h *diagnosticHandler) WithGroup(name string) slog.Handler {
+	return &diagnosticHandler{Handler: h.Handler.WithGroup(name), attrs: h.attrs}
+}
+
+// collectedDiagnostics returns the diagnostics recorded so far
+func collectedDiagnostics() []schema.Diagnostic {
+	diagnostics.mu.Lock()
+	defer diagnostics.mu.Unlock()
+	return slices.Clone(diagnostics.records)
+}
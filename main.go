@@ -3,15 +3,28 @@
 package main
 
 import (
+	"context"
 	_ "embed"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
+	"path/filepath"
 	"regexp"
+	"runtime/debug"
+	"slices"
 	"strings"
+	"time"
 
 	"golang.org/x/tools/go/ssa"
+
+	"github.com/esprimo/iamgo/internal/libraries"
+	"github.com/esprimo/iamgo/internal/mapping"
+	"github.com/esprimo/iamgo/internal/policy"
+	"github.com/esprimo/iamgo/internal/render"
+	"github.com/esprimo/iamgo/internal/sdk"
+	"github.com/esprimo/iamgo/schema"
 )
 
 func usage() {
@@ -19,6 +32,30 @@ func usage() {
 	
 Usage:
   iamgo [OPTIONS] [PACKAGE]
+  iamgo lock [OPTIONS] [PACKAGE]    write the required IAM actions to a lockfile
+  iamgo check [OPTIONS] [PACKAGE]   fail if the code needs IAM actions that aren't in the lockfile
+  iamgo diff -from REV [-to REV] [OPTIONS] [PACKAGE]
+  iamgo diff [OPTIONS] OLD NEW      show the IAM actions added and removed between two git revisions,
+                                    or two directories or reports saved with -format json
+  iamgo serve [OPTIONS] [PACKAGE]   answer queries about the required IAM actions over HTTP
+  iamgo web [OPTIONS] [PACKAGE]     serve a page to search the required IAM actions, draw the call paths
+                                    to them and preview the policy
+  iamgo rpc [OPTIONS] [PACKAGE]     answer JSON-RPC queries on stdin and stdout, for editors
+  iamgo tui [OPTIONS] [PACKAGE]     browse the required IAM actions by service, and the call paths to them
+  iamgo comment -diff BASE..HEAD [OPTIONS] [PACKAGE]
+                                    write a Markdown pull request comment about the IAM actions a
+                                    change adds and removes, and optionally post it
+  iamgo changelog [OPTIONS] [PACKAGE]
+                                    write a Markdown changelog of the IAM actions added and removed by
+                                    each commit of the lockfile, and by the code since
+  iamgo changelog OLD NEW           the same between two lockfiles
+  iamgo history [-since TAG] [OPTIONS] [PACKAGE]
+                                    chart how the required IAM actions evolved over the tagged revisions
+  iamgo merge [-o FILE] REPORT...   combine reports saved with -format json into one
+  iamgo actions [SERVICE...]        list the IAM actions iamgo knows of, e.g. of s3
+  iamgo inject -template FILE [OPTIONS] [PACKAGE]
+                                    add the statements each Lambda function in a SAM or CloudFormation
+                                    template needs to its policies
 
 Options:
 `)
@@ -27,204 +64,946 @@ Options:
 	fmt.Fprint(os.Stderr, `
 Examples:
   iamgo .
+  iamgo -version
   iamgo main.go
+  iamgo ./svc-a ./svc-b
+  iamgo -C services/api ./...
+  find services -name go.mod -exec dirname {} \; | iamgo -
   iamgo -sdk-calls main.go
+  iamgo -counts ./...
+  iamgo -sdk-versions ./...
+  iamgo -risk -format json ./...
+  iamgo -dead ./...
+  iamgo -debug-detect . >/dev/null
+  iamgo -per-client .
+  iamgo -per-binary -format policy ./cmd/...
+  iamgo -annotate . | git apply
+  iamgo -exclude 'github.com/org/app/examples/...' ./...
+  iamgo -buildflag=-mod=vendor .
+  iamgo -timeout 10m ./...
+  iamgo -binary ./app
+  iamgo -ssa=C .
   iamgo -why ssm:getparameters .
+  iamgo -why s3:GetObject -why s3:PutObject -all-paths .
+  iamgo -why 's3:*' .
+  iamgo -why DynamoDB.BatchGetItem .
+  iamgo -why s3:PutObject -via handlers.Upload .
+  iamgo -why s3:PutObject -format json .
+  iamgo -format json-full -paths 3 ./...
+  iamgo -why s3:PutObject -relpaths .
+  iamgo -xray -format policy ./...
+  iamgo -include-runtime-baseline -per-binary -format policy ./...
+  iamgo -target ecs -format policy ./cmd/worker
+  iamgo -format eks -eks-service-account prod/orders ./cmd/orders
+  iamgo -format boundary -boundary-services ./cmd/orders
+  iamgo -format scp ./...
+  iamgo -infer-resources -format policy ./...
+  iamgo -split-read-write -format terraform ./...
+  iamgo -why s3:PutObject -root-binary api ./cmd/...
+  iamgo -why s3:PutObject -paths 3 .
+  iamgo -service s3,dynamodb .
+  iamgo -exclude-service sts,sso .
+  iamgo -export-graph graph.json .
+  iamgo -format policy .
+  iamgo -format terraform .
+  iamgo -format terraform-role -target lambda .
+  iamgo -format cloudformation -target ecs .
+  iamgo -provider gcp -format policy ./...
+  iamgo -provider azure ./...
+  iamgo -plugin ./tools/iamgo-awsx ./...
+  iamgo -config ci/iamgo.yaml .
+  iamgo -check-policy policy.json .
+  iamgo -check-role arn:aws:iam::123456789012:role/app .
+  iamgo -check-role app -no-new-access .
+  iamgo -check-terraform plan.json -terraform-role aws_iam_role.app .
+  iamgo -cloudtrail events.json -cloudtrail-role app .
+  iamgo -cloudtrail-resources ./trail -format policy .
+  iamgo -iamlive iamlive.json .
+  iamgo -expect actions.txt .
+  iamgo -forbid 'iam:*,*:Delete*' ./...
+  iamgo lock .
+  iamgo check -lockfile deploy/iamgo.lock ./...
+  iamgo changelog ./...
+  iamgo changelog v1.lock v2.lock
+  iamgo history -since v1.0.0 ./...
+  iamgo diff -from main -to HEAD ./...
+  iamgo diff ../app-v1 ../app-v2
+  iamgo diff -sdk-calls before.json after.json
+  iamgo serve -addr localhost:9000 ./...
+  iamgo serve -webhook https://hooks.slack.com/services/T000/B000/XXXX ./...
+  iamgo tui ./...
+  iamgo web ./...
+  iamgo comment -diff main...HEAD ./...
+  iamgo comment -diff origin/main...HEAD -post github ./...
+  iamgo comment -diff main...feature -post gitlab -repo org/app -pr 42 ./...
+  iamgo merge svc-a.json svc-b.json -o account.json
+  iamgo actions ssm
+  iamgo inject -template template.yaml ./...
+  iamgo inject -template template.yaml -w ./...
+
+Exit codes:
+  0  success
+  1  invalid flags or arguments, or an error
+  2  the packages couldn't be loaded
+  3  a check failed, e.g. the code needs actions the lockfile or a policy doesn't allow
+  4  nothing was found, e.g. no SDK calls or nothing matching -why
 
 `)
 }
 
+// parseFlags parses command line flags, exiting with exitError if they're
+// invalid, or exitOK after printing the usage for -h
+func parseFlags(args []string) {
+	err := flag.CommandLine.Parse(args)
+	switch {
+	case errors.Is(err, flag.ErrHelp):
+		os.Exit(exitOK)
+	case err != nil:
+		os.Exit(exitError)
+	}
+}
+
 func main() {
-	log.SetPrefix("iamgo: ")
-	log.SetFlags(0) // don't show timestamp
+	// Commands come before the flags, e.g. "iamgo check -tags x ."
+	var command string
+	if len(os.Args) > 1 && isCommand(os.Args[1]) {
+		command = os.Args[1]
+		os.Args = slices.Delete(os.Args, 1, 2)
+	}
+
+	var excludeFlag stringsFlag
+	flag.Var(&excludeFlag, "exclude", "package pattern (e.g. 'github.com/org/legacy/...') to leave out of the analysis, may be repeated")
+	var buildFlag stringsFlag
+	flag.Var(&buildFlag, "buildflag", "flag to pass to the build system as-is, e.g. '-mod=vendor', may be repeated (GOFLAGS is honored too)")
+
+	var pluginFlag stringsFlag
+	flag.Var(&pluginFlag, "plugin", "command of a plugin that recognizes more SDK calls, e.g. of an internal wrapper of the AWS SDK, or maps them to actions, may be repeated (see: Plugins in the README)")
+	var webhookFlag stringsFlag
+	flag.Var(&webhookFlag, "webhook", "with the serve and rpc commands, URL to post the change to when the required actions change on reload, e.g. of a Slack incoming webhook, may be repeated")
+	var whyFlag stringsFlag
+	flag.Var(&whyFlag, "why", "show a call path to an SDK call that requires a certain permission, e.g. 'ssm:GetParameter' or 's3:Put*', or to an SDK method or function, e.g. 'SSM.GetParameter', may be repeated")
+
+	var ssaFlag ssa.BuilderMode
+	flag.Var(&ssaFlag, "ssa", "extra SSA builder options as letters, e.g. N to build naive SSA form, C to sanity check functions or L to build packages serially (see: golang.org/x/tools/go/ssa BuilderModeDoc)")
+
+	// These only select a mode, see modes
+	flag.Bool("dead", false, "print the SDK calls in the analyzed packages that aren't reachable from any root, e.g. in dead code or code whose entry point the analysis misses")
+	flag.Bool("per-binary", false, "output the result of each main package separately, e.g. a policy per Lambda function in cmd/, named after the package")
+	flag.Bool("per-client", false, "group the result by where the SDK clients the calls are made through are constructed")
+	flag.Bool("annotate", false, "print a patch that adds a comment above each function listing the IAM actions reachable from it")
 
 	var (
-		testFlag       = flag.Bool("test", false, "include implicit test packages and executables")
-		tagsFlag       = flag.String("tags", "", "comma-separated list of extra build tags (see: go help buildconstraint)")
-		reflectionFlag = flag.Bool("reflection", false, "include calls that are only reachable through reflection (false positive prone)")
-		sdkcallsFlag   = flag.Bool("sdk-calls", false, "print SDK calls instead of IAM actions")
-		whyFlag        = flag.String("why", "", "show a call path to an SDK call that requires a certain permission")
+		checkPolicyFlag = flag.String("check-policy", "", "compare the required IAM actions with the ones an IAM policy document in a JSON file allows")
+		noNewAccessFlag = flag.Bool("no-new-access", false, "with -check-policy, -check-role or -check-terraform, have IAM Access Analyzer tell whether the generated policy grants access the existing one doesn't instead, and fail if it does")
+		checkRoleFlag   = flag.String("check-role", "", "compare the required IAM actions with the ones the policies of a deployed IAM role allow, given by its ARN or name")
+		checkTFFlag     = flag.String("check-terraform", "", "compare the required IAM actions with the ones the policies of an IAM role in the output of 'terraform show -json' for a plan or state allow")
+		tfRoleFlag      = flag.String("terraform-role", "", "with -check-terraform, the role to check, given by its address (e.g. 'aws_iam_role.app') or name, if there's more than one")
+		cloudTrailFlag  = flag.String("cloudtrail", "", "compare the required IAM actions with the ones used in CloudTrail events in a file or directory (JSON log files or CSV, e.g. from Athena)")
+		ctResourcesFlag = flag.String("cloudtrail-resources", "", "allow actions in a generated policy only on the resources they were used on in CloudTrail events in a file or directory, where every event of the action tells")
+		cloudTrailRole  = flag.String("cloudtrail-role", "", "with -cloudtrail or -cloudtrail-resources, only include events made by an IAM role, given by its ARN or name")
+		iamliveFlag     = flag.String("iamlive", "", "compare the required IAM actions with the ones in a policy or CSV file generated by iamlive")
+		configFlag      = flag.String("config", "", "read the project configuration from a file instead of the "+configFilename+" at the module root, whose plugins and driver aren't run")
+		exportGraphFlag = flag.String("export-graph", "", "write the call graph between roots and AWS SDK calls as JSON to a file")
+		addrFlag        = flag.String("addr", "localhost:8080", "with the serve and web commands, the address to listen on")
+		outputFlag      = flag.String("o", "", "with the merge command, the file to write the merged report to instead of stdout")
+		templateFlag    = flag.String("template", "", "with the inject command, the SAM or CloudFormation template in YAML to add policy statements to")
+		lockfileFlag    = flag.String("lockfile", defaultLockfile, "with the lock, check and changelog commands, the lockfile to write or compare with (with serve, in /metrics)")
+		fromFlag        = flag.String("from", "", "with the diff command, the git revision to compare from, e.g. 'main'")
+		toFlag          = flag.String("to", "HEAD", "with the diff command, the git revision to compare to")
+		sinceFlag       = flag.String("since", "", "with the history command, the tag to start from, e.g. 'v1.0.0' (default: the first one)")
+		diffFlag        = flag.String("diff", "", "with the comment command, the git revisions to compare, e.g. 'main..HEAD', or 'main...HEAD' to compare with where HEAD branched off")
+		postFlag        = flag.String("post", "", "with the comment command, post the comment to a pull request on 'github' or a merge request on 'gitlab', using the token in GITHUB_TOKEN or GITLAB_TOKEN")
+		repoFlag        = flag.String("repo", "", "with -post, the repository, e.g. 'org/app' (default: from the CI environment)")
+		prFlag          = flag.Int("pr", 0, "with -post, the number of the pull or merge request (default: from the CI environment)")
+		expectFlag      = flag.String("expect", "", "fail if the code needs IAM actions not in a file with one action or pattern (e.g. 's3:Get*') per line")
+		forbidFlag      = flag.String("forbid", "", "fail if the code needs IAM actions matching a comma-separated list of actions or patterns, e.g. 'iam:*,*:Delete*'")
+		serviceFlag     = flag.String("service", "", "comma-separated list of services, e.g. 's3,dynamodb', or action patterns, e.g. 'iam:*', to limit the result and -why to")
+		excludeSvcFlag  = flag.String("exclude-service", "", "comma-separated list of services, e.g. 'sts,sso', or action patterns, e.g. '*:Delete*', to leave out of the result and -why")
+		providerFlag    = flag.String("provider", "aws", "cloud provider whose SDK calls to look for: aws (the AWS SDK for Go v1 and v2), gcp (the Google Cloud client libraries, cloud.google.com/go) or azure (the Azure SDK for Go)")
+		formatFlag      = flag.String("format", "text", "output format: text, json, json-full (the same with every call path to each action, see -paths), policy (an IAM policy document, or a custom role with -provider gcp or azure), terraform (the same as a Terraform data source or resource), terraform-role (Terraform configuration of a role with the policy attached), cloudformation (a CloudFormation template of a role with the policy inline), eks (the policy, a role with a trust policy for a service account and the service account, see -eks-service-account), boundary (a permissions boundary allowing the actions) or scp (a service control policy denying the services not used), policy, terraform, terraform-role, cloudformation, eks, boundary and scp only for the list of actions")
+		boundarySvcFlag = flag.Bool("boundary-services", false, "with -format boundary, allow every action of the services the program uses, e.g. 's3:*', so the boundary doesn't change with every new call")
+		eksSAFlag       = flag.String("eks-service-account", "", "with -format eks, the Kubernetes service account the program runs as, as 'namespace/name'")
+		eksOIDCFlag     = flag.String("eks-oidc-provider", "", "with -format eks, the OIDC provider of the cluster, its issuer URL or ARN, for IAM roles for service accounts (IRSA) instead of EKS Pod Identity")
+		eksRoleFlag     = flag.String("eks-role", "", "with -format eks, the name of the role (default: namespace-name of the service account)")
+		testFlag        = flag.Bool("test", false, "include implicit test packages and executables")
+		driverFlag      = flag.String("driver", "", "program implementing the GOPACKAGESDRIVER protocol to load packages with instead of the go command, e.g. for Bazel (GOPACKAGESDRIVER is honored too)")
+		tagsFlag        = flag.String("tags", "", "comma-separated list of extra build tags (see: go help buildconstraint)")
+		reflectionFlag  = flag.Bool("reflection", false, "include calls that are only reachable through reflection (false positive prone)")
+		sdkcallsFlag    = flag.Bool("sdk-calls", false, "print SDK calls instead of IAM actions")
+		countsFlag      = flag.Bool("counts", false, "print how many distinct places in the analyzed packages call the SDK in a way that requires each action")
+		riskFlag        = flag.Bool("risk", false, "print the risk level of each action (read, write, destructive or permissions) and a risk score of the program, to tell which programs need a security review first")
+		versionsFlag    = flag.Bool("sdk-versions", false, "print the SDKs, e.g. v1 and v2 of the AWS SDK for Go, each action is required through, to follow a migration from one to the other")
+		debugDetectFlag = flag.Bool("debug-detect", false, "log why each function of an SDK the code calls is or isn't recognized as an API call, to find out why a call is missed")
+		allPathsFlag    = flag.Bool("all-paths", false, "with -why, show a call path through every place the SDK is called instead of only the first one found")
+		pathsFlag       = flag.Int("paths", 1, "with -why and -format json-full, show up to this many of the shortest call paths that go through different functions")
+		viaFlag         = flag.String("via", "", "with -why, only show call paths through a function, e.g. 'handlers.Upload'")
+		rootBinaryFlag  = flag.String("root-binary", "", "with -why, only show call paths starting from a main package, given by its path or binary name")
+		binaryFlag      = flag.Bool("binary", false, "inspect a compiled Go binary instead of source (approximate, doesn't account for reachability)")
+		lowMemoryFlag   = flag.Bool("low-memory", false, "use less memory at the cost of a slower analysis, for very large programs")
+		writeFlag       = flag.Bool("w", false, "with -annotate, write the comments to the source files instead of printing a patch, or with the inject command, write the template instead of printing it")
+		targetFlag      = flag.String("target", "", "add the baseline of the compute service the program runs on, the actions it needs there regardless of what it does (e.g. to write logs or pull its image): "+strings.Join(baselineTargets(), ", "))
+		baselineFlag    = flag.Bool("include-runtime-baseline", false, "when the program is a Lambda function, include the actions of its execution role: writing its logs and, if it uses databases or other resources in a VPC, managing its network interfaces")
+		inferResFlag    = flag.Bool("infer-resources", false, "allow actions in a generated policy only on the resources the code names with constants, e.g. a bucket in a string literal, where every call that needs the action does")
+		splitFlag       = flag.Bool("split-read-write", false, "with -format policy or terraform, output a policy with the actions that only list or read and another with the ones that write, e.g. to attach them to different roles")
+		xrayFlag        = flag.Bool("xray", false, "include the X-Ray actions needed to send traces when the program uses the X-Ray SDK or the OpenTelemetry X-Ray exporter")
+		relPathsFlag    = flag.Bool("relpaths", false, "print positions in the module relative to its root, and in the module cache relative to the cache, instead of as absolute paths")
+		quietFlag       = flag.Bool("q", false, "only print errors, not warnings or notes")
+		verboseFlag     = flag.Bool("v", false, "print the progress of the analysis")
+		veryVerboseFlag = flag.Bool("vv", false, "print the progress of the analysis in detail, e.g. every SDK call found")
+		timeoutFlag     = flag.Duration("timeout", 0, "stop and fail if the analysis takes longer than this, e.g. '5m' (with serve and rpc, each time the program is loaded)")
+		targetsFileFlag = flag.String("targets-file", "", "file listing more package patterns or module directories to analyze, one per line, e.g. for a scan of many modules ('-' as an argument reads them from stdin)")
+		chdirFlag       = flag.String("C", "", "change to this directory before anything else, like the go command, so patterns, the project configuration and other relative paths are resolved from there")
+		versionFlag     = flag.Bool("version", false, "print the version of iamgo, the Go version it was built with and where its action mapping comes from")
 	)
 
 	flag.Usage = usage
+	// Exit with exitError rather than the flag package's 2 on invalid flags
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+
+	parseFlags(os.Args[1:])
+
+	// Diagnostics go to stderr so that only the result is on stdout
+	level := slog.LevelInfo
+	switch {
+	case *veryVerboseFlag:
+		level = levelTrace
+	case *verboseFlag:
+		level = slog.LevelDebug
+	case *quietFlag:
+		level = slog.LevelError
+	}
+	slog.SetDefault(slog.New(newCLIHandler(os.Stderr, level)))
 
-	flag.Parse()
-	if len(flag.Args()) == 0 {
-		usage()
-		os.Exit(2)
+	if *chdirFlag != "" {
+		if err := os.Chdir(*chdirFlag); err != nil {
+			fatal(codeUsage, "failed to change to the -C directory", "err", err)
+		}
 	}
 
-	if *whyFlag != "" {
-		whyFormat := regexp.MustCompile(`^[A-Za-z0-9-]+\:[A-Za-z-]+$`)
-		if !whyFormat.MatchString(*whyFlag) {
-			usage()
-			log.Fatal("-why value must be an IAM action in format 'service:method', for example '-why ssm:GetParameter'")
+	if *versionFlag {
+		printVersion(os.Stdout)
+		return
+	}
+
+	// Patterns may be read from a file or stdin instead, since a scan of
+	// hundreds of modules doesn't fit in the arguments
+	if args := flag.Args(); *targetsFileFlag != "" || slices.Contains(args, "-") {
+		if command == "rpc" && slices.Contains(args, "-") {
+			fatal(codeUsage, "the rpc command reads requests from stdin, use -targets-file")
+		}
+		var targets []string
+		for _, arg := range args {
+			if arg != "-" {
+				targets = append(targets, arg)
+				continue
+			}
+			stdin, err := readTargets(os.Stdin)
+			if err != nil {
+				fatal(codeRead, "failed to read patterns from stdin", "err", err)
+			}
+			targets = append(targets, stdin...)
+		}
+		if *targetsFileFlag != "" {
+			f, err := os.Open(*targetsFileFlag)
+			if err != nil {
+				fatal(codeRead, "failed to read -targets-file", "err", err)
+			}
+			listed, err := readTargets(f)
+			f.Close()
+			if err != nil {
+				fatal(codeRead, "failed to read -targets-file", "err", err)
+			}
+			targets = append(targets, listed...)
 		}
+		if len(targets) == 0 {
+			fatal(codeUsage, "no package patterns or module directories listed")
+		}
+		// "--" so that nothing listed is taken for a flag
+		parseFlags(append([]string{"--"}, targets...))
+	}
+	if len(flag.Args()) == 0 && command != "actions" {
+		usage()
+		os.Exit(exitError)
 	}
 
-	// Load program, create graph etc
-	graph := analyze(*testFlag, *tagsFlag)
+	// The project configuration provides defaults for flags that aren't
+	// given on the command line
+	cfg, err := loadConfig(*configFlag)
+	if err != nil {
+		fatal(codeConfig, "failed to read config", "err", err)
+	}
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if !set["tags"] {
+		*tagsFlag = cfg.Tags
+	}
+	excludeFlag = append(excludeFlag, cfg.Exclude...)
+	if !set["format"] && cfg.Format != "" {
+		*formatFlag = cfg.Format
+	}
+	if !set["target"] {
+		*targetFlag = cfg.Target
+	}
+	if !set["driver"] {
+		*driverFlag = cfg.Driver
+	}
+	// The driver runs in the directory of the packages, which is a worktree
+	// for the diff and history commands
+	if strings.ContainsRune(*driverFlag, filepath.Separator) {
+		if abs, err := filepath.Abs(*driverFlag); err == nil {
+			*driverFlag = abs
+		}
+	}
 
-	// If we just want to list the SDK calls we don't need
-	// to load the method->iam mapping
-	if !*sdkcallsFlag {
-		loadMap()
+	// What the run does, and which flags and formats apply to it, see modes
+	runMode := selectMode(command, flag.Args())
+	if *sdkcallsFlag && !runMode.sdkCalls {
+		fatal(codeUsage, "-sdk-calls doesn't apply to "+runMode.String())
+	}
+	if (*countsFlag || *versionsFlag || *riskFlag) && (runMode != listMode || *sdkcallsFlag) {
+		fatal(codeUsage, "-counts, -sdk-versions and -risk only apply to the list of actions of a single module")
+	}
+	if *exportGraphFlag != "" && runMode.modules {
+		fatal(codeUsage, "-export-graph works on one module at a time")
 	}
 
-	// The -why=action flag shows a path of function calls that
-	// leads to an AWS SDK call that requires the IAM action
-	if *whyFlag != "" {
-		// Map AWS IAM action permission to any SDK methods that might need them
-		sdkMethods := actionToSDKMethods(*whyFlag)
-		if len(sdkMethods) == 0 {
-			log.Fatalf("didn't find any SDK method that requires the action %s. Are you sure it exist?", *whyFlag)
-		}
-		for _, method := range sdkMethods {
-			// Based on the SDK method names, find what they might be called in different SDK versions
-			for _, fnName := range possibleFunctionNames(method) {
-				if path := graph.whyReachable(fnName); path != nil {
-					graph.printPath(path)
-					return // only print the first match we find
-				}
-			}
+	// -provider gcp or azure looks for calls to the Google Cloud client
+	// libraries or the Azure SDK instead, mapped to their permissions.
+	// What's about AWS policies, roles and events doesn't apply
+	detectors := sdk.AWS
+	switch *providerFlag {
+	case "aws":
+	case "gcp", "azure":
+		if runMode.formatsFor(*providerFlag) == nil {
+			fatal(codeUsage, "-provider "+*providerFlag+" can't be combined with "+runMode.String()+", which only applies to AWS")
+		}
+		if *ctResourcesFlag != "" {
+			fatal(codeUsage, "-provider "+*providerFlag+" can't be combined with -cloudtrail-resources")
 		}
-		log.Fatalf("no call path found that requires %s. It might only be reachable via reflection", *whyFlag)
+		detectors = loadProvider(*providerFlag)
+	default:
+		usage()
+		fatal(codeUsage, "-provider must be aws, gcp or azure")
 	}
 
-	var sdkMethods []string
-	for fn := range graph.reachable {
-		if fn.Synthetic != "" {
-			continue // ignore synthetic wrappers etc
+	// The helper libraries are of AWS
+	var libs []libraries.Library
+	if *providerFlag == "aws" {
+		libs = append(slices.Clip(libraries.Known), cfg.Libraries...)
+	}
+	if _, ok := libraries.Baselines[*targetFlag]; !ok && *targetFlag != "" {
+		usage()
+		fatal(codeUsage, "-target must be one of: "+strings.Join(baselineTargets(), ", "))
+	}
+	if (*targetFlag != "" || *baselineFlag) && (*providerFlag != "aws" || *binaryFlag) {
+		fatal(codeUsage, "-target and -include-runtime-baseline can't be combined with -provider "+*providerFlag+" or -binary")
+	}
+	if *targetFlag != "" && *baselineFlag {
+		fatal(codeUsage, "-target can't be combined with -include-runtime-baseline, which adds the baseline of lambda for Lambda functions")
+	}
+
+	if commands := append(pluginFlag, cfg.Plugins...); len(commands) > 0 {
+		if *binaryFlag {
+			fatal(codeUsage, "-plugin can't be combined with -binary")
 		}
+		detectors = append(startPlugins(commands), detectors...)
+		defer func() {
+			// A plugin may fail after the analysis, e.g. asked for the
+			// action of a method only a -why query names
+			err := takePluginError()
+			stopPlugins()
+			if err != nil {
+				fatalFailure(codeExternal, "plugin failed", err)
+			}
+		}()
+	}
 
-		// Use origin rather than instantiations
-		if orig := fn.Origin(); orig != nil {
-			fn = orig
+	formats := runMode.formatsFor(*providerFlag)
+	// SDK calls, their counts, versions and risks aren't policies
+	if *sdkcallsFlag || *countsFlag || *versionsFlag || *riskFlag {
+		formats = slices.DeleteFunc(slices.Clone(formats), func(format string) bool { return format != "text" && format != "json" })
+	}
+	if !slices.Contains(formats, *formatFlag) {
+		if set["format"] {
+			usage()
+			fatal(codeUsage, "-format "+*formatFlag+" is not supported here, must be one of: "+strings.Join(formats, ", "))
 		}
+		*formatFlag = formats[0] // the configured default doesn't apply to this mode
+	}
 
-		// Ignore unreachable nested functions
-		if fn.Parent() != nil {
-			continue
+	var policyOpts render.Options
+	if *formatFlag == "eks" {
+		namespace, name, ok := strings.Cut(*eksSAFlag, "/")
+		if !ok || namespace == "" || name == "" || strings.Contains(name, "/") {
+			usage()
+			fatal(codeUsage, "-format eks needs -eks-service-account, given as 'namespace/name'")
 		}
+		policyOpts.EKS = render.EKS{OIDCProvider: *eksOIDCFlag, Namespace: namespace, ServiceAccount: name, Role: *eksRoleFlag}
+	} else if *eksSAFlag != "" || *eksOIDCFlag != "" || *eksRoleFlag != "" {
+		fatal(codeUsage, "-eks-service-account, -eks-oidc-provider and -eks-role only apply to -format eks")
+	}
+	if *boundarySvcFlag && *formatFlag != "boundary" {
+		fatal(codeUsage, "-boundary-services only applies to -format boundary")
+	}
+	policyOpts.ServiceWildcards = *boundarySvcFlag
 
-		sdkVersion := sdkVersion(fn)
-		if sdkVersion == "" {
-			continue // We only care about AWS SDK calls
+	if *splitFlag && ((*formatFlag != "policy" && *formatFlag != "terraform") || *providerFlag != "aws" || runMode != listMode) {
+		fatal(codeUsage, "-split-read-write only applies to -format policy or terraform for a single program, with -provider aws")
+	}
+	policyOpts.SplitReadWrite = *splitFlag
+
+	if *pathsFlag < 1 {
+		fatal(codeUsage, "-paths must be at least 1")
+	}
+
+	// Tools reading JSON output get failures and diagnostics as JSON too
+	if *formatFlag == "json" || *formatFlag == "json-full" {
+		jsonErrors = true
+		slog.SetDefault(slog.New(&diagnosticHandler{Handler: newJSONLogHandler(os.Stderr, level)}))
+	}
+
+	actionFormat := regexp.MustCompile(`^[A-Za-z0-9*?-]+\:[A-Za-z*?-]+$`)
+	// Google Cloud SDK methods and permissions have three parts, e.g.
+	// "storage.ObjectHandle.NewReader" and "storage.objects.get"
+	sdkMethodFormat := regexp.MustCompile(`^[A-Za-z0-9]+(\.[A-Za-z0-9*?]+){1,2}$`)
+	for _, query := range whyFlag {
+		isFunction := strings.Contains(query, "/") && strings.Contains(query, ".")
+		if !actionFormat.MatchString(query) && !sdkMethodFormat.MatchString(query) && !isFunction {
+			usage()
+			fatal(codeUsage, "-why value must be an IAM action in format 'service:method' (e.g. 'ssm:GetParameter' or 's3:Put*'), "+
+				"an SDK method (e.g. 'SSM.GetParameter'), a Google Cloud permission with -provider gcp (e.g. 'storage.objects.get') or a full function name (e.g. 'github.com/aws/aws-sdk-go-v2/service/ssm.Client.GetParameter')")
 		}
+	}
+
+	if *noNewAccessFlag && *checkPolicyFlag == "" && *checkRoleFlag == "" && *checkTFFlag == "" {
+		fatal(codeUsage, "-no-new-access needs -check-policy, -check-role or -check-terraform")
+	}
+	if *riskFlag && *providerFlag != "aws" {
+		fatal(codeUsage, "-risk only applies to AWS actions")
+	}
+	if *debugDetectFlag && *quietFlag {
+		fatal(codeUsage, "-debug-detect logs notes, which -q leaves out")
+	}
+
+	if runMode.mapping {
+		loadMap()
+	}
 
-		// search for a path to determine if it's only reachable
-		// through reflection
-		if !*reflectionFlag {
-			if path := graph.findPath(fn); path == nil { // only reachable through reflection
+	// Typos in -why actions are caught before the analysis, which may take
+	// minutes. Plugins may know of actions the mapping doesn't
+	if len(whyFlag) > 0 && *providerFlag == "aws" && len(plugins) == 0 {
+		for _, query := range whyFlag {
+			if !actionFormat.MatchString(query) || knownAction(query, libs) {
 				continue
 			}
+			msg := "no SDK method requires the action " + query
+			if similar := similarActions(query); len(similar) > 0 && !strings.ContainsAny(query, "*?") {
+				msg += ". Did you mean " + strings.Join(similar, " or ") + "?"
+			}
+			fatal(codeNotFound, msg+" 'iamgo actions "+mapping.Service(query)+"' lists the actions of the service")
 		}
+	}
+
+	// The -timeout flag stops everything from loading the packages to
+	// searching for call paths, except for serve, web and rpc which only limit
+	// loading the program
+	ctx := context.Background()
+	if *timeoutFlag > 0 && command != "serve" && command != "web" && command != "rpc" {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeoutFlag)
+		defer cancel()
+		context.AfterFunc(ctx, func() {
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				fatal(codeTimeout, "the analysis took longer than -timeout "+timeoutFlag.String())
+			}
+		})
+	}
+
+	// The loader only collects garbage more often while it loads, since
+	// serve, web and rpc keep running. Other commands are done after the
+	// analysis, so the rest of it gets the same
+	if *lowMemoryFlag && os.Getenv("GOGC") == "" && command != "serve" && command != "web" && command != "rpc" {
+		debug.SetGCPercent(25)
+	}
+
+	config := analyzeConfig{
+		patterns:    flag.Args(),
+		tests:       *testFlag,
+		tags:        *tagsFlag,
+		buildFlags:  buildFlag,
+		exclude:     excludeFlag,
+		lowMemory:   *lowMemoryFlag,
+		builderMode: ssaFlag,
+		driver:      *driverFlag,
+
+		provider:        *providerFlag,
+		detectors:       detectors,
+		libraries:       libs,
+		tracing:         *xrayFlag,
+		target:          *targetFlag,
+		runtimeBaseline: *baselineFlag,
+		relPaths:        *relPathsFlag,
+	}
+	if *serviceFlag != "" {
+		config.services = strings.Split(*serviceFlag, ",")
+	}
+	if *excludeSvcFlag != "" {
+		config.excludeServices = strings.Split(*excludeSvcFlag, ",")
+	}
 
-		var fnName string
-		if sdkVersion == "v1" {
-			// All SDK v1 calls has an extra 'Request' suffix
-			fnName = strings.TrimSuffix(fn.Name(), "Request")
-		} else {
-			fnName = fn.Name()
+	// What the mode does is up to its run function, see modes
+	runMode.run(ctx, &options{
+		command:  command,
+		args:     flag.Args(),
+		cfg:      cfg,
+		analyze:  config,
+		timeout:  *timeoutFlag,
+		format:   *formatFlag,
+		sdkCalls: *sdkcallsFlag,
+		counts:   *countsFlag,
+		versions: *versionsFlag,
+		risk:     *riskFlag,
+		paths:    *pathsFlag,
+		render:   policyOpts,
+
+		includeReflection:   *reflectionFlag,
+		debugDetect:         *debugDetectFlag,
+		inferResources:      *inferResFlag,
+		cloudTrailResources: *ctResourcesFlag,
+		exportGraph:         *exportGraphFlag,
+
+		why: whyFlag,
+		whyOpts: whyOptions{
+			all:        *allPathsFlag,
+			via:        *viaFlag,
+			rootBinary: *rootBinaryFlag,
+			paths:      *pathsFlag,
+		},
+
+		lockfile: *lockfileFlag,
+		output:   *outputFlag,
+		template: *templateFlag,
+		write:    *writeFlag,
+		from:     *fromFlag,
+		to:       *toFlag,
+		since:    *sinceFlag,
+		diff:     *diffFlag,
+		post:     *postFlag,
+		repo:     *repoFlag,
+		pr:       *prFlag,
+		addr:     *addrFlag,
+		webhooks: webhookFlag,
+
+		checkPolicy:    *checkPolicyFlag,
+		checkRole:      *checkRoleFlag,
+		checkTerraform: *checkTFFlag,
+		terraformRole:  *tfRoleFlag,
+		noNewAccess:    *noNewAccessFlag,
+		cloudTrail:     *cloudTrailFlag,
+		cloudTrailRole: *cloudTrailRole,
+		iamlive:        *iamliveFlag,
+		expect:         *expectFlag,
+		forbid:         *forbidFlag,
+	})
+}
+
+// analyzeWithin analyzes the program, failing if it takes longer than
+// timeout, if set
+func analyzeWithin(timeout time.Duration, config analyzeConfig) (*graph, error) {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return analyze(ctx, config)
+}
+
+// printPolicyCheck outputs the required actions a policy doesn't allow and
+// the actions it allows that aren't required. Returns false if it doesn't
+// allow all required actions
+func printPolicyCheck(existing policyFile, actions []string) bool {
+	missing, unused := policy.Check(existing, actions)
+	if len(missing) == 0 && len(unused) == 0 {
+		slog.Info(fmt.Sprintf("the policy allows all %d required actions and nothing else", len(actions)))
+		return true
+	}
+
+	printed := printSection(false, "Required but not allowed:", missing)
+	printSection(printed, "Allowed but not required:", unused)
+	return len(missing) == 0
+}
+
+// printUsageCheck outputs the required actions that weren't used at
+// runtime, which may be code that's never run or only run rarely, and the
+// used actions that aren't required, which may be calls the analysis
+// missed. The used actions may be patterns, e.g. "s3:Get*"
+func printUsageCheck(used, actions []string) {
+	var unused, undetected []string
+	for _, action := range actions {
+		if !slices.ContainsFunc(used, func(pattern string) bool { return matchAction(pattern, action) }) {
+			unused = append(unused, action)
+		}
+	}
+	for _, pattern := range used {
+		if !slices.ContainsFunc(actions, func(action string) bool { return matchAction(pattern, action) }) {
+			undetected = append(undetected, pattern)
 		}
+	}
+	if len(unused) == 0 && len(undetected) == 0 {
+		slog.Info(fmt.Sprintf("all %d required actions were used, and nothing else", len(actions)))
+		return
+	}
+
+	printed := printSection(false, "Required but never used:", unused)
+	printSection(printed, "Used but not detected:", undetected)
+}
+
+// printSection outputs a heading followed by indented lines, with a blank
+// line in between if an earlier section was printed. Nothing is printed if
+// there are no lines. Returns whether this or an earlier section was printed
+func printSection(printed bool, heading string, lines []string) bool {
+	if len(lines) == 0 {
+		return printed
+	}
+	if printed {
+		fmt.Println()
+	}
+	fmt.Println(heading)
+	for _, line := range lines {
+		fmt.Printf("    %s\n", line)
+	}
+	return true
+}
+
+// report is the JSON output of the list of actions
+type report = schema.Report
 
-		// The package name is the same as the AWS service name
-		sdkMethod := fmt.Sprintf("%s.%s", fn.Pkg.Pkg.Name(), fnName)
-		sdkMethods = append(sdkMethods, sdkMethod)
+// printActions outputs the reachable SDK calls, or the sorted, unique IAM
+// actions they require, in the format of the options. Actions suppressed by
+// the config are left out. With -counts, the number of call sites of each
+// action is too, with -sdk-versions the SDKs it's required through and with
+// -risk its risk level
+func printActions(graph *graph, o *options) {
+	fns := graph.SDKCalls(o.includeReflection)
+	var sdkMethods []string
+	for _, fn := range fns {
+		sdkMethods = append(sdkMethods, graph.detectors.MethodName(fn))
 	}
 
 	if len(sdkMethods) == 0 {
-		log.Fatalf("found no actiave use of the AWS API via AWS SDK v1 or v2")
+		if p, ok := providers[graph.provider]; ok {
+			fatal(codeNoSDKCalls, "found no active use of the "+p.name+" API via "+p.sdk)
+		}
+		fatal(codeNoSDKCalls, "found no active use of the AWS API via AWS SDK v1 or v2")
+	}
+	opts := o.render
+	opts.SDKCalls = o.sdkCalls
+	opts.Resources = resourcePatterns(o.cfg.Resources)
+	opts.Provider = graph.provider
+	if graph.provider == "azure" {
+		opts.DataActions = iamMap.IsDataAction
+	}
+	r := report{SchemaVersion: schema.Version, SDKCalls: sdkMethods}
+	if !o.sdkCalls {
+		// Several SDK methods, e.g. of v1 and v2, may require the same action
+		r.Actions = requiredActions(sdkMethods, o.cfg.Suppress)
+		r.Libraries = graph.UsedLibraries(o.includeReflection, o.cfg.Suppress, nil)
+		r.Target, r.Baseline = graph.Baseline(o.includeReflection, o.cfg.Suppress, nil)
+		r.Actions = append(r.Actions, libraryActions(r.Libraries)...)
+		r.Actions = append(r.Actions, r.Baseline...)
+		slices.Sort(r.Actions)
+		r.Actions = slices.Compact(r.Actions)
+		if len(r.Actions) == 0 {
+			// it's uncommon but there are some SDK methods/API calls that doesn't
+			// require any IAM permissions to use
+			if p, ok := providers[graph.provider]; ok {
+				fatal(codeNoActions, "found no needed "+p.name+" permissions")
+			}
+			fatal(codeNoActions, "found no needed AWS IAM permissions")
+		}
+		r.Ignored = graph.ignoredActions()
+		var escalations []escalation
+		if graph.provider == "aws" {
+			escalations = escalationRisks(r.Actions, o.cfg.Resources)
+		}
+		if !o.includeReflection {
+			r.ReflectionOnly, _ = compareActions(r.Actions, actionSet(graph, true, o.cfg.Suppress))
+		}
+		r.Tests = graph.testActions(o.includeReflection, o.cfg.Suppress)
+		if o.counts {
+			r.Counts = graph.actionCounts(fns, o.cfg.Suppress)
+		}
+		if o.versions {
+			r.SDKVersions = graph.actionVersions(fns, o.cfg.Suppress)
+		}
+		if o.risk {
+			r.Risks, r.RiskScore = actionRisks(r.Actions, len(escalations) > 0)
+		}
+		if o.format == "json" || o.format == "json-full" {
+			r.Resources = actionResources(r.Actions, o.cfg.Resources)
+			r.Controls = actionControls(r.Actions, o.cfg.Controls)
+			r.Environment = placeholders(r.Resources)
+			r.Calls = graph.sdkCallReports(fns, o.cfg.Suppress)
+		}
+		graph.logEscalationRisks(escalations)
+		if o.format == "json-full" {
+			r.Provenance = graph.provenance(r.Actions, o.paths)
+		}
+		if o.format == "json" || o.format == "json-full" {
+			r.Diagnostics = collectedDiagnostics()
+		}
+	}
+
+	if err := render.Render(os.Stdout, o.format, r, opts); err != nil {
+		fatal(codeWrite, "failed to write "+o.format, "err", err)
+	}
+	if !o.sdkCalls {
+		// The text output stays a plain list
+		if o.format == "text" {
+			logIgnored(r.Ignored)
+			logReflectionOnly(r.ReflectionOnly)
+			logTestActions(r.Tests)
+			logVersions(r.SDKVersions)
+			logRisk(r.Risks, r.RiskScore)
+			logLibraries(r.Libraries)
+			logBaseline(r.Target, r.Baseline)
+		}
+		logTracing(graph.TracingLibraries(o.includeReflection))
 	}
-	if *sdkcallsFlag {
-		for _, method := range sdkMethods {
-			fmt.Println(method)
+}
+
+// logIgnored outputs actions ignored by //iamgo:ignore comments to stderr,
+// so the output stays a plain list
+func logIgnored(actions []ignoredAction) {
+	for _, ignored := range actions {
+		args := []any{"at", fmt.Sprintf("%s:%d", ignored.Position.Filename, ignored.Position.Line)}
+		if ignored.Reason != "" {
+			args = append(args, "reason", ignored.Reason)
 		}
+		slog.Info("ignored "+ignored.Action, args...)
+	}
+}
+
+// logReflectionOnly outputs the actions only needed by calls that are only
+// reachable through reflection to stderr, so they're seen without being
+// part of the list. There may be many, e.g. every method of a client kept
+// in an interface, so only the first few are named
+func logReflectionOnly(actions []string) {
+	if len(actions) == 0 {
 		return
 	}
+	names := strings.Join(actions, ",")
+	if len(actions) > 5 {
+		names = fmt.Sprintf("%s and %d more", strings.Join(actions[:5], ","), len(actions)-5)
+	}
+	slog.Info(fmt.Sprintf("left out %d actions only reachable through reflection, include them with -reflection or see reflection_only in -format json", len(actions)), "actions", names)
+}
 
+// requiredActions returns the IAM actions that SDK methods require, leaving
+// out any that match a suppress pattern
+func requiredActions(sdkMethods []string, suppress []string) []string {
 	var iamActions []string
 	for _, sdkMethod := range sdkMethods {
 		iamAction := sdkMethodToAction(sdkMethod)
-		if iamAction != "" {
+		if iamAction != "" && !suppressed(iamAction, suppress) {
 			iamActions = append(iamActions, iamAction)
 		}
 	}
-	if len(iamActions) == 0 {
-		// it's uncommon but there are some SDK methods/API calls that doesn't
-		// require any IAM permissions to use
-		log.Fatalf("found no needed AWS IAM permissions")
+	return iamActions
+}
+
+// actionSet returns the sorted, unique IAM actions the reachable SDK calls
+// and the helper libraries the program calls require, and any baseline,
+// e.g. as recorded in a lockfile
+func actionSet(graph *graph, includeReflection bool, suppress []string) []string {
+	var sdkMethods []string
+	for _, fn := range graph.SDKCalls(includeReflection) {
+		sdkMethods = append(sdkMethods, graph.detectors.MethodName(fn))
 	}
-	for _, iamAction := range iamActions {
-		fmt.Println(iamAction)
+	actions := requiredActions(sdkMethods, suppress)
+	actions = append(actions, libraryActions(graph.UsedLibraries(includeReflection, suppress, nil))...)
+	actions = append(actions, graph.BaselineActions(includeReflection, suppress, nil)...)
+	slices.Sort(actions)
+	return slices.Compact(actions)
+}
+
+// suppressed returns whether an action matches any of the suppress patterns
+// of the config
+func suppressed(action string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if matchAction(pattern, action) {
+			return true
+		}
 	}
+	return false
 }
 
-// possibleFunctionNames takes an SDK method, e.g. "DynamoDB.BatchGetItem",
-// and returns a list of strings with the full names the different SDK
-// versions use
-func possibleFunctionNames(sdkMethod string) []string {
-	var fnNames []string
-	service := strings.ToLower(strings.Split(sdkMethod, ".")[0])
-	method := strings.Split(sdkMethod, ".")[1]
+// stringsFlag is a flag that can be given multiple times
+type stringsFlag []string
 
-	v1 := fmt.Sprintf("(*github.com/aws/aws-sdk-go/service/%s.%s).%sRequest", // v1 has "Request" suffix
-		service,                          // service
-		strings.Split(sdkMethod, ".")[0], // Correctly capitalized service name
-		method,                           // method
-	)
-	v2 := fmt.Sprintf("(*github.com/aws/aws-sdk-go-v2/service/%s.Client).%s",
-		service, // service
-		method,  // method
-	)
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
 
-	return append(fnNames, v1, v2)
+func (f *stringsFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
 }
 
-// sdkVersion determines if a function is a call to AWS SDK v1 or v2.
-// Returns an empty string if it's not a call to any of them
-func sdkVersion(fn *ssa.Function) string {
-	if isAWSSDKv2Call(fn) {
-		return "v2"
+// printWhy outputs call paths to SDK calls that require each of the
+// queried IAM actions, or to the queried SDK methods or functions. An
+// action may be a pattern, e.g. "s3:Put*", in which case the paths for
+// every required action that matches are shown. Returns the code of the
+// failure if any of the queries failed, or an empty code
+func printWhy(graph *graph, queries []string, opts whyOptions, includeReflection bool, format string) errorCode {
+	if opts.via != "" && len(graph.findFuncs(opts.via)) == 0 {
+		slog.Error("didn't find any reachable function named "+opts.via, "code", codeNotFound)
+		return codeNotFound
 	}
-	if isAWSSDKv1Call(fn) {
-		return "v1"
+
+	var actions []string
+	var failed errorCode
+	for _, query := range queries {
+		matches, err := graph.expandWhyQuery(query, includeReflection)
+		if err != nil {
+			slog.Error(err.Error(), "code", codeNotFound)
+			failed = codeNotFound
+		}
+		actions = append(actions, matches...)
 	}
 
-	return ""
-}
+	printed := false
+	results := []whyResult{}
+	for _, action := range actions {
+		paths, err := graph.whyPaths(action, opts)
+		if err != nil {
+			slog.Error(err.Error(), "code", codeNotFound)
+			failed = codeNotFound
+			continue
+		}
 
-// isAWSSDKv2Call checks whether a function is an AWS API call via
-// AWS SDK v2, based on the name of the package, file and function
-func isAWSSDKv2Call(fn *ssa.Function) bool {
-	filename := fn.Prog.Fset.Position(fn.Pos()).Filename
-	pkgpath := fn.Pkg.Pkg.Path()
+		if format == "json" {
+			results = append(results, graph.whyResult(action, paths))
+			continue
+		}
+		if printed {
+			fmt.Println()
+		}
+		printed = true
+		if len(actions) > 1 {
+			fmt.Printf("%s:\n", action)
+		}
+		for i, path := range paths {
+			if i > 0 {
+				fmt.Println()
+			}
+			// Tell which binary the path is in when there's more than one
+			if len(graph.Mains) > 1 {
+				fmt.Printf("From binary %s:\n", binaryOf(path))
+			}
+			graph.printPath(path)
+		}
+	}
 
-	// The SDK method name is in the filename too
-	isRelevantFile := strings.HasSuffix(filename, "/api_op_"+fn.Name()+".go")
+	if format == "json" {
+		if err := printJSON(results); err != nil {
+			slog.Error("failed to write JSON", "code", codeWrite, "err", err)
+			return codeWrite
+		}
+	}
+	return failed
+}
 
-	return strings.HasPrefix(pkgpath, "github.com/aws/aws-sdk-go-v2/service/") &&
-		isRelevantFile
+// printJSON outputs a value as indented JSON
+func printJSON(v any) error {
+	return render.JSON(os.Stdout, v)
 }
 
-func isAWSSDKv1Call(fn *ssa.Function) bool {
-	filename := fn.Prog.Fset.Position(fn.Pos()).Filename
-	pkgpath := fn.Pkg.Pkg.Path()
+// printBinary outputs the SDK calls, or the IAM actions they require, found
+// in a compiled binary
+func printBinary(filename string, sdkCalls bool) {
+	sdkMethods, err := binarySDKMethods(filename)
+	if err != nil {
+		fatal(codeRead, "failed to read binary", "file", filename, "err", err)
+	}
+	if len(sdkMethods) == 0 {
+		fatal(codeNoSDKCalls, "found no AWS SDK v1 or v2 calls in "+filename)
+	}
+	slog.Warn("the result is based on the functions compiled into the binary, not on what's reachable, so it may include unused permissions")
 
-	// SDK v1 has no "-vX"
-	isRelevantPackage := strings.HasPrefix(pkgpath, "github.com/aws/aws-sdk-go/service/")
+	if sdkCalls {
+		for _, sdkMethod := range sdkMethods {
+			fmt.Println(sdkMethod)
+		}
+		return
+	}
 
-	// All SDK v1 API calls happen in api.go
-	isRelevantFile := strings.HasSuffix(filename, "/api.go")
+	loadMap()
+	var iamActions []string
+	for _, sdkMethod := range sdkMethods {
+		iamAction := sdkMethodToAction(sdkMethod)
+		if iamAction != "" && !slices.Contains(iamActions, iamAction) {
+			iamActions = append(iamActions, iamAction)
+		}
+	}
+	for _, iamAction := range iamActions {
+		fmt.Println(iamAction)
+	}
+}
+
+// clientLines returns what -per-client shows for the SDK methods a client
+// calls: the actions they require, or with -sdk-calls the methods, sorted
+// and each once. Several methods may require the same action, e.g. an
+// operation and the transfer manager that calls it
+func clientLines(methods []string, sdkCalls bool, suppress []string) []string {
+	var lines []string
+	for _, sdkMethod := range methods {
+		if sdkCalls {
+			lines = append(lines, sdkMethod)
+		} else if iamAction := sdkMethodToAction(sdkMethod); iamAction != "" && !suppressed(iamAction, suppress) {
+			lines = append(lines, iamAction)
+		}
+	}
+	slices.Sort(lines)
+	return slices.Compact(lines)
+}
 
-	// SDK v1 methods that calls the API are suffixed with "Request"
-	// This may have false positives if other functions have "Request" in the name
-	// but it seems they either start with 'new' or 'Set' in that case. I'm sure
-	// there is a better way to do this but this was quick and seems to work. v1
-	// is being deprecated soon too.
-	isRelevantFunctionName := strings.HasSuffix(fn.Name(), "Request") &&
-		!strings.HasSuffix(fn.Name(), "new") &&
-		!strings.HasSuffix(fn.Name(), "Set")
+// printPerClient outputs the SDK calls, or the IAM actions they require,
+// grouped by the client they are made through. Suppressed actions are left
+// out
+func printPerClient(graph *graph, fns []*ssa.Function, sdkCalls bool, suppress []string) {
+	clientCalls := graph.clientCalls(fns)
+	if len(clientCalls) == 0 {
+		fatal(codeNoSDKCalls, "found no active use of the AWS API via AWS SDK v1 or v2")
+	}
 
-	return isRelevantPackage && isRelevantFile && isRelevantFunctionName
+	clients := make([]client, 0, len(clientCalls))
+	for c := range clientCalls {
+		clients = append(clients, c)
+	}
+	slices.SortFunc(clients, func(a, b client) int {
+		return strings.Compare(a.String(), b.String())
+	})
+
+	printed := false
+	for _, c := range clients {
+		methods := make([]string, 0, len(clientCalls[c]))
+		for _, fn := range clientCalls[c] {
+			methods = append(methods, graph.detectors.MethodName(fn))
+		}
+		lines := clientLines(methods, sdkCalls, suppress)
+		if len(lines) == 0 {
+			continue // e.g. clients only used for calls that need no permissions
+		}
+
+		if printed {
+			fmt.Println()
+		}
+		printed = true
+		fmt.Println(c)
+		for _, line := range lines {
+			fmt.Printf("    %s\n", line)
+		}
+	}
 }
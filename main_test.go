@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/esprimo/iamgo/schema"
+)
+
+// TestMain runs iamgo itself instead of the tests when the test binary is
+// started by runIamgo, since main exits
+func TestMain(m *testing.M) {
+	if os.Getenv("IAMGO_TEST_MAIN") != "" {
+		os.Args = append([]string{"iamgo"}, os.Args[1:]...)
+		main()
+		os.Exit(exitOK)
+	}
+	os.Exit(m.Run())
+}
+
+// runIamgo runs iamgo with args and returns its stdout, stderr and exit
+// code
+func runIamgo(t *testing.T, args ...string) (string, string, int) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), "IAMGO_TEST_MAIN=1")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		t.Fatalf("failed to run iamgo: %v", err)
+	}
+	return stdout.String(), stderr.String(), cmd.ProcessState.ExitCode()
+}
+
+func TestSDKCalls(t *testing.T) {
+	if testing.Short() {
+		t.Skip("analyzes a program")
+	}
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"ignore comment", []string{"-sdk-calls", "./testdata/app"}, "iam.ListRoles\n"},
+		{"service", []string{"-sdk-calls", "-service", "iam", "./testdata/app"}, "iam.ListRoles\n"},
+		{"per-binary", []string{"-sdk-calls", "-per-binary", "./testdata/app"}, "app (github.com/esprimo/iamgo/testdata/app):\n    iam.ListRoles\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout, stderr, code := runIamgo(t, tt.args...)
+			if code != exitOK {
+				t.Fatalf("iamgo %s exited with %d: %s", strings.Join(tt.args, " "), code, stderr)
+			}
+			if stdout != tt.want {
+				t.Errorf("iamgo %s printed %q, want %q", strings.Join(tt.args, " "), stdout, tt.want)
+			}
+		})
+	}
+}
+
+func TestJSONFull(t *testing.T) {
+	if testing.Short() {
+		t.Skip("analyzes a program")
+	}
+	// The Lambda baseline adds actions that no call path leads to
+	stdout, stderr, code := runIamgo(t, "-format", "json-full", "-target", "lambda", "./testdata/app")
+	if code != exitOK {
+		t.Fatalf("iamgo exited with %d: %s", code, stderr)
+	}
+	var r schema.Report
+	if err := json.Unmarshal([]byte(stdout), &r); err != nil {
+		t.Fatalf("invalid output: %v\n%s", err, stdout)
+	}
+	if len(r.Diagnostics) > 0 {
+		t.Errorf("diagnostics %+v, want none", r.Diagnostics)
+	}
+	if len(r.Provenance) != len(r.Actions) {
+		t.Fatalf("provenance of %d actions, want one for each of %q", len(r.Provenance), r.Actions)
+	}
+	for _, p := range r.Provenance {
+		if !strings.HasPrefix(p.Action, "logs:") {
+			continue
+		}
+		if len(p.Methods) > 0 {
+			t.Errorf("%s of the baseline has methods %+v, want none", p.Action, p.Methods)
+		}
+	}
+
+	p := r.Provenance[0]
+	if p.Action != "iam:ListRoles" || len(p.Methods) != 1 || p.Methods[0].Method != "iam.ListRoles" || len(p.Methods[0].Paths) != 1 {
+		t.Fatalf("provenance %+v, want iam:ListRoles required by iam.ListRoles through one path", p)
+	}
+	steps := p.Methods[0].Paths[0].Steps
+	site := steps[len(steps)-1].CallSite
+	if want := filepath.Join("testdata", "app", "main.go"); !strings.HasSuffix(site.Filename, want) || site.Line != 13 {
+		t.Errorf("iam.ListRoles is called at %s:%d, want %s:13", site.Filename, site.Line, want)
+	}
+}
+
+func TestCounts(t *testing.T) {
+	if testing.Short() {
+		t.Skip("analyzes a program")
+	}
+	// Only the SDK calls STS, to get credentials
+	stdout, stderr, code := runIamgo(t, "-counts", "./testdata/sdkinternal")
+	if code != exitOK {
+		t.Fatalf("iamgo exited with %d: %s", code, stderr)
+	}
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	if !slices.Contains(lines, "iam:ListRoles 1") {
+		t.Errorf("iamgo -counts printed %q, want iam:ListRoles with 1 call site", lines)
+	}
+	if !slices.Contains(lines, "sts:AssumeRole") {
+		t.Errorf("iamgo -counts printed %q, want sts:AssumeRole without a count", lines)
+	}
+}
+
+func TestUsageErrors(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-format", "json-full", "-paths", "0", "./testdata/app"}, "-paths must be at least 1"},
+		{[]string{"lock", "-why", "iam:ListRoles", "./testdata/app"}, "-why can't be combined with the lock command"},
+		{[]string{"-dead", "-per-binary", "./testdata/app"}, "-per-binary can't be combined with -dead"},
+		{[]string{"check", "-sdk-calls", "./testdata/app"}, "-sdk-calls doesn't apply to the check command"},
+		{[]string{"-counts", "-why", "iam:ListRoles", "./testdata/app"}, "-counts, -sdk-versions and -risk only apply to the list of actions of a single module"},
+		{[]string{"-provider", "gcp", "-per-client", "./testdata/app"}, "-provider gcp can't be combined with -per-client"},
+		{[]string{"-why", "iam:ListRoles", "-format", "policy", "./testdata/app"}, "-format policy is not supported here"},
+	}
+	for _, tt := range tests {
+		args := strings.Join(tt.args, " ")
+		t.Run(args, func(t *testing.T) {
+			_, stderr, code := runIamgo(t, tt.args...)
+			if code != exitError || !strings.Contains(stderr, tt.want) {
+				t.Errorf("iamgo %s exited with %d: %s, want a usage error: %s", args, code, stderr, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientLines(t *testing.T) {
+	loadMap()
+	// The same call in several places, and calls requiring the same action
+	methods := []string{"s3.PutObject", "s3.GetObject", "s3.PutObject", "manager.Uploader.Upload"}
+	tests := []struct {
+		sdkCalls bool
+		want     []string
+	}{
+		{false, []string{"s3:GetObject", "s3:PutObject"}},
+		{true, []string{"manager.Uploader.Upload", "s3.GetObject", "s3.PutObject"}},
+	}
+	for _, tt := range tests {
+		if got := clientLines(methods, tt.sdkCalls, nil); !slices.Equal(got, tt.want) {
+			t.Errorf("clientLines(%q, %v) = %q, want %q", methods, tt.sdkCalls, got, tt.want)
+		}
+	}
+}
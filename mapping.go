@@ -1,56 +1,209 @@
 package main
 
 import (
-	_ "embed"
-	"encoding/json"
-	"log"
+	"log/slog"
+	"slices"
 	"strings"
+	"sync"
+
+	"golang.org/x/tools/go/ssa"
+
+	"github.com/esprimo/iamgo/internal/libraries"
+	"github.com/esprimo/iamgo/internal/mapping"
+	"github.com/esprimo/iamgo/internal/plugin"
+	"github.com/esprimo/iamgo/internal/sdk"
 )
 
-//go:embed map.json
-var bIAMMap []byte
+// iamMap is the API method -> IAM permission mapping, see loadMap
+var iamMap *mapping.Map
+
+// cloudProvider is a cloud provider other than AWS, see -provider
+type cloudProvider struct {
+	// Names of the provider and its SDK, for messages
+	name, sdk string
+	load      func() (*mapping.Map, error)
+	detector  func(known func(method string) bool) sdk.Detector
+}
+
+var providers = map[string]cloudProvider{
+	"gcp":   {"Google Cloud", "cloud.google.com/go", mapping.LoadGCP, sdk.GCP},
+	"azure": {"Azure", "the Azure SDK for Go", mapping.LoadAzure, sdk.Azure},
+}
 
 func loadMap() {
-	// Load API method -> IAM permission mapping
-	err := json.Unmarshal(bIAMMap, &iamMap)
+	if iamMap != nil {
+		return // already loaded, e.g. by loadProvider
+	}
+	m, err := mapping.Load()
+	if err != nil {
+		fatal(codeLoad, "failed to load the action mapping", "err", err)
+	}
+	iamMap = m
+}
+
+// loadProvider loads the mapping to permissions of a provider other than
+// AWS, for -provider, and returns the detector of its SDK. The mapping is
+// needed to recognize the calls, so it's loaded right away
+func loadProvider(name string) sdk.Detectors {
+	p := providers[name]
+	m, err := p.load()
 	if err != nil {
-		log.Fatal(err)
+		fatal(codeLoad, "failed to load the permission mapping", "err", err)
 	}
+	iamMap = m
+	return sdk.Detectors{p.detector(m.Has)}
+}
+
+// plugins are the plugins given by -plugin, see startPlugins
+var plugins []*plugin.Plugin
+
+// startPlugins starts the plugins given by -plugin and the configuration,
+// and returns their detectors. They're tried before the built-in ones, so a
+// plugin may also recognize functions in the SDK itself
+func startPlugins(commands []string) sdk.Detectors {
+	var detectors sdk.Detectors
+	for _, command := range commands {
+		p, err := plugin.Start(command)
+		if err != nil {
+			fatal(codeExternal, "failed to start plugin", "plugin", command, "err", err)
+		}
+		slog.Debug("started plugin", "plugin", p.Name(), "command", command)
+		plugins = append(plugins, p)
+		detectors = append(detectors, pluginDetector{p})
+	}
+	return detectors
+}
+
+// pluginErr is the first failure of a plugin since the last call to
+// takePluginError. Plugins are asked from where errors can't be returned,
+// e.g. by sdk.Detector, so failures are kept for analyze and the commands
+// to report instead of exiting, which would stop serve over one bad answer
+var (
+	pluginErrMu sync.Mutex
+	pluginErr   error
+)
+
+// pluginFailed keeps the failure of a plugin for takePluginError, unless an
+// earlier one is kept
+func pluginFailed(p *plugin.Plugin, err error, args ...any) {
+	pluginErrMu.Lock()
+	defer pluginErrMu.Unlock()
+	if pluginErr == nil {
+		pluginErr = &failure{codeExternal, "plugin failed", append([]any{"plugin", p.Name(), "err", err}, args...)}
+	}
+}
+
+// takePluginError returns the first failure of a plugin since the last
+// call, as a failure, and forgets it. Returns nil if none failed
+func takePluginError() error {
+	pluginErrMu.Lock()
+	defer pluginErrMu.Unlock()
+	err := pluginErr
+	pluginErr = nil
+	return err
+}
+
+// stopPlugins closes the plugins, which are expected to exit then
+func stopPlugins() {
+	for _, p := range plugins {
+		if err := p.Close(); err != nil {
+			slog.Warn("plugin exited with an error", "plugin", p.Name(), "err", err)
+		}
+	}
+	plugins = nil
+}
+
+// pluginDetector is a plugin as an sdk.Detector. A function the plugin
+// fails on isn't an SDK call, and the failure is kept for analyze to fail
+// with, see pluginFailed
+type pluginDetector struct {
+	p *plugin.Plugin
+}
+
+func (d pluginDetector) Name() string {
+	return d.p.Name()
+}
+
+func (d pluginDetector) Method(fn *ssa.Function) string {
+	method, err := d.p.Detect(fn)
+	if err != nil {
+		pluginFailed(d.p, err, "func", fn.String())
+	}
+	return method
 }
 
 // sdkMethodToAction looks up the IAM action for a given AWS SDK call or returns
-// an empty string if there is no match (not all calls require permissions)
+// an empty string if there is no match (not all calls require permissions).
+// Plugins are asked first. A plugin failure is kept for the caller to
+// report, see takePluginError, and the built-in mapping is used instead
 func sdkMethodToAction(apiMethod string) string {
-	for iamMethodName, iamMethods := range iamMap.SDKMethodIAMMappings {
-		if strings.EqualFold(iamMethodName, apiMethod) {
-			for _, priv := range iamMethods {
-				return priv.Action
-			}
+	for _, p := range plugins {
+		action, ok, err := p.Action(apiMethod)
+		if err != nil {
+			pluginFailed(p, err, "sdk_method", apiMethod)
+			continue
+		}
+		if ok {
+			return action
 		}
 	}
-	return ""
+	return iamMap.Action(apiMethod)
+}
+
+// canonicalSDKMethod returns an SDK method, e.g. "dynamodb.batchgetitem",
+// the way it's capitalized in the mapping, e.g. "DynamoDB.BatchGetItem".
+// Returns the method as-is if it's not in the mapping
+func canonicalSDKMethod(apiMethod string) string {
+	return iamMap.Method(apiMethod)
 }
 
 // actionToSDKMethods finds looks up all SDK calls that requires a specific
 // IAM action to make. Returns and empty list if no matches are found
 func actionToSDKMethods(action string) []string {
-	var sdkCalls []string
-	for iamMethodName, iamMethods := range iamMap.SDKMethodIAMMappings {
-		for _, priv := range iamMethods {
-			if strings.EqualFold(priv.Action, action) {
-				sdkCalls = append(sdkCalls, iamMethodName)
-			}
+	return iamMap.Methods(action)
+}
+
+// knownAction reports whether an action, or a pattern such as "s3:Put*",
+// matches any action the mapping or one of the helper libraries knows of
+func knownAction(pattern string, libs []libraries.Library) bool {
+	service := mapping.Service(pattern)
+	if strings.ContainsAny(service, "*?") {
+		return true // too broad to be a typo
+	}
+	for _, action := range knownActions(service, libs) {
+		if matchAction(pattern, action) {
+			return true
 		}
 	}
-	return sdkCalls
+	return false
 }
 
-type iamMapMethod struct {
-	Action string `json:"action"`
+// knownActions returns the actions of a service the mapping or one of the
+// helper libraries knows of, e.g. for "iamgo actions s3", or all of them if
+// the service is empty
+func knownActions(service string, libs []libraries.Library) []string {
+	var actions []string
+	all := iamMap.Actions()
+	for _, lib := range libs {
+		all = append(all, lib.Actions...)
+	}
+	for _, action := range all {
+		if service == "" || strings.EqualFold(mapping.Service(action), service) {
+			actions = append(actions, action)
+		}
+	}
+	slices.Sort(actions)
+	return slices.Compact(actions)
 }
 
-type iamMapBase struct {
-	SDKMethodIAMMappings map[string][]iamMapMethod `json:"sdk_method_iam_mappings"`
+// similarActions returns the known actions closest to one that isn't
+// known, see mapping.Map.Similar
+func similarActions(action string) []string {
+	return iamMap.Similar(action)
 }
 
-var iamMap iamMapBase
+// matchAction reports whether an IAM action matches a pattern, see
+// mapping.MatchAction
+func matchAction(pattern, action string) bool {
+	return mapping.MatchAction(pattern, action)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"slices"
+
+	"github.com/esprimo/iamgo/schema"
+)
+
+// sourceReport is a report and where it comes from, e.g. its file name
+type sourceReport struct {
+	source string
+	report report
+}
+
+// readReports reads reports saved with -format json
+func readReports(filenames []string) ([]sourceReport, error) {
+	var reports []sourceReport
+	for _, filename := range filenames {
+		r, err := readReport(filename)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, sourceReport{filename, r})
+	}
+	return reports, nil
+}
+
+// mergeReports combines reports into one that has every action, SDK call
+// and ignored action in any of them. The sources of each action are kept:
+// the sources of the reports that need it, or the sources they had if they
+// were merged themselves
+func mergeReports(reports []sourceReport) report {
+	merged := report{SchemaVersion: schema.Version, Sources: make(map[string][]string)}
+	// What the production code and the tests of the reports need, where
+	// reports without tests only have production code
+	var production, tests []string
+	withTests := false
+	for _, sr := range reports {
+		r := sr.report
+		if r.Tests != nil {
+			withTests = true
+			production = append(append(production, r.Tests.ProductionOnly...), r.Tests.Both...)
+			tests = append(append(tests, r.Tests.TestsOnly...), r.Tests.Both...)
+		} else {
+			production = append(production, r.Actions...)
+		}
+		merged.Actions = append(merged.Actions, r.Actions...)
+		merged.SDKCalls = append(merged.SDKCalls, r.SDKCalls...)
+		merged.ReflectionOnly = append(merged.ReflectionOnly, r.ReflectionOnly...)
+		for _, ignored := range r.Ignored {
+			if !slices.Contains(merged.Ignored, ignored) {
+				merged.Ignored = append(merged.Ignored, ignored)
+			}
+		}
+		for action, ids := range r.Controls {
+			if merged.Controls == nil {
+				merged.Controls = make(map[string][]string)
+			}
+			merged.Controls[action] = append(merged.Controls[action], ids...)
+		}
+		for _, action := range r.Actions {
+			sources := r.Sources[action]
+			if len(sources) == 0 {
+				sources = []string{sr.source}
+			}
+			merged.Sources[action] = append(merged.Sources[action], sources...)
+		}
+	}
+
+	slices.Sort(merged.Actions)
+	merged.Actions = slices.Compact(merged.Actions)
+	slices.Sort(merged.SDKCalls)
+	merged.SDKCalls = slices.Compact(merged.SDKCalls)
+	// Needed by another report without reflection
+	slices.Sort(merged.ReflectionOnly)
+	merged.ReflectionOnly, _ = compareActions(merged.Actions, slices.Compact(merged.ReflectionOnly))
+	if withTests {
+		slices.Sort(production)
+		slices.Sort(tests)
+		merged.Tests = splitTestActions(slices.Compact(production), slices.Compact(tests))
+	}
+	for action, ids := range merged.Controls {
+		slices.Sort(ids)
+		merged.Controls[action] = slices.Compact(ids)
+	}
+	for action, sources := range merged.Sources {
+		slices.Sort(sources)
+		merged.Sources[action] = slices.Compact(sources)
+	}
+	return merged
+}
+
+// writeReport writes a report as indented JSON to a file, or to stdout if
+// the file name is empty
+func writeReport(filename string, r report) error {
+	if filename == "" {
+		return printJSON(r)
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, append(data, '\n'), 0o644)
+}
+
+// interspersedArgs returns the arguments, also parsing the flags that come
+// after them, e.g. "iamgo merge a.json b.json -o all.json"
+func interspersedArgs() []string {
+	var args []string
+	rest := flag.Args()
+	for len(rest) > 0 {
+		args = append(args, rest[0])
+		parseFlags(rest[1:])
+		rest = flag.Args()
+	}
+	return args
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/esprimo/iamgo/internal/mapping"
+)
+
+// analysisStats is how long the analyses of a server took, and how many
+// reloads kept the last one since nothing changed, for /metrics
+type analysisStats struct {
+	count int
+	total time.Duration
+	last  time.Duration
+	hits  int
+}
+
+// metrics answers /metrics with the footprint of the program in the
+// Prometheus text format, to monitor it across services:
+//
+//	iamgo_actions{service}                       the required actions by service
+//	iamgo_analysis_duration_seconds              how long the last analysis took
+//	iamgo_analyses_duration_seconds              how long all analyses took, as a summary
+//	iamgo_analysis_cache_requests_total{result}  the analyses kept because nothing changed (hit) and made (miss)
+//	iamgo_lockfile_drift_actions{change}         the actions added and removed since the lockfile, if there is one
+func (s *server) metrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed, use GET", http.StatusMethodNotAllowed)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	s.writeMetrics(w)
+}
+
+// writeMetrics writes the metrics of /metrics
+func (s *server) writeMetrics(w io.Writer) {
+	actions := actionSet(s.graph, s.includeReflection, s.cfg.Suppress)
+	services := make(map[string]int)
+	for _, action := range actions {
+		services[mapping.Service(action)]++
+	}
+	names := make([]string, 0, len(services))
+	for service := range services {
+		names = append(names, service)
+	}
+	slices.Sort(names)
+
+	fmt.Fprintln(w, "# HELP iamgo_actions IAM actions the program requires, by service.")
+	fmt.Fprintln(w, "# TYPE iamgo_actions gauge")
+	for _, service := range names {
+		fmt.Fprintf(w, "iamgo_actions{service=%q} %d\n", service, services[service])
+	}
+
+	fmt.Fprintln(w, "# HELP iamgo_analysis_duration_seconds How long the last analysis of the program took.")
+	fmt.Fprintln(w, "# TYPE iamgo_analysis_duration_seconds gauge")
+	fmt.Fprintf(w, "iamgo_analysis_duration_seconds %g\n", s.stats.last.Seconds())
+	fmt.Fprintln(w, "# HELP iamgo_analyses_duration_seconds How long the analyses of the program took, at start and on /reload.")
+	fmt.Fprintln(w, "# TYPE iamgo_analyses_duration_seconds summary")
+	fmt.Fprintf(w, "iamgo_analyses_duration_seconds_sum %g\n", s.stats.total.Seconds())
+	fmt.Fprintf(w, "iamgo_analyses_duration_seconds_count %d\n", s.stats.count)
+	fmt.Fprintln(w, "# HELP iamgo_analysis_cache_requests_total Analyses asked for, at start and on /reload, that kept the last one since none of the program's files changed (hit), or analyzed the program (miss).")
+	fmt.Fprintln(w, "# TYPE iamgo_analysis_cache_requests_total counter")
+	fmt.Fprintf(w, "iamgo_analysis_cache_requests_total{result=\"hit\"} %d\n", s.stats.hits)
+	fmt.Fprintf(w, "iamgo_analysis_cache_requests_total{result=\"miss\"} %d\n", s.stats.count)
+
+	if s.lockfile == "" {
+		return
+	}
+	locked, err := readActions(s.lockfile)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		slog.Warn("failed to read lockfile", "code", codeRead, "file", s.lockfile, "err", err)
+		return
+	}
+	added, removed := compareActions(locked, actions)
+	fmt.Fprintln(w, "# HELP iamgo_lockfile_drift_actions IAM actions the program requires that aren't in the lockfile (added), and the other way around (removed).")
+	fmt.Fprintln(w, "# TYPE iamgo_lockfile_drift_actions gauge")
+	fmt.Fprintf(w, "iamgo_lockfile_drift_actions{change=\"added\"} %d\n", len(added))
+	fmt.Fprintf(w, "iamgo_lockfile_drift_actions{change=\"removed\"} %d\n", len(removed))
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"slices"
+	"strings"
+)
+
+// mode is what a run of iamgo does: a command, a flag that shows something
+// else than the list of actions, e.g. -why, or the list of actions itself.
+// Only one can be selected at a time
+type mode struct {
+	// The command that selects it, e.g. "lock"
+	command string
+	// The flags that select it when given, e.g. "why"
+	flags []string
+	// Whether it's selected by several module directories as arguments,
+	// e.g. "iamgo ./svc-a ./svc-b"
+	modules bool
+
+	// Output formats, the first is the default
+	formats []string
+	// Output formats with -provider gcp or azure, none if it's only
+	// about AWS
+	providerFormats []string
+	// Whether it needs the mapping of SDK methods to actions, loaded
+	// before anything else is done. The analysis needs it to resolve
+	// actions, e.g. for -service and //iamgo:ignore comments, even when
+	// only the SDK calls are printed
+	mapping bool
+	// Whether -sdk-calls applies, printing the SDK calls instead of the
+	// actions
+	sdkCalls bool
+
+	// Does what the mode does, see options
+	run func(ctx context.Context, o *options)
+}
+
+var (
+	allFormats      = []string{"text", "json", "json-full", "policy", "terraform", "terraform-role", "cloudformation", "eks", "boundary", "scp"}
+	textFormats     = []string{"text"}
+	textJSONFormats = []string{"text", "json"}
+	// A service account runs one program in EKS, and a boundary is set on
+	// one role
+	programsFormats = []string{"text", "json", "policy", "terraform"}
+)
+
+// modes are the modes other than the list of actions
+var modes = []mode{
+	{command: "actions", formats: textJSONFormats, providerFormats: textJSONFormats, mapping: true, run: runActions},
+	{command: "merge", formats: []string{"json"}, providerFormats: []string{"json"}, run: runMerge},
+	{command: "history", formats: textJSONFormats, providerFormats: textJSONFormats, mapping: true, run: runHistory},
+	{command: "diff", formats: textFormats, providerFormats: textFormats, mapping: true, sdkCalls: true, run: runDiff},
+	{command: "comment", formats: textFormats, providerFormats: textFormats, mapping: true, sdkCalls: true, run: runComment},
+	{command: "serve", formats: textFormats, providerFormats: textFormats, mapping: true, run: runServe},
+	{command: "web", formats: textFormats, providerFormats: textFormats, mapping: true, run: runServe},
+	{command: "rpc", formats: textFormats, providerFormats: textFormats, mapping: true, run: runRPC},
+	{command: "tui", formats: textFormats, providerFormats: textFormats, mapping: true, run: runTUI},
+	{command: "lock", formats: textFormats, providerFormats: textFormats, mapping: true, run: runLock},
+	{command: "check", formats: textFormats, providerFormats: textFormats, mapping: true, run: runCheck},
+	{command: "changelog", formats: textFormats, providerFormats: textFormats, mapping: true, run: runChangelog},
+	{command: "inject", formats: textFormats, mapping: true, run: runInject},
+	// Loads the mapping itself, unless only the SDK calls are printed
+	{flags: []string{"binary"}, formats: textFormats, sdkCalls: true, run: runBinary},
+	{modules: true, formats: programsFormats, providerFormats: textJSONFormats, mapping: true, sdkCalls: true, run: runModules},
+	{flags: []string{"annotate"}, formats: textFormats, providerFormats: textFormats, mapping: true, run: runAnnotate},
+	{flags: []string{"why"}, formats: textJSONFormats, providerFormats: textJSONFormats, mapping: true, run: runWhy},
+	{flags: []string{"dead"}, formats: textJSONFormats, providerFormats: textJSONFormats, mapping: true, run: runDead},
+	{flags: []string{"per-client"}, formats: textFormats, mapping: true, sdkCalls: true, run: runPerClient},
+	{flags: []string{"per-binary"}, formats: programsFormats, providerFormats: textJSONFormats, mapping: true, sdkCalls: true, run: runPerBinary},
+	{flags: []string{"check-policy"}, formats: textFormats, mapping: true, run: runCheckPolicy},
+	{flags: []string{"check-role"}, formats: textFormats, mapping: true, run: runCheckRole},
+	{flags: []string{"check-terraform"}, formats: textFormats, mapping: true, run: runCheckTerraform},
+	{flags: []string{"cloudtrail"}, formats: textFormats, mapping: true, run: runCloudTrail},
+	{flags: []string{"iamlive"}, formats: textFormats, mapping: true, run: runIamlive},
+	{flags: []string{"expect", "forbid"}, formats: textFormats, providerFormats: textFormats, mapping: true, run: runExpect},
+}
+
+// listMode is the mode when nothing else is selected. Custom roles of
+// other providers are generated for it too
+var listMode = &mode{formats: allFormats, providerFormats: programsFormats, mapping: true, sdkCalls: true, run: runList}
+
+// isCommand tells whether an argument is the name of a command
+func isCommand(arg string) bool {
+	return slices.ContainsFunc(modes, func(m mode) bool { return m.command != "" && m.command == arg })
+}
+
+// selectMode returns the mode the command, flags and arguments select,
+// exiting if they select more than one
+func selectMode(command string, args []string) *mode {
+	var selected []*mode
+	for i := range modes {
+		if modes[i].selected(command, args) {
+			selected = append(selected, &modes[i])
+		}
+	}
+	switch len(selected) {
+	case 0:
+		return listMode
+	case 1:
+		return selected[0]
+	}
+	fatal(codeUsage, selected[1].String()+" can't be combined with "+selected[0].String())
+	return nil
+}
+
+func (m *mode) selected(command string, args []string) bool {
+	switch {
+	case m.command != "":
+		return m.command == command
+	case m.modules:
+		return command == "" && moduleDirs(args) != nil
+	}
+	return slices.ContainsFunc(m.flags, flagGiven)
+}
+
+// String returns how errors name the mode, e.g. "the lock command" or
+// "-expect or -forbid"
+func (m *mode) String() string {
+	switch {
+	case m.command != "":
+		return "the " + m.command + " command"
+	case m.modules:
+		return "several modules"
+	case len(m.flags) > 0:
+		return "-" + strings.Join(m.flags, " or -")
+	}
+	return "the list of actions"
+}
+
+// formatsFor returns the output formats of the mode with a provider, none
+// if it only applies to AWS
+func (m *mode) formatsFor(provider string) []string {
+	if provider != "aws" {
+		return m.providerFormats
+	}
+	return m.formats
+}
+
+// flagGiven tells whether a flag has a value other than its zero value,
+// e.g. -dead but not -dead=false
+func flagGiven(name string) bool {
+	v := flag.Lookup(name).Value.String()
+	return v != "" && v != "false"
+}
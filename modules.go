@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/esprimo/iamgo/internal/render"
+)
+
+// readTargets reads package patterns or module directories, one per line,
+// for "iamgo -" and -targets-file. Blank lines and lines starting with #
+// are skipped
+func readTargets(r io.Reader) ([]string, error) {
+	var targets []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			targets = append(targets, line)
+		}
+	}
+	return targets, scanner.Err()
+}
+
+// moduleDirs returns the arguments if they're all directories of Go
+// modules other than the one in the working directory, e.g.
+// "iamgo ./svc-a ./svc-b", or nil otherwise
+func moduleDirs(args []string) []string {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil
+	}
+	for _, arg := range args {
+		abs, err := filepath.Abs(arg)
+		if err != nil || abs == cwd {
+			return nil
+		}
+		if info, err := os.Stat(filepath.Join(arg, "go.mod")); err != nil || info.IsDir() {
+			return nil
+		}
+	}
+	return args
+}
+
+// modulesReport is the JSON output of several modules
+type modulesReport struct {
+	// By module directory, as given on the command line
+	Modules map[string]report `json:"modules"`
+	// All the modules together, with the modules each action comes from
+	Combined report `json:"combined"`
+}
+
+// modulesPolicy is the output of several modules with -format policy
+type modulesPolicy struct {
+	Modules  map[string]policyDocument `json:"modules"`
+	Combined policyDocument            `json:"combined"`
+}
+
+// printModules analyzes all packages in each of several modules, one at a
+// time, and outputs the result of each module followed by the combined
+// result of all of them
+func printModules(ctx context.Context, config analyzeConfig, dirs []string, includeReflection, sdkCalls bool, format string, cfg config) {
+	var reports []sourceReport
+	for _, dir := range dirs {
+		config.dir = dir
+		config.patterns = []string{"./..."}
+		graph, err := analyze(ctx, config)
+		if err != nil {
+			fatalFailure(codeLoad, "failed to analyze", err)
+		}
+		r := graphReport(graph, includeReflection, cfg.Suppress)
+		r.Ignored = graph.ignoredActions()
+		reports = append(reports, sourceReport{dir, r})
+	}
+	combined := mergeReports(reports)
+
+	switch format {
+	case "json":
+		out := modulesReport{Modules: make(map[string]report), Combined: combined}
+		out.Combined.Controls = actionControls(combined.Actions, cfg.Controls)
+		for _, sr := range reports {
+			sr.report.Controls = actionControls(sr.report.Actions, cfg.Controls)
+			out.Modules[sr.source] = sr.report
+		}
+		if err := printJSON(out); err != nil {
+			fatal(codeWrite, "failed to write JSON", "err", err)
+		}
+	case "policy":
+		out := modulesPolicy{Modules: make(map[string]policyDocument), Combined: newPolicy(combined.Actions, cfg.Resources)}
+		for _, sr := range reports {
+			out.Modules[sr.source] = newPolicy(sr.report.Actions, cfg.Resources)
+		}
+		if err := printJSON(out); err != nil {
+			fatal(codeWrite, "failed to write JSON", "err", err)
+		}
+	case "terraform":
+		for _, sr := range reports {
+			if err := render.Terraform(os.Stdout, filepath.Base(sr.source), newPolicy(sr.report.Actions, cfg.Resources)); err != nil {
+				fatal(codeWrite, "failed to write terraform", "err", err)
+			}
+			fmt.Println()
+		}
+		if err := render.Terraform(os.Stdout, "combined", newPolicy(combined.Actions, cfg.Resources)); err != nil {
+			fatal(codeWrite, "failed to write terraform", "err", err)
+		}
+	default:
+		printed := false
+		items := func(r report) []string {
+			if sdkCalls {
+				return r.SDKCalls
+			}
+			return r.Actions
+		}
+		for _, sr := range reports {
+			printed = printSection(printed, sr.source+":", items(sr.report))
+		}
+		printSection(printed, "All modules:", items(combined))
+		if !sdkCalls {
+			logIgnored(combined.Ignored)
+		}
+	}
+}
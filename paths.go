@@ -0,0 +1,90 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// displayPath returns a filename the way positions in the output show it.
+// With -relpaths, files in the module the program was loaded from are
+// relative to its root and files in the module cache relative to the cache,
+// e.g. "github.com/aws/aws-sdk-go-v2/service/s3@v1.58.0/api_op_PutObject.go",
+// so the output is the same on every machine. Other files stay absolute
+func (g *graph) displayPath(filename string) string {
+	if !g.relPaths || filename == "" {
+		return filename
+	}
+	g.moduleRootOnce.Do(func() {
+		dir := g.dir
+		if dir == "" {
+			dir, _ = os.Getwd()
+		}
+		g.moduleRoot = moduleRoot(dir)
+	})
+	for _, base := range []string{g.moduleRoot, moduleCache()} {
+		if rel, ok := relativeTo(base, filename); ok {
+			return rel
+		}
+	}
+	return filename
+}
+
+// relativeTo returns a filename relative to a directory, with forward slashes,
+// if it's in the directory
+func relativeTo(dir, filename string) (string, bool) {
+	if dir == "" {
+		return "", false
+	}
+	rel, err := filepath.Rel(dir, filename)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
+
+// rootFiles are the files at the root of a module, or of a Bazel workspace
+// whose packages may not be in one
+var rootFiles = []string{"go.mod", "MODULE.bazel", "WORKSPACE", "WORKSPACE.bazel"}
+
+// isRoot reports whether a directory is the root of a module or workspace,
+// see rootFiles
+func isRoot(dir string) bool {
+	for _, name := range rootFiles {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// moduleRoot returns the root of the module, or Bazel workspace, a
+// directory is in, or the directory itself if it's in neither
+func moduleRoot(dir string) string {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return ""
+	}
+	for d := dir; ; {
+		if isRoot(d) {
+			return d
+		}
+		parent := filepath.Dir(d)
+		if parent == d {
+			return dir
+		}
+		d = parent
+	}
+}
+
+// moduleCache returns the directory of the module cache, GOMODCACHE, or an
+// empty string if the go command can't tell
+var moduleCache = sync.OnceValue(func() string {
+	out, err := exec.Command("go", "env", "GOMODCACHE").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+})
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"golang.org/x/tools/go/callgraph"
+	"golang.org/x/tools/go/ssa"
+
+	"github.com/esprimo/iamgo/internal/render"
+	"github.com/esprimo/iamgo/schema"
+)
+
+// binaryResult is the result of one main package, e.g. a Lambda function
+// in cmd/, with -per-binary
+type binaryResult struct {
+	// Name of the binary, see binaryResults
+	Name string
+	// Path of the main package
+	Package string
+	// Directory of the main package
+	Dir      string
+	Actions  []string
+	SDKCalls []string
+}
+
+// binaryResults returns the SDK calls, and the actions they require, that
+// are reachable from each main package, sorted by name. A binary is named
+// after the last element of its package path (like go build names it), or
+// its whole path with dashes for slashes if another main package has the
+// same last element. Suppressed actions are left out
+func binaryResults(graph *graph, includeReflection bool, suppress []string) []binaryResult {
+	fns := graph.SDKCalls(includeReflection)
+	noSkip := func(*callgraph.Edge) bool { return false }
+
+	var results []binaryResult
+	for _, main := range graph.Mains {
+		pkgpath := main.Pkg.Path()
+		reached := graph.ReachableFrom(graph.binaryRoots(pkgpath), noSkip)
+		sdkMethods, actions := graph.reachedActions(fns, reached, includeReflection, suppress)
+		results = append(results, binaryResult{
+			Name:     path.Base(pkgpath),
+			Package:  pkgpath,
+			Dir:      filepath.Dir(graph.Prog.Fset.Position(main.Func("main").Pos()).Filename),
+			Actions:  actions,
+			SDKCalls: sdkMethods,
+		})
+	}
+
+	for i := range results {
+		ambiguous := slices.ContainsFunc(results, func(r binaryResult) bool {
+			return r.Package != results[i].Package && path.Base(r.Package) == path.Base(results[i].Package)
+		})
+		if ambiguous {
+			results[i].Name = strings.ReplaceAll(results[i].Package, "/", "-")
+		}
+	}
+	slices.SortFunc(results, func(a, b binaryResult) int { return strings.Compare(a.Name, b.Name) })
+	return results
+}
+
+// reachedActions returns the sorted, unique SDK methods among fns that are
+// in reached, and the actions they, the helper libraries and the baseline
+// require from there. Suppressed actions are left out
+func (g *graph) reachedActions(fns []*ssa.Function, reached map[*ssa.Function]bool, includeReflection bool, suppress []string) (sdkMethods, actions []string) {
+	for _, fn := range fns {
+		if reached[fn] {
+			sdkMethods = append(sdkMethods, g.detectors.MethodName(fn))
+		}
+	}
+	slices.Sort(sdkMethods)
+	actions = requiredActions(sdkMethods, suppress)
+	actions = append(actions, libraryActions(g.UsedLibraries(includeReflection, suppress, reached))...)
+	actions = append(actions, g.BaselineActions(includeReflection, suppress, reached)...)
+	slices.Sort(actions)
+	return slices.Compact(sdkMethods), slices.Compact(actions)
+}
+
+// printPerBinary outputs the result of each main package, see
+// binaryResults. With -format policy there's a policy per binary that
+// needs any actions, keyed by its name, and with -format terraform a data
+// source per binary named after it
+func printPerBinary(graph *graph, includeReflection, sdkCalls bool, format string, cfg config) {
+	results := binaryResults(graph, includeReflection, cfg.Suppress)
+
+	switch format {
+	case "json":
+		out := make(map[string]report)
+		for _, r := range results {
+			out[r.Name] = report{SchemaVersion: schema.Version, Actions: r.Actions, SDKCalls: r.SDKCalls}
+		}
+		if err := printJSON(out); err != nil {
+			fatal(codeWrite, "failed to write JSON", "err", err)
+		}
+	case "policy":
+		out := make(map[string]policyDocument)
+		for _, r := range results {
+			if len(r.Actions) > 0 { // a policy needs a statement
+				out[r.Name] = newPolicy(r.Actions, cfg.Resources)
+			}
+		}
+		if err := printJSON(out); err != nil {
+			fatal(codeWrite, "failed to write JSON", "err", err)
+		}
+	case "terraform":
+		printed := false
+		for _, r := range results {
+			if len(r.Actions) == 0 {
+				continue
+			}
+			if printed {
+				fmt.Println()
+			}
+			printed = true
+			if err := render.Terraform(os.Stdout, r.Name, newPolicy(r.Actions, cfg.Resources)); err != nil {
+				fatal(codeWrite, "failed to write terraform", "err", err)
+			}
+		}
+	default:
+		printed := false
+		for _, r := range results {
+			lines := r.Actions
+			if sdkCalls {
+				lines = r.SDKCalls
+			}
+			printed = printSection(printed, r.Name+" ("+r.Package+"):", lines)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+
+	"github.com/esprimo/iamgo/internal/policy"
+)
+
+// policyDocument is an AWS IAM policy, the output of -format policy
+type policyDocument = policy.Document
+
+// policyStatement is a statement in an AWS IAM policy
+type policyStatement = policy.Statement
+
+// newPolicy creates a policy that allows a set of actions on the resources
+// configured for them, see policy.New
+func newPolicy(actions []string, resources map[string]stringList) policyDocument {
+	return policy.New(actions, resourcePatterns(resources))
+}
+
+// resourcePatterns converts the resources of the config to the form the
+// internal packages take them in
+func resourcePatterns(resources map[string]stringList) map[string][]string {
+	patterns := make(map[string][]string, len(resources))
+	for pattern, arns := range resources {
+		patterns[pattern] = arns
+	}
+	return patterns
+}
+
+// policyFile is an AWS IAM policy as read by -check-policy, see
+// policy.File
+type policyFile = policy.File
+
+// readPolicy reads an AWS IAM policy from a JSON file
+func readPolicy(filename string) (policyFile, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return policyFile{}, err
+	}
+	return parsePolicy(data)
+}
+
+// parsePolicy parses an AWS IAM policy document, see policy.Parse
+func parsePolicy(data []byte) (policyFile, error) {
+	return policy.Parse(data)
+}
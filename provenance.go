@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"golang.org/x/tools/go/callgraph"
+	"golang.org/x/tools/go/ssa"
+
+	"github.com/esprimo/iamgo/schema"
+)
+
+// provenance returns what needs each of the actions, for -format json-full:
+// the SDK methods that require it, or the functions of helper libraries
+// and plugins that need it, with up to n of the shortest call paths to
+// each through every place it's called from
+func (g *graph) provenance(actions []string, n int) []schema.Provenance {
+	provenance := []schema.Provenance{}
+	for _, action := range actions {
+		p := schema.Provenance{Action: action, Methods: []schema.MethodProvenance{}}
+		paths, err := g.whyPaths(action, whyOptions{all: true, paths: n})
+		var noPath noPathError
+		if err != nil && !errors.As(err, &noPath) {
+			slog.Warn("failed to find the call paths to an action", "action", action, "err", err)
+		}
+		// No paths means nothing calls it, e.g. an action of the baseline
+		byFunc := make(map[*ssa.Function][][]*callgraph.Edge)
+		var fns []*ssa.Function
+		for _, path := range paths {
+			if len(path) == 0 {
+				continue
+			}
+			fn := path[len(path)-1].Callee.Func
+			if _, ok := byFunc[fn]; !ok {
+				fns = append(fns, fn)
+			}
+			byFunc[fn] = append(byFunc[fn], path)
+		}
+		for _, fn := range fns {
+			method := cleanName(fn)
+			if g.detectors.Version(fn) != "" {
+				method = g.detectors.MethodName(fn)
+			}
+			m := schema.MethodProvenance{Method: method, Paths: g.whyResult(action, byFunc[fn]).Paths}
+			slices.SortStableFunc(m.Paths, func(a, b schema.Path) int { return strings.Compare(pathKey(a), pathKey(b)) })
+			p.Methods = append(p.Methods, m)
+		}
+		slices.SortStableFunc(p.Methods, func(a, b schema.MethodProvenance) int { return strings.Compare(a.Method, b.Method) })
+		provenance = append(provenance, p)
+	}
+	return provenance
+}
+
+// pathKey returns a string that orders paths by where their calls are
+// made, so the order of the output doesn't depend on the analysis
+func pathKey(p schema.Path) string {
+	var key strings.Builder
+	key.WriteString(p.Root)
+	for _, s := range p.Steps {
+		fmt.Fprintf(&key, "\n%s:%d:%d %s", s.CallSite.Filename, s.CallSite.Line, s.CallSite.Column, s.Callee)
+	}
+	return key.String()
+}
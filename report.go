@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"golang.org/x/tools/go/ssa"
+
+	"github.com/esprimo/iamgo/internal/mapping"
+	"github.com/esprimo/iamgo/internal/policy"
+	"github.com/esprimo/iamgo/schema"
+)
+
+// sdkCallReports returns each SDK call with the places in the analyzed
+// packages it's made from and a shortest path to it, for the JSON report
+func (g *graph) sdkCallReports(fns []*ssa.Function, suppress []string) []schema.Call {
+	calls := []schema.Call{}
+	for _, fn := range fns {
+		method := g.detectors.MethodName(fn)
+		call := schema.Call{
+			Method:    method,
+			Version:   g.detectors.Version(fn),
+			CallSites: []schema.Position{},
+			Path:      []schema.Step{},
+		}
+		if action := sdkMethodToAction(method); action != "" && !suppressed(action, suppress) {
+			call.Action = action
+		}
+		for _, site := range g.CallSites(fn) {
+			pos := g.Prog.Fset.Position(site)
+			site := position{Filename: g.displayPath(pos.Filename), Line: pos.Line, Column: pos.Column}
+			if !containsPosition(call.CallSites, site) {
+				call.CallSites = append(call.CallSites, site)
+			}
+		}
+		call.Path = append(call.Path, g.steps(g.FindPath(fn))...)
+		calls = append(calls, call)
+	}
+	slices.SortStableFunc(calls, func(a, b schema.Call) int { return strings.Compare(a.Method, b.Method) })
+	return calls
+}
+
+// actionCounts returns the number of distinct places in the analyzed
+// packages that call the SDK in a way that requires each action, see
+// callSites. Actions only required by calls the SDK makes itself, e.g. to
+// get credentials or inside the S3 transfer managers, have no such place
+// and are left out
+func (g *graph) actionCounts(fns []*ssa.Function, suppress []string) map[string]int {
+	sites := make(map[string][]schema.Position)
+	for _, fn := range fns {
+		action := sdkMethodToAction(g.detectors.MethodName(fn))
+		if action == "" || suppressed(action, suppress) {
+			continue
+		}
+		for _, site := range g.CallSites(fn) {
+			pos := g.Prog.Fset.Position(site)
+			site := position{Filename: g.displayPath(pos.Filename), Line: pos.Line, Column: pos.Column}
+			if !containsPosition(sites[action], site) {
+				sites[action] = append(sites[action], site)
+			}
+		}
+	}
+	counts := make(map[string]int, len(sites))
+	for action, positions := range sites {
+		counts[action] = len(positions)
+	}
+	return counts
+}
+
+// actionVersions returns the actions the SDK calls require by the SDK they
+// belong to, e.g. "v1" and "v2" for a program that's moving from one to
+// the other
+func (g *graph) actionVersions(fns []*ssa.Function, suppress []string) map[string][]string {
+	versions := make(map[string][]string)
+	for _, fn := range fns {
+		action := sdkMethodToAction(g.detectors.MethodName(fn))
+		if action == "" || suppressed(action, suppress) {
+			continue
+		}
+		version := g.detectors.Version(fn)
+		if !slices.Contains(versions[version], action) {
+			versions[version] = append(versions[version], action)
+		}
+	}
+	for _, actions := range versions {
+		slices.Sort(actions)
+	}
+	return versions
+}
+
+// logVersions outputs how many of the actions are required through each
+// SDK to stderr, and which are required through more than one, e.g. to
+// follow a migration from v1 to v2 of the AWS SDK for Go
+func logVersions(versions map[string][]string) {
+	if len(versions) < 2 {
+		return
+	}
+	names := make([]string, 0, len(versions))
+	for version := range versions {
+		names = append(names, version)
+	}
+	slices.Sort(names)
+	counts := make(map[string]int)
+	var args []any
+	for _, version := range names {
+		args = append(args, version, len(versions[version]))
+		for _, action := range versions[version] {
+			counts[action]++
+		}
+	}
+	var shared []string
+	for action, n := range counts {
+		if n > 1 {
+			shared = append(shared, action)
+		}
+	}
+	slices.Sort(shared)
+	if len(shared) > 0 {
+		args = append(args, "shared", strings.Join(shared, ","))
+	}
+	slog.Info("actions required through each SDK", args...)
+}
+
+// actionControls returns the compliance controls of the actions, by
+// action, from the controls of the project configuration keyed by action
+// pattern. Actions that match no pattern are left out
+func actionControls(actions []string, controls map[string]stringList) map[string][]string {
+	var out map[string][]string
+	for _, action := range actions {
+		var ids []string
+		for pattern, patternIDs := range controls {
+			if mapping.MatchAction(pattern, action) {
+				ids = append(ids, patternIDs...)
+			}
+		}
+		if len(ids) == 0 {
+			continue
+		}
+		if out == nil {
+			out = make(map[string][]string)
+		}
+		slices.Sort(ids)
+		out[action] = slices.Compact(ids)
+	}
+	return out
+}
+
+// actionRisks returns the risk level of each action, see mapping.Risk, and
+// the score of the program: the score of its riskiest action, or one more
+// than the riskiest level if escalates, i.e. the actions allow privilege
+// escalation
+func actionRisks(actions []string, escalates bool) (map[string]string, int) {
+	risks := make(map[string]string, len(actions))
+	score := 0
+	for _, action := range actions {
+		risks[action] = mapping.Risk(action)
+		score = max(score, slices.Index(mapping.RiskLevels, risks[action])+1)
+	}
+	if escalates {
+		score = len(mapping.RiskLevels) + 1
+	}
+	return risks, score
+}
+
+// logRisk outputs the risk score of the program to stderr, with how many
+// of its actions are of each level, so the list stays plain
+func logRisk(risks map[string]string, score int) {
+	if len(risks) == 0 {
+		return
+	}
+	level := "privilege escalation"
+	if score <= len(mapping.RiskLevels) {
+		level = mapping.RiskLevels[score-1]
+	}
+	counts := make(map[string]int)
+	for _, risk := range risks {
+		counts[risk]++
+	}
+	var args []any
+	for _, risk := range mapping.RiskLevels {
+		args = append(args, risk, counts[risk])
+	}
+	slog.Info(fmt.Sprintf("risk score %d (%s)", score, level), args...)
+}
+
+func containsPosition(positions []schema.Position, p schema.Position) bool {
+	for _, q := range positions {
+		if q == p {
+			return true
+		}
+	}
+	return false
+}
+
+// actionResources returns the resources configured for each action that
+// has any, or nil if there are none
+func actionResources(actions []string, resources map[string]stringList) map[string][]string {
+	var out map[string][]string
+	for _, action := range actions {
+		if arns := policy.Resources(action, resourcePatterns(resources)); len(arns) > 0 {
+			if out == nil {
+				out = make(map[string][]string)
+			}
+			out[action] = arns
+		}
+	}
+	return out
+}
@@ -0,0 +1,635 @@
+package main
+
+import (
+	"go/constant"
+	"go/token"
+	"go/types"
+	"log/slog"
+	"net/url"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+
+	"golang.org/x/tools/go/callgraph"
+	"golang.org/x/tools/go/ssa"
+
+	"github.com/esprimo/iamgo/internal/mapping"
+)
+
+// inferResources returns the resources the reachable SDK calls are made on,
+// by action, for the actions whose every call names its resources with
+// constants, e.g.
+//
+//	client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String("reports"), Key: &key})
+//
+// is made on "arn:aws:s3:::reports/*". An action is left out if any of its
+// calls names a resource any other way, e.g. with a parameter, or if the
+// resource of its service can't be told from the input
+func (g *graph) inferResources(fns []*ssa.Function, suppress []string) map[string][]string {
+	resources := make(map[string][]string)
+	unknown := make(map[string]bool)
+	for _, fn := range fns {
+		action := sdkMethodToAction(g.detectors.MethodName(fn))
+		if action == "" || suppressed(action, suppress) || unknown[action] {
+			continue
+		}
+		sites := g.inputSites(fn)
+		if len(sites) == 0 {
+			unknown[action] = true
+			continue
+		}
+		for _, site := range sites {
+			arns, ok := g.siteResources(action, site)
+			if !ok {
+				unknown[action] = true
+				break
+			}
+			resources[action] = append(resources[action], arns...)
+		}
+	}
+
+	inferred := make(map[string][]string)
+	for action, arns := range resources {
+		if !unknown[action] {
+			slices.Sort(arns)
+			inferred[action] = slices.Compact(arns)
+		}
+	}
+	return inferred
+}
+
+// inputSites returns the calls that lead to an SDK function from outside
+// the SDK, directly or through other functions of its package. Calls made
+// by other packages of the SDK, e.g. of the S3 transfer manager, are left
+// out since the calls of the SDK methods they're made for are. A call
+// without a site, e.g. through reflection, is returned as nil
+func (g *graph) inputSites(fn *ssa.Function) []*callgraph.Edge {
+	var sites []*callgraph.Edge
+	visited := map[*ssa.Function]bool{fn: true}
+	queue := []*ssa.Function{fn}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		node := g.CallGraph.Nodes[current]
+		if node == nil {
+			continue
+		}
+		for _, edge := range node.In {
+			caller := edge.Caller.Func
+			switch {
+			case edge.Site == nil || caller.Pkg == nil:
+				sites = append(sites, nil)
+			case caller.Pkg == fn.Pkg:
+				if !visited[caller] {
+					visited[caller] = true
+					queue = append(queue, caller)
+				}
+			case strings.HasPrefix(caller.Pkg.Pkg.Path(), "github.com/aws/aws-sdk-go"):
+			default:
+				sites = append(sites, edge)
+			}
+		}
+	}
+	return sites
+}
+
+// siteResources returns the ARNs of the resources a call requires an action
+// on, and false if they can't be told from constants
+func (g *graph) siteResources(action string, site *callgraph.Edge) ([]string, bool) {
+	if site == nil {
+		return nil, false
+	}
+	fields, ok := g.inputFields(site.Site.Common())
+	if !ok {
+		return nil, false
+	}
+	return fieldResources(action, fields)
+}
+
+// fieldResources returns the ARNs of the resources an action is used on
+// given the values of the fields of the input of the call, named as in the
+// SDK, e.g. "Bucket" and "Key" for s3:GetObject, and false if they can't be
+// told from them
+func fieldResources(action string, fields map[string][]string) ([]string, bool) {
+	// Each field may be set to a different constant in different branches
+	one := func(name string) ([]string, bool) {
+		values, ok := fields[name]
+		return values, ok && len(values) > 0
+	}
+	// A field that's an ARN or a name, arn makes an ARN of a name or is
+	// nil if the field must be an ARN
+	arnOrName := func(field string, arn func(name string) string) ([]string, bool) {
+		values, ok := one(field)
+		if !ok {
+			return nil, false
+		}
+		var arns []string
+		for _, v := range values {
+			switch {
+			case strings.HasPrefix(v, "arn:"):
+				arns = append(arns, v)
+			case arn == nil:
+				return nil, false
+			default:
+				arns = append(arns, arn(v))
+			}
+		}
+		return arns, true
+	}
+
+	switch mapping.Service(action) {
+	case "s3":
+		buckets, ok := one("Bucket")
+		if !ok {
+			return nil, false
+		}
+		var arns []string
+		for _, bucket := range buckets {
+			if strings.HasPrefix(bucket, "arn:") {
+				return nil, false // access points and Outposts buckets
+			}
+			if !isObjectAction(action) {
+				arns = append(arns, "arn:aws:s3:::"+bucket)
+				continue
+			}
+			keys, ok := one("Key")
+			if !ok {
+				keys = []string{"*"}
+			}
+			for _, key := range keys {
+				arns = append(arns, "arn:aws:s3:::"+bucket+"/"+key)
+			}
+		}
+		return arns, true
+	case "dynamodb":
+		return arnOrName("TableName", func(name string) string { return "arn:aws:dynamodb:*:*:table/" + name })
+	case "sqs":
+		urls, ok := one("QueueUrl")
+		if !ok {
+			return nil, false
+		}
+		var arns []string
+		for _, u := range urls {
+			arn, ok := queueARN(u)
+			if !ok {
+				return nil, false
+			}
+			arns = append(arns, arn)
+		}
+		return arns, true
+	case "sns":
+		return arnOrName("TopicArn", nil)
+	case "states":
+		return arnOrName("StateMachineArn", nil)
+	case "lambda":
+		return arnOrName("FunctionName", func(name string) string { return "arn:aws:lambda:*:*:function:" + name })
+	case "ssm":
+		return arnOrName("Name", func(name string) string { return "arn:aws:ssm:*:*:parameter/" + strings.TrimPrefix(name, "/") })
+	case "secretsmanager":
+		// Secret ARNs end with a random suffix
+		return arnOrName("SecretId", func(name string) string { return "arn:aws:secretsmanager:*:*:secret:" + name + "-*" })
+	case "kinesis":
+		if _, ok := one("StreamARN"); ok {
+			return arnOrName("StreamARN", nil)
+		}
+		return arnOrName("StreamName", func(name string) string { return "arn:aws:kinesis:*:*:stream/" + name })
+	}
+	return nil, false
+}
+
+// isObjectAction reports whether an S3 action is on objects rather than on
+// buckets, e.g. s3:GetObject but not s3:GetObjectLockConfiguration
+func isObjectAction(action string) bool {
+	name := strings.TrimPrefix(action, "s3:")
+	switch {
+	case name == "AbortMultipartUpload" || name == "ListMultipartUploadParts":
+		return true
+	case strings.Contains(name, "ObjectLockConfiguration"):
+		return false
+	}
+	return strings.Contains(name, "Object") && !strings.Contains(name, "Bucket")
+}
+
+// queueARN returns the ARN of a queue given by its URL, e.g.
+// "https://sqs.eu-west-1.amazonaws.com/123456789012/jobs"
+func queueARN(queueURL string) (string, bool) {
+	u, err := url.Parse(queueURL)
+	if err != nil {
+		return "", false
+	}
+	account, name, ok := strings.Cut(strings.Trim(u.Path, "/"), "/")
+	if !ok || strings.Contains(name, "/") {
+		return "", false
+	}
+	region := strings.TrimSuffix(strings.TrimPrefix(u.Host, "sqs."), ".amazonaws.com")
+	region = strings.TrimSuffix(region, ".queue")
+	if region == "" || strings.Contains(region, ".") {
+		return "", false
+	}
+	return "arn:aws:sqs:" + region + ":" + account + ":" + name, true
+}
+
+// inputFields returns the string constants the fields of the input of an
+// SDK call, the argument that's a pointer to a struct named like
+// "GetObjectInput", are set to, by field. Fields that aren't set, or are set
+// to anything but constants, are left out. Returns false if the input isn't
+// a struct built where the call is made, and so may be set anywhere
+func (g *graph) inputFields(call *ssa.CallCommon) (map[string][]string, bool) {
+	var input *ssa.Alloc
+	for _, arg := range call.Args {
+		ptr, ok := arg.Type().(*types.Pointer)
+		if !ok {
+			continue
+		}
+		if named, ok := ptr.Elem().(*types.Named); ok && strings.HasSuffix(named.Obj().Name(), "Input") {
+			input, _ = arg.(*ssa.Alloc)
+			break
+		}
+	}
+	if input == nil {
+		return nil, false
+	}
+	st, ok := input.Type().(*types.Pointer).Elem().Underlying().(*types.Struct)
+	if !ok {
+		return nil, false
+	}
+
+	fields := make(map[string][]string)
+	unknown := make(map[string]bool)
+	for _, ref := range *input.Referrers() {
+		switch ref := ref.(type) {
+		case *ssa.FieldAddr:
+			name := st.Field(ref.Field).Name()
+			for _, use := range *ref.Referrers() {
+				switch use := use.(type) {
+				case *ssa.Store:
+					if s, ok := g.stringValue(use.Val); ok {
+						fields[name] = append(fields[name], s)
+					} else {
+						unknown[name] = true
+					}
+				case *ssa.UnOp, *ssa.DebugRef: // reads
+				default:
+					return nil, false // e.g. its address is passed on
+				}
+			}
+		case *ssa.DebugRef:
+		case ssa.CallInstruction:
+			if ref.Common() != call {
+				return nil, false // may be set by the function called
+			}
+		default:
+			return nil, false
+		}
+	}
+	for name := range unknown {
+		delete(fields, name)
+	}
+	return fields, true
+}
+
+// stringValue returns the string a value is known to be: a constant, a
+// pointer to one made with aws.String, a local variable only ever set to
+// one, an unexported package variable only ever set to one, or strings
+// concatenated from those, formatted with fmt.Sprintf (see sprintf) or
+// built as an ARN (see arnValue). An environment variable, read with os.Getenv or
+// os.LookupEnv or into a field of a config struct tagged with its name
+// (e.g. `env:"BUCKET"` or `envconfig:"BUCKET"`), is a placeholder such as
+// "${env:BUCKET}"
+func (g *graph) stringValue(v ssa.Value) (string, bool) {
+	switch v := v.(type) {
+	case *ssa.Const:
+		if v.Value != nil && v.Value.Kind() == constant.String {
+			return constant.StringVal(v.Value), true
+		}
+	case *ssa.ChangeType:
+		return g.stringValue(v.X)
+	case *ssa.BinOp:
+		if v.Op != token.ADD {
+			return "", false
+		}
+		x, ok := g.stringValue(v.X)
+		if !ok {
+			return "", false
+		}
+		y, ok := g.stringValue(v.Y)
+		return x + y, ok
+	case *ssa.Extract:
+		if call, ok := v.Tuple.(*ssa.Call); ok && v.Index == 0 && isFunc(call, "os", "LookupEnv") {
+			return envPlaceholder(call.Call.Args[0])
+		}
+	case *ssa.Field:
+		if st, ok := v.X.Type().Underlying().(*types.Struct); ok {
+			return envField(st, v.Field)
+		}
+	case *ssa.FieldAddr:
+		if st, ok := v.X.Type().(*types.Pointer).Elem().Underlying().(*types.Struct); ok {
+			return envField(st, v.Field)
+		}
+	case *ssa.Call:
+		switch {
+		case isFunc(v, "github.com/aws/aws-sdk-go-v2/aws", "String"), isFunc(v, "github.com/aws/aws-sdk-go/aws", "String"):
+			return g.stringValue(v.Call.Args[0])
+		case isFunc(v, "os", "Getenv"):
+			return envPlaceholder(v.Call.Args[0])
+		case isFunc(v, "fmt", "Sprintf"):
+			return g.sprintf(v.Call.Args[0], v.Call.Args[1])
+		case isARNString(v):
+			return g.arnValue(v.Call.Args[0])
+		}
+	case *ssa.Alloc:
+		return g.onlyStored(v, *v.Referrers())
+	case *ssa.UnOp:
+		if v.Op != token.MUL {
+			return "", false
+		}
+		switch x := v.X.(type) {
+		case *ssa.FieldAddr, *ssa.Alloc:
+			return g.stringValue(x)
+		case *ssa.Global:
+			if x.Object() == nil || x.Object().Exported() {
+				return "", false // may be set by other packages
+			}
+			return g.onlyStored(x, g.globalUses()[x])
+		}
+	}
+	return "", false
+}
+
+// isFunc reports whether a call is a static call of a function of a
+// package with one argument, not counting the variadic ones
+func isFunc(call *ssa.Call, pkgpath, name string) bool {
+	callee := call.Call.StaticCallee()
+	if callee == nil || callee.Pkg == nil || callee.Signature.Recv() != nil || callee.Name() != name || callee.Pkg.Pkg.Path() != pkgpath {
+		return false
+	}
+	args := 1
+	if callee.Signature.Variadic() {
+		args++
+	}
+	return len(call.Call.Args) == args
+}
+
+// isARNString reports whether a call is of the String method of an ARN of
+// the arn package of the SDK
+func isARNString(call *ssa.Call) bool {
+	callee := call.Call.StaticCallee()
+	if callee == nil || callee.Pkg == nil || callee.Name() != "String" || callee.Signature.Recv() == nil {
+		return false
+	}
+	path := callee.Pkg.Pkg.Path()
+	return path == "github.com/aws/aws-sdk-go-v2/aws/arn" || path == "github.com/aws/aws-sdk-go/aws/arn"
+}
+
+// arnValue returns the string of an ARN struct built from constants, e.g.
+//
+//	arn.ARN{Partition: "aws", Service: "sns", Region: region, AccountID: account, Resource: "events"}
+//
+// is "arn:aws:sns:*:*:events". The parts that aren't constants match any,
+// but the service and resource must be
+func (g *graph) arnValue(v ssa.Value) (string, bool) {
+	load, ok := v.(*ssa.UnOp)
+	if !ok || load.Op != token.MUL {
+		return "", false
+	}
+	alloc, ok := load.X.(*ssa.Alloc)
+	if !ok {
+		return "", false
+	}
+	st := alloc.Type().(*types.Pointer).Elem().Underlying().(*types.Struct)
+	parts := make(map[string]string)
+	for _, ref := range *alloc.Referrers() {
+		switch ref := ref.(type) {
+		case *ssa.FieldAddr:
+			name := st.Field(ref.Field).Name()
+			part, ok := g.onlyStored(ref, *ref.Referrers())
+			if !ok {
+				part = "*"
+			}
+			parts[name] = part
+		case *ssa.UnOp, *ssa.DebugRef:
+		default:
+			return "", false // e.g. its address is passed on
+		}
+	}
+	for _, name := range []string{"Service", "Resource"} {
+		if part := parts[name]; part == "" || part == "*" {
+			return "", false
+		}
+	}
+	return "arn:" + parts["Partition"] + ":" + parts["Service"] + ":" + parts["Region"] + ":" + parts["AccountID"] + ":" + parts["Resource"], true
+}
+
+// sprintf returns the string fmt.Sprintf makes of a constant format, given
+// the slice of its arguments. Arguments formatted with %s, %v or %d that
+// aren't constants match any, e.g.
+//
+//	fmt.Sprintf("arn:aws:sqs:%s:%s:jobs", region, account)
+//
+// is "arn:aws:sqs:*:*:jobs". Other verbs, or flags, aren't supported
+func (g *graph) sprintf(format, args ssa.Value) (string, bool) {
+	f, ok := g.stringValue(format)
+	if !ok {
+		return "", false
+	}
+	values, ok := g.sprintfArgs(args)
+	if !ok {
+		return "", false
+	}
+	var b strings.Builder
+	literal := false
+	for i := 0; i < len(f); i++ {
+		if f[i] != '%' {
+			b.WriteByte(f[i])
+			literal = true
+			continue
+		}
+		if i++; i == len(f) {
+			return "", false
+		}
+		switch f[i] {
+		case '%':
+			b.WriteByte('%')
+		case 's', 'v', 'd':
+			if len(values) == 0 {
+				return "", false
+			}
+			b.WriteString(values[0])
+			values = values[1:]
+		default:
+			return "", false
+		}
+	}
+	return b.String(), literal && len(values) == 0
+}
+
+// sprintfArgs returns the strings of the arguments of a call of a variadic
+// function that takes any, given the slice they're passed in. The ones that
+// aren't constants are "*"
+func (g *graph) sprintfArgs(args ssa.Value) ([]string, bool) {
+	if c, ok := args.(*ssa.Const); ok && c.IsNil() {
+		return nil, true
+	}
+	slice, ok := args.(*ssa.Slice)
+	if !ok {
+		return nil, false
+	}
+	array, ok := slice.X.(*ssa.Alloc)
+	if !ok {
+		return nil, false
+	}
+	values := make([]string, array.Type().(*types.Pointer).Elem().(*types.Array).Len())
+	for i := range values {
+		values[i] = "*"
+	}
+	for _, ref := range *array.Referrers() {
+		addr, ok := ref.(*ssa.IndexAddr)
+		if !ok {
+			continue // the slice
+		}
+		index, ok := addr.Index.(*ssa.Const)
+		if !ok {
+			return nil, false
+		}
+		for _, use := range *addr.Referrers() {
+			store, ok := use.(*ssa.Store)
+			if !ok {
+				continue
+			}
+			iface, ok := store.Val.(*ssa.MakeInterface)
+			if !ok {
+				continue
+			}
+			if s, ok := g.stringValue(iface.X); ok {
+				values[index.Int64()] = s
+			} else if c, ok := iface.X.(*ssa.Const); ok && c.Value != nil && c.Value.Kind() == constant.Int {
+				values[index.Int64()] = c.Value.ExactString()
+			}
+		}
+	}
+	return values, true
+}
+
+// envPlaceholder returns the placeholder of an environment variable whose
+// name is a constant
+func envPlaceholder(name ssa.Value) (string, bool) {
+	c, ok := name.(*ssa.Const)
+	if !ok || c.Value == nil || c.Value.Kind() != constant.String {
+		return "", false
+	}
+	return "${env:" + constant.StringVal(c.Value) + "}", true
+}
+
+// envField returns the placeholder of the environment variable a field of
+// a config struct is read from, according to its tag
+func envField(st *types.Struct, field int) (string, bool) {
+	tag := reflect.StructTag(st.Tag(field))
+	for _, key := range []string{"env", "envconfig"} {
+		name, _, _ := strings.Cut(tag.Get(key), ",")
+		if name != "" && name != "-" {
+			return "${env:" + name + "}", true
+		}
+	}
+	return "", false
+}
+
+// placeholders returns the environment variables whose placeholders are in
+// resources, sorted
+func placeholders(resources map[string][]string) []string {
+	var names []string
+	for _, arns := range resources {
+		for _, arn := range arns {
+			for _, m := range placeholder.FindAllStringSubmatch(arn, -1) {
+				names = append(names, m[1])
+			}
+		}
+	}
+	slices.Sort(names)
+	return slices.Compact(names)
+}
+
+// placeholder matches the placeholder of an environment variable
+var placeholder = regexp.MustCompile(`\$\{env:([^}]+)\}`)
+
+// onlyStored returns the string constant that's the only value stored at
+// an address, given the instructions that use it. Loads and the field
+// stores of an SDK input don't count
+func (g *graph) onlyStored(addr ssa.Value, uses []ssa.Instruction) (string, bool) {
+	var value string
+	stores := 0
+	for _, use := range uses {
+		switch use := use.(type) {
+		case *ssa.Store:
+			if use.Addr != addr {
+				continue // the address itself is stored, e.g. in an input
+			}
+			s, ok := g.stringValue(use.Val)
+			if !ok {
+				return "", false
+			}
+			value = s
+			stores++
+		case *ssa.UnOp, *ssa.DebugRef:
+		default:
+			return "", false // e.g. its address is passed on
+		}
+	}
+	return value, stores == 1
+}
+
+// globalUses returns the instructions of the reachable functions that use
+// each package variable, computed once
+func (g *graph) globalUses() map[*ssa.Global][]ssa.Instruction {
+	g.globalsOnce.Do(func() {
+		g.globals = make(map[*ssa.Global][]ssa.Instruction)
+		for fn := range g.Reachable {
+			for _, b := range fn.Blocks {
+				for _, instr := range b.Instrs {
+					for _, op := range instr.Operands(nil) {
+						if global, ok := (*op).(*ssa.Global); ok {
+							g.globals[global] = append(g.globals[global], instr)
+						}
+					}
+				}
+			}
+		}
+	})
+	return g.globals
+}
+
+// withResources adds the inferred resources of actions to the configured
+// ones, except for actions that have configured resources. source is
+// where they were inferred from, for the note about them
+func withResources(configured map[string]stringList, inferred map[string][]string, source string) map[string]stringList {
+	resources := make(map[string]stringList, len(configured)+len(inferred))
+	for pattern, arns := range configured {
+		resources[pattern] = arns
+	}
+	added := make(map[string][]string)
+	for action, arns := range inferred {
+		if configuredFor(configured, action) {
+			continue
+		}
+		resources[action] = arns
+		added[action] = arns
+	}
+	if len(added) > 0 {
+		slog.Info("inferred the resources of actions from "+source, "actions", len(added))
+	}
+	if vars := placeholders(added); len(vars) > 0 {
+		slog.Info("replace the placeholders of environment variables in the resources, e.g. ${env:"+vars[0]+"}", "variables", strings.Join(vars, ","))
+	}
+	return resources
+}
+
+// configuredFor reports whether any pattern of the configured resources
+// matches an action
+func configuredFor(configured map[string]stringList, action string) bool {
+	for pattern := range configured {
+		if matchAction(pattern, action) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+)
+
+// rolePolicy fetches the inline and managed policies of an IAM role,
+// combined into one policy. The role is given by its ARN, e.g.
+// "arn:aws:iam::123456789012:role/app", or name. Credentials are loaded the
+// same way as by the AWS CLI
+//
+// Permissions boundaries, service control policies and resource-based
+// policies aren't included
+func rolePolicy(ctx context.Context, role string) (policyFile, error) {
+	// The name is the last element of the path in the ARN
+	name := role[strings.LastIndex(role, "/")+1:]
+
+	cfg, err := awsconfig.LoadDefaultConfig(ctx)
+	if err != nil {
+		return policyFile{}, err
+	}
+	client := iam.NewFromConfig(cfg)
+
+	var policy policyFile
+	add := func(document string) error {
+		// Policy documents are URL encoded
+		decoded, err := url.QueryUnescape(document)
+		if err != nil {
+			return err
+		}
+		p, err := parsePolicy([]byte(decoded))
+		if err != nil {
+			return err
+		}
+		policy.Add(p)
+		return nil
+	}
+
+	inline := iam.NewListRolePoliciesPaginator(client, &iam.ListRolePoliciesInput{RoleName: &name})
+	for inline.HasMorePages() {
+		page, err := inline.NextPage(ctx)
+		if err != nil {
+			return policyFile{}, err
+		}
+		for _, policyName := range page.PolicyNames {
+			out, err := client.GetRolePolicy(ctx, &iam.GetRolePolicyInput{RoleName: &name, PolicyName: &policyName})
+			if err != nil {
+				return policyFile{}, err
+			}
+			if err := add(*out.PolicyDocument); err != nil {
+				return policyFile{}, fmt.Errorf("inline policy %s: %w", policyName, err)
+			}
+		}
+	}
+
+	attached := iam.NewListAttachedRolePoliciesPaginator(client, &iam.ListAttachedRolePoliciesInput{RoleName: &name})
+	for attached.HasMorePages() {
+		page, err := attached.NextPage(ctx)
+		if err != nil {
+			return policyFile{}, err
+		}
+		for _, p := range page.AttachedPolicies {
+			out, err := client.GetPolicy(ctx, &iam.GetPolicyInput{PolicyArn: p.PolicyArn})
+			if err != nil {
+				return policyFile{}, err
+			}
+			version, err := client.GetPolicyVersion(ctx, &iam.GetPolicyVersionInput{
+				PolicyArn: p.PolicyArn,
+				VersionId: out.Policy.DefaultVersionId,
+			})
+			if err != nil {
+				return policyFile{}, err
+			}
+			if err := add(*version.PolicyVersion.Document); err != nil {
+				return policyFile{}, fmt.Errorf("managed policy %s: %w", *p.PolicyArn, err)
+			}
+		}
+	}
+	return policy, nil
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/token"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/go/ssa"
+)
+
+// JSON-RPC 2.0 error codes
+const (
+	rpcParseError     = -32700
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcFailed         = -32000
+)
+
+// rpcRequest is a JSON-RPC 2.0 request. Requests without an ID are
+// notifications and aren't responded to
+type rpcRequest struct {
+	ID     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+// rpcResponse is a JSON-RPC 2.0 response
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+// rpcError is the error of a failed JSON-RPC 2.0 request
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// rpcPosition is a place in a source file, as sent by an editor. Lines
+// and columns start at 1, and a column of 0 means anywhere on the line
+type rpcPosition struct {
+	Filename string `json:"filename"`
+	Line     int    `json:"line"`
+	Column   int    `json:"column"`
+}
+
+// rpcWhyParams are the parameters of the why method. If a position is
+// given, only call paths through the function at it are returned
+type rpcWhyParams struct {
+	whyRequest
+	rpcPosition
+}
+
+// rpcActionsResult is the result of the actions method
+type rpcActionsResult struct {
+	// Full name of the function at the position
+	Function string   `json:"function"`
+	Actions  []string `json:"actions"`
+}
+
+// serveRPC answers JSON-RPC 2.0 requests about the program from r on w
+// until r is closed. Messages are framed like in the Language Server
+// Protocol, with a Content-Length header. The methods are:
+//
+//	actions  the IAM actions the function at an rpcPosition needs
+//	why      call paths for an rpcWhyParams, as with -why and -format json
+//	reload   analyze the program again, e.g. after it's changed
+func serveRPC(r io.Reader, w io.Writer, s *server) error {
+	if err := s.reload(); err != nil {
+		return err
+	}
+
+	in := bufio.NewReader(r)
+	for {
+		body, err := readRPCMessage(in)
+		if errors.Is(err, io.EOF) {
+			s.notifications.Wait()
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var req rpcRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			if err := writeRPCMessage(w, rpcResponse{ID: json.RawMessage("null"), Error: &rpcError{rpcParseError, err.Error()}}); err != nil {
+				return err
+			}
+			continue
+		}
+		result, rpcErr := s.call(req)
+		if err := takePluginError(); err != nil && rpcErr == nil {
+			result, rpcErr = nil, &rpcError{rpcFailed, err.Error()}
+		}
+		if req.ID == nil {
+			continue // a notification
+		}
+		if err := writeRPCMessage(w, rpcResponse{ID: req.ID, Result: result, Error: rpcErr}); err != nil {
+			return err
+		}
+	}
+}
+
+// call answers a JSON-RPC request
+func (s *server) call(req rpcRequest) (any, *rpcError) {
+	switch req.Method {
+	case "actions":
+		var pos rpcPosition
+		if err := json.Unmarshal(req.Params, &pos); err != nil || pos.Filename == "" || pos.Line == 0 {
+			return nil, &rpcError{rpcInvalidParams, "expected a filename and line"}
+		}
+		fn := s.graph.funcAt(pos)
+		if fn == nil {
+			return nil, &rpcError{rpcFailed, fmt.Sprintf("no reachable function at %s:%d", pos.Filename, pos.Line)}
+		}
+		actions := s.graph.reachableActions([]*ssa.Function{fn})
+		if actions == nil {
+			actions = []string{}
+		}
+		return rpcActionsResult{Function: cleanName(fn), Actions: actions}, nil
+
+	case "why":
+		var params rpcWhyParams
+		if err := json.Unmarshal(req.Params, &params); err != nil || params.Query == "" {
+			return nil, &rpcError{rpcInvalidParams, "expected a query"}
+		}
+		if params.Filename != "" {
+			fn := s.graph.funcAt(params.rpcPosition)
+			if fn == nil {
+				return nil, &rpcError{rpcFailed, fmt.Sprintf("no reachable function at %s:%d", params.Filename, params.Line)}
+			}
+			params.Via = cleanName(fn)
+		}
+		results, err := s.whyResults(params.whyRequest)
+		if err != nil {
+			return nil, &rpcError{rpcFailed, err.Error()}
+		}
+		return results, nil
+
+	case "reload":
+		if err := s.reload(); err != nil {
+			return nil, &rpcError{rpcFailed, err.Error()}
+		}
+		return struct{}{}, nil
+	}
+	return nil, &rpcError{rpcMethodNotFound, "unknown method " + req.Method}
+}
+
+// funcAt returns the innermost reachable function in the analyzed packages
+// whose source contains a position, or nil if there's none
+func (g *graph) funcAt(pos rpcPosition) *ssa.Function {
+	filename, err := filepath.Abs(pos.Filename)
+	if err != nil {
+		return nil
+	}
+
+	var found *ssa.Function
+	var foundSize int
+	for fn := range g.Reachable {
+		if fn.Synthetic != "" || fn.Syntax() == nil || fn.Origin() != nil {
+			continue
+		}
+		start := g.Prog.Fset.Position(fn.Syntax().Pos())
+		end := g.Prog.Fset.Position(fn.Syntax().End())
+		if start.Filename != filename || !within(pos, start, end) {
+			continue
+		}
+		if size := fn.Syntax().End() - fn.Syntax().Pos(); found == nil || int(size) < foundSize {
+			found, foundSize = fn, int(size)
+		}
+	}
+	return found
+}
+
+// within returns whether a position is between two others
+func within(pos rpcPosition, start, end token.Position) bool {
+	if pos.Line < start.Line || pos.Line > end.Line {
+		return false
+	}
+	if pos.Column == 0 {
+		return true
+	}
+	return (pos.Line > start.Line || pos.Column >= start.Column) && (pos.Line < end.Line || pos.Column <= end.Column)
+}
+
+// readRPCMessage reads the body of a message framed with a Content-Length
+// header
+func readRPCMessage(r *bufio.Reader) ([]byte, error) {
+	length := -1
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break // end of the headers
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if ok && strings.EqualFold(strings.TrimSpace(name), "Content-Length") {
+			if length, err = strconv.Atoi(strings.TrimSpace(value)); err != nil {
+				return nil, fmt.Errorf("invalid Content-Length: %w", err)
+			}
+		}
+	}
+	if length < 0 {
+		return nil, errors.New("missing Content-Length header")
+	}
+	body := make([]byte, length)
+	_, err := io.ReadFull(r, body)
+	return body, err
+}
+
+// writeRPCMessage writes a message framed with a Content-Length header
+func writeRPCMessage(w io.Writer, resp rpcResponse) error {
+	resp.JSONRPC = "2.0"
+	body, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	return err
+}
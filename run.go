@@ -0,0 +1,522 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/esprimo/iamgo/internal/render"
+)
+
+// options are what a run is asked to do, from the flags and the project
+// configuration. main checks them and hands them to the run function of the
+// selected mode, see modes
+type options struct {
+	command string
+	// Package patterns, module directories or other arguments, e.g. the
+	// services of the actions command
+	args []string
+	cfg  config
+	// How the program is loaded and analyzed
+	analyze analyzeConfig
+	// Stop if loading the program takes longer than this, see -timeout
+	timeout time.Duration
+
+	format            string
+	includeReflection bool
+	sdkCalls          bool
+	counts            bool
+	versions          bool
+	risk              bool
+	// Up to how many call paths to show, see -paths
+	paths int
+	// What -format eks, -split-read-write and -boundary-services add to the
+	// rendered policies
+	render render.Options
+
+	// What happens before the result of a single program is printed, see
+	// graph
+	debugDetect         bool
+	inferResources      bool
+	cloudTrailResources string
+	exportGraph         string
+
+	// The -why queries and how their call paths are shown
+	why     []string
+	whyOpts whyOptions
+
+	// Flags of the commands
+	lockfile string
+	output   string
+	template string
+	write    bool
+	from, to string
+	since    string
+	diff     string
+	post     string
+	repo     string
+	pr       int
+	addr     string
+	webhooks []string
+
+	// Flags of the modes comparing the required actions with something else
+	checkPolicy, checkRole, checkTerraform string
+	terraformRole                          string
+	noNewAccess                            bool
+	cloudTrail, cloudTrailRole             string
+	iamlive                                string
+	expect, forbid                         string
+}
+
+// load analyzes the program, exiting if it fails
+func (o *options) load(ctx context.Context) *graph {
+	graph, err := analyze(ctx, o.analyze)
+	if err != nil {
+		fatalFailure(codeLoad, "failed to analyze", err)
+	}
+	if o.debugDetect {
+		graph.logDetection()
+	}
+	return graph
+}
+
+// graph analyzes the program for the modes that print a result about it,
+// narrowing the resources of the policies down for -infer-resources and
+// -cloudtrail-resources and writing the call graph for -export-graph
+func (o *options) graph(ctx context.Context) *graph {
+	graph := o.load(ctx)
+
+	// The -infer-resources flag narrows the resources of the generated
+	// policies down to the ones named in the code
+	if o.inferResources {
+		o.cfg.Resources = withResources(o.cfg.Resources, graph.inferResources(graph.SDKCalls(o.includeReflection), o.cfg.Suppress), "constants in the code")
+	}
+
+	// The -cloudtrail-resources flag does the same with the resources the
+	// program was seen using, for the actions whose resources are still
+	// unknown
+	if o.cloudTrailResources != "" {
+		observed, err := cloudTrailResources(o.cloudTrailResources, o.cloudTrailRole)
+		if err != nil {
+			fatal(codeRead, "failed to read CloudTrail events", "err", err)
+		}
+		o.cfg.Resources = withResources(o.cfg.Resources, observed, "CloudTrail events")
+	}
+
+	// The -export-graph flag saves the relevant part of the call graph
+	// for other tools, in addition to the regular output
+	if o.exportGraph != "" {
+		if err := graph.exportGraph(o.exportGraph); err != nil {
+			fatal(codeWrite, "failed to export graph", "err", err)
+		}
+	}
+	return graph
+}
+
+// runActions lists what's in the mapping, without analyzing anything
+func runActions(_ context.Context, o *options) {
+	services := o.args
+	if len(services) == 0 {
+		services = []string{""}
+	}
+	var actions []string
+	for _, service := range services {
+		known := knownActions(service, o.analyze.libraries)
+		if len(known) == 0 {
+			fatal(codeNotFound, "no known actions of the service "+service)
+		}
+		actions = append(actions, known...)
+	}
+	if o.format == "json" {
+		if err := printJSON(actions); err != nil {
+			fatal(codeWrite, "failed to write JSON", "err", err)
+		}
+		return
+	}
+	for _, action := range actions {
+		fmt.Println(action)
+	}
+}
+
+// runMerge combines saved reports, e.g. of every service in an account,
+// without analyzing anything
+func runMerge(_ context.Context, o *options) {
+	reports, err := readReports(interspersedArgs())
+	if err != nil {
+		fatal(codeRead, "failed to read report", "err", err)
+	}
+	if err := writeReport(o.output, mergeReports(reports)); err != nil {
+		fatal(codeWrite, "failed to write merged report", "err", err)
+	}
+}
+
+// runDiff analyzes two revisions of the program rather than the one in the
+// working directory, or two directories, or compares two saved reports
+func runDiff(ctx context.Context, o *options) {
+	switch {
+	case o.from != "":
+		oldGraph, err := revisionGraph(ctx, o.analyze, o.from)
+		if err != nil {
+			fatalFailure(codeLoad, "failed to analyze", err)
+		}
+		graph, err := revisionGraph(ctx, o.analyze, o.to)
+		if err != nil {
+			fatalFailure(codeLoad, "failed to analyze", err)
+		}
+		old := graphReport(oldGraph, o.includeReflection, o.cfg.Suppress)
+		printDiff(old, graphReport(graph, o.includeReflection, o.cfg.Suppress), graph, o.sdkCalls)
+	case len(o.args) == 2:
+		old, _ := pathReport(ctx, o.analyze, o.args[0], o.includeReflection, o.cfg.Suppress)
+		new, graph := pathReport(ctx, o.analyze, o.args[1], o.includeReflection, o.cfg.Suppress)
+		printDiff(old, new, graph, o.sdkCalls)
+	default:
+		fatal(codeUsage, "the diff command needs a git revision to compare from, e.g. -from main, or two directories or JSON reports to compare")
+	}
+}
+
+// runHistory analyzes every tagged revision from -since on
+func runHistory(ctx context.Context, o *options) {
+	entries, err := history(ctx, o.analyze, o.since, o.includeReflection, o.cfg.Suppress)
+	if err != nil {
+		fatal(codeExternal, "failed to list tags", "err", err)
+	}
+	if len(entries) == 0 {
+		fatal(codeUsage, "the history command needs a git repository with tags")
+	}
+	if o.format == "json" {
+		if err := printJSON(entries); err != nil {
+			fatal(codeWrite, "failed to write JSON", "err", err)
+		}
+		return
+	}
+	writeHistory(os.Stdout, entries)
+}
+
+// runComment is the diff command for pull requests
+func runComment(ctx context.Context, o *options) {
+	if o.diff == "" {
+		fatal(codeUsage, "the comment command needs the git revisions to compare, e.g. -diff main...HEAD")
+	}
+	base, head, err := revisionRange(o.diff)
+	if err != nil {
+		fatal(codeUsage, "invalid -diff", "err", err)
+	}
+	oldGraph, err := revisionGraph(ctx, o.analyze, base)
+	if err != nil {
+		fatalFailure(codeLoad, "failed to analyze", err)
+	}
+	graph, err := revisionGraph(ctx, o.analyze, head)
+	if err != nil {
+		fatalFailure(codeLoad, "failed to analyze", err)
+	}
+	old := graphReport(oldGraph, o.includeReflection, o.cfg.Suppress)
+	var body strings.Builder
+	writeComment(&body, old, graphReport(graph, o.includeReflection, o.cfg.Suppress), graph, o.sdkCalls)
+	fmt.Print(body.String())
+	if o.post != "" {
+		if err := postComment(o.post, o.repo, o.pr, body.String()); err != nil {
+			fatal(codeExternal, "failed to post comment", "err", err)
+		}
+	}
+}
+
+// server returns the server of the serve, web and rpc commands, which keep
+// the analyzed program in memory and answer queries about it until they're
+// stopped
+func (o *options) server() *server {
+	// What the notifications of webhooks name
+	wd, _ := os.Getwd()
+	return &server{
+		load:              func() (*graph, error) { return analyzeWithin(o.timeout, o.analyze) },
+		includeReflection: o.includeReflection,
+		cfg:               o.cfg,
+		webhooks:          append(o.webhooks, o.cfg.Webhooks...),
+		program:           strings.Join(o.args, " ") + " in " + wd,
+	}
+}
+
+// runServe answers queries over HTTP, and the web command serves a page
+// about the program too
+func runServe(_ context.Context, o *options) {
+	s := o.server()
+	s.lockfile = o.lockfile
+	s.web = o.command == "web"
+	fatalFailure(codeExternal, "failed to serve", serve(o.addr, s))
+}
+
+// runRPC answers queries over stdin and stdout, for editors
+func runRPC(_ context.Context, o *options) {
+	if err := serveRPC(os.Stdin, os.Stdout, o.server()); err != nil {
+		fatalFailure(codeExternal, "failed to answer JSON-RPC requests", err)
+	}
+}
+
+// runTUI browses the result interactively instead of printing it
+func runTUI(ctx context.Context, o *options) {
+	graph := o.load(ctx)
+	if err := graph.explore(os.Stdin, os.Stdout, actionSet(graph, o.includeReflection, o.cfg.Suppress)); err != nil {
+		fatal(codeRead, "failed to read commands", "err", err)
+	}
+}
+
+// runLock records the required actions so the check command can tell when
+// the code starts needing new ones
+func runLock(ctx context.Context, o *options) {
+	graph := o.graph(ctx)
+	actions := actionSet(graph, o.includeReflection, o.cfg.Suppress)
+	if err := writeLock(o.lockfile, actions); err != nil {
+		fatal(codeWrite, "failed to write lockfile", "err", err)
+	}
+	slog.Info("wrote lockfile", "file", o.lockfile, "actions", len(actions))
+}
+
+// runCheck fails if the code needs actions that aren't in the lockfile
+func runCheck(ctx context.Context, o *options) {
+	graph := o.graph(ctx)
+	locked, err := readActions(o.lockfile)
+	if err != nil {
+		fatal(codeRead, "failed to read lockfile", "err", err)
+	}
+	if len(diffActions(os.Stdout, locked, actionSet(graph, o.includeReflection, o.cfg.Suppress))) > 0 {
+		fatal(codeUnexpectedActions, "the code needs actions that aren't in "+o.lockfile+", review them and run iamgo lock to update it")
+	}
+}
+
+// runChangelog writes the changes of the lockfile over its history and of
+// the code since, or compares two lockfiles without analyzing anything when
+// given them
+func runChangelog(ctx context.Context, o *options) {
+	if len(o.args) == 2 && isFile(o.args[0]) && isFile(o.args[1]) {
+		var versions [2][]string
+		for i, filename := range o.args {
+			actions, err := readActions(filename)
+			if err != nil {
+				fatal(codeRead, "failed to read lockfile", "err", err)
+			}
+			versions[i] = actions
+		}
+		change := lockChange{title: o.args[0] + " to " + o.args[1]}
+		change.added, change.removed = compareActions(versions[0], versions[1])
+		writeChangelog(os.Stdout, []lockChange{change})
+		return
+	}
+
+	graph := o.graph(ctx)
+	history, err := lockHistory(o.lockfile)
+	if err != nil {
+		fatal(codeExternal, "failed to read the history of the lockfile", "err", err)
+	}
+	unreleased := graph.unreleasedChange(history, actionSet(graph, o.includeReflection, o.cfg.Suppress))
+	writeChangelog(os.Stdout, append([]lockChange{unreleased}, history...))
+}
+
+// runInject keeps the policies in a template in sync with the code of each
+// function
+func runInject(ctx context.Context, o *options) {
+	if o.template == "" {
+		fatal(codeUsage, "the inject command needs a template, e.g. -template template.yaml")
+	}
+	graph := o.graph(ctx)
+	if err := inject(graph, o.template, o.write, o.includeReflection, o.cfg, os.Stdout); err != nil {
+		fatal(codeWrite, "failed to inject policies", "err", err)
+	}
+}
+
+// runBinary looks at which SDK calls a compiled binary contains, for when
+// the source isn't available
+func runBinary(_ context.Context, o *options) {
+	printBinary(o.args[0], o.sdkCalls)
+}
+
+// runModules analyzes several modules one at a time, each in its own
+// directory, e.g. "iamgo ./svc-a ./svc-b"
+func runModules(ctx context.Context, o *options) {
+	printModules(ctx, o.analyze, moduleDirs(o.args), o.includeReflection, o.sdkCalls, o.format, o.cfg)
+}
+
+// runAnnotate puts the IAM actions next to the code that needs them
+func runAnnotate(ctx context.Context, o *options) {
+	graph := o.graph(ctx)
+	if err := graph.annotate(os.Stdout, o.write); err != nil {
+		fatal(codeWrite, "failed to annotate source", "err", err)
+	}
+}
+
+// runWhy shows a path of function calls that leads to an AWS SDK call that
+// requires the IAM action
+func runWhy(ctx context.Context, o *options) {
+	graph := o.graph(ctx)
+	if code := printWhy(graph, o.why, o.whyOpts, o.includeReflection, o.format); code != "" {
+		os.Exit(code.exitCode())
+	}
+}
+
+// runDead shows the SDK calls the analysis found no way to reach, which are
+// either dead code or a sign of a missing root
+func runDead(ctx context.Context, o *options) {
+	graph := o.graph(ctx)
+	calls := graph.deadCalls(o.includeReflection)
+	if o.format == "json" {
+		if calls == nil {
+			calls = []deadCall{}
+		}
+		if err := printJSON(calls); err != nil {
+			fatal(codeWrite, "failed to write JSON", "err", err)
+		}
+		return
+	}
+	printDeadCalls(calls)
+}
+
+// runPerClient shows one set of actions per SDK client, for programs that
+// use different credentials for different clients
+func runPerClient(ctx context.Context, o *options) {
+	graph := o.graph(ctx)
+	printPerClient(graph, graph.SDKCalls(o.includeReflection), o.sdkCalls, o.cfg.Suppress)
+}
+
+// runPerBinary shows one set of actions per main package, so that e.g. each
+// Lambda function can get its own role
+func runPerBinary(ctx context.Context, o *options) {
+	graph := o.graph(ctx)
+	if len(graph.Mains) == 0 {
+		fatal(codeNoMain, "-per-binary needs main packages")
+	}
+	printPerBinary(graph, o.includeReflection, o.sdkCalls, o.format, o.cfg)
+}
+
+// checkExisting compares the required actions with an existing policy, or
+// with -no-new-access has IAM Access Analyzer compare the policy generated
+// for them with it, and exits with exitViolation if it isn't enough
+func (o *options) checkExisting(ctx context.Context, graph *graph, existing policyFile) {
+	actions := actionSet(graph, o.includeReflection, o.cfg.Suppress)
+	if !o.noNewAccess {
+		if !printPolicyCheck(existing, actions) {
+			os.Exit(exitViolation)
+		}
+		return
+	}
+	old, err := existing.Document()
+	if err != nil {
+		fatal(codeRead, "failed to read policy", "err", err)
+	}
+	generated, err := json.Marshal(newPolicy(actions, o.cfg.Resources))
+	if err != nil {
+		fatal(codeWrite, "failed to write policy", "err", err)
+	}
+	result, err := checkNoNewAccess(ctx, old, generated)
+	if err != nil {
+		fatal(codeExternal, "failed to check for new access with IAM Access Analyzer", "err", err)
+	}
+	if !printNoNewAccess(result) {
+		os.Exit(exitViolation)
+	}
+}
+
+// runCheckPolicy tells whether an existing policy is enough for the
+// program, and what in it isn't needed
+func runCheckPolicy(ctx context.Context, o *options) {
+	graph := o.graph(ctx)
+	policy, err := readPolicy(o.checkPolicy)
+	if err != nil {
+		fatal(codeRead, "failed to read policy", "file", o.checkPolicy, "err", err)
+	}
+	o.checkExisting(ctx, graph, policy)
+}
+
+// runCheckRole does the same for the policies of a deployed role
+func runCheckRole(ctx context.Context, o *options) {
+	graph := o.graph(ctx)
+	policy, err := rolePolicy(ctx, o.checkRole)
+	if err != nil {
+		fatal(codeExternal, "failed to get the policies of role", "role", o.checkRole, "err", err)
+	}
+	o.checkExisting(ctx, graph, policy)
+}
+
+// runCheckTerraform does the same for the policies a Terraform plan
+// attaches to a role, before it's applied
+func runCheckTerraform(ctx context.Context, o *options) {
+	graph := o.graph(ctx)
+	policy, err := terraformRolePolicy(o.checkTerraform, o.terraformRole)
+	if err != nil {
+		fatal(codeRead, "failed to read the policies of role from terraform", "err", err)
+	}
+	o.checkExisting(ctx, graph, policy)
+}
+
+// runCloudTrail reconciles the analysis with what the program actually does
+// when it runs
+func runCloudTrail(ctx context.Context, o *options) {
+	graph := o.graph(ctx)
+	used, err := cloudTrailActions(o.cloudTrail, o.cloudTrailRole)
+	if err != nil {
+		fatal(codeRead, "failed to read CloudTrail events", "err", err)
+	}
+	if len(used) == 0 {
+		fatal(codeRead, "found no CloudTrail events in "+o.cloudTrail)
+	}
+	printUsageCheck(used, actionSet(graph, o.includeReflection, o.cfg.Suppress))
+}
+
+// runIamlive does the same with the actions iamlive recorded, e.g. while
+// running the tests
+func runIamlive(ctx context.Context, o *options) {
+	graph := o.graph(ctx)
+	used, err := iamliveActions(o.iamlive)
+	if err != nil {
+		fatal(codeRead, "failed to read iamlive output", "err", err)
+	}
+	if len(used) == 0 {
+		fatal(codeRead, "found no actions in "+o.iamlive)
+	}
+	printUsageCheck(used, actionSet(graph, o.includeReflection, o.cfg.Suppress))
+}
+
+// runExpect is for -expect and -forbid, simple guardrails against the code
+// starting to need actions nobody agreed to
+func runExpect(ctx context.Context, o *options) {
+	graph := o.graph(ctx)
+	var expected, forbidden []string
+	if o.expect != "" {
+		var err error
+		if expected, err = readActions(o.expect); err != nil {
+			fatal(codeRead, "failed to read expected actions", "err", err)
+		}
+	}
+	if o.forbid != "" {
+		forbidden = strings.Split(o.forbid, ",")
+	}
+	matches := func(patterns []string, action string) bool {
+		return slices.ContainsFunc(patterns, func(pattern string) bool { return matchAction(pattern, action) })
+	}
+	var unexpected []string
+	for _, action := range actionSet(graph, o.includeReflection, o.cfg.Suppress) {
+		if (o.expect != "" && !matches(expected, action)) || matches(forbidden, action) {
+			unexpected = append(unexpected, action)
+		}
+	}
+	if len(unexpected) > 0 {
+		for _, action := range unexpected {
+			fmt.Printf("+ %s\n", action)
+		}
+		msg := "the code needs actions that aren't expected by " + o.expect
+		switch {
+		case o.expect == "":
+			msg = "the code needs actions forbidden by -forbid"
+		case o.forbid != "":
+			msg += " or are forbidden by -forbid"
+		}
+		fatal(codeUnexpectedActions, msg)
+	}
+}
+
+// runList prints the required actions, see printActions
+func runList(ctx context.Context, o *options) {
+	printActions(o.graph(ctx), o)
+}
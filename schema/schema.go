@@ -0,0 +1,202 @@
+// Package schema defines the structured output of iamgo, e.g. of
+// "iamgo -format json", for programs that read it. The JSON Schema of a
+// Report is in report.schema.json, also available as JSONSchema.
+//
+// The output is versioned: within a version fields are only ever added, and
+// a field is only removed or changed by increasing Version. Every Report
+// has the version it was written with in SchemaVersion
+package schema
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Version is the version of the schema written by this version of iamgo
+const Version = 1
+
+// JSONSchema is the JSON Schema of a Report
+//
+//go:embed report.schema.json
+var JSONSchema []byte
+
+// Report is what a program needs, the JSON output of the list of actions
+type Report struct {
+	// Version of the schema the report was written with. Reports written
+	// before it was versioned have none, and are the same as version 1
+	SchemaVersion int `json:"schema_version"`
+	// IAM actions the program needs
+	Actions []string `json:"actions,omitempty"`
+	// SDK methods, e.g. "s3.GetObject"
+	SDKCalls []string `json:"sdk_calls"`
+	// Actions ignored by //iamgo:ignore comments
+	Ignored []IgnoredAction `json:"ignored,omitempty"`
+	// Helper libraries the program uses that need actions its SDK calls
+	// don't show, e.g. the S3 actions of the SQS Extended Client. Their
+	// actions are included in Actions
+	Libraries []Library `json:"libraries,omitempty"`
+	// The compute service given by -target, or "lambda" for a Lambda
+	// function with -include-runtime-baseline, whose baseline was added
+	Target string `json:"target,omitempty"`
+	// The actions of the baseline of Target, which the program needs to run
+	// there regardless of what it does, e.g. to write its logs. They're
+	// included in Actions
+	Baseline []string `json:"baseline,omitempty"`
+	// Actions only needed by calls that are only reachable through
+	// reflection, which aren't in Actions unless -reflection is given
+	ReflectionOnly []string `json:"reflection_only,omitempty"`
+	// With -test, the actions split by whether the tests, the rest of the
+	// program or both need them
+	Tests *TestActions `json:"tests,omitempty"`
+	// For merged reports, the reports each action comes from
+	Sources map[string][]string `json:"sources,omitempty"`
+	// Resources configured for actions, by action, see the resources
+	// setting of the project configuration
+	Resources map[string][]string `json:"resources,omitempty"`
+	// Environment variables the resources depend on, whose values are left
+	// as placeholders such as "${env:BUCKET}", see -infer-resources
+	Environment []string `json:"environment,omitempty"`
+	// The compliance controls of each action, by action, from the
+	// controls of the project configuration
+	Controls map[string][]string `json:"controls,omitempty"`
+	// With -counts, the number of distinct places in the analyzed packages
+	// that call the SDK in a way that requires each action, by action.
+	// Actions without any, e.g. only required by calls the SDK makes
+	// itself, are left out
+	Counts map[string]int `json:"counts,omitempty"`
+	// With -sdk-versions, the actions required through each SDK, e.g. "v1"
+	// and "v2" of the AWS SDK for Go, by SDK. An action under more than one
+	// is needed by calls through each of them
+	SDKVersions map[string][]string `json:"sdk_versions,omitempty"`
+	// With -risk, the risk level of each action, by action: "read",
+	// "write", "destructive" or "permissions"
+	Risks map[string]string `json:"risks,omitempty"`
+	// With -risk, how much attention the program needs from a security
+	// review: the score of its riskiest action from 1 (read) to 4
+	// (permissions), or 5 if its actions allow privilege escalation
+	RiskScore int `json:"risk_score,omitempty"`
+	// Each SDK call, with where it's made and how it's reached
+	Calls []Call `json:"calls,omitempty"`
+	// With -format json-full, what needs each action and the call paths
+	// to it, by action
+	Provenance []Provenance `json:"provenance,omitempty"`
+	// Warnings found during the analysis
+	Diagnostics []Diagnostic `json:"diagnostics,omitempty"`
+}
+
+// IgnoredAction is an action ignored by //iamgo:ignore comments, as
+// reported next to the list of actions
+type IgnoredAction struct {
+	Action string `json:"action"`
+	// Why, and where, it's ignored
+	Reason   string   `json:"reason,omitempty"`
+	Position Position `json:"position"`
+}
+
+// TestActions splits the actions of a program analyzed with its tests, so
+// the role tests run with (e.g. in CI) and the production role each get
+// only what they need
+type TestActions struct {
+	// Needed only outside of the test files
+	ProductionOnly []string `json:"production_only"`
+	// Needed only by tests, benchmarks, fuzz targets and examples
+	TestsOnly []string `json:"tests_only"`
+	// Needed by both
+	Both []string `json:"both"`
+}
+
+// Library is a helper library the program uses and the actions it needs
+type Library struct {
+	Name    string   `json:"name"`
+	Actions []string `json:"actions"`
+	// Why the library needs them
+	Reason string `json:"reason,omitempty"`
+}
+
+// Call is an SDK method the program calls
+type Call struct {
+	// SDK method, e.g. "s3.GetObject"
+	Method string `json:"method"`
+	// IAM action the call requires, empty if none
+	Action string `json:"action,omitempty"`
+	// SDK the method belongs to, e.g. "v1" or "v2" for the AWS SDK for Go
+	Version string `json:"version,omitempty"`
+	// Where the SDK method is called from outside the SDK, or where a method
+	// value of it is taken
+	CallSites []Position `json:"call_sites"`
+	// A shortest call path from a root to the SDK method, empty if it's
+	// only reachable through reflection
+	Path []Step `json:"path"`
+}
+
+// Provenance is what needs an action, with the call paths to it
+type Provenance struct {
+	Action string `json:"action"`
+	// SDK methods that require it, or functions of helper libraries and
+	// plugins, empty if no call needs it (e.g. a baseline action)
+	Methods []MethodProvenance `json:"methods"`
+}
+
+// MethodProvenance is an SDK method that requires an action, or a
+// function that needs it, and the call paths to it
+type MethodProvenance struct {
+	// e.g. "s3.GetObject", or the full name of a function
+	Method string `json:"method"`
+	// The shortest paths from a root to the method through each place
+	// it's called from
+	Paths []Path `json:"paths"`
+}
+
+// Path is a call path from a root to a function
+type Path struct {
+	// Path of the main package the path starts from
+	Binary string `json:"binary"`
+	// Full name of the function the path starts from
+	Root  string `json:"root"`
+	Steps []Step `json:"steps"`
+}
+
+// Step is a call from one function to another in a call path
+type Step struct {
+	// Full names of the calling and called functions
+	Caller string `json:"caller"`
+	Callee string `json:"callee"`
+	// What kind of call it is, e.g. "static method call"
+	CallType string `json:"call_type"`
+	// Where the call happens
+	CallSite Position `json:"call_site"`
+	// Where the called function is defined
+	Definition Position `json:"definition"`
+}
+
+// Position is a place in a source file
+type Position struct {
+	Filename string `json:"filename"`
+	Line     int    `json:"line"`
+	Column   int    `json:"column"`
+}
+
+// Diagnostic is a warning about the analysis, e.g. a comment that
+// couldn't be read
+type Diagnostic struct {
+	// "warning" or "error"
+	Level   string `json:"level"`
+	Message string `json:"message"`
+	// Attributes of the warning, e.g. the file it's about
+	Details map[string]any `json:"details,omitempty"`
+}
+
+// Read reads a report, failing if it was written with a newer version of
+// the schema than this one
+func Read(r io.Reader) (Report, error) {
+	var report Report
+	if err := json.NewDecoder(r).Decode(&report); err != nil {
+		return report, err
+	}
+	if report.SchemaVersion > Version {
+		return report, fmt.Errorf("report has schema version %d, newer than %d, upgrade iamgo to read it", report.SchemaVersion, Version)
+	}
+	return report, nil
+}
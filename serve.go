@@ -0,0 +1,266 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"go/token"
+	"log/slog"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/esprimo/iamgo/internal/policy"
+)
+
+// server answers queries about a program over HTTP. The program is only
+// analyzed at start and on /reload, so queries are cheap
+type server struct {
+	// analyzes the program
+	load func() (*graph, error)
+	// include calls only reachable through reflection
+	includeReflection bool
+	cfg               config
+	// lockfile to compare the required actions with in /metrics, if it
+	// exists
+	lockfile string
+	// URLs to post the change to when the required actions change, and
+	// what's analyzed to name in it, e.g. "./... in /src/app"
+	webhooks []string
+	program  string
+	// also serve the page of the web command at /
+	web bool
+
+	// A reload replaces the graph, so only one query is answered at a time
+	mu    sync.Mutex
+	graph *graph
+	stats analysisStats
+	// Stamps of the files the graph was loaded from, to skip reloads when
+	// none changed
+	stamps map[string]fileStamp
+	// Webhooks being notified of a change, which the rpc command waits for
+	// before exiting
+	notifications sync.WaitGroup
+}
+
+// whyRequest is the JSON body of a /why request. The options are the same
+// as the -why flags'
+type whyRequest struct {
+	// e.g. "s3:GetObject", "s3:Put*" or "S3.GetObject"
+	Query      string `json:"query"`
+	AllPaths   bool   `json:"all_paths"`
+	Via        string `json:"via"`
+	RootBinary string `json:"root_binary"`
+	Paths      int    `json:"paths"`
+}
+
+// errorResponse is the JSON body of a failed request
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// serve analyzes the program and answers queries about it on an address
+// until it fails, or the first analysis does:
+//
+//	GET  /analyze  the required actions and SDK calls, as with -format json
+//	GET  /policy   a policy allowing the required actions, as with -format policy
+//	POST /why      call paths for a whyRequest, as with -why and -format json
+//	POST /reload   analyze the program again, e.g. after it's changed
+//	GET  /metrics  the required actions by service and more, for Prometheus
+//	GET  /         a page browsing all of the above, for the web command
+func serve(addr string, s *server) error {
+	if err := s.reload(); err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/analyze", s.handle(http.MethodGet, func(r *http.Request) (any, int, error) {
+		rep := graphReport(s.graph, s.includeReflection, s.cfg.Suppress)
+		rep.Ignored = s.graph.ignoredActions()
+		rep.Tests = s.graph.testActions(s.includeReflection, s.cfg.Suppress)
+		rep.Calls = s.graph.sdkCallReports(s.graph.SDKCalls(s.includeReflection), s.cfg.Suppress)
+		return rep, http.StatusOK, nil
+	}))
+	mux.HandleFunc("/policy", s.handle(http.MethodGet, func(r *http.Request) (any, int, error) {
+		actions := actionSet(s.graph, s.includeReflection, s.cfg.Suppress)
+		switch s.graph.provider {
+		case "gcp":
+			return policy.NewRole(actions), http.StatusOK, nil
+		case "azure":
+			return policy.NewAzureRole(actions, iamMap.IsDataAction), http.StatusOK, nil
+		}
+		return newPolicy(actions, s.cfg.Resources), http.StatusOK, nil
+	}))
+	mux.HandleFunc("/why", s.handle(http.MethodPost, s.why))
+	mux.HandleFunc("/reload", s.handle(http.MethodPost, func(r *http.Request) (any, int, error) {
+		if err := s.reload(); err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		return struct{}{}, http.StatusOK, nil
+	}))
+	mux.HandleFunc("/metrics", s.metrics)
+	if s.web {
+		mux.HandleFunc("/", s.page)
+	}
+
+	slog.Info("listening", "url", "http://"+addr)
+	return http.ListenAndServe(addr, mux)
+}
+
+// reload analyzes the program, keeping track of how long it takes, and
+// notifies the webhooks if the actions it requires changed. If the
+// analysis fails, e.g. because the program doesn't build at the moment,
+// the previous one is kept. If none of the files it was loaded from
+// changed since, it's kept too, as a hit of the cache in /metrics
+func (s *server) reload() error {
+	first := s.graph == nil
+	var old []string
+	if !first {
+		if maps.EqualFunc(s.stamps, s.graph.sourceStamps(), fileStamp.equal) {
+			s.stats.hits++
+			return nil
+		}
+		old = actionSet(s.graph, s.includeReflection, s.cfg.Suppress)
+	}
+	start := time.Now()
+	graph, err := s.load()
+	if err != nil {
+		return err
+	}
+	s.graph = graph
+	s.stamps = graph.sourceStamps()
+	took := time.Since(start)
+	s.stats.count++
+	s.stats.total += took
+	s.stats.last = took
+	if !first {
+		s.notifications.Add(1)
+		go s.notifyChange(old, actionSet(s.graph, s.includeReflection, s.cfg.Suppress))
+	}
+	return nil
+}
+
+// fileStamp is what tells whether a file or directory changed, see
+// sourceStamps. It's zero if the file doesn't exist
+type fileStamp struct {
+	size    int64
+	modTime time.Time
+}
+
+func (a fileStamp) equal(b fileStamp) bool {
+	return a.size == b.size && a.modTime.Equal(b.modTime)
+}
+
+// sourceStamps returns the stamps of what the program was loaded from: its
+// source files, the directories they're in and the ones up to the root of
+// the module, which change when a file or package is added, and the files
+// at the root, e.g. go.mod and go.sum
+func (g *graph) sourceStamps() map[string]fileStamp {
+	dir := g.dir
+	if dir == "" {
+		dir, _ = os.Getwd()
+	}
+	root := moduleRoot(dir)
+	stamps := make(map[string]fileStamp)
+	stamp := func(name string) {
+		if _, ok := stamps[name]; ok {
+			return
+		}
+		var st fileStamp
+		if info, err := os.Stat(name); err == nil {
+			st = fileStamp{info.Size(), info.ModTime()}
+		}
+		stamps[name] = st
+	}
+	for _, name := range append(rootFiles, "go.sum", "go.work", "go.work.sum") {
+		stamp(filepath.Join(root, name))
+	}
+	g.Prog.Fset.Iterate(func(f *token.File) bool {
+		stamp(f.Name())
+		for dir := filepath.Dir(f.Name()); ; dir = filepath.Dir(dir) {
+			stamp(dir)
+			if _, ok := relativeTo(root, dir); !ok || dir == root {
+				break
+			}
+		}
+		return true
+	})
+	return stamps
+}
+
+// why answers a /why request
+func (s *server) why(r *http.Request) (any, int, error) {
+	var req whyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	if req.Query == "" {
+		return nil, http.StatusBadRequest, errors.New("missing query")
+	}
+	results, err := s.whyResults(req)
+	if err != nil {
+		return nil, http.StatusNotFound, err
+	}
+	return results, http.StatusOK, nil
+}
+
+// whyResults returns the call paths for a whyRequest
+func (s *server) whyResults(req whyRequest) ([]whyResult, error) {
+	actions, err := s.graph.expandWhyQuery(req.Query, s.includeReflection)
+	if err != nil {
+		return nil, err
+	}
+	opts := whyOptions{
+		all:        req.AllPaths,
+		via:        req.Via,
+		rootBinary: req.RootBinary,
+		paths:      req.Paths,
+	}
+	results := []whyResult{}
+	for _, action := range actions {
+		paths, err := s.graph.whyPaths(action, opts)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, s.graph.whyResult(action, paths))
+	}
+	return results, nil
+}
+
+// handle returns a handler for requests with a method that responds with
+// what fn returns as JSON, or the error if it fails
+func (s *server) handle(method string, fn func(*http.Request) (any, int, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			writeJSON(w, http.StatusMethodNotAllowed, errorResponse{"method not allowed, use " + method})
+			return
+		}
+
+		s.mu.Lock()
+		body, status, err := fn(r)
+		if pluginErr := takePluginError(); pluginErr != nil && err == nil {
+			status, err = http.StatusInternalServerError, pluginErr
+		}
+		s.mu.Unlock()
+		if err != nil {
+			writeJSON(w, status, errorResponse{err.Error()})
+			return
+		}
+		writeJSON(w, status, body)
+	}
+}
+
+// writeJSON writes a response with a JSON body
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	b, err := json.MarshalIndent(body, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(b, '\n'))
+}
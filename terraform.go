@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+)
+
+// tfOutput is the output of "terraform show -json" for a plan or a state,
+// only what's needed to find the policies of a role
+type tfOutput struct {
+	// Of a plan
+	PlannedValues *tfValues `json:"planned_values"`
+	// Of a state
+	Values        *tfValues `json:"values"`
+	Configuration struct {
+		RootModule tfConfigModule `json:"root_module"`
+	} `json:"configuration"`
+}
+
+type tfValues struct {
+	RootModule tfModule `json:"root_module"`
+}
+
+type tfModule struct {
+	Resources    []tfResource `json:"resources"`
+	ChildModules []tfModule   `json:"child_modules"`
+}
+
+type tfResource struct {
+	Address string                     `json:"address"`
+	Mode    string                     `json:"mode"`
+	Type    string                     `json:"type"`
+	Values  map[string]json.RawMessage `json:"values"`
+}
+
+// tfConfigModule is a module in the configuration, which has the
+// references between resources whose values aren't known until they're
+// applied, e.g. the ARN of a policy created by the same plan
+type tfConfigModule struct {
+	Resources []struct {
+		Address     string `json:"address"`
+		Expressions map[string]struct {
+			References []string `json:"references"`
+		} `json:"expressions"`
+	} `json:"resources"`
+	ModuleCalls map[string]struct {
+		Module tfConfigModule `json:"module"`
+	} `json:"module_calls"`
+}
+
+// value returns a value of a resource as a string, or "" if it isn't one,
+// e.g. because it's unknown until the plan is applied
+func (r tfResource) value(key string) string {
+	var s string
+	json.Unmarshal(r.Values[key], &s)
+	return s
+}
+
+// terraformRolePolicy reads the output of "terraform show -json" for a plan
+// or state and returns the policies attached to an IAM role in it, combined
+// into one policy. The role is given by its address (e.g.
+// "aws_iam_role.app") or name, and may be left empty if there's only one.
+// Policies are attached through the inline_policy of the role,
+// aws_iam_role_policy, aws_iam_role_policy_attachment and
+// aws_iam_policy_attachment. Managed policies that aren't in the plan, such
+// as the ones AWS provides, can't be read and are left out with a warning
+func terraformRolePolicy(filename, role string) (policyFile, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return policyFile{}, err
+	}
+	var out tfOutput
+	if err := json.Unmarshal(data, &out); err != nil {
+		return policyFile{}, fmt.Errorf("%s: %w", filename, err)
+	}
+	values := out.PlannedValues
+	if values == nil {
+		values = out.Values
+	}
+	if values == nil {
+		return policyFile{}, fmt.Errorf("%s: no planned_values or values, is it the output of terraform show -json?", filename)
+	}
+
+	var resources []tfResource
+	var walk func(m tfModule)
+	walk = func(m tfModule) {
+		for _, r := range m.Resources {
+			if r.Mode == "managed" {
+				resources = append(resources, r)
+			}
+		}
+		for _, c := range m.ChildModules {
+			walk(c)
+		}
+	}
+	walk(values.RootModule)
+	references := make(map[string]map[string][]string)
+	out.Configuration.RootModule.references("", references)
+
+	// Find the role
+	var roles []tfResource
+	for _, r := range resources {
+		if r.Type == "aws_iam_role" && (role == "" || r.Address == role || r.value("name") == role) {
+			roles = append(roles, r)
+		}
+	}
+	switch {
+	case len(roles) == 0 && role == "":
+		return policyFile{}, fmt.Errorf("%s: no aws_iam_role", filename)
+	case len(roles) == 0:
+		return policyFile{}, fmt.Errorf("%s: no aws_iam_role with address or name %s", filename, role)
+	case len(roles) > 1:
+		var addresses []string
+		for _, r := range roles {
+			addresses = append(addresses, r.Address)
+		}
+		return policyFile{}, fmt.Errorf("%s: several roles, choose one with -terraform-role: %s", filename, strings.Join(addresses, ", "))
+	}
+	roleResource := roles[0]
+	roleName := roleResource.value("name")
+
+	// refers returns whether an attribute of a resource is the role, by
+	// its name or, if that isn't known yet, by a reference to it
+	refers := func(r tfResource, key string, target tfResource) bool {
+		var names []string
+		if err := json.Unmarshal(r.Values[key], &names); err != nil {
+			names = []string{r.value(key)}
+		}
+		name := target.value("name")
+		if target.Type == "aws_iam_policy" {
+			name = target.value("arn")
+		}
+		if name != "" && slices.Contains(names, name) {
+			return true
+		}
+		return slices.ContainsFunc(references[configAddress(r.Address)][key], func(ref string) bool {
+			return ref == configAddress(target.Address)
+		})
+	}
+
+	var policy policyFile
+	add := func(document, source string) error {
+		p, err := parsePolicy([]byte(document))
+		if err != nil {
+			return fmt.Errorf("%s: %w", source, err)
+		}
+		policy.Add(p)
+		return nil
+	}
+	addManaged := func(arn string, attachment tfResource) error {
+		for _, p := range resources {
+			if p.Type == "aws_iam_policy" && ((arn != "" && p.value("arn") == arn) || refers(attachment, "policy_arn", p)) {
+				return add(p.value("policy"), p.Address)
+			}
+		}
+		slog.Warn("managed policy isn't in the plan, leaving it out", "policy", arn, "attachment", attachment.Address)
+		return nil
+	}
+
+	var inline []struct {
+		Name   string `json:"name"`
+		Policy string `json:"policy"`
+	}
+	json.Unmarshal(roleResource.Values["inline_policy"], &inline)
+	for _, p := range inline {
+		if p.Policy == "" {
+			continue // the block that removes all inline policies
+		}
+		if err := add(p.Policy, roleResource.Address+" inline_policy "+p.Name); err != nil {
+			return policyFile{}, err
+		}
+	}
+	var managedARNs []string
+	json.Unmarshal(roleResource.Values["managed_policy_arns"], &managedARNs)
+	for _, arn := range managedARNs {
+		if err := addManaged(arn, roleResource); err != nil {
+			return policyFile{}, err
+		}
+	}
+
+	for _, r := range resources {
+		var err error
+		switch r.Type {
+		case "aws_iam_role_policy":
+			if refers(r, "role", roleResource) {
+				err = add(r.value("policy"), r.Address)
+			}
+		case "aws_iam_role_policy_attachment":
+			if refers(r, "role", roleResource) {
+				err = addManaged(r.value("policy_arn"), r)
+			}
+		case "aws_iam_policy_attachment":
+			if refers(r, "roles", roleResource) {
+				err = addManaged(r.value("policy_arn"), r)
+			}
+		}
+		if err != nil {
+			return policyFile{}, err
+		}
+	}
+	slog.Debug("read role from terraform", "role", roleResource.Address, "name", roleName, "statements", len(policy.Statement))
+	return policy, nil
+}
+
+// references collects the references of each attribute of each resource
+// in a module of the configuration and its child modules, by the address
+// of the resource
+func (m tfConfigModule) references(prefix string, refs map[string]map[string][]string) {
+	for _, r := range m.Resources {
+		address := prefix + r.Address
+		refs[address] = make(map[string][]string)
+		for key, e := range r.Expressions {
+			for _, ref := range e.References {
+				// References are relative to the module, and to an
+				// attribute of a resource as well as the resource itself
+				refs[address][key] = append(refs[address][key], prefix+ref)
+			}
+		}
+	}
+	for name, call := range m.ModuleCalls {
+		call.Module.references(prefix+"module."+name+".", refs)
+	}
+}
+
+// configAddress returns the address of a resource in the configuration,
+// i.e. without the instance keys of count and for_each, e.g.
+// "module.app.aws_iam_role.this" for "module.app[0].aws_iam_role.this[0]"
+func configAddress(address string) string {
+	var b strings.Builder
+	depth := 0
+	for _, c := range address {
+		switch {
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+		case depth == 0:
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
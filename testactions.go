@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"golang.org/x/tools/go/callgraph"
+	"golang.org/x/tools/go/ssa"
+
+	"github.com/esprimo/iamgo/schema"
+)
+
+// testActions returns the actions the roots in test files (and the main
+// packages of test binaries) need, the ones the other roots need, and
+// both, or nil if the program was loaded without its tests
+func (g *graph) testActions(includeReflection bool, suppress []string) *schema.TestActions {
+	if !g.tests {
+		return nil
+	}
+	var production, tests []*ssa.Function
+	for _, root := range g.Roots {
+		if g.isTestRoot(root) {
+			tests = append(tests, root)
+		} else {
+			production = append(production, root)
+		}
+	}
+	fns := g.SDKCalls(includeReflection)
+	noSkip := func(*callgraph.Edge) bool { return false }
+	_, prodActions := g.reachedActions(fns, g.ReachableFrom(production, noSkip), includeReflection, suppress)
+	_, testActions := g.reachedActions(fns, g.ReachableFrom(tests, noSkip), includeReflection, suppress)
+	return splitTestActions(prodActions, testActions)
+}
+
+// isTestRoot reports whether a root is only run by go test: it's in a test
+// file, or in the main package go test generates for a test binary
+func (g *graph) isTestRoot(root *ssa.Function) bool {
+	if root.Pkg != nil && strings.HasSuffix(root.Pkg.Pkg.Path(), ".test") {
+		return true
+	}
+	return strings.HasSuffix(g.Prog.Fset.Position(root.Pos()).Filename, "_test.go")
+}
+
+// splitTestActions splits the sorted actions the production code and the
+// tests need into the ones only either needs and the ones both need
+func splitTestActions(production, tests []string) *schema.TestActions {
+	split := &schema.TestActions{ProductionOnly: []string{}, TestsOnly: []string{}, Both: []string{}}
+	for _, action := range production {
+		if slices.Contains(tests, action) {
+			split.Both = append(split.Both, action)
+		} else {
+			split.ProductionOnly = append(split.ProductionOnly, action)
+		}
+	}
+	for _, action := range tests {
+		if !slices.Contains(production, action) {
+			split.TestsOnly = append(split.TestsOnly, action)
+		}
+	}
+	return split
+}
+
+// logTestActions outputs which of the actions only the tests need, only
+// the production code needs, and both need to stderr, so the output stays
+// a plain list
+func logTestActions(split *schema.TestActions) {
+	if split == nil {
+		return
+	}
+	for _, set := range []struct {
+		name    string
+		actions []string
+	}{
+		{"only needed by tests", split.TestsOnly},
+		{"only needed outside of tests", split.ProductionOnly},
+		{"needed by both tests and the rest of the program", split.Both},
+	} {
+		slog.Info(fmt.Sprintf("%s %s", plural(len(set.actions), "action"), set.name), "actions", strings.Join(set.actions, ","))
+	}
+}
@@ -0,0 +1,16 @@
+// Command app is analyzed by the tests of iamgo
+package main
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+)
+
+func main() {
+	ctx := context.Background()
+	client := iam.New(iam.Options{})
+	client.ListRoles(ctx, nil)
+	//iamgo:ignore iam:GetUser only to show who runs it
+	client.GetUser(ctx, nil)
+}
@@ -0,0 +1,19 @@
+// Command sdkinternal is analyzed by the tests of iamgo. Loading the
+// configuration makes the SDK call STS, SSO and SSO OIDC itself
+package main
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+)
+
+func main() {
+	ctx := context.Background()
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		panic(err)
+	}
+	iam.NewFromConfig(cfg).ListRoles(ctx, nil)
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/go/ssa"
+
+	"github.com/esprimo/iamgo/internal/analysis"
+	"github.com/esprimo/iamgo/internal/mapping"
+)
+
+// tuiView is a numbered list the tui command shows, e.g. the actions of a
+// service
+type tuiView struct {
+	title string
+	items []tuiItem
+	// only show the items containing this, see /
+	filter string
+}
+
+// tuiItem is an entry of a tuiView
+type tuiItem struct {
+	label string
+	// returns the view the item leads to, nil if it leads nowhere
+	open func() *tuiView
+}
+
+const tuiHelp = `  N      open entry N
+  /TEXT  only show the entries containing TEXT, ignoring case, / alone shows all of them
+  b      go back
+  q      quit
+`
+
+// explore lets the user browse the required actions by service, the call
+// paths to each action and the actions reachable from each function on a
+// path, reading commands from r, for the tui command. Jumping back and forth
+// between actions and the functions that reach them is cheaper than running
+// -why for each
+func (g *graph) explore(r io.Reader, w io.Writer, actions []string) error {
+	stack := []*tuiView{g.servicesView(actions)}
+	in := bufio.NewScanner(r)
+	for {
+		view := stack[len(stack)-1]
+		writeView(w, view)
+		fmt.Fprint(w, "> ")
+		if !in.Scan() {
+			fmt.Fprintln(w)
+			return in.Err()
+		}
+		cmd := strings.TrimSpace(in.Text())
+		switch {
+		case cmd == "":
+		case cmd == "q":
+			return nil
+		case cmd == "b":
+			if len(stack) > 1 {
+				stack = stack[:len(stack)-1]
+			}
+		case cmd == "?":
+			fmt.Fprint(w, tuiHelp)
+		case strings.HasPrefix(cmd, "/"):
+			view.filter = strings.TrimPrefix(cmd, "/")
+		default:
+			n, err := strconv.Atoi(cmd)
+			if err != nil || n < 1 || n > len(view.items) {
+				fmt.Fprintf(w, "unknown command %q, ? lists the commands\n", cmd)
+				continue
+			}
+			if item := view.items[n-1]; item.open != nil {
+				stack = append(stack, item.open())
+			}
+		}
+	}
+}
+
+// writeView writes the title and the items of a view that match its
+// filter, numbered by their place in the whole view so the numbers don't
+// change with the filter
+func writeView(w io.Writer, view *tuiView) {
+	fmt.Fprintf(w, "\n%s\n", view.title)
+	if view.filter != "" {
+		fmt.Fprintf(w, "(containing %q)\n", view.filter)
+	}
+	for i, item := range view.items {
+		if strings.Contains(strings.ToLower(item.label), strings.ToLower(view.filter)) {
+			fmt.Fprintf(w, "%4d  %s\n", i+1, item.label)
+		}
+	}
+	if len(view.items) == 0 {
+		fmt.Fprintln(w, "      (none)")
+	}
+}
+
+// servicesView lists the services of the required actions
+func (g *graph) servicesView(actions []string) *tuiView {
+	byService := make(map[string][]string)
+	for _, action := range actions {
+		service := mapping.Service(action)
+		byService[service] = append(byService[service], action)
+	}
+	services := make([]string, 0, len(byService))
+	for service := range byService {
+		services = append(services, service)
+	}
+	slices.Sort(services)
+
+	view := &tuiView{title: "Services the program needs actions of:"}
+	for _, service := range services {
+		service := service
+		view.items = append(view.items, tuiItem{
+			label: fmt.Sprintf("%s (%s)", service, plural(len(byService[service]), "action")),
+			open: func() *tuiView {
+				return g.actionsView("Actions of "+service+":", byService[service], nil)
+			},
+		})
+	}
+	return view
+}
+
+// actionsView lists actions, leading to their call paths through via, or
+// any if via is nil
+func (g *graph) actionsView(title string, actions []string, via *ssa.Function) *tuiView {
+	view := &tuiView{title: title}
+	for _, action := range actions {
+		action := action
+		view.items = append(view.items, tuiItem{
+			label: action,
+			open:  func() *tuiView { return g.pathsView(action, via) },
+		})
+	}
+	return view
+}
+
+// pathsView lists the functions on the call paths to the SDK calls that
+// require an action, through each place they're called from, leading to
+// the actions reachable from each function. Only the functions of the
+// analyzed packages and the SDK call are listed, the calls in between
+// only tell where the SDK goes from there
+func (g *graph) pathsView(action string, via *ssa.Function) *tuiView {
+	opts := whyOptions{all: true, paths: 1}
+	title := "Call paths to " + action + ":"
+	if via != nil {
+		opts.via = cleanName(via)
+		title = "Call paths to " + action + " through " + cleanName(via) + ":"
+	}
+	view := &tuiView{title: title}
+	paths, err := g.whyPaths(action, opts)
+	if err != nil {
+		view.title = err.Error()
+		return view
+	}
+	var seen []string
+	for _, path := range paths {
+		if len(path) == 0 {
+			continue
+		}
+		items := []tuiItem{g.functionItem(cleanName(path[0].Caller.Func), path[0].Caller.Func)}
+		skipped := 0
+		for i, edge := range path {
+			if i < len(path)-1 && !slices.Contains(g.Packages, analysis.CodePackage(edge.Callee.Func)) {
+				skipped++
+				continue
+			}
+			if skipped > 0 {
+				items = append(items, tuiItem{label: fmt.Sprintf("        ... %d calls in libraries", skipped)})
+				skipped = 0
+			}
+			s := g.createStep(edge)
+			label := fmt.Sprintf("        calls %s at %s:%d", s.fullName, s.callComingFromFilename, s.callComingFromLine)
+			items = append(items, g.functionItem(label, edge.Callee.Func))
+		}
+		// Paths through different places in the SDK look the same
+		var key strings.Builder
+		for _, item := range items {
+			key.WriteString(item.label + "\n")
+		}
+		if slices.Contains(seen, key.String()) {
+			continue
+		}
+		seen = append(seen, key.String())
+		items[0].label = fmt.Sprintf("path %d  %s", len(seen), items[0].label)
+		view.items = append(view.items, items...)
+	}
+	return view
+}
+
+// functionItem is an item for a function on a call path, leading to the
+// actions reachable from it
+func (g *graph) functionItem(label string, fn *ssa.Function) tuiItem {
+	return tuiItem{
+		label: label,
+		open: func() *tuiView {
+			return g.actionsView("Actions reachable from "+cleanName(fn)+":", g.reachableActions([]*ssa.Function{fn}), fn)
+		},
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/esprimo/iamgo/internal/mapping"
+)
+
+// printVersion outputs what produced a result: the version of iamgo and
+// the commit it was built from, the Go toolchain, and where the embedded
+// mapping comes from along with a hash that identifies it exactly
+func printVersion(w io.Writer) {
+	version := "(unknown)"
+	var revision, buildTime string
+	var modified bool
+	if info, ok := debug.ReadBuildInfo(); ok {
+		// "(devel)" unless installed with go install ...@version
+		version = info.Main.Version
+		for _, s := range info.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				revision = s.Value
+			case "vcs.time":
+				buildTime = s.Value
+			case "vcs.modified":
+				modified = s.Value == "true"
+			}
+		}
+	}
+	fmt.Fprintf(w, "iamgo %s", version)
+	if revision != "" {
+		fmt.Fprintf(w, " (commit %s, %s", revision, buildTime)
+		if modified {
+			fmt.Fprint(w, ", modified")
+		}
+		fmt.Fprint(w, ")")
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "built with %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+
+	var m struct {
+		Info                 string                     `json:"info"`
+		SDKMethodIAMMappings map[string]json.RawMessage `json:"sdk_method_iam_mappings"`
+	}
+	if err := json.Unmarshal(mapping.JSON, &m); err != nil {
+		fmt.Fprintf(w, "mapping: %v\n", err)
+		return
+	}
+	fmt.Fprintf(w, "mapping: %d SDK methods, sha256 %x\n", len(m.SDKMethodIAMMappings), sha256.Sum256(mapping.JSON))
+	fmt.Fprintf(w, "  %s\n", m.Info)
+}
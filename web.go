@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"html/template"
+	"log/slog"
+	"net/http"
+)
+
+// webPage is the page of the web command, with the program analyzed
+//
+//go:embed web.html
+var webPage string
+
+var webTemplate = template.Must(template.New("web").Parse(webPage))
+
+// page answers / with a page that lists the required actions, draws the
+// call paths to them and previews the policy, all through the queries of
+// serve
+func (s *server) page(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed, use GET", http.StatusMethodNotAllowed)
+		return
+	}
+	// Rendered in full first, so a failure is an error rather than half a
+	// page
+	var page bytes.Buffer
+	if err := webTemplate.Execute(&page, s.program); err != nil {
+		slog.Error("failed to render the page", "err", err)
+		http.Error(w, "failed to render the page", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(page.Bytes())
+}
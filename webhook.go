@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// webhookClient posts to webhooks. An endpoint that doesn't answer is given
+// up on rather than waited for forever
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// actionChange is the JSON body posted to webhooks when the required
+// actions change. Text makes it a message for Slack incoming webhooks,
+// and for the many chat tools compatible with them
+type actionChange struct {
+	Text    string   `json:"text"`
+	Program string   `json:"program"`
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+}
+
+// notifyChange posts the actions added and removed since the last
+// analysis of a server to its webhooks, if they changed. It's run in its
+// own goroutine, so queries aren't held up by slow webhooks, see
+// server.notifications
+func (s *server) notifyChange(old, new []string) {
+	defer s.notifications.Done()
+	added, removed := compareActions(old, new)
+	if len(s.webhooks) == 0 || len(added)+len(removed) == 0 {
+		return
+	}
+	var diff strings.Builder
+	diffActions(&diff, old, new)
+	change := actionChange{
+		Text:    fmt.Sprintf("The IAM actions %s requires changed:\n```\n%s```", s.program, diff.String()),
+		Program: s.program,
+		Added:   append([]string{}, added...),
+		Removed: append([]string{}, removed...),
+	}
+	for _, endpoint := range s.webhooks {
+		if err := postWebhook(endpoint, change); err != nil {
+			// Only the host, the URLs of webhooks are often secrets
+			host := "?"
+			if u, err := url.Parse(endpoint); err == nil {
+				host = u.Host
+			}
+			slog.Warn("failed to notify webhook of changed actions", "code", codeExternal, "host", host, "err", err)
+		}
+	}
+}
+
+// postWebhook posts a change to a webhook. Errors leave the URL out
+func postWebhook(endpoint string, change actionChange) error {
+	data, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+	resp, err := webhookClient.Post(endpoint, "application/json", bytes.NewReader(data))
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return urlErr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	"golang.org/x/tools/go/callgraph"
+	"golang.org/x/tools/go/ssa"
+
+	"github.com/esprimo/iamgo/internal/sdk"
+	"github.com/esprimo/iamgo/schema"
+)
+
+// whyOptions controls which call paths -why shows
+type whyOptions struct {
+	// show one path per place the function is called from rather than
+	// only the first path found
+	all bool
+	// only show paths through this function, see findFuncs for the format
+	via string
+	// only show paths starting from this main package, given by its full
+	// path or the name of the binary (the last element of the path)
+	rootBinary string
+	// show up to this many paths (per place the function is called from
+	// if all is set) that pass through different functions
+	paths int
+}
+
+// whyResult is the JSON output of -why
+type whyResult struct {
+	// The -why query, or the action a pattern in it matched
+	Query string    `json:"query"`
+	Paths []whyPath `json:"paths"`
+}
+
+// whyPath is a call path from a root to the function a -why query refers to
+type whyPath = schema.Path
+
+// whyStep is a call from one function to another in a whyPath
+type whyStep = schema.Step
+
+// position is a place in a source file
+type position = schema.Position
+
+// whyResult converts the paths found for a -why query to the JSON output
+func (g *graph) whyResult(query string, paths [][]*callgraph.Edge) whyResult {
+	res := whyResult{Query: query, Paths: []whyPath{}}
+	for _, path := range paths {
+		p := whyPath{Binary: binaryOf(path), Steps: append([]whyStep{}, g.steps(path)...)}
+		if len(path) > 0 {
+			p.Root = cleanName(path[0].Caller.Func)
+		}
+		res.Paths = append(res.Paths, p)
+	}
+	return res
+}
+
+// steps converts a call path to the steps of the JSON output
+func (g *graph) steps(path []*callgraph.Edge) []whyStep {
+	var steps []whyStep
+	for _, edge := range path {
+		s := g.createStep(edge)
+		steps = append(steps, whyStep{
+			Caller:     cleanName(edge.Caller.Func),
+			Callee:     s.fullName,
+			CallType:   s.callType,
+			CallSite:   position{Filename: s.callComingFromFilename, Line: s.callComingFromLine, Column: s.callComingFromColumn},
+			Definition: position{Filename: s.filename, Line: s.line, Column: s.column},
+		})
+	}
+	return steps
+}
+
+// expandWhyQuery returns the sorted required actions an action pattern in a
+// -why query, e.g. "s3:Put*", matches. Other queries are returned as-is
+func (g *graph) expandWhyQuery(query string, includeReflection bool) ([]string, error) {
+	if !strings.Contains(query, ":") || !strings.ContainsAny(query, "*?") {
+		return []string{query}, nil
+	}
+
+	var matches []string
+	for _, fn := range g.SDKCalls(includeReflection) {
+		action := sdkMethodToAction(g.detectors.MethodName(fn))
+		if action != "" && matchAction(query, action) && !slices.Contains(matches, action) {
+			matches = append(matches, action)
+		}
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("no required action matches %s", query)
+	}
+	slices.Sort(matches)
+	return matches, nil
+}
+
+// noPathError is returned by whyPaths when nothing reachable requires what
+// a query names, e.g. an action that's only in the baseline, rather than
+// when the query or its options are wrong
+type noPathError struct {
+	error
+}
+
+// whyPaths returns call paths from a root to the functions a -why query
+// refers to, see whyTargets
+func (g *graph) whyPaths(query string, opts whyOptions) ([][]*callgraph.Edge, error) {
+	targets, err := g.whyTargets(query)
+	if err != nil {
+		return nil, err
+	}
+
+	var via []*ssa.Function
+	if opts.via != "" {
+		via = g.findFuncs(opts.via)
+		if len(via) == 0 {
+			return nil, fmt.Errorf("didn't find any reachable function named %s", opts.via)
+		}
+	}
+
+	roots := g.Roots
+	if opts.rootBinary != "" {
+		roots = g.binaryRoots(opts.rootBinary)
+		if len(roots) == 0 {
+			return nil, fmt.Errorf("didn't find any main package named %s", opts.rootBinary)
+		}
+	}
+
+	n := max(opts.paths, 1)
+	var paths [][]*callgraph.Edge
+	for _, fn := range targets {
+		if !opts.all {
+			paths = append(paths, g.pathsVia(roots, fn, via, n-len(paths))...)
+			if len(paths) == n {
+				break // only the first matches we find
+			}
+			continue
+		}
+		paths = append(paths, g.pathsPerCallSite(roots, fn, via, n)...)
+	}
+	if len(paths) == 0 && via != nil {
+		return nil, noPathError{fmt.Errorf("no call path through %s found that requires %s", opts.via, query)}
+	}
+	if len(paths) == 0 {
+		return nil, noPathError{fmt.Errorf("no call path found that requires %s. It might only be reachable via reflection", query)}
+	}
+	return paths, nil
+}
+
+// whyTargets returns the reachable functions a -why query refers to. The
+// query is one of:
+//   - an IAM action, e.g. "dynamodb:BatchGetItem", or with -provider gcp or
+//     azure a permission, e.g. "storage.objects.get"
+//   - an SDK method, e.g. "DynamoDB.BatchGetItem"
+//   - the full name of any function, e.g.
+//     "github.com/aws/aws-sdk-go-v2/service/dynamodb.Client.BatchGetItem"
+func (g *graph) whyTargets(query string) ([]*ssa.Function, error) {
+	// Plugins may recognize functions whose SDK methods and actions the
+	// mapping doesn't know, e.g. the methods of a wrapper of the SDK
+	pluginFuncs := g.pluginFuncs(query)
+	// Helper libraries may need actions without any SDK call requiring them
+	libraryFuncs := g.libraryFuncs(query)
+
+	var sdkMethods []string
+	switch {
+	case g.provider != "aws" && len(actionToSDKMethods(query)) > 0: // a permission
+		sdkMethods = actionToSDKMethods(query)
+		slices.Sort(sdkMethods)
+	case strings.Contains(query, "/"): // a full function name
+		targets := g.findFuncs(query)
+		if len(targets) == 0 {
+			return nil, fmt.Errorf("didn't find any reachable function named %s", query)
+		}
+		return targets, nil
+	case strings.Contains(query, ":"): // an IAM action
+		// Map AWS IAM action permission to any SDK methods that might need them
+		sdkMethods = actionToSDKMethods(query)
+		if len(sdkMethods) == 0 && len(pluginFuncs) == 0 && len(libraryFuncs) == 0 {
+			if similar := similarActions(query); len(similar) > 0 {
+				return nil, noPathError{fmt.Errorf("didn't find any SDK method that requires the action %s. Did you mean %s?", query, strings.Join(similar, " or "))}
+			}
+			return nil, noPathError{fmt.Errorf("didn't find any SDK method that requires the action %s. Are you sure it exist?", query)}
+		}
+		slices.Sort(sdkMethods) // for consistent output
+	default: // an SDK method
+		// The mapping has the correct capitalization of the service,
+		// which SDK v1 uses in its function names
+		sdkMethods = []string{canonicalSDKMethod(query)}
+	}
+
+	var targets []*ssa.Function
+	filtered := false
+	for _, fn := range pluginFuncs {
+		if !g.IncludesService(fn) {
+			filtered = true
+			continue
+		}
+		targets = append(targets, fn)
+	}
+	targets = append(targets, libraryFuncs...)
+	for _, method := range sdkMethods {
+		// Based on the SDK method names, find what they might be called in different SDK versions
+		var fns []*ssa.Function
+		if g.provider == "aws" {
+			for _, fnName := range sdk.FunctionNames(method) {
+				if fn := g.findFunc(fnName); fn != nil {
+					fns = append(fns, fn)
+				}
+			}
+			if len(fns) == 0 {
+				// The package isn't named like the service in the
+				// mapping, e.g. sfn for StepFunctions
+				fns = g.sdkFuncs(method)
+			}
+		} else {
+			fns = g.sdkFuncs(method)
+		}
+		for _, fn := range fns {
+			if !g.IncludesService(fn) {
+				filtered = true
+				continue
+			}
+			targets = append(targets, fn)
+		}
+	}
+	if len(targets) == 0 && filtered {
+		return nil, noPathError{fmt.Errorf("%s is required, but not by the services given by -service or -exclude-service", query)}
+	}
+	if len(targets) == 0 {
+		return nil, noPathError{fmt.Errorf("no call path found that requires %s. It might only be reachable via reflection", query)}
+	}
+	return targets, nil
+}
+
+// sdkFuncs returns the reachable functions the SDK detectors recognize as
+// an SDK method, for SDKs whose function names can't be derived from it.
+// Methods are compared the way the mapping names them
+func (g *graph) sdkFuncs(method string) []*ssa.Function {
+	var fns []*ssa.Function
+	for fn := range g.Reachable {
+		if fn.Synthetic != "" || fn.Pkg == nil {
+			continue
+		}
+		if _, m := g.detectors.Detect(fn); m != "" && strings.EqualFold(canonicalSDKMethod(m), canonicalSDKMethod(method)) {
+			fns = append(fns, fn)
+		}
+	}
+	slices.SortFunc(fns, func(a, b *ssa.Function) int { return strings.Compare(a.String(), b.String()) })
+	return fns
+}
+
+// pluginFuncs returns the reachable functions a plugin recognizes as an SDK
+// method that is, or requires the action that is, query
+func (g *graph) pluginFuncs(query string) []*ssa.Function {
+	if len(plugins) == 0 {
+		return nil
+	}
+	var fns []*ssa.Function
+	for fn := range g.Reachable {
+		if fn.Synthetic != "" || fn.Pkg == nil {
+			continue
+		}
+		d, method := g.detectors.Detect(fn)
+		if _, ok := d.(pluginDetector); !ok {
+			continue
+		}
+		if strings.EqualFold(method, query) || sdkMethodToAction(method) == query {
+			fns = append(fns, fn)
+		}
+	}
+	slices.SortFunc(fns, func(a, b *ssa.Function) int { return strings.Compare(a.String(), b.String()) })
+	return fns
+}
+
+// typeParams matches the type parameters in the name of a generic function
+// or of a method of a generic type, e.g. "[C any]" or "[C]"
+var typeParams = regexp.MustCompile(`\[[^\[\]]*\]`)
+
+// findFuncs returns the reachable functions with a name, either the full
+// name (e.g. "github.com/org/app/handlers.Handler") or the name qualified
+// by the package name (e.g. "handlers.Handler"). A generic function is
+// named without its type parameters and found as its instances
+func (g *graph) findFuncs(name string) []*ssa.Function {
+	var fns []*ssa.Function
+	for fn := range g.Reachable {
+		named := fn
+		if fn.Origin() != nil {
+			named = fn.Origin()
+		}
+		if named.Synthetic != "" || named.Pkg == nil {
+			continue
+		}
+		fullName := typeParams.ReplaceAllString(cleanName(named), "")
+		shortName := strings.TrimPrefix(fullName, path.Dir(named.Pkg.Pkg.Path())+"/")
+		if fn.String() == name || fullName == name || shortName == name {
+			fns = append(fns, fn)
+		}
+	}
+	return fns
+}
+
+// binaryRoots returns the roots in the main packages with a path, or whose
+// binary (the last element of the path) is named, binary
+func (g *graph) binaryRoots(binary string) []*ssa.Function {
+	var roots []*ssa.Function
+	for _, root := range g.Roots {
+		pkgpath := root.Pkg.Pkg.Path()
+		if pkgpath == binary || path.Base(pkgpath) == binary {
+			roots = append(roots, root)
+		}
+	}
+	return roots
+}
+
+// binaryOf returns the path of the main package a call path starts from
+func binaryOf(path []*callgraph.Edge) string {
+	if len(path) == 0 || path[0].Caller.Func.Pkg == nil {
+		return ""
+	}
+	return path[0].Caller.Func.Pkg.Pkg.Path()
+}
+
+// pathsVia returns up to n of the shortest paths from any of the roots to
+// a function that pass through any of the via functions, or just the
+// shortest paths if there are none, see findPaths
+func (g *graph) pathsVia(roots []*ssa.Function, fn *ssa.Function, via []*ssa.Function, n int) [][]*callgraph.Edge {
+	if len(via) == 0 {
+		return g.FindPaths(roots, fn, n)
+	}
+
+	var paths [][]*callgraph.Edge
+	for _, v := range via {
+		tail := g.BFS(v, fn)
+		if tail == nil {
+			continue
+		}
+		for _, head := range g.FindPaths(roots, v, n) {
+			paths = append(paths, append(slices.Clip(head), tail...))
+		}
+	}
+	slices.SortStableFunc(paths, func(a, b []*callgraph.Edge) int { return len(a) - len(b) })
+	return paths[:min(n, len(paths))]
+}
+
+// pathsPerCallSite returns up to n of the shortest paths from any of the
+// roots to a function through each place it's called from, optionally
+// passing through any of the via functions. For SDK calls that's each
+// place outside of the SDK that calls it
+func (g *graph) pathsPerCallSite(roots []*ssa.Function, fn *ssa.Function, via []*ssa.Function, n int) [][]*callgraph.Edge {
+	var sites []*callgraph.Edge
+	if g.detectors.Version(fn) != "" {
+		sites, _ = g.SDKCallSites(g.PathGraph(), fn)
+	} else if node := g.PathGraph().Nodes[fn]; node != nil {
+		sites = node.In
+	}
+
+	var paths [][]*callgraph.Edge
+	for _, site := range sites {
+		// Within the SDK, e.g. GetObject -> GetObjectRequest for v1
+		tail := g.BFS(site.Callee.Func, fn)
+		if tail == nil {
+			continue
+		}
+		// No heads means e.g. the caller is only reachable through reflection
+		for _, head := range g.pathsVia(roots, site.Caller.Func, via, n) {
+			paths = append(paths, append(append(slices.Clip(head), site), tail...))
+		}
+	}
+	return paths
+}